@@ -5,15 +5,27 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/donsprallo/zeitgeist/internal/ntp"
+	"os"
+	"text/tabwriter"
+	"time"
 )
 
 // Variables for command line arguments.
 var (
-	ntpHost *string
-	ntpPort *int
+	ntpHost         *string
+	ntpPort         *int
+	originTolerance *time.Duration
+	maxRefAge       *time.Duration
+	samples         *int
+	sampleInterval  *time.Duration
+	jsonOutput      *bool
+	format          *string
+	decode          *bool
 )
 
 // Setup command line arguments.
@@ -22,33 +34,172 @@ func init() {
 		"host", "localhost", "request host address")
 	ntpPort = flag.Int(
 		"port", 123, "request port")
+	originTolerance = flag.Duration(
+		"origin-tolerance", ntp.DefaultOriginTolerance,
+		"maximum difference of response originate timestamp")
+	maxRefAge = flag.Duration(
+		"max-ref-age", time.Hour,
+		"maximum age of the reference timestamp before warning")
+	samples = flag.Int(
+		"samples", 1, "number of samples to filter the offset")
+	sampleInterval = flag.Duration(
+		"sample-interval", time.Second, "interval between samples")
+	jsonOutput = flag.Bool(
+		"json", false,
+		"alias of -format json")
+	format = flag.String(
+		"format", "text",
+		"output format; text, json as single JSON object or csv with "+
+			"a row per sample")
+	decode = flag.Bool(
+		"decode", false,
+		"decode a captured package in hex or binary from stdin")
 	// Parse command line arguments.
 	flag.Parse()
 }
 
+// Resolve the -json alias of -format json. An explicit -format other than
+// json conflicts with the alias.
+func resolveFormat() error {
+	if !*jsonOutput {
+		return nil
+	}
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		explicit = explicit || f.Name == "format"
+	})
+	if explicit && *format != "json" {
+		return fmt.Errorf("-json conflicts with -format %s", *format)
+	}
+	*format = "json"
+	return nil
+}
+
 func main() {
+	if err := resolveFormat(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	opts := ntp.RequestOptions{OriginTolerance: *originTolerance}
+	if *decode {
+		decodePackage()
+		return
+	}
+	switch *format {
+	case "text", "json":
+	case "csv":
+		// Print the samples for analysis tools and nothing else.
+		printSamplesCSV(opts)
+		return
+	default:
+		_, _ = fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		os.Exit(2)
+	}
+
 	// Request a ntp package from remote server.
-	pkg, err := ntp.Request(*ntpHost, *ntpPort)
+	result, err := ntp.Query(*ntpHost, *ntpPort, opts)
+	if err != nil && *format == "json" {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 	if err != nil {
 		fmt.Printf(err.Error())
 		return
 	}
 
+	// Print the query result for scripts and nothing else; samples are
+	// ignored.
+	if *format == "json" {
+		err = json.NewEncoder(os.Stdout).Encode(result)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	pkg := result.Package
+
 	// Print request result to user.
-	fmt.Println("header:")
-	fmt.Printf("leap: %d\n", pkg.GetLeap())
-	fmt.Printf("version: %d\n", pkg.GetVersion())
-	fmt.Printf("mode: %d\n", pkg.GetMode())
-	fmt.Printf("stratum: %d\n", pkg.GetStratum())
-	fmt.Printf("poll: %d\n", pkg.GetPoll())
-	fmt.Printf("precision: %d\n", pkg.GetPrecision())
-
-	fmt.Println("\npackage:")
-	fmt.Printf("root delay: %d\n", pkg.GetRootDelay())
-	fmt.Printf("root dispersion: %d\n", pkg.GetRootDispersion())
-	fmt.Printf("ref clock id: 0x%X\n", pkg.GetReferenceClockId())
-	fmt.Printf("ref timestamp: %v\n", pkg.GetReferenceTimestamp())
-	fmt.Printf("originate timestamp: %v\n", pkg.GetOriginateTimestamp())
-	fmt.Printf("recv timestamp: %v\n", pkg.GetReceiveTimestamp())
-	fmt.Printf("transmit timestamp: %v\n", pkg.GetTransmitTimestamp())
+	_ = ntp.WritePackageText(os.Stdout, pkg)
+
+	fmt.Println("\nclock:")
+	fmt.Printf("offset: %s\n", result.Offset)
+	fmt.Printf("delay: %s\n", result.RTT)
+	fmt.Printf("dispersion: %s\n", result.Dispersion)
+
+	// Print how fresh the response and the server synchronization are.
+	fmt.Println("\nfreshness:")
+	// The response is received at T4, before the result is processed.
+	fmt.Printf("response age: %s\n",
+		result.T4.Sub(pkg.GetTransmitTimestamp()))
+	fmt.Printf("reference age: %s\n", pkg.ReferenceAge())
+	if pkg.IsStale(*maxRefAge) {
+		fmt.Printf("warning: reference timestamp is older than %s; "+
+			"the server has not synchronized recently\n", *maxRefAge)
+	}
+
+	// Request more samples and print a summary of the samples.
+	if *samples > 1 {
+		stats, err := ntp.QueryN(context.Background(), *ntpHost,
+			*ntpPort, *samples, *sampleInterval, opts)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		printSampleStats(stats)
+	}
+}
+
+// Decode a captured package from stdin and print the package fields in
+// the output format.
+func decodePackage() {
+	pkg, err := ntp.ReadPackage(os.Stdin)
+	if err == nil && *format == "json" {
+		err = json.NewEncoder(os.Stdout).Encode(pkg)
+	} else if err == nil {
+		err = ntp.WritePackageText(os.Stdout, pkg)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// Request the samples with opts and print a CSV row per sample. The failed
+// samples are skipped.
+func printSamplesCSV(opts ntp.RequestOptions) {
+	stats, err := ntp.QueryN(context.Background(), *ntpHost,
+		*ntpPort, max(*samples, 1), *sampleInterval, opts)
+	if err == nil {
+		err = ntp.WriteSamplesCSV(os.Stdout,
+			append(stats.Samples, stats.Outliers...))
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// Print a summary table of the sample statistics.
+func printSampleStats(stats *ntp.SampleStats) {
+	fmt.Println("\nsamples:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "offset\tdelay\tstatus")
+	for _, sample := range stats.Samples {
+		status := ""
+		if sample == stats.Best {
+			status = "best"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n",
+			sample.Offset, sample.RTT, status)
+	}
+	for _, sample := range stats.Outliers {
+		_, _ = fmt.Fprintf(w, "%s\t%s\toutlier\n",
+			sample.Offset, sample.RTT)
+	}
+	_ = w.Flush()
+	fmt.Printf("failed: %d\n", stats.Failed)
+	fmt.Printf("best offset: %s\n", stats.Best.Offset)
+	fmt.Printf("median offset: %s\n", stats.MedianOffset)
+	fmt.Printf("jitter: %s\n", stats.Jitter)
 }
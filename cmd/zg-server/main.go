@@ -10,15 +10,18 @@ import (
 	"fmt"
 	"github.com/donsprallo/zeitgeist/internal/web/api/routes"
 	"github.com/donsprallo/zeitgeist/pkg/config"
+	"net"
 	"os"
 	"os/signal"
+	"runtime"
+	"strconv"
 	"time"
 
+	"github.com/donsprallo/zeitgeist/internal/metrics"
 	"github.com/donsprallo/zeitgeist/internal/ntp"
 	"github.com/donsprallo/zeitgeist/internal/server"
 	"github.com/donsprallo/zeitgeist/internal/web"
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -29,29 +32,93 @@ var (
 
 // Variables for command line arguments.
 var (
-	ntpHost     *string
-	ntpPort     *int
-	webHost     *string
-	webPort     *int
-	showVersion *bool
-	logLevel    *string
+	ntpHost      *string
+	ntpPort      *int
+	ntpNetwork   *string
+	ntpInline    *bool
+	ntpOptional  *bool
+	localClock   *bool
+	localStratum *uint
+	ntpWorkers   *int
+	ntpQueue     *int
+	ntpQueueKoD  *bool
+	rateLimit    *time.Duration
+	rateBurst    *int
+	keysFile     *string
+	validation   *string
+	addresses    *string
+	listeners    *string
+	loopbackRef  *string
+	responseTTL  *time.Duration
+	captureFile  *string
+	captureSize  *int64
+	captureFiles *int
+	upstream     *string
+	upstreamIntv *time.Duration
+	webHost      *string
+	webPort      *int
+	webTimeout   *time.Duration
+	timerTypes   *string
+	identity     *string
+	maxRoutes    *int
+	routing      *string
+	showVersion  *bool
+	logLevel     *string
+	logFormat    *string
+	configFile   *string
 )
 
 // Default command line argument values.
 var (
-	defaultNtpHost  string
-	defaultNtpPort  int
-	defaultWebHost  string
-	defaultWebPort  int
-	defaultLogLevel string
+	defaultNtpHost   string
+	defaultNtpPort   int
+	defaultNetwork   string
+	defaultNtpInline bool
+	defaultOptional  bool
+	defaultLocal     bool
+	defaultStratum   int
+	defaultWorkers   int
+	defaultQueue     int
+	defaultQueueKoD  bool
+	defaultRateLimit time.Duration
+	defaultRateBurst int
+	defaultKeysFile  string
+	defaultValidate  string
+	defaultAddrs     string
+	defaultListeners string
+	defaultLoopback  string
+	defaultRespTTL   time.Duration
+	defaultCapture   string
+	defaultCapSize   int
+	defaultCapFiles  int
+	defaultUpstream  string
+	defaultUpIntv    time.Duration
+	defaultWebHost   string
+	defaultWebPort   int
+	defaultTimeout   time.Duration
+	defaultTypes     string
+	defaultIdentity  string
+	defaultMaxRoutes int
+	defaultRouting   string
+	defaultLogLevel  string
+	defaultLogFormat string
 )
 
-// Load dotenv when .env file available. When this file
-// does not exist, this is not an error.
+// The content of the configuration file; nil without file.
+var fileConfig *config.Config
+
+// The key of the api requests, that modify state. An empty key disables
+// the authentication.
+var apiKey string
+
+// Load dotenv files when available. The files are listed comma separated
+// in ENV_FILES and loaded in order, where later files override earlier
+// ones. When a file does not exist, this is not an error.
 func init() {
-	err := godotenv.Load()
+	files := config.GetEnvList("ENV_FILES", []string{".env"})
+	err := config.LoadEnvFiles(files...)
 	if err != nil {
-		log.Debug("no .env file to load")
+		log.Debugf("can not load env files: %s", err)
 	}
 }
 
@@ -61,9 +128,43 @@ func init() {
 func init() {
 	defaultNtpHost = config.GetEnvStr("NTP_HOST", "localhost")
 	defaultNtpPort = config.GetEnvInt("NTP_PORT", 123)
+	defaultNetwork = config.GetEnvStr("NTP_NETWORK", "udp")
+	defaultNtpInline = config.GetEnvBool("NTP_INLINE", false)
+	defaultOptional = config.GetEnvBool("NTP_OPTIONAL", false)
+	defaultLocal = config.GetEnvBool("NTP_LOCAL_CLOCK", false)
+	defaultStratum = config.GetEnvInt(
+		"NTP_LOCAL_STRATUM", int(server.DefaultLocalStratum))
+	defaultWorkers = config.GetEnvInt("NTP_WORKERS", runtime.NumCPU())
+	defaultQueue = config.GetEnvInt("NTP_QUEUE", server.DefaultQueueSize)
+	defaultQueueKoD = config.GetEnvBool("NTP_QUEUE_KOD", false)
+	defaultRateLimit = config.GetEnvDuration("NTP_RATE_LIMIT", 0)
+	defaultRateBurst = config.GetEnvInt("NTP_RATE_BURST", 8)
+	defaultKeysFile = config.GetEnvStr("NTP_KEYS_FILE", "")
+	defaultValidate = config.GetEnvStr("NTP_VALIDATION", "lenient")
+	defaultAddrs = config.GetEnvStr("NTP_ADDRESSES", "")
+	defaultListeners = config.GetEnvStr("NTP_LISTENERS", "")
+	defaultLoopback = config.GetEnvStr("NTP_LOOPBACK_REFID", "")
+	defaultRespTTL = config.GetEnvDuration("NTP_RESPONSE_TTL", 10*time.Minute)
+	defaultCapture = config.GetEnvStr("NTP_CAPTURE_FILE", "")
+	defaultCapSize = config.GetEnvInt(
+		"NTP_CAPTURE_SIZE", server.DefaultCaptureSize)
+	defaultCapFiles = config.GetEnvInt("NTP_CAPTURE_FILES", 3)
+	defaultUpstream = config.GetEnvStr("NTP_UPSTREAM", "")
+	defaultUpIntv = config.GetEnvDuration("NTP_UPSTREAM_INTERVAL", time.Minute)
 	defaultWebHost = config.GetEnvStr("WEB_HOST", "localhost")
 	defaultWebPort = config.GetEnvInt("WEB_PORT", 80)
+	defaultTimeout = config.GetEnvDuration("WEB_TIMEOUT", 10*time.Second)
+	defaultTypes = config.GetEnvStr("TIMER_TYPES", "")
+	// The identity defaults to the hostname; it is empty, when the
+	// hostname is not available.
+	hostname, _ := os.Hostname()
+	defaultIdentity = config.GetEnvStr("SERVER_IDENTITY", hostname)
+	defaultMaxRoutes = config.GetEnvInt("MAX_ROUTES", 0)
+	defaultRouting = config.GetEnvStr("ROUTING", "static")
+	// The api key is a secret, so it is not a command line argument.
+	apiKey = config.GetEnvStr("API_KEY", "")
 	defaultLogLevel = config.GetEnvStr("LOGLEVEL", "debug")
+	defaultLogFormat = config.GetEnvStr("LOG_FORMAT", "text")
 }
 
 // Setup command line arguments.
@@ -74,6 +175,48 @@ func init() {
 		"ntp daemon host interface name")
 	ntpPort = flag.Int("port", defaultNtpPort,
 		"ntp daemon host interface port")
+	ntpNetwork = flag.String("network", defaultNetwork,
+		"ntp daemon network; udp, udp4 for IPv4 or udp6 for IPv6 only")
+	ntpInline = flag.Bool("inline", defaultNtpInline,
+		"handle ntp requests inline for lower latency")
+	ntpOptional = flag.Bool("ntp-optional", defaultOptional,
+		"keep the web server running, when the ntp server fails")
+	localClock = flag.Bool("local-clock", defaultLocal,
+		"serve the system time as unsynchronized local clock")
+	localStratum = flag.Uint("local-stratum", uint(defaultStratum),
+		"stratum advertised by the local clock")
+	ntpWorkers = flag.Int("workers", defaultWorkers,
+		"number of workers handling ntp requests")
+	ntpQueue = flag.Int("queue", defaultQueue,
+		"number of ntp requests queued for the workers")
+	ntpQueueKoD = flag.Bool("queue-kod", defaultQueueKoD,
+		"answer ntp requests with a RATE kiss code, when queue is full")
+	rateLimit = flag.Duration("rate-limit", defaultRateLimit,
+		"average interval between client requests; zero is unlimited")
+	rateBurst = flag.Int("rate-burst", defaultRateBurst,
+		"number of client requests allowed in a burst")
+	keysFile = flag.String("keys", defaultKeysFile,
+		"ntp.keys file of the symmetric key authentication")
+	validation = flag.String("validation", defaultValidate,
+		"request validation level; strict, lenient or permissive")
+	addresses = flag.String("addresses", defaultAddrs,
+		"additional host:port addresses serving like the ntp address")
+	listeners = flag.String("listeners", defaultListeners,
+		"additional ports serving the default timer, like 1123:omit-origin")
+	loopbackRef = flag.String("loopback-refid", defaultLoopback,
+		"reference id of a diagnostic timer answering loopback clients")
+	responseTTL = flag.Duration("response-ttl", defaultRespTTL,
+		"time the last response of a client is kept for the api")
+	captureFile = flag.String("capture", defaultCapture,
+		"file capturing the sent ntp responses; empty is disabled")
+	captureSize = flag.Int64("capture-size", int64(defaultCapSize),
+		"maximum size in bytes of the capture file before rotation")
+	captureFiles = flag.Int("capture-files", defaultCapFiles,
+		"number of rotated capture files kept")
+	upstream = flag.String("upstream", defaultUpstream,
+		"upstream ntp server host:port checked by the healthcheck")
+	upstreamIntv = flag.Duration("upstream-interval", defaultUpIntv,
+		"interval between the checks of the upstream ntp server")
 	// Web server arguments.
 	webHost = flag.String(
 		"web-host", defaultWebHost,
@@ -81,16 +224,90 @@ func init() {
 	webPort = flag.Int(
 		"web-port", defaultWebPort,
 		"web host interface port")
+	webTimeout = flag.Duration(
+		"web-timeout", defaultTimeout,
+		"web api request handler timeout")
+	timerTypes = flag.String(
+		"timer-types", defaultTypes,
+		"timer types allowed to create with the web api, like "+
+			"SystemTimer,ModifyTimer; empty allows all")
+	identity = flag.String(
+		"identity", defaultIdentity,
+		"instance identity reported in the health responses")
+	// Routing arguments.
+	maxRoutes = flag.Int(
+		"max-routes", defaultMaxRoutes,
+		"maximum number of routes; zero is unlimited")
+	routing = flag.String(
+		"routing", defaultRouting,
+		"routing strategy; static or trie for large tables")
 	showVersion = flag.Bool(
 		"version", false,
 		"show version information and exit")
 	logLevel = flag.String(
 		"loglevel", defaultLogLevel,
 		"set application logger level")
+	logFormat = flag.String(
+		"log-format", defaultLogFormat,
+		"application logger format; text or json")
+	configFile = flag.String(
+		"config", config.GetEnvStr("CONFIG_FILE", ""),
+		"JSON or YAML configuration file with server settings, timers "+
+			"and routes")
 	// Parse command line arguments.
 	flag.Parse()
 }
 
+// Load the configuration file. The file values are used for the arguments,
+// that are neither set on the command line nor in the environment.
+func init() {
+	if *configFile == "" {
+		return
+	}
+	var err error
+	fileConfig, err = config.Load(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = fileConfig.Apply(flag.CommandLine, map[string]string{
+		"host":              "NTP_HOST",
+		"port":              "NTP_PORT",
+		"network":           "NTP_NETWORK",
+		"inline":            "NTP_INLINE",
+		"ntp-optional":      "NTP_OPTIONAL",
+		"local-clock":       "NTP_LOCAL_CLOCK",
+		"local-stratum":     "NTP_LOCAL_STRATUM",
+		"workers":           "NTP_WORKERS",
+		"queue":             "NTP_QUEUE",
+		"queue-kod":         "NTP_QUEUE_KOD",
+		"rate-limit":        "NTP_RATE_LIMIT",
+		"rate-burst":        "NTP_RATE_BURST",
+		"keys":              "NTP_KEYS_FILE",
+		"validation":        "NTP_VALIDATION",
+		"addresses":         "NTP_ADDRESSES",
+		"listeners":         "NTP_LISTENERS",
+		"loopback-refid":    "NTP_LOOPBACK_REFID",
+		"response-ttl":      "NTP_RESPONSE_TTL",
+		"upstream":          "NTP_UPSTREAM",
+		"upstream-interval": "NTP_UPSTREAM_INTERVAL",
+		"capture":           "NTP_CAPTURE_FILE",
+		"capture-size":      "NTP_CAPTURE_SIZE",
+		"capture-files":     "NTP_CAPTURE_FILES",
+		"web-host":          "WEB_HOST",
+		"web-port":          "WEB_PORT",
+		"web-timeout":       "WEB_TIMEOUT",
+		"timer-types":       "TIMER_TYPES",
+		"identity":          "SERVER_IDENTITY",
+		"routing":           "ROUTING",
+		"max-routes":        "MAX_ROUTES",
+		"loglevel":          "LOGLEVEL",
+		"log-format":        "LOG_FORMAT",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 // Setup application logger.
 func init() {
 	level := log.DebugLevel
@@ -107,6 +324,16 @@ func init() {
 		log.Warn("no valid log level set")
 	}
 	log.SetLevel(level)
+
+	// The json format is parsed by log collectors; the request fields are
+	// separate json fields.
+	switch *logFormat {
+	case "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.Warn("no valid log format set")
+	}
 }
 
 func main() {
@@ -132,6 +359,11 @@ func main() {
 	defaultTimer := &server.SystemTimer{
 		NTPPackage: defaultTimerPackage,
 	}
+	// Without upstream synchronization, the default timer can advertise
+	// the system time as local clock instead of a primary server.
+	if *localClock {
+		defaultTimer.SetLocalClock(uint32(*localStratum))
+	}
 
 	// Create routing protocol for handle requests. For this, we need to create
 	// a routing table. The table contains all ip address's and the
@@ -144,17 +376,163 @@ func main() {
 	timers := server.NewTimerCollection(10)
 	timerId := timers.Add(defaultTimer)
 
+	// The diagnostic timer answers loopback clients like the default
+	// timer, but with its own reference id. So the self monitoring of the
+	// host can tell its responses apart from the client responses.
+	var loopbackTimer *server.SystemTimer
+	if *loopbackRef != "" {
+		loopbackTimer = &server.SystemTimer{
+			NTPPackage: defaultTimer.NTPPackage,
+		}
+		loopbackTimer.NTPPackage.SetReferenceClockId([]byte(*loopbackRef))
+		timers.Add(loopbackTimer)
+	}
+
 	// The RoutingStrategy is used to specify, how a request and its ip
 	// address is matching a timer. The default timer is used to handle all
 	// requests matching the default route.
-	routingStrategy := server.NewStaticRouting(
-		routingTable, defaultTimer, timerId)
+	var routingStrategy server.RoutingStrategy
+	switch *routing {
+	case "trie":
+		routingStrategy = server.NewTrieRouting(
+			routingTable, defaultTimer, timerId)
+	case "static":
+		routingStrategy = server.NewStaticRouting(
+			routingTable, defaultTimer, timerId)
+	default:
+		log.Fatalf("no valid routing strategy %q", *routing)
+	}
+
+	// Limit the routing table size after the default routes are added.
+	// This bounds the memory and the cost to find a route; the routes of
+	// the configuration file count against the limit.
+	routingTable.MaxSize = *maxRoutes
+
+	// Add the predefined timers and routes of the configuration file.
+	if fileConfig != nil {
+		_, err := server.AddConfigTimers(fileConfig, timers,
+			routingTable, defaultTimer.NTPPackage)
+		if err != nil {
+			log.Fatalf("can not add timers of config: %s", err)
+		}
+	}
+
+	// Select how strict ntp requests are validated before answered.
+	validationLevel, err := ntp.ParseValidationLevel(*validation)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Log the effective settings before the servers are started.
+	config.LogStartupSummary(log.StandardLogger(), config.Summary{
+		Version:      version,
+		NtpAddress:   fmt.Sprintf("%s:%d", *ntpHost, *ntpPort),
+		WebAddress:   fmt.Sprintf("%s:%d", *webHost, *webPort),
+		DefaultTimer: server.TimerName(defaultTimer),
+		LogLevel:     *logLevel,
+		Options: map[string]any{
+			"network":      *ntpNetwork,
+			"inline":       *ntpInline,
+			"ntp_optional": *ntpOptional,
+			"local_clock":  *localClock,
+			"workers":      *ntpWorkers,
+			"queue":        *ntpQueue,
+			"queue_kod":    *ntpQueueKoD,
+			"rate_limit":   rateLimit.String(),
+			"rate_burst":   *rateBurst,
+			"validation":   validationLevel.String(),
+			"addresses":    *addresses,
+			"listeners":    *listeners,
+			"loopback":     *loopbackRef,
+			"response_ttl": responseTTL.String(),
+			"capture":      *captureFile,
+			"upstream":     *upstream,
+			"max_routes":   *maxRoutes,
+			"routing":      *routing,
+			"web_timeout":  webTimeout.String(),
+			"timer_types":  *timerTypes,
+			"identity":     *identity,
+			"api_key":      apiKey != "",
+			"log_format":   *logFormat,
+		},
+	})
 
 	// Create ntp server and start application. The ntp server handle all
 	// ntp requests with a RoutingStrategy.
 	ntpServer := server.NewServer(
 		*ntpHost, *ntpPort, routingStrategy)
-	go ntpServer.Serve()
+	if err := ntpServer.SetNetwork(*ntpNetwork); err != nil {
+		log.Fatal(err)
+	}
+	ntpServer.SetInline(*ntpInline)
+	ntpServer.SetWorkerPool(*ntpWorkers, *ntpQueue, *ntpQueueKoD)
+	ntpServer.SetTimers(timers)
+	// The default routes can be removed, so that the default timer
+	// answers requests without route.
+	ntpServer.SetRoutingFallback(defaultTimer, false)
+	ntpServer.SetValidationLevel(validationLevel)
+	// The health of the ntp server is reported by the health endpoint.
+	ntpChecker := server.NewNtpServerChecker()
+	ntpServer.SetChecker(ntpChecker)
+	// The last response of each client is kept for support debugging.
+	responseLog := server.NewResponseLog(
+		*responseTTL, server.DefaultResponseLogSize)
+	ntpServer.SetResponseLog(responseLog)
+	if loopbackTimer != nil {
+		ntpServer.SetLoopbackTimer(loopbackTimer)
+	}
+	// The sent responses are captured for offline analysis.
+	var capture *server.CaptureWriter
+	if *captureFile != "" {
+		capture, err = server.NewCaptureWriter(
+			*captureFile, *captureSize, *captureFiles)
+		if err != nil {
+			log.Fatalf("can not open capture file: %s", err)
+		}
+		ntpServer.SetCaptureWriter(capture)
+	}
+	var rateLimiter *server.RateLimiter
+	if *rateLimit > 0 {
+		rateLimiter = server.NewRateLimiter(*rateLimit, *rateBurst)
+		ntpServer.SetRateLimiter(rateLimiter)
+	}
+	if *keysFile != "" {
+		ntpServer.SetKeyStore(mustLoadKeys(*keysFile))
+	}
+	// Additional addresses serve like the ntp address, for example the
+	// IPv4 and IPv6 address of a dual-stack host.
+	for _, address := range config.SplitList(*addresses) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			log.Fatalf("invalid ntp address %q: %s", address, err)
+		}
+		portNum, err := strconv.Atoi(port)
+		if err != nil {
+			log.Fatalf("invalid ntp address %q: %s", address, err)
+		}
+		ntpServer.AddAddress(host, portNum)
+	}
+	// Additional ports serve the default timer with port specific
+	// response options, like for a side by side comparison.
+	for _, spec := range config.SplitList(*listeners) {
+		port, options, err := server.ParseListener(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ntpServer.AddListener(port, defaultTimer, options)
+	}
+	ntpStopped := make(chan struct{})
+	go func() {
+		defer close(ntpStopped)
+		// The application can run without ntp server, for example to
+		// prepare the timers and routes with the web api.
+		err := ntpServer.Serve()
+		if err != nil && *ntpOptional {
+			log.Errorf("ntp server stopped: %s", err)
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}()
 
 	// Now we create a web server. First we need a router that handle http
 	// requests. The strict slash option is needed here. This means, that
@@ -166,25 +544,86 @@ func main() {
 	// For the web api we need to create endpoints. An endpoint is a collection
 	// of logically related functions for a web API.
 	apiHealth := routes.NewHealthEndpoint()
-	apiTimer := routes.NewTimerEndpoint(timers)
+	apiHealth.SetIdentity(*identity)
+	apiHealth.AddChecker("ntp", ntpChecker)
+	// The reachability of the upstream ntp server is checked in
+	// background, so that a healthcheck does not wait for the upstream.
+	var upstreamChecker *server.UpstreamChecker
+	if *upstream != "" {
+		host, port, err := net.SplitHostPort(*upstream)
+		if err != nil {
+			log.Fatalf("invalid upstream address %q: %s", *upstream, err)
+		}
+		portNum, err := strconv.Atoi(port)
+		if err != nil {
+			log.Fatalf("invalid upstream address %q: %s", *upstream, err)
+		}
+		// The NtpTimers serve the reference of the upstream server.
+		upstreamChecker = server.NewUpstreamChecker(host, portNum, timers)
+		apiHealth.AddChecker("upstream", upstreamChecker)
+	}
+
+	// Health checkers can be declared as JSON array in HEALTH_CHECKS. Each
+	// checker is instantiated from a built-in checker type.
+	if checks := config.GetEnvStr("HEALTH_CHECKS", ""); checks != "" {
+		checkers, err := routes.ParseCheckerConfigs([]byte(checks))
+		if err == nil {
+			err = apiHealth.AddCheckers(checkers)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	apiTimer := routes.NewTimerEndpoint(timers, routingTable)
+	// A locked-down deployment allows only some timer types, for example
+	// no NtpTimer with outbound connections.
+	if err := apiTimer.SetAllowedTypes(
+		config.SplitList(*timerTypes)); err != nil {
+		log.Fatal(err)
+	}
 	apiRoute := routes.NewRouteEndpoint(timers, routingTable)
+	apiServer := routes.NewServerEndpoint(ntpServer)
+	apiConfig := routes.NewConfigEndpoint(defaultTimer)
+	apiNtp := routes.NewNtpEndpoint(responseLog)
+	apiRateLimit := routes.NewRateLimitEndpoint(rateLimiter)
+	apiMetrics := routes.NewMetricsEndpoint(metrics.DefaultRegistry)
 
 	// We still need a web server so that we can deliver our routes.
 	webServer := web.NewServer(
 		*webHost, *webPort, router)
 
+	// All requests are logged and a panic of a handler is answered with
+	// an internal server error. All responses get security headers. API
+	// responses are not cached.
+	webServer.Use(web.RequestLogger, web.Recover)
+	// Requests, that modify timers, routes or the server, require the api
+	// key, when it is configured.
+	webServer.Use(web.APIKeyAuth(apiKey))
+	webServer.Use(web.SecurityHeaders(web.DefaultSecurityConfig))
+
 	// The API endpoints must be registered with the web server. Here we define
 	// a prefix under which address the endpoint can be reached.
+	// Handlers of the management endpoints are limited by a timeout and
+	// answer errors as problem details, when the client accepts them.
 	webServer.RegisterEndpoint("/api/v1/health", apiHealth)
-	webServer.RegisterEndpoint("/api/v1/timer", apiTimer)
-	webServer.RegisterEndpoint("/api/v1/route", apiRoute)
+	webServer.RegisterEndpoint("/api/v1/timer", apiTimer,
+		web.ProblemDetails, web.Timeout(*webTimeout))
+	webServer.RegisterEndpoint("/api/v1/route", apiRoute,
+		web.ProblemDetails, web.Timeout(*webTimeout))
+	webServer.RegisterEndpoint("/api/v1/server", apiServer,
+		web.ProblemDetails, web.Timeout(*webTimeout))
+	webServer.RegisterEndpoint("/api/v1/config", apiConfig,
+		web.ProblemDetails, web.Timeout(*webTimeout))
+	webServer.RegisterEndpoint("/api/v1/ntp", apiNtp,
+		web.ProblemDetails, web.Timeout(*webTimeout))
+	webServer.RegisterEndpoint("/api/v1/ratelimit", apiRateLimit,
+		web.ProblemDetails, web.Timeout(*webTimeout))
+	// The metrics are scraped from the common Prometheus path.
+	webServer.RegisterEndpoint("/metrics", apiMetrics)
 
 	// Now we can start our webserver in background.
 	go webServer.Serve()
 
-	// Create ticker to update all timers every second.
-	timerTicker := time.NewTicker(1 * time.Second)
-
 	// Gracefully shutdown.
 	idleConnectionsClosed := make(chan struct{})
 	go func() {
@@ -194,6 +633,12 @@ func main() {
 		// Block until SIGINT received.
 		<-sigint
 
+		// Stop answering ntp requests. The queued requests are still
+		// answered until Serve returns.
+		if err := ntpServer.Shutdown(); err != nil {
+			log.Error(err)
+		}
+
 		// Create a deadline to wait for shutdown.
 		wait := 10 * time.Second
 		ctx, cancel := context.WithTimeout(
@@ -206,19 +651,42 @@ func main() {
 		if err != nil {
 			log.Error(err)
 		}
+		// Stop the background checks of the health checkers.
+		apiHealth.Close()
 
 		close(idleConnectionsClosed)
 	}()
 
-	// Loop infinity until gracefully shutdown.
-	for {
-		select {
-		// On ticker ticks, update all timers.
-		case <-timerTicker.C:
-			timers.AllUpdate()
-		// On gracefully shutdown.
-		case <-idleConnectionsClosed:
-			break
+	// Check the upstream ntp server until gracefully shutdown.
+	if upstreamChecker != nil {
+		go upstreamChecker.CheckLoop(*upstreamIntv, idleConnectionsClosed)
+	}
+
+	// Update all timers every second until gracefully shutdown.
+	timers.UpdateLoop(1*time.Second, idleConnectionsClosed)
+
+	// Close the capture file after the last response is sent.
+	<-ntpStopped
+	if capture != nil {
+		if err := capture.Close(); err != nil {
+			log.Error(err)
 		}
 	}
+	log.Info("server gracefully shutdown")
+	os.Exit(0)
+}
+
+// Load the symmetric keys of the ntp authentication from the ntp.keys
+// file at path. On failure, the application exits.
+func mustLoadKeys(path string) *ntp.KeyStore {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	keys := ntp.NewKeyStore()
+	if err = keys.ParseKeys(file); err != nil {
+		log.Fatalf("can not load keys from %s: %s", path, err)
+	}
+	return keys
 }
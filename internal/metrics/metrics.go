@@ -0,0 +1,20 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package metrics collects metrics of the ntp server. The metrics are
+// registered with a Prometheus registry and scraped with Handler.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Handler get a http.Handler, that serves the metrics of registry in the
+// Prometheus exposition format.
+func Handler(registry *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHandler(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "test_total",
+		Help: "Test counter.",
+	}, []string{"kind"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "test_seconds",
+		Help:    "Test histogram.",
+		Buckets: []float64{0.1, 1},
+	})
+	registry.MustRegister(counter, histogram)
+
+	counter.WithLabelValues("b").Inc()
+	counter.WithLabelValues("a\"").Inc()
+	counter.WithLabelValues("b").Inc()
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+
+	// Scrape the metrics in the text exposition format.
+	rec := httptest.NewRecorder()
+	Handler(registry).ServeHTTP(
+		rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("invalid status code: %d", rec.Code)
+	}
+	want := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 5.55
+test_seconds_count 3
+# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{kind="a\""} 1
+test_total{kind="b"} 2
+`
+	if rec.Body.String() != want {
+		t.Errorf("invalid output:\n%s\nwant:\n%s", rec.Body.String(), want)
+	}
+}
+
+func TestDefaultRegistry(t *testing.T) {
+	// All metrics of the ntp server must be gathered.
+	RequestsTotal.WithLabelValues("3").Inc()
+	families, err := DefaultRegistry.Gather()
+	if err != nil {
+		t.Fatalf("gather err: %s", err)
+	}
+	names := make([]string, 0, len(families))
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	if !strings.Contains(strings.Join(names, " "), "ntp_requests_total") {
+		t.Errorf("requests counter not gathered: %v", names)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Stages of the ntp request handling, where an error can occur.
+const (
+	StageParse    = "parse"    // The request can not be parsed.
+	StageValidate = "validate" // The request is rejected.
+	StageAuth     = "auth"     // The request MAC is invalid.
+	StageQueue    = "queue"    // The request queue is full.
+	StageRouting  = "routing"  // No timer is found for the client.
+	StageMarshal  = "marshal"  // The response can not be built.
+	StageWrite    = "write"    // The response can not be sent.
+)
+
+// Reasons of a corrected ntp response.
+const (
+	CorrectionLeapStratum = "leap_stratum" // Unsynchronized leap at a synchronized stratum.
+)
+
+// Metrics of the ntp server.
+var (
+	// RequestsTotal counts the parsed ntp requests by request mode.
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ntp_requests_total",
+		Help: "Number of ntp requests by mode.",
+	}, []string{"mode"})
+	// ResponsesTotal counts the ntp responses by type of the timer.
+	ResponsesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ntp_responses_total",
+		Help: "Number of ntp responses by timer type.",
+	}, []string{"timer_type"})
+	// RouteMatchesTotal counts the ntp requests by matched route prefix.
+	RouteMatchesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ntp_route_matches_total",
+		Help: "Number of ntp requests by matched route.",
+	}, []string{"route"})
+	// ErrorsTotal counts the failed ntp requests by handling stage.
+	ErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ntp_errors_total",
+		Help: "Number of failed ntp requests by stage.",
+	}, []string{"stage"})
+	// CorrectionsTotal counts the corrected ntp responses by reason.
+	CorrectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ntp_response_corrections_total",
+		Help: "Number of corrected ntp responses by reason.",
+	}, []string{"reason"})
+	// HandlerSeconds observes the time from receiving a ntp request to
+	// sending the response.
+	HandlerSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "ntp_handler_duration_seconds",
+		Help: "Latency of the ntp request handler in seconds.",
+		Buckets: []float64{
+			.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1},
+	})
+)
+
+// DefaultRegistry is the Prometheus registry of the ntp server metrics.
+var DefaultRegistry = prometheus.NewRegistry()
+
+func init() {
+	DefaultRegistry.MustRegister(
+		RequestsTotal, ResponsesTotal, RouteMatchesTotal, ErrorsTotal,
+		CorrectionsTotal, HandlerSeconds)
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ntp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// MaxDecodeSize is the maximum number of bytes read by ReadPackage.
+const MaxDecodeSize = 1 << 16
+
+// ReadPackage read a captured Package from r. The input is either the raw
+// binary package or the package as hex string, like the output of
+// tcpdump -x. Whitespace and line breaks of a hex string are ignored. The
+// package must have at least PackageSize bytes.
+func ReadPackage(r io.Reader) (*Package, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxDecodeSize))
+	if err != nil {
+		return nil, err
+	}
+	// Decode a hex string; binary input is used as it is.
+	if decoded, ok := decodeHex(data); ok {
+		data = decoded
+	}
+	return PackageFromBytes(data)
+}
+
+// Decode data as hex string without whitespace. When data is not a hex
+// string, false is returned.
+func decodeHex(data []byte) ([]byte, bool) {
+	digits := bytes.Join(bytes.Fields(data), nil)
+	digits = bytes.TrimPrefix(digits, []byte("0x"))
+	decoded := make([]byte, hex.DecodedLen(len(digits)))
+	if _, err := hex.Decode(decoded, digits); err != nil {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// WritePackageText write the fields of pkg as human readable text to w.
+// The fixed point values are written with the computed durations.
+func WritePackageText(w io.Writer, pkg *Package) error {
+	precision := int8(pkg.GetPrecision())
+	precisionDuration := time.Duration(
+		math.Ldexp(1, int(precision)) * float64(time.Second))
+
+	lines := []string{
+		"header:",
+		fmt.Sprintf("leap: %d", pkg.GetLeap()),
+		fmt.Sprintf("version: %d", pkg.GetVersion()),
+		fmt.Sprintf("mode: %d", pkg.GetMode()),
+		fmt.Sprintf("stratum: %d", pkg.GetStratum()),
+		fmt.Sprintf("poll: %d (%s)",
+			int8(pkg.GetPoll()), pkg.PollInterval()),
+		fmt.Sprintf("precision: %d (%s)", precision, precisionDuration),
+		"",
+		"package:",
+		fmt.Sprintf("root delay: %d (%s)",
+			pkg.GetRootDelay(), pkg.GetRootDelayDuration()),
+		fmt.Sprintf("root dispersion: %d (%s)",
+			pkg.GetRootDispersion(), pkg.GetRootDispersionDuration()),
+		fmt.Sprintf("ref clock id: %s (0x%X)",
+			pkg.GetReferenceClockIdString(), pkg.GetReferenceClockId()),
+		fmt.Sprintf("ref timestamp: %v", pkg.GetReferenceTimestamp()),
+		fmt.Sprintf("originate timestamp: %v", pkg.GetOriginateTimestamp()),
+		fmt.Sprintf("recv timestamp: %v", pkg.GetReceiveTimestamp()),
+		fmt.Sprintf("transmit timestamp: %v", pkg.GetTransmitTimestamp()),
+	}
+	// Write the extension fields and the MAC of an extended package.
+	for _, field := range pkg.GetExtensionFields() {
+		lines = append(lines, fmt.Sprintf("extension field: type 0x%04X "+
+			"length %d", field.Type, field.Length()))
+	}
+	if keyId, ok := pkg.GetKeyId(); ok {
+		lines = append(lines, fmt.Sprintf("mac key id: %d", keyId))
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ntp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A captured version 4 server package with stratum 2, poll 6, precision
+// -20, a root delay of 31.25ms and the reference id GPS.
+const testPackageHex = `240206ec 00000800 00000400 47505300
+e95b1e80 00000000 00000000 00000000
+e95b1e81 00000000 e95b1e81 80000000`
+
+func TestReadPackage(t *testing.T) {
+	data, err := hex.DecodeString(strings.Join(
+		strings.Fields(testPackageHex), ""))
+	if err != nil {
+		t.Fatalf("can not decode test package: %s", err)
+	}
+
+	// Create test table; each input must decode to the same package.
+	table := []string{
+		testPackageHex,
+		"0x" + hex.EncodeToString(data) + "\n",
+		string(data),
+	}
+	for _, input := range table {
+		pkg, err := ReadPackage(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("%q can not read package: %s", input, err)
+			continue
+		}
+		if pkg.GetStratum() != 2 || pkg.GetMode() != ModeServer ||
+			pkg.GetVersion() != VersionV4 {
+			t.Errorf("%q invalid package: %s", input, pkg)
+		}
+		if pkg.GetRootDelayDuration() != 31250*time.Microsecond {
+			t.Errorf("%q invalid root delay: %s",
+				input, pkg.GetRootDelayDuration())
+		}
+	}
+
+	// A short package can not be decoded.
+	if _, err = ReadPackage(strings.NewReader("240206ec")); err == nil {
+		t.Errorf("short package decoded")
+	}
+}
+
+func TestWritePackageText(t *testing.T) {
+	pkg, err := ReadPackage(strings.NewReader(testPackageHex))
+	if err != nil {
+		t.Fatalf("can not read package: %s", err)
+	}
+	var b bytes.Buffer
+	if err = WritePackageText(&b, pkg); err != nil {
+		t.Fatalf("can not write package: %s", err)
+	}
+
+	// The fields are written with the computed durations.
+	for _, want := range []string{
+		"stratum: 2\n",
+		"poll: 6 (1m4s)\n",
+		"root delay: 2048 (31.25ms)\n",
+		"ref clock id: GPS (0x47505300)\n",
+		"transmit timestamp: 2024-01-24 05:28:33.5 +0000 UTC\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing %q in output:\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ntp
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Key is a symmetric key of the ntp authentication with the digest
+// algorithm Algo, like MACAlgoMD5.
+type Key struct {
+	Algo   string
+	Secret []byte
+}
+
+// KeyStore holds the symmetric keys of the ntp authentication by key
+// identifier. The store is safe for concurrent use.
+type KeyStore struct {
+	mu   sync.RWMutex
+	keys map[uint32]Key
+}
+
+// NewKeyStore create a new empty KeyStore.
+func NewKeyStore() *KeyStore {
+	return &KeyStore{keys: make(map[uint32]Key)}
+}
+
+// Set the key with identifier keyId. When the digest algorithm of the key
+// is unknown, an error is returned.
+func (s *KeyStore) Set(keyId uint32, key Key) error {
+	if key.Algo != MACAlgoMD5 && key.Algo != MACAlgoSHA1 {
+		return fmt.Errorf("unknown ntp digest algorithm %q", key.Algo)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[keyId] = key
+	return nil
+}
+
+// Get the key with identifier keyId.
+func (s *KeyStore) Get(keyId uint32) (Key, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[keyId]
+	return key, ok
+}
+
+// Delete the key with identifier keyId.
+func (s *KeyStore) Delete(keyId uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, keyId)
+}
+
+// Verify the MAC of pkg with the key of its key identifier. The key is
+// returned, so that the response can be signed with it. When the package
+// has no MAC, false is returned.
+func (s *KeyStore) Verify(pkg *Package) (uint32, Key, bool) {
+	keyId, ok := pkg.GetKeyId()
+	if !ok {
+		return 0, Key{}, false
+	}
+	key, ok := s.Get(keyId)
+	if !ok || !pkg.VerifyMAC(keyId, key.Secret) {
+		return keyId, Key{}, false
+	}
+	return keyId, key, true
+}
+
+// ParseKeys parse keys in the format of the ntp.keys file of the reference
+// implementation into the store. Each line holds a key identifier, the
+// digest algorithm and the key. A key of 40 hex digits is hex decoded,
+// other keys are ASCII. Text after "#" is a comment.
+func (s *KeyStore) ParseKeys(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return fmt.Errorf("ntp keys line %d: want 3 fields", line)
+		}
+		keyId, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("ntp keys line %d: %w", line, err)
+		}
+		secret := []byte(fields[2])
+		if len(fields[2]) == 40 {
+			if secret, err = hex.DecodeString(fields[2]); err != nil {
+				return fmt.Errorf("ntp keys line %d: %w", line, err)
+			}
+		}
+		key := Key{Algo: strings.ToUpper(fields[1]), Secret: secret}
+		if err = s.Set(uint32(keyId), key); err != nil {
+			return fmt.Errorf("ntp keys line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ntp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyStoreParseKeys(t *testing.T) {
+	keys := NewKeyStore()
+	err := keys.ParseKeys(strings.NewReader(`
+# ntp keys
+1 MD5 secret
+2 sha1 0102030405060708090a0b0c0d0e0f1011121314 # hex key
+`))
+	if err != nil {
+		t.Fatalf("can not parse keys: %s", err)
+	}
+	key, ok := keys.Get(1)
+	if !ok || key.Algo != MACAlgoMD5 || string(key.Secret) != "secret" {
+		t.Errorf("invalid ascii key: %+v", key)
+	}
+	key, ok = keys.Get(2)
+	if !ok || key.Algo != MACAlgoSHA1 || len(key.Secret) != 20 ||
+		key.Secret[0] != 0x01 {
+		t.Errorf("invalid hex key: %+v", key)
+	}
+
+	// Invalid lines are rejected.
+	for _, data := range []string{
+		"1 MD5",
+		"x MD5 secret",
+		"1 SHA256 secret",
+	} {
+		if err := NewKeyStore().ParseKeys(
+			strings.NewReader(data)); err == nil {
+			t.Errorf("%q parsed without error", data)
+		}
+	}
+}
+
+func TestKeyStoreVerify(t *testing.T) {
+	keys := NewKeyStore()
+	_ = keys.Set(5, Key{Algo: MACAlgoSHA1, Secret: []byte("secret")})
+	var pkg Package
+	pkg.SetVersion(VersionV4)
+	pkg.SetMode(ModeClient)
+	pkg.SetTransmitTimestamp(time.Now())
+
+	// A package without MAC is not verified.
+	if _, _, ok := keys.Verify(&pkg); ok {
+		t.Errorf("package without MAC verified")
+	}
+
+	// A package signed with a known key is verified.
+	_ = pkg.AppendMAC(5, []byte("secret"), MACAlgoSHA1)
+	data, _ := pkg.ToBytes()
+	received, _ := PackageFromBytes(data)
+	keyId, key, ok := keys.Verify(received)
+	if !ok || keyId != 5 || !bytes.Equal(key.Secret, []byte("secret")) {
+		t.Errorf("signed package not verified: %d %+v", keyId, key)
+	}
+
+	// A tampered package or an unknown key is not verified.
+	data[3] ^= 0x01
+	received, _ = PackageFromBytes(data)
+	if _, _, ok := keys.Verify(received); ok {
+		t.Errorf("tampered package verified")
+	}
+	data[3] ^= 0x01
+	keys.Delete(5)
+	received, _ = PackageFromBytes(data)
+	if _, _, ok := keys.Verify(received); ok {
+		t.Errorf("package with unknown key verified")
+	}
+}
@@ -5,10 +5,19 @@
 package ntp
 
 import (
+	"bytes"
+	"cmp"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
+	"math"
 	"net"
+	"strconv"
 	"time"
 )
 
@@ -37,6 +46,60 @@ const (
 	PrecisionMask uint32 = 0x0000_00FF
 )
 
+// MaxStratum is the highest stratum of a ntp package. A stratum of 16 is
+// unsynchronized and higher strata are reserved.
+const MaxStratum uint32 = 15
+
+// UnsyncStratum is the stratum of an unsynchronized server.
+const UnsyncStratum uint32 = MaxStratum + 1
+
+// Kiss codes of a Kiss-o'-Death package, like described in RFC 5905. The
+// code tells a client, why the server does not serve it.
+const (
+	KissCodeDeny       = "DENY" // Access denied; stop sending requests.
+	KissCodeRestricted = "RSTR" // Access restricted; stop sending requests.
+	KissCodeRate       = "RATE" // Rate exceeded; reduce the poll interval.
+)
+
+// Constants for the symmetric key authentication. The MAC follows the
+// package and consists of a key identifier and a MD5 or SHA1 digest.
+const (
+	DigestSize int = md5.Size
+	MACSize    int = 4 + DigestSize
+	MaxMACSize int = 4 + sha1.Size
+)
+
+// MinExtensionFieldSize is the minimum size of an extension field, like
+// described in RFC 7822. The minimum size distinguishes an extension field
+// from a MAC following the package.
+const MinExtensionFieldSize int = 16
+
+// ExtensionField is a NTPv4 extension field following the package header.
+// The Value holds the field data including the padding, so that the field
+// Length is 4 bytes of type and length plus the Value size.
+type ExtensionField struct {
+	Type  uint16
+	Value []byte
+}
+
+// Length get the size of the encoded extension field.
+func (f ExtensionField) Length() int {
+	return 4 + len(f.Value)
+}
+
+// Digest algorithms of the symmetric key authentication.
+const (
+	MACAlgoMD5  = "MD5"
+	MACAlgoSHA1 = "SHA1"
+)
+
+// Constants for the ntp package header poll field. The poll interval is
+// a log2 seconds exponent in the range of MinPoll and MaxPoll.
+const (
+	MinPoll int = 4
+	MaxPoll int = 17
+)
+
 // Constants for the ntp package header leap indicator field.
 const (
 	LeapNotSet uint32 = 0x0000_0000
@@ -69,23 +132,47 @@ type Timestamp struct {
 }
 
 // ToTimestamp convert a unix time.Time to seconds and fractional
-// part of a ntp timestamp.
+// part of a ntp timestamp. The seconds wrap around at the end of each
+// ntp era of 2^32 seconds, the first one ends in 2036.
 func ToTimestamp(t time.Time) Timestamp {
 	var ts Timestamp
 	unix := t.Unix()
-	ts.Seconds = uint32(unix) + TimeDelta
-	ts.Fraction = uint32(float64(t.UnixMicro()) * (1 << 32) * 1.0e-6)
+	ts.Seconds = uint32(unix + int64(TimeDelta))
+	ts.Fraction = uint32((uint64(t.Nanosecond()) << 32) / 1e9)
 	return ts
 }
 
-// ToTime convert seconds and fraction of seconds to time.Time.
+// ToTime convert seconds and fraction of seconds to time.Time. The seconds
+// are interpreted in the ntp era closest to the current time. A timestamp
+// with zero seconds is unset and converted to the UnixEpoch.
 func ToTime(ts Timestamp) time.Time {
-	if ts.Seconds > 0 {
-		ts.Seconds -= TimeDelta
+	if ts.Seconds == 0 {
+		return UnixEpoch.Add(fractionToDuration(ts.Fraction))
 	}
-	seconds := time.Duration(ts.Seconds) * time.Second
-	nanoseconds := time.Duration(ts.Fraction)
-	return UnixEpoch.Add(seconds + nanoseconds)
+	return ToTimeNear(ts, time.Now())
+}
+
+// Convert the fraction of a ntp timestamp in units of 2^-32 seconds to
+// time.Duration.
+func fractionToDuration(fraction uint32) time.Duration {
+	return time.Duration((uint64(fraction) * uint64(time.Second)) >> 32)
+}
+
+// ToTimeNear convert seconds and fraction of seconds to time.Time. The
+// seconds are interpreted in the ntp era, that results in the time closest
+// to pivot. Like described in RFC 5905, a time within 68 years of pivot is
+// converted correctly.
+func ToTimeNear(ts Timestamp, pivot time.Time) time.Time {
+	const eraSeconds = int64(1) << 32
+	// Find the seconds since ntp epoch within half an era of pivot.
+	base := pivot.Unix() + int64(TimeDelta) - eraSeconds/2
+	offset := (int64(ts.Seconds) - base) % eraSeconds
+	if offset < 0 {
+		offset += eraSeconds
+	}
+	seconds := base + offset - int64(TimeDelta)
+	nanoseconds := fractionToDuration(ts.Fraction)
+	return time.Unix(seconds, 0).UTC().Add(nanoseconds)
 }
 
 // Package is the ntp package representation. A package is
@@ -99,6 +186,11 @@ type Package struct {
 	originateTimestamp time.Time
 	receiveTimestamp   time.Time
 	transmitTimestamp  time.Time
+	extension          []byte // Extension fields and MAC after the header.
+	extensionFields    []ExtensionField
+	keyId              uint32 // Key identifier of the MAC.
+	digest             []byte // MD5 digest of the MAC; nil without MAC.
+	received           []byte // Received bytes before the MAC to verify.
 }
 
 // GetLeap get the package leap indicator.
@@ -156,6 +248,32 @@ func (pkg *Package) SetPoll(value uint32) {
 	pkg.header |= PollMask & (value << 8)
 }
 
+// PollInterval get the package poll interval as time.Duration. The poll
+// field is a signed log2 seconds exponent, where a negative exponent is a
+// sub-second interval like described in RFC 5905. The exponent is clamped
+// to the range of -MaxPoll and MaxPoll, so that the interval can not
+// overflow.
+func (pkg *Package) PollInterval() time.Duration {
+	exp := int(int8(pkg.GetPoll()))
+	exp = max(-MaxPoll, min(exp, MaxPoll))
+	if exp < 0 {
+		return time.Second >> -exp
+	}
+	return time.Second << exp
+}
+
+// SetPollInterval set the package poll interval from time.Duration. The
+// duration is rounded to the nearest power of two seconds exponent and
+// clamped to the range of MinPoll and MaxPoll.
+func (pkg *Package) SetPollInterval(d time.Duration) {
+	exp := MinPoll
+	if d > 0 {
+		exp = int(math.Round(math.Log2(d.Seconds())))
+	}
+	exp = max(MinPoll, min(exp, MaxPoll))
+	pkg.SetPoll(uint32(exp))
+}
+
 // GetPrecision get the package precision value.
 func (pkg *Package) GetPrecision() uint32 {
 	return (pkg.header & PrecisionMask) >> 0
@@ -177,6 +295,18 @@ func (pkg *Package) SetRootDelay(value uint32) {
 	pkg.rootDelay = value
 }
 
+// GetRootDelayDuration get the package root delay as time.Duration. The
+// root delay is a signed 16.16 fixed point seconds value.
+func (pkg *Package) GetRootDelayDuration() time.Duration {
+	return shortToDuration(pkg.rootDelay)
+}
+
+// SetRootDelayDuration set the package root delay from time.Duration. The
+// duration is truncated to the 16.16 fixed point resolution of about 15µs.
+func (pkg *Package) SetRootDelayDuration(d time.Duration) {
+	pkg.rootDelay = durationToShort(d)
+}
+
 // GetRootDispersion get the package root dispersion.
 func (pkg *Package) GetRootDispersion() uint32 {
 	return pkg.rootDispersion
@@ -187,16 +317,56 @@ func (pkg *Package) SetRootDispersion(value uint32) {
 	pkg.rootDispersion = value
 }
 
+// GetRootDispersionDuration get the package root dispersion as
+// time.Duration. The root dispersion is a signed 16.16 fixed point seconds
+// value.
+func (pkg *Package) GetRootDispersionDuration() time.Duration {
+	return shortToDuration(pkg.rootDispersion)
+}
+
+// SetRootDispersionDuration set the package root dispersion from
+// time.Duration. The duration is truncated to the 16.16 fixed point
+// resolution of about 15µs.
+func (pkg *Package) SetRootDispersionDuration(d time.Duration) {
+	pkg.rootDispersion = durationToShort(d)
+}
+
+// Convert a ntp short format value, a signed 16.16 fixed point seconds
+// value, to time.Duration.
+func shortToDuration(value uint32) time.Duration {
+	return time.Duration(int64(int32(value)) * int64(time.Second) >> 16)
+}
+
+// Convert time.Duration to a ntp short format value. The duration is
+// truncated toward zero and clamped to the range of the format.
+func durationToShort(d time.Duration) uint32 {
+	const limit = time.Duration(math.MaxInt16+1) * time.Second
+	d = max(-limit, min(d, limit-1))
+	return uint32(int32(int64(d) << 16 / int64(time.Second)))
+}
+
 // GetReferenceClockId get the package reference clock identifier.
 func (pkg *Package) GetReferenceClockId() []byte {
-	buf := make([]byte, 4)
+	buf := make([]byte, 0, 4)
 	return binary.BigEndian.AppendUint32(
 		buf, pkg.referenceClockId)
 }
 
-// SetReferenceClockId set the package reference clock identifier.
+// GetReferenceClockIdString get the package reference clock identifier as
+// string. An ASCII identifier, like the clock source "GPS" of a stratum 1
+// package, is returned without trailing zeros. Other identifiers, like the
+// upstream server address of a secondary server, are returned as IPv4
+// address.
+func (pkg *Package) GetReferenceClockIdString() string {
+	return formatReferenceId(pkg.GetReferenceClockId())
+}
+
+// SetReferenceClockId set the package reference clock identifier. A value
+// shorter than four bytes is padded with zeros, a longer value truncated.
 func (pkg *Package) SetReferenceClockId(value []byte) {
-	pkg.referenceClockId = binary.BigEndian.Uint32(value)
+	buf := make([]byte, 4)
+	copy(buf, value)
+	pkg.referenceClockId = binary.BigEndian.Uint32(buf)
 }
 
 // GetReferenceTimestamp get the package reference timestamp.
@@ -239,6 +409,171 @@ func (pkg *Package) SetTransmitTimestamp(value time.Time) {
 	pkg.transmitTimestamp = value
 }
 
+// ReferenceAge get the time since the server clock was last synchronized,
+// when the package was transmitted. This is the difference of the transmit
+// timestamp and the reference timestamp.
+func (pkg *Package) ReferenceAge() time.Duration {
+	return pkg.transmitTimestamp.Sub(pkg.referenceTimestamp)
+}
+
+// IsStale check whether the reference timestamp of the package is unset or
+// older than maxAge, so that the server has not synchronized recently.
+func (pkg *Package) IsStale(maxAge time.Duration) bool {
+	ref := pkg.referenceTimestamp
+	if ref.IsZero() || ref.Unix() == 0 {
+		return true
+	}
+	return pkg.ReferenceAge() > maxAge
+}
+
+// GetExtension get the raw bytes following the package header, like
+// extension fields and MAC of a received package. The raw bytes are not
+// part of the MarshalBinary encoding, but the parsed extension fields and
+// MAC are.
+func (pkg *Package) GetExtension() []byte {
+	return pkg.extension
+}
+
+// NewKissPackage create a Kiss-o'-Death response to the request package
+// req. The kiss code is sent as reference id of an unsynchronized stratum
+// 0 package. A code shorter than four characters is padded with zeros.
+func NewKissPackage(req *Package, code string) *Package {
+	pkg := &Package{}
+	pkg.SetLeap(LeapNotSyn)
+	pkg.SetVersion(req.GetVersion())
+	pkg.SetMode(ModeServer)
+	pkg.SetStratum(0)
+	pkg.SetPoll(req.GetPoll())
+	pkg.SetReferenceClockId([]byte(code))
+	pkg.SetOriginateTimestamp(req.GetTransmitTimestamp())
+	pkg.SetReceiveTimestamp(req.GetReceiveTimestamp())
+	pkg.SetTransmitTimestamp(time.Now())
+	return pkg
+}
+
+// CorrectLeapStratum make the leap indicator and the stratum of a response
+// package coherent. An unsynchronized package can not have the stratum of
+// a synchronized server, so the stratum is set to UnsyncStratum. Strict
+// clients reject such a contradiction. When the package is corrected,
+// true is returned.
+func (pkg *Package) CorrectLeapStratum() bool {
+	stratum := pkg.GetStratum()
+	if pkg.GetLeap() != LeapNotSyn || stratum == 0 || stratum > MaxStratum {
+		return false
+	}
+	pkg.SetStratum(UnsyncStratum)
+	return true
+}
+
+// GetKissCode get the kiss code of a Kiss-o'-Death package. A package with
+// stratum 0 carries the kiss code in the reference id. For other packages,
+// false is returned.
+func (pkg *Package) GetKissCode() (string, bool) {
+	if pkg.GetStratum() != 0 {
+		return "", false
+	}
+	code := bytes.TrimRight(pkg.GetReferenceClockId(), "\x00")
+	return string(code), true
+}
+
+// ValidationLevel is the strictness of Package.Validate. A higher level
+// rejects more non-conforming packages.
+type ValidationLevel int
+
+// Validation levels of Package.Validate.
+const (
+	// ValidationPermissive accepts every package, that can be decoded.
+	ValidationPermissive ValidationLevel = iota
+	// ValidationLenient rejects packages, that can not be answered. These
+	// are packages with an unsupported header like checked by
+	// UnmarshalBinaryStrict and responses of other servers, which could
+	// cause a loop of answers.
+	ValidationLenient
+	// ValidationStrict accepts client requests of version 3 or 4 with a
+	// transmit timestamp only.
+	ValidationStrict
+)
+
+// Names of the validation levels.
+var validationLevelNames = map[ValidationLevel]string{
+	ValidationPermissive: "permissive",
+	ValidationLenient:    "lenient",
+	ValidationStrict:     "strict",
+}
+
+// String implements the fmt.Stringer interface.
+func (level ValidationLevel) String() string {
+	if name, ok := validationLevelNames[level]; ok {
+		return name
+	}
+	return fmt.Sprintf("ValidationLevel(%d)", int(level))
+}
+
+// ParseValidationLevel parse a ValidationLevel by its name.
+func ParseValidationLevel(name string) (ValidationLevel, error) {
+	for level, levelName := range validationLevelNames {
+		if levelName == name {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown validation level %q", name)
+}
+
+// Errors returned by Package.Validate.
+var (
+	ErrInvalidVersion = errors.New("ntp package version not supported")
+	ErrInvalidMode    = errors.New("ntp package mode not supported")
+	ErrInvalidStratum = errors.New("ntp package stratum not supported")
+	ErrNoTransmit     = errors.New("ntp package transmit timestamp unset")
+)
+
+// Validate check that the package is a request, that is answered at the
+// validation level. When the package is rejected, an error is returned.
+func (pkg *Package) Validate(level ValidationLevel) error {
+	if level <= ValidationPermissive {
+		return nil
+	}
+	if err := pkg.checkHeader(); err != nil {
+		return err
+	}
+	mode := pkg.GetMode()
+	if level >= ValidationStrict {
+		if mode != ModeClient {
+			return fmt.Errorf("%w: mode %d is no client request",
+				ErrInvalidMode, mode)
+		}
+		// A client always sends its transmit timestamp.
+		if t := pkg.transmitTimestamp; t.IsZero() || t.Unix() == 0 {
+			return ErrNoTransmit
+		}
+		return nil
+	}
+	if mode == ModeServer || mode == ModeBroadcast {
+		return fmt.Errorf("%w: mode %d is a server response",
+			ErrInvalidMode, mode)
+	}
+	return nil
+}
+
+// Check that the package has a supported version, no reserved mode and a
+// stratum up to MaxStratum.
+func (pkg *Package) checkHeader() error {
+	if version := pkg.GetVersion(); version != VersionV3 &&
+		version != VersionV4 {
+		return fmt.Errorf("%w: version %d, want %d or %d",
+			ErrInvalidVersion, version, VersionV3, VersionV4)
+	}
+	if mode := pkg.GetMode(); mode == ModeReserved {
+		return fmt.Errorf("%w: mode %d is reserved",
+			ErrInvalidMode, mode)
+	}
+	if stratum := pkg.GetStratum(); stratum > MaxStratum {
+		return fmt.Errorf("%w: stratum %d, want up to %d",
+			ErrInvalidStratum, stratum, MaxStratum)
+	}
+	return nil
+}
+
 // ToBytes converts package to bytes.
 func (pkg *Package) ToBytes() ([]byte, error) {
 	return pkg.MarshalBinary()
@@ -254,18 +589,184 @@ func PackageFromBytes(data []byte) (*Package, error) {
 	return &pkg, nil
 }
 
+// PackageFromBytesStrict parse package from bytes like
+// Package.UnmarshalBinaryStrict.
+func PackageFromBytesStrict(data []byte) (*Package, error) {
+	pkg := Package{}
+	err := pkg.UnmarshalBinaryStrict(data)
+	if err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
 // String implements the fmt.Stringer interface.
 func (pkg *Package) String() string {
 	return fmt.Sprintf("<NtpPackage(mode=%d, version=%d, stratum=%d)>",
 		pkg.GetMode(), pkg.GetVersion(), pkg.GetStratum())
 }
 
-// MarshalBinary implements encoding.BinaryMarshaler interface.
+// JSON representation of a Package. The root delay and dispersion are in
+// the ntp short format and the precision is a signed log2 seconds exponent.
+type packageJSON struct {
+	Leap               uint32 `json:"leap"`
+	Version            uint32 `json:"version"`
+	Mode               uint32 `json:"mode"`
+	Stratum            uint32 `json:"stratum"`
+	Poll               uint32 `json:"poll"`
+	Precision          int8   `json:"precision"`
+	RootDelay          uint32 `json:"rootDelay"`
+	RootDispersion     uint32 `json:"rootDispersion"`
+	ReferenceId        string `json:"referenceId"`
+	ReferenceTimestamp string `json:"referenceTimestamp"`
+	OriginateTimestamp string `json:"originateTimestamp"`
+	ReceiveTimestamp   string `json:"receiveTimestamp"`
+	TransmitTimestamp  string `json:"transmitTimestamp"`
+}
+
+// MarshalJSON implements json.Marshaler interface. The reference id is
+// encoded as ASCII string, when it is printable, like the kiss code or
+// clock source of a stratum 0 or 1 package. Otherwise, it is encoded as
+// IPv4 address. The timestamps are encoded in RFC3339 format.
+func (pkg *Package) MarshalJSON() ([]byte, error) {
+	return json.Marshal(packageJSON{
+		Leap:               pkg.GetLeap(),
+		Version:            pkg.GetVersion(),
+		Mode:               pkg.GetMode(),
+		Stratum:            pkg.GetStratum(),
+		Poll:               pkg.GetPoll(),
+		Precision:          int8(pkg.GetPrecision()),
+		RootDelay:          pkg.rootDelay,
+		RootDispersion:     pkg.rootDispersion,
+		ReferenceId:        formatReferenceId(pkg.GetReferenceClockId()),
+		ReferenceTimestamp: pkg.referenceTimestamp.Format(time.RFC3339Nano),
+		OriginateTimestamp: pkg.originateTimestamp.Format(time.RFC3339Nano),
+		ReceiveTimestamp:   pkg.receiveTimestamp.Format(time.RFC3339Nano),
+		TransmitTimestamp:  pkg.transmitTimestamp.Format(time.RFC3339Nano),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface. A header field out
+// of range of its bitfield is an error.
+func (pkg *Package) UnmarshalJSON(data []byte) error {
+	var v packageJSON
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	// Validate header fields, so that no bits are lost.
+	if v.Leap > LeapNotSyn || v.Version > 7 || v.Mode > ModePrivate ||
+		v.Stratum > math.MaxUint8 || v.Poll > math.MaxUint8 {
+		return errors.New("ntp package header field out of range")
+	}
+	refId, err := parseReferenceId(v.ReferenceId)
+	if err != nil {
+		return err
+	}
+	// Parse timestamps.
+	var timestamps [4]time.Time
+	for idx, value := range []string{
+		v.ReferenceTimestamp, v.OriginateTimestamp,
+		v.ReceiveTimestamp, v.TransmitTimestamp,
+	} {
+		timestamps[idx], err = time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return fmt.Errorf("invalid ntp package timestamp: %w", err)
+		}
+	}
+
+	result := Package{}
+	result.SetLeap(v.Leap)
+	result.SetVersion(v.Version)
+	result.SetMode(v.Mode)
+	result.SetStratum(v.Stratum)
+	result.SetPoll(v.Poll)
+	result.SetPrecision(uint32(uint8(v.Precision)))
+	result.rootDelay = v.RootDelay
+	result.rootDispersion = v.RootDispersion
+	result.SetReferenceClockId(refId)
+	result.referenceTimestamp = timestamps[0]
+	result.originateTimestamp = timestamps[1]
+	result.receiveTimestamp = timestamps[2]
+	result.transmitTimestamp = timestamps[3]
+	*pkg = result
+	return nil
+}
+
+// GetExtensionFields get the extension fields of the package.
+func (pkg *Package) GetExtensionFields() []ExtensionField {
+	return pkg.extensionFields
+}
+
+// SetExtensionFields set the extension fields of the package, that are
+// encoded between the header and the MAC on MarshalBinary. Each field must
+// have at least MinExtensionFieldSize bytes and be 4-byte aligned.
+func (pkg *Package) SetExtensionFields(fields []ExtensionField) error {
+	for _, field := range fields {
+		size := field.Length()
+		if size < MinExtensionFieldSize || size%4 != 0 ||
+			size > math.MaxUint16 {
+			return fmt.Errorf(
+				"invalid ntp extension field size %d", size)
+		}
+	}
+	pkg.extensionFields = fields
+	return nil
+}
+
+// Format a reference id as ASCII string without trailing zeros, when it is
+// printable, otherwise as IPv4 address.
+func formatReferenceId(refId []byte) string {
+	code := bytes.TrimRight(refId, "\x00")
+	for _, c := range code {
+		if c < 0x20 || c > 0x7e {
+			return net.IP(refId).String()
+		}
+	}
+	return string(code)
+}
+
+// Parse a reference id formatted by formatReferenceId.
+func parseReferenceId(value string) ([]byte, error) {
+	if ip := net.ParseIP(value).To4(); ip != nil {
+		return ip, nil
+	}
+	if len(value) > 4 {
+		return nil, fmt.Errorf("invalid ntp reference id %q", value)
+	}
+	return []byte(value), nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler interface. The
+// extension fields and the MAC are appended to the package, when the
+// package has them.
 func (pkg *Package) MarshalBinary() ([]byte, error) {
+	enc := pkg.marshalFields()
+	if pkg.digest != nil {
+		enc = binary.BigEndian.AppendUint32(enc, pkg.keyId)
+		enc = append(enc, pkg.digest...)
+	}
+	return enc, nil
+}
+
+// Encode the package header and the extension fields without MAC. The
+// MAC is computed over these bytes.
+func (pkg *Package) marshalFields() []byte {
+	enc := pkg.marshalHeader()
+	for _, field := range pkg.extensionFields {
+		enc = binary.BigEndian.AppendUint16(enc, field.Type)
+		enc = binary.BigEndian.AppendUint16(enc, uint16(field.Length()))
+		enc = append(enc, field.Value...)
+	}
+	return enc
+}
+
+// Encode the package header without extension fields and MAC.
+func (pkg *Package) marshalHeader() []byte {
 	// Create encoder with network byte order
 	encoder := binary.BigEndian
 	// Create ntp package buffer
-	enc := make([]byte, 0, PackageSize)
+	enc := make([]byte, 0, PackageSize+MaxMACSize)
 
 	// Encode package data
 	enc = encoder.AppendUint32(enc, pkg.header)
@@ -290,7 +791,7 @@ func (pkg *Package) MarshalBinary() ([]byte, error) {
 	enc = encoder.AppendUint32(enc, ts.Seconds)
 	enc = encoder.AppendUint32(enc, ts.Fraction)
 
-	return enc, nil
+	return enc
 }
 
 // UnmarshalBinary implements encoding.BinaryUnmarshaler interface.
@@ -336,40 +837,332 @@ func (pkg *Package) UnmarshalBinary(data []byte) error {
 	}
 	pkg.transmitTimestamp = ToTime(ts)
 
+	// Retain extension fields and MAC.
+	pkg.extension = append([]byte(nil), data[PackageSize:]...)
+
+	// Decode extension fields until the remaining bytes have the length
+	// of a MD5 or SHA1 MAC or no valid extension field follows.
+	offset := PackageSize
+	pkg.extensionFields = nil
+	for offset < len(data) && !isMACSize(len(data)-offset) {
+		field, ok := parseExtensionField(data[offset:])
+		if !ok {
+			break
+		}
+		pkg.extensionFields = append(pkg.extensionFields, field)
+		offset += field.Length()
+	}
+	pkg.received = append([]byte(nil), data[:offset]...)
+
+	// Decode MAC of a package with the length of a MD5 or SHA1 MAC.
+	pkg.keyId, pkg.digest = 0, nil
+	if isMACSize(len(data) - offset) {
+		pkg.keyId = dec.Uint32(buf[offset:])
+		pkg.digest = append([]byte(nil), buf[offset+4:]...)
+	}
+
+	return nil
+}
+
+// Check whether size is the size of a MD5 or SHA1 MAC.
+func isMACSize(size int) bool {
+	return size == MACSize || size == MaxMACSize
+}
+
+// Parse the extension field at the start of data. A field must have at
+// least MinExtensionFieldSize bytes, be 4-byte aligned and fit into data.
+func parseExtensionField(data []byte) (ExtensionField, bool) {
+	if len(data) < MinExtensionFieldSize {
+		return ExtensionField{}, false
+	}
+	size := int(binary.BigEndian.Uint16(data[2:]))
+	if size < MinExtensionFieldSize || size%4 != 0 || size > len(data) {
+		return ExtensionField{}, false
+	}
+	return ExtensionField{
+		Type:  binary.BigEndian.Uint16(data),
+		Value: append([]byte(nil), data[4:size]...),
+	}, true
+}
+
+// UnmarshalBinaryStrict decode a package like UnmarshalBinary, but reject
+// a package with a reserved mode, a version other than 3 or 4 or a stratum
+// above MaxStratum. The error wraps ErrInvalidMode, ErrInvalidVersion or
+// ErrInvalidStratum.
+func (pkg *Package) UnmarshalBinaryStrict(data []byte) error {
+	err := pkg.UnmarshalBinary(data)
+	if err != nil {
+		return err
+	}
+	return pkg.checkHeader()
+}
+
+// Compute the digest of key and the package header data with the digest
+// algorithm algo.
+func computeDigest(algo string, key []byte, data []byte) ([]byte, error) {
+	var h hash.Hash
+	switch algo {
+	case MACAlgoMD5:
+		h = md5.New()
+	case MACAlgoSHA1:
+		h = sha1.New()
+	default:
+		return nil, fmt.Errorf("unknown ntp digest algorithm %q", algo)
+	}
+	h.Write(key)
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// AppendMAC sign the package with the symmetric key and key identifier
+// keyId. The MAC is the digest of algo over key, the package header and
+// the extension fields, like described in RFC 5905. The MAC is appended on
+// MarshalBinary. When algo is unknown, an error is returned.
+func (pkg *Package) AppendMAC(keyId uint32, key []byte, algo string) error {
+	digest, err := computeDigest(algo, key, pkg.marshalFields())
+	if err != nil {
+		return err
+	}
+	pkg.keyId = keyId
+	pkg.digest = digest
+	pkg.received = nil
 	return nil
 }
 
-// Request a Package from remote host.
+// ClearMAC remove the MAC of the package.
+func (pkg *Package) ClearMAC() {
+	pkg.keyId, pkg.digest, pkg.received = 0, nil, nil
+}
+
+// GetKeyId get the key identifier of the package MAC. When the package has
+// no MAC, false is returned.
+func (pkg *Package) GetKeyId() (uint32, bool) {
+	return pkg.keyId, pkg.digest != nil
+}
+
+// VerifyMAC verify the MAC of the package with the symmetric key and key
+// identifier keyId. The digest algorithm is selected by the digest size.
+// A received package is verified over the received bytes. Without
+// MAC, the package is not verified.
+func (pkg *Package) VerifyMAC(keyId uint32, key []byte) bool {
+	if pkg.digest == nil || pkg.keyId != keyId {
+		return false
+	}
+	algo := MACAlgoMD5
+	if len(pkg.digest) == sha1.Size {
+		algo = MACAlgoSHA1
+	}
+	data := pkg.received
+	if data == nil {
+		data = pkg.marshalFields()
+	}
+	digest, _ := computeDigest(algo, key, data)
+	return subtle.ConstantTimeCompare(digest, pkg.digest) == 1
+}
+
+// Defaults of the RequestOptions, that are used for zero fields.
+const (
+	// DefaultTimeout is the read and write timeout of a request.
+	DefaultTimeout = 1 * time.Second
+	// DefaultMaxResponseSize is the maximum size of a response. A response
+	// may be larger than PackageSize, when it contains extension fields or
+	// a MAC.
+	DefaultMaxResponseSize = 1024
+	// DefaultOriginTolerance is the maximum difference of the response
+	// originate timestamp from the request transmit timestamp. The
+	// tolerance covers the precision lost in the timestamp encoding.
+	DefaultOriginTolerance = 10 * time.Microsecond
+)
+
+// ErrOriginMismatch is returned by Query, when the originate timestamp
+// of the response does not match the transmit timestamp of the request.
+// The response may be spoofed or belongs to another request.
+var ErrOriginMismatch = errors.New("ntp response originate mismatch")
+
+// The frequency tolerance of a clock, which adds to the dispersion over
+// time.
+const frequencyTolerance = 15e-6
+
+// RequestOptions configure a request. A request is retried up to Retries
+// times after a timeout. Before each retry, the request waits Backoff,
+// that doubles on every retry. Bytes of a response exceeding
+// MaxResponseSize are discarded. The response must echo the request
+// transmit timestamp within OriginTolerance. A zero field is replaced by
+// its default, so that the zero RequestOptions time out after
+// DefaultTimeout and are not retried.
+type RequestOptions struct {
+	Timeout         time.Duration
+	Retries         int
+	Backoff         time.Duration
+	MaxResponseSize int
+	OriginTolerance time.Duration
+}
+
+// Get opts with the defaults of the zero fields.
+func (opts RequestOptions) withDefaults() RequestOptions {
+	opts.Timeout = cmp.Or(opts.Timeout, DefaultTimeout)
+	opts.MaxResponseSize = cmp.Or(
+		opts.MaxResponseSize, DefaultMaxResponseSize)
+	opts.OriginTolerance = cmp.Or(
+		opts.OriginTolerance, DefaultOriginTolerance)
+	return opts
+}
+
+// QueryResult is the result of a SNTP unicast query. The T1 is the client
+// transmit time, T2 the server receive time, T3 the server transmit time
+// and T4 the client receive time. The Offset of the server clock from the
+// client clock, the round trip delay RTT of the network and the Dispersion,
+// the error of the query, are computed from the four timestamps like
+// described in RFC 5905.
+type QueryResult struct {
+	Package       *Package
+	Offset        time.Duration
+	RTT           time.Duration
+	Dispersion    time.Duration
+	Stratum       uint32
+	LeapIndicator uint32
+	T1            time.Time
+	T2            time.Time
+	T3            time.Time
+	T4            time.Time
+}
+
+// Compute the Offset, RTT and Dispersion of the result from the four
+// timestamps. The precision is the log2 seconds precision of the server
+// clock.
+func (r *QueryResult) computeMetrics(precision int8) {
+	elapsed := r.T4.Sub(r.T1)
+	r.Offset = (r.T2.Sub(r.T1) + r.T3.Sub(r.T4)) / 2
+	r.RTT = elapsed - r.T3.Sub(r.T2)
+	r.Dispersion = time.Duration(math.Ldexp(1, int(precision))*1e9) +
+		time.Duration(frequencyTolerance*float64(elapsed))
+}
+
+// The JSON encoding of a QueryResult. The durations are encoded in
+// seconds and the timestamps in RFC3339 format.
+type queryResultJSON struct {
+	Offset        float64  `json:"offset"`
+	RTT           float64  `json:"rtt"`
+	Dispersion    float64  `json:"dispersion"`
+	Stratum       uint32   `json:"stratum"`
+	LeapIndicator uint32   `json:"leapIndicator"`
+	T1            string   `json:"t1"`
+	T2            string   `json:"t2"`
+	T3            string   `json:"t3"`
+	T4            string   `json:"t4"`
+	Package       *Package `json:"package"`
+}
+
+// MarshalJSON implements json.Marshaler interface. The durations are
+// encoded in seconds and the timestamps in RFC3339 format. The Package is
+// encoded like Package.MarshalJSON.
+func (r *QueryResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(queryResultJSON{
+		Offset:        r.Offset.Seconds(),
+		RTT:           r.RTT.Seconds(),
+		Dispersion:    r.Dispersion.Seconds(),
+		Stratum:       r.Stratum,
+		LeapIndicator: r.LeapIndicator,
+		T1:            r.T1.Format(time.RFC3339Nano),
+		T2:            r.T2.Format(time.RFC3339Nano),
+		T3:            r.T3.Format(time.RFC3339Nano),
+		T4:            r.T4.Format(time.RFC3339Nano),
+		Package:       r.Package,
+	})
+}
+
+// Query remote host with opts and compute the clock offset, round trip
+// delay and dispersion of the query. The extension fields and MAC of the
+// response are retained in the Package. The response must echo the request
+// transmit timestamp within the OriginTolerance of opts. The client times
+// are taken around the socket write and read. A timed out query is retried
+// like described in RequestOptions; when all retries time out, the error
+// of the last query is returned.
+func Query(
+	host string, port int, opts RequestOptions,
+) (*QueryResult, error) {
+	opts = opts.withDefaults()
+	backoff := opts.Backoff
+	for retry := 0; ; retry++ {
+		result, err := queryOnce(host, port, opts)
+		// Only a timed out query is retried.
+		var netErr net.Error
+		if err == nil || retry >= opts.Retries ||
+			!errors.As(err, &netErr) || !netErr.Timeout() {
+			return result, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Request a Package from remote host with the zero RequestOptions like
+// Query. The extension fields and MAC of the response are retained in the
+// Package.
 func Request(host string, port int) (*Package, error) {
-	var pkg Package
-	pkg.SetMode(ModeClient)
-	pkg.SetVersion(VersionV3)
-	pkg.SetTransmitTimestamp(time.Now())
+	return RequestWithOptions(host, port, RequestOptions{})
+}
 
-	// Convert package to bytes.
-	bytesToSent, err := pkg.ToBytes()
+// RequestWithOptions request a Package from remote host like Request with
+// opts. When all retries time out, the error of the last request is
+// returned.
+func RequestWithOptions(
+	host string, port int, opts RequestOptions,
+) (*Package, error) {
+	result, err := Query(host, port, opts)
 	if err != nil {
 		return nil, err
 	}
+	return result.Package, nil
+}
 
+// Query remote host once with opts, that have the defaults set.
+func queryOnce(
+	host string, port int, opts RequestOptions,
+) (*QueryResult, error) {
 	// Create udp connection with read write timeout.
-	conn, err := createUdpConn(host, port, 1*time.Second)
+	conn, err := createUdpConn(host, port, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Take the transmit time right before the package is sent.
+	var pkg Package
+	pkg.SetMode(ModeClient)
+	pkg.SetVersion(VersionV3)
+	transmit := time.Now()
+	pkg.SetTransmitTimestamp(transmit)
+
+	// Convert package to bytes.
+	bytesToSent, err := pkg.ToBytes()
 	if err != nil {
 		return nil, err
 	}
 
 	// Write bytes to connection.
 	write, err := conn.Write(bytesToSent)
-	if err != nil || write != PackageSize {
+	if err != nil {
 		return nil, err
 	}
+	if write != PackageSize {
+		return nil, fmt.Errorf(
+			"ntp request short write of %d bytes", write)
+	}
 
-	// Read response from connection.
-	buffer := make([]byte, PackageSize)
+	// Read response from connection; the response may be larger than
+	// a package.
+	buffer := make([]byte, max(opts.MaxResponseSize, PackageSize))
 	read, err := conn.Read(buffer)
-	if err != nil || read != PackageSize {
+	received := time.Now()
+	if err != nil {
 		return nil, err
 	}
+	if read < PackageSize {
+		return nil, fmt.Errorf(
+			"ntp response short read of %d bytes", read)
+	}
+	buffer = buffer[:read]
 
 	// Parse package from received bytes.
 	err = pkg.UnmarshalBinary(buffer)
@@ -377,13 +1170,31 @@ func Request(host string, port int) (*Package, error) {
 		return nil, err
 	}
 
-	return &pkg, nil
+	// Verify that the response belongs to the request.
+	diff := pkg.GetOriginateTimestamp().Sub(transmit).Abs()
+	if diff > opts.OriginTolerance {
+		return nil, fmt.Errorf(
+			"%w: off by %s", ErrOriginMismatch, diff)
+	}
+
+	// Compute the metrics of the four timestamps.
+	result := QueryResult{
+		Package:       &pkg,
+		Stratum:       pkg.GetStratum(),
+		LeapIndicator: pkg.GetLeap(),
+		T1:            transmit,
+		T2:            pkg.GetReceiveTimestamp(),
+		T3:            pkg.GetTransmitTimestamp(),
+		T4:            received,
+	}
+	result.computeMetrics(int8(pkg.GetPrecision()))
+	return &result, nil
 }
 
 func createUdpConn(
 	host string, port int, timeout time.Duration,
 ) (net.Conn, error) {
-	addr := fmt.Sprintf("%s:%d", host, port)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	// Dial to remote udp address.
 	conn, err := net.Dial("udp", addr)
 	if err != nil {
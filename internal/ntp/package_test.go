@@ -6,6 +6,11 @@ package ntp
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
 	"testing"
 	"time"
 )
@@ -51,9 +56,9 @@ func TestToTime(t *testing.T) {
 		{
 			Timestamp{
 				Seconds:  1671180400 + TimeDelta,
-				Fraction: 4096,
+				Fraction: 1 << 31,
 			}, time.Date(
-				2022, time.December, 16, 8, 46, 40, 4096, time.UTC),
+				2022, time.December, 16, 8, 46, 40, 5e8, time.UTC),
 		},
 		{
 			Timestamp{
@@ -92,11 +97,10 @@ func TestToTime(t *testing.T) {
 func TestTimeConversion(t *testing.T) {
 	// Create test data table.
 	values := []time.Time{
-		// TODO: This case is not handled now.
-		// time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC),
 		time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC),
 		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
 		time.Date(2038, time.January, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2040, time.January, 1, 0, 0, 0, 0, time.UTC),
 	}
 
 	// Test all entries in test table.
@@ -111,6 +115,43 @@ func TestTimeConversion(t *testing.T) {
 	}
 }
 
+func TestTimeConversionEra(t *testing.T) {
+	// Create test data table; each time is converted in the era closest
+	// to pivot.
+	table := []struct {
+		datetime time.Time
+		pivot    time.Time
+	}{
+		{time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC), Epoch},
+		{time.Date(1930, time.May, 1, 0, 0, 0, 0, time.UTC), UnixEpoch},
+		{time.Date(2036, time.February, 7, 6, 28, 16, 0, time.UTC),
+			UnixEpoch.AddDate(60, 0, 0)},
+		{time.Date(2040, time.January, 1, 0, 0, 0, 0, time.UTC),
+			UnixEpoch.AddDate(60, 0, 0)},
+		{time.Date(2040, time.January, 1, 0, 0, 0, 250_000_000, time.UTC),
+			UnixEpoch.AddDate(60, 0, 0)},
+		{time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC),
+			UnixEpoch.AddDate(100, 0, 0)},
+	}
+
+	// Test all entries in test table.
+	for idx, e := range table {
+		ts := ToTimestamp(e.datetime)
+		tv := ToTimeNear(ts, e.pivot)
+
+		if tv != e.datetime {
+			t.Errorf("[%d] incorrect timestamp conversion %s != %s",
+				idx, tv.String(), e.datetime.String())
+		}
+	}
+
+	// The 2040 timestamp is in the second era; the seconds wrap around.
+	ts := ToTimestamp(table[3].datetime)
+	if ts.Seconds >= TimeDelta {
+		t.Errorf("timestamp seconds not wrapped: %d", ts.Seconds)
+	}
+}
+
 func TestPackageToBytes(t *testing.T) {
 	// Create test e; the ntp package will convert to bytes
 	// and check that the result is equal to data.
@@ -295,3 +336,963 @@ func TestSetGetStratum(t *testing.T) {
 		}
 	}
 }
+
+func TestSetPollInterval(t *testing.T) {
+	// Create test data table; each duration maps to a poll exponent.
+	// Out of range durations must be clamped to valid exponents.
+	table := []struct {
+		interval time.Duration
+		poll     uint32
+	}{
+		{16 * time.Second, 4},
+		{64 * time.Second, 6},
+		{1024 * time.Second, 10},
+		{1000 * time.Second, 10},
+		{90 * time.Second, 6},
+		{0, 4},
+		{-1 * time.Second, 4},
+		{1 * time.Second, 4},
+		{1 << 17 * time.Second, 17},
+		{24 * 365 * time.Hour, 17},
+	}
+
+	// Test all entries in test table.
+	for _, e := range table {
+		pkg := Package{}
+		pkg.SetPollInterval(e.interval)
+		if pkg.GetPoll() != e.poll {
+			t.Errorf("ntp set poll interval %s failed: %d != %d",
+				e.interval, pkg.GetPoll(), e.poll)
+		}
+		// Other header fields must not be touched.
+		if pkg.GetPrecision() != 0 || pkg.GetStratum() != 0 {
+			t.Errorf("ntp set poll interval %s overflow header",
+				e.interval)
+		}
+	}
+}
+
+func TestPollInterval(t *testing.T) {
+	// Create a test values array; the poll exponent is converted
+	// to a time.Duration of power of two seconds.
+	values := []time.Duration{
+		16 * time.Second,
+		64 * time.Second,
+		1024 * time.Second,
+	}
+
+	// Test all data in test values
+	for _, value := range values {
+		pkg := Package{}
+		pkg.SetPollInterval(value)
+		interval := pkg.PollInterval()
+		if interval != value {
+			t.Errorf("ntp get poll interval failed: %s != %s",
+				interval, value)
+		}
+	}
+	// Create test data table; the poll field is a signed exponent, that
+	// is clamped to the range of -MaxPoll and MaxPoll.
+	table := []struct {
+		poll     int8
+		interval time.Duration
+	}{
+		{-6, 15625 * time.Microsecond},
+		{0, time.Second},
+		{17, 1 << 17 * time.Second},
+		{63, 1 << 17 * time.Second},
+		{-128, time.Second >> 17},
+	}
+	for _, e := range table {
+		pkg := Package{}
+		pkg.SetPoll(uint32(uint8(e.poll)))
+		if interval := pkg.PollInterval(); interval != e.interval {
+			t.Errorf("poll %d invalid interval: want %s get %s",
+				e.poll, e.interval, interval)
+		}
+	}
+}
+
+func TestSetGetReferenceClockId(t *testing.T) {
+	pkg := Package{}
+	pkg.SetReferenceClockId([]byte("NICO"))
+	value := pkg.GetReferenceClockId()
+	if !bytes.Equal(value, []byte("NICO")) {
+		t.Errorf("ntp get reference clock id failed: %q != %q",
+			value, "NICO")
+	}
+}
+
+func TestReferenceClockIdString(t *testing.T) {
+	// Create test table; each input maps to the stored bytes and string.
+	table := []struct {
+		value []byte
+		bytes []byte
+		str   string
+	}{
+		{[]byte("G"), []byte("G\x00\x00\x00"), "G"},
+		{[]byte("GPS"), []byte("GPS\x00"), "GPS"},
+		{[]byte("NICO"), []byte("NICO"), "NICO"},
+		{[]byte("LOCAL"), []byte("LOCA"), "LOCA"},
+		{nil, []byte{0, 0, 0, 0}, ""},
+		{[]byte{192, 168, 1, 10}, []byte{192, 168, 1, 10}, "192.168.1.10"},
+	}
+
+	for _, e := range table {
+		var pkg Package
+		pkg.SetReferenceClockId(e.value)
+		if get := pkg.GetReferenceClockId(); !bytes.Equal(get, e.bytes) {
+			t.Errorf("%q invalid bytes: want %q get %q",
+				e.value, e.bytes, get)
+		}
+		str := pkg.GetReferenceClockIdString()
+		if str != e.str {
+			t.Errorf("%q invalid string: want %q get %q",
+				e.value, e.str, str)
+		}
+		// The string round trips to the same bytes.
+		var get Package
+		refId, _ := parseReferenceId(str)
+		get.SetReferenceClockId(refId)
+		if !bytes.Equal(get.GetReferenceClockId(), e.bytes) {
+			t.Errorf("%q invalid round trip: %q", e.value, str)
+		}
+	}
+}
+
+func TestQueryExtension(t *testing.T) {
+	// Create a fake server, that answers with a package followed by a
+	// 20 byte extension.
+	conn, err := net.ListenUDP(
+		"udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("can not listen udp: %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	extension := bytes.Repeat([]byte{0xAB}, 20)
+	go func() {
+		data := make([]byte, PackageSize)
+		_, addr, err := conn.ReadFromUDP(data)
+		if err != nil {
+			return
+		}
+		req, _ := PackageFromBytes(data)
+		var pkg Package
+		pkg.SetMode(ModeServer)
+		pkg.SetStratum(2)
+		pkg.SetOriginateTimestamp(req.GetTransmitTimestamp())
+		res, _ := pkg.ToBytes()
+		_, _ = conn.WriteToUDP(append(res, extension...), addr)
+	}()
+
+	// Request the package; the 68 byte response must be parsed.
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	result, err := Query(addr.IP.String(), addr.Port, RequestOptions{})
+	if err != nil {
+		t.Fatalf("query err: %s", err)
+	}
+	pkg := result.Package
+	if pkg.GetMode() != ModeServer || pkg.GetStratum() != 2 {
+		t.Errorf("invalid package: %s", pkg)
+	}
+	if !bytes.Equal(pkg.GetExtension(), extension) {
+		t.Errorf("invalid extension: want %X get %X",
+			extension, pkg.GetExtension())
+	}
+}
+
+func TestPackageMAC(t *testing.T) {
+	key := []byte("secret")
+	for _, algo := range []string{MACAlgoMD5, MACAlgoSHA1} {
+		var pkg Package
+		pkg.SetVersion(VersionV4)
+		pkg.SetMode(ModeServer)
+		pkg.SetStratum(2)
+		pkg.SetTransmitTimestamp(time.Now())
+
+		// Sign and marshal the package; the MAC must be appended.
+		if err := pkg.AppendMAC(42, key, algo); err != nil {
+			t.Fatalf("%s sign err: %s", algo, err)
+		}
+		data, err := pkg.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s marshal err: %s", algo, err)
+		}
+		macSize := MACSize
+		if algo == MACAlgoSHA1 {
+			macSize = MaxMACSize
+		}
+		if len(data) != PackageSize+macSize {
+			t.Fatalf("%s invalid signed package size: %d", algo, len(data))
+		}
+
+		// Unmarshal and verify the package.
+		received, err := PackageFromBytes(data)
+		if err != nil {
+			t.Fatalf("%s unmarshal err: %s", algo, err)
+		}
+		if keyId, ok := received.GetKeyId(); !ok || keyId != 42 {
+			t.Errorf("%s invalid key id: %d", algo, keyId)
+		}
+		if !received.VerifyMAC(42, key) {
+			t.Errorf("%s valid MAC not verified", algo)
+		}
+		if received.VerifyMAC(42, []byte("wrong")) {
+			t.Errorf("%s MAC verified with wrong key", algo)
+		}
+		if received.VerifyMAC(7, key) {
+			t.Errorf("%s MAC verified with wrong key identifier", algo)
+		}
+
+		// A modified package must not be verified.
+		data[1] ^= 0xFF
+		received, _ = PackageFromBytes(data)
+		if received.VerifyMAC(42, key) {
+			t.Errorf("%s MAC of modified package verified", algo)
+		}
+
+		// A package without MAC must not be verified.
+		received, _ = PackageFromBytes(data[:PackageSize])
+		if received.VerifyMAC(42, key) {
+			t.Errorf("%s package without MAC verified", algo)
+		}
+	}
+
+	// An unknown digest algorithm can not sign.
+	var pkg Package
+	if err := pkg.AppendMAC(42, key, "SHA256"); err == nil {
+		t.Errorf("package signed with unknown algorithm")
+	}
+}
+
+func TestPackageExtensionFields(t *testing.T) {
+	var pkg Package
+	pkg.SetVersion(VersionV4)
+	pkg.SetMode(ModeClient)
+	// Whole seconds are encoded without loss of the fraction.
+	pkg.SetTransmitTimestamp(time.Now().Truncate(time.Second))
+	header, _ := pkg.ToBytes()
+
+	// Append a 28 byte extension field to the header; a field with the
+	// size of a MAC is parsed as MAC.
+	field := []byte{0x01, 0x04, 0x00, 0x1C}
+	field = append(field, bytes.Repeat([]byte{0xCD}, 24)...)
+	data := append(append([]byte(nil), header...), field...)
+
+	// Parse the extension field.
+	received, err := PackageFromBytes(data)
+	if err != nil {
+		t.Fatalf("unmarshal err: %s", err)
+	}
+	fields := received.GetExtensionFields()
+	if len(fields) != 1 || fields[0].Type != 0x0104 ||
+		fields[0].Length() != len(field) {
+		t.Fatalf("invalid extension fields: %v", fields)
+	}
+	if _, ok := received.GetKeyId(); ok {
+		t.Errorf("extension field parsed as MAC")
+	}
+
+	// Round trip the package byte for byte.
+	enc, err := received.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal err: %s", err)
+	}
+	if !bytes.Equal(enc, data) {
+		t.Errorf("invalid round trip: want %X get %X", data, enc)
+	}
+
+	// A signed package with extension field must verify and round trip.
+	key := []byte("secret")
+	if err := received.AppendMAC(42, key, MACAlgoSHA1); err != nil {
+		t.Fatalf("sign err: %s", err)
+	}
+	enc, _ = received.MarshalBinary()
+	if len(enc) != len(data)+MaxMACSize {
+		t.Fatalf("invalid signed package size: %d", len(enc))
+	}
+	signed, err := PackageFromBytes(enc)
+	if err != nil {
+		t.Fatalf("unmarshal signed err: %s", err)
+	}
+	if len(signed.GetExtensionFields()) != 1 {
+		t.Errorf("invalid extension fields: %v", signed.GetExtensionFields())
+	}
+	if !signed.VerifyMAC(42, key) {
+		t.Errorf("MAC of package with extension field not verified")
+	}
+	if again, _ := signed.MarshalBinary(); !bytes.Equal(again, enc) {
+		t.Errorf("invalid signed round trip: want %X get %X", enc, again)
+	}
+
+	// Create test table; each field value size maps to valid.
+	table := []struct {
+		size  int
+		valid bool
+	}{
+		{12, true},
+		{8, false},
+		{13, false},
+		{60, true},
+	}
+	for _, e := range table {
+		err := pkg.SetExtensionFields([]ExtensionField{
+			{Type: 1, Value: make([]byte, e.size)},
+		})
+		if (err == nil) != e.valid {
+			t.Errorf("value size %d invalid: want %t get %v",
+				e.size, e.valid, err)
+		}
+	}
+}
+
+func TestPackageMACKnownDigest(t *testing.T) {
+	// Create test table; each digest is computed over the key "secret"
+	// and a package of zero bytes.
+	table := []struct {
+		algo   string
+		digest string
+	}{
+		{MACAlgoMD5, "cffb1b806c0408d3fcf421b90206de76"},
+		{MACAlgoSHA1, "57f14491aea7b102344e159031c8648095729c09"},
+	}
+
+	for _, e := range table {
+		digest, _ := hex.DecodeString(e.digest)
+		data := make([]byte, PackageSize+4, PackageSize+MaxMACSize)
+		binary.BigEndian.PutUint32(data[PackageSize:], 1)
+		data = append(data, digest...)
+		pkg, err := PackageFromBytes(data)
+		if err != nil {
+			t.Fatalf("%s unmarshal err: %s", e.algo, err)
+		}
+		if !pkg.VerifyMAC(1, []byte("secret")) {
+			t.Errorf("%s known digest not verified", e.algo)
+		}
+	}
+}
+
+func TestQueryOrigin(t *testing.T) {
+	// Create a fake server, that answers with the originate timestamp
+	// shifted by offset.
+	conn, err := net.ListenUDP(
+		"udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("can not listen udp: %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	serve := func(offset time.Duration) {
+		data := make([]byte, PackageSize)
+		_, addr, err := conn.ReadFromUDP(data)
+		if err != nil {
+			return
+		}
+		req, _ := PackageFromBytes(data)
+		var pkg Package
+		pkg.SetMode(ModeServer)
+		pkg.SetOriginateTimestamp(
+			req.GetTransmitTimestamp().Add(offset))
+		res, _ := pkg.ToBytes()
+		_, _ = conn.WriteToUDP(res, addr)
+	}
+	addr := conn.LocalAddr().(*net.UDPAddr)
+
+	// A matching originate timestamp must be accepted.
+	go serve(0)
+	_, err = Query(addr.IP.String(), addr.Port, RequestOptions{})
+	if err != nil {
+		t.Errorf("matching response err: %s", err)
+	}
+
+	// A mismatched originate timestamp must be rejected.
+	go serve(time.Second)
+	_, err = Request(addr.IP.String(), addr.Port)
+	if !errors.Is(err, ErrOriginMismatch) {
+		t.Errorf("invalid mismatched response err: %v", err)
+	}
+
+	// The origin tolerance of the options accepts the mismatch.
+	go serve(time.Second)
+	_, err = RequestWithOptions(addr.IP.String(), addr.Port,
+		RequestOptions{OriginTolerance: 2 * time.Second})
+	if err != nil {
+		t.Errorf("tolerated response err: %s", err)
+	}
+}
+
+func TestQueryRetry(t *testing.T) {
+	// Create a fake server, that drops the first request of each pair
+	// and answers the second one.
+	conn, err := net.ListenUDP(
+		"udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("can not listen udp: %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		for drop := true; ; drop = !drop {
+			data := make([]byte, PackageSize)
+			_, addr, err := conn.ReadFromUDP(data)
+			if err != nil {
+				return
+			}
+			if drop {
+				continue
+			}
+			req, _ := PackageFromBytes(data)
+			var pkg Package
+			pkg.SetMode(ModeServer)
+			pkg.SetStratum(2)
+			pkg.SetOriginateTimestamp(req.GetTransmitTimestamp())
+			res, _ := pkg.ToBytes()
+			_, _ = conn.WriteToUDP(res, addr)
+		}
+	}()
+	addr := conn.LocalAddr().(*net.UDPAddr)
+
+	// Without retry, the dropped request times out.
+	opts := RequestOptions{Timeout: 50 * time.Millisecond}
+	_, err = Query(addr.IP.String(), addr.Port, opts)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("invalid error: want timeout get %v", err)
+	}
+	// Answer the second request of the pair, so that the next request
+	// is dropped again.
+	_, err = Query(addr.IP.String(), addr.Port, opts)
+	if err != nil {
+		t.Fatalf("request err: %s", err)
+	}
+
+	// With retry, the second request is answered.
+	opts.Retries = 1
+	opts.Backoff = time.Millisecond
+	result, err := Query(addr.IP.String(), addr.Port, opts)
+	if err != nil {
+		t.Fatalf("query with retry err: %s", err)
+	}
+	pkg := result.Package
+	if pkg.GetMode() != ModeServer || pkg.GetStratum() != 2 {
+		t.Errorf("invalid package: %s", pkg)
+	}
+}
+
+func TestRootDelayDispersionDuration(t *testing.T) {
+	// Create test table; each duration maps to a 16.16 fixed point value.
+	table := []struct {
+		duration time.Duration
+		value    uint32
+	}{
+		{0, 0},
+		{time.Second, 0x0001_0000},
+		{1500 * time.Millisecond, 0x0001_8000},
+		{12500 * time.Microsecond, 819},
+		{50 * time.Millisecond, 3276},
+		{-time.Second, 0xFFFF_0000},
+	}
+
+	// Test all entries in test table.
+	resolution := time.Second >> 16
+	for _, e := range table {
+		var pkg Package
+		pkg.SetRootDelayDuration(e.duration)
+		pkg.SetRootDispersionDuration(e.duration)
+		if pkg.GetRootDelay() != e.value ||
+			pkg.GetRootDispersion() != e.value {
+			t.Errorf("%s invalid value: want %#x get %#x %#x",
+				e.duration, e.value,
+				pkg.GetRootDelay(), pkg.GetRootDispersion())
+		}
+		// The round trip must be exact to the resolution.
+		for _, d := range []time.Duration{
+			pkg.GetRootDelayDuration(),
+			pkg.GetRootDispersionDuration(),
+		} {
+			if diff := (e.duration - d).Abs(); diff > resolution {
+				t.Errorf("%s invalid round trip: get %s", e.duration, d)
+			}
+		}
+	}
+}
+
+func TestKissPackage(t *testing.T) {
+	var req Package
+	req.SetVersion(VersionV4)
+	req.SetMode(ModeClient)
+	req.SetPoll(6)
+	req.SetTransmitTimestamp(time.Now())
+
+	// Build, marshal and parse kiss packages.
+	for _, code := range []string{KissCodeRate, KissCodeDeny, "XY"} {
+		data, _ := NewKissPackage(&req, code).ToBytes()
+		pkg, err := PackageFromBytes(data)
+		if err != nil {
+			t.Fatalf("%s can not parse: %s", code, err)
+		}
+		get, ok := pkg.GetKissCode()
+		if !ok || get != code {
+			t.Errorf("invalid kiss code: want %q get %q", code, get)
+		}
+		if pkg.GetLeap() != LeapNotSyn || pkg.GetMode() != ModeServer ||
+			pkg.GetVersion() != VersionV4 || pkg.GetPoll() != 6 {
+			t.Errorf("%s invalid kiss package: %s", code, pkg)
+		}
+	}
+
+	// A package with stratum has no kiss code.
+	var pkg Package
+	pkg.SetStratum(1)
+	pkg.SetReferenceClockId([]byte("RATE"))
+	if code, ok := pkg.GetKissCode(); ok {
+		t.Errorf("kiss code of stratum 1 package: %q", code)
+	}
+}
+
+func TestPackageValidate(t *testing.T) {
+	// Create a decoded request package.
+	newRequest := func(version uint32, mode uint32, transmit bool) *Package {
+		var req Package
+		req.SetVersion(version)
+		req.SetMode(mode)
+		if transmit {
+			req.SetTransmitTimestamp(time.Now())
+		}
+		data, _ := req.ToBytes()
+		if !transmit {
+			// Clear the transmit timestamp encoded from the zero time.
+			clear(data[40:])
+		}
+		pkg, _ := PackageFromBytes(data)
+		return pkg
+	}
+	// Set the stratum of a request package.
+	withStratum := func(pkg *Package, stratum uint32) *Package {
+		pkg.SetStratum(stratum)
+		return pkg
+	}
+
+	// Create test table; each package is accepted by the levels up to
+	// the given level.
+	table := []struct {
+		name  string
+		pkg   *Package
+		upTo  ValidationLevel
+		error error
+	}{
+		{"client v4", newRequest(VersionV4, ModeClient, true),
+			ValidationStrict, nil},
+		{"client v3", newRequest(VersionV3, ModeClient, true),
+			ValidationStrict, nil},
+		{"client v2", newRequest(2, ModeClient, true),
+			ValidationPermissive, ErrInvalidVersion},
+		{"reserved", newRequest(VersionV4, ModeReserved, true),
+			ValidationPermissive, ErrInvalidMode},
+		{"symmetric active", newRequest(VersionV4, ModeSymActive, true),
+			ValidationLenient, ErrInvalidMode},
+		{"no transmit", newRequest(VersionV4, ModeClient, false),
+			ValidationLenient, ErrNoTransmit},
+		{"version 0", newRequest(0, ModeClient, true),
+			ValidationPermissive, ErrInvalidVersion},
+		{"version 7", newRequest(7, ModeClient, true),
+			ValidationPermissive, ErrInvalidVersion},
+		{"server", newRequest(VersionV4, ModeServer, true),
+			ValidationPermissive, ErrInvalidMode},
+		{"broadcast", newRequest(VersionV4, ModeBroadcast, true),
+			ValidationPermissive, ErrInvalidMode},
+		{"stratum 15", withStratum(
+			newRequest(VersionV4, ModeClient, true), MaxStratum),
+			ValidationStrict, nil},
+		{"stratum 16", withStratum(
+			newRequest(VersionV4, ModeClient, true), 16),
+			ValidationPermissive, ErrInvalidStratum},
+		{"stratum 255", withStratum(
+			newRequest(VersionV3, ModeSymActive, true), 255),
+			ValidationPermissive, ErrInvalidStratum},
+	}
+
+	// Validate each package at each level.
+	levels := []ValidationLevel{
+		ValidationPermissive, ValidationLenient, ValidationStrict}
+	for _, e := range table {
+		for _, level := range levels {
+			err := e.pkg.Validate(level)
+			if level <= e.upTo && err != nil {
+				t.Errorf("%s rejected at %s: %s", e.name, level, err)
+			}
+			if level > e.upTo && !errors.Is(err, e.error) {
+				t.Errorf("%s invalid error at %s: want %v get %v",
+					e.name, level, e.error, err)
+			}
+		}
+	}
+}
+
+func TestParseValidationLevel(t *testing.T) {
+	for _, level := range []ValidationLevel{
+		ValidationPermissive, ValidationLenient, ValidationStrict,
+	} {
+		get, err := ParseValidationLevel(level.String())
+		if err != nil || get != level {
+			t.Errorf("%s invalid level: %s %v", level, get, err)
+		}
+	}
+	if _, err := ParseValidationLevel("paranoid"); err == nil {
+		t.Errorf("unknown level parsed")
+	}
+}
+
+func TestUnmarshalBinaryStrict(t *testing.T) {
+	// Create test table; each header is parsed with an error.
+	table := []struct {
+		version uint32
+		mode    uint32
+		error   error
+	}{
+		{VersionV4, ModeClient, nil},
+		{VersionV3, ModeSymActive, nil},
+		{VersionV4, ModeReserved, ErrInvalidMode},
+		{7, ModeClient, ErrInvalidVersion},
+		{0, ModeClient, ErrInvalidVersion},
+	}
+
+	for _, e := range table {
+		var req Package
+		req.SetVersion(e.version)
+		req.SetMode(e.mode)
+		req.SetTransmitTimestamp(time.Now())
+		data, _ := req.ToBytes()
+
+		// The lenient parse accepts every header.
+		if _, err := PackageFromBytes(data); err != nil {
+			t.Errorf("v%d mode %d can not parse: %s",
+				e.version, e.mode, err)
+		}
+		_, err := PackageFromBytesStrict(data)
+		if !errors.Is(err, e.error) {
+			t.Errorf("v%d mode %d invalid error: want %v get %v",
+				e.version, e.mode, e.error, err)
+		}
+	}
+	if _, err := PackageFromBytesStrict(make([]byte, 10)); err == nil {
+		t.Errorf("undersized package parsed")
+	}
+}
+
+func TestPackageJSON(t *testing.T) {
+	// Create a package with all header fields set.
+	var pkg Package
+	precision := int8(-20)
+	pkg.SetLeap(LeapAddSec)
+	pkg.SetVersion(VersionV4)
+	pkg.SetMode(ModeServer)
+	pkg.SetStratum(2)
+	pkg.SetPoll(10)
+	pkg.SetPrecision(uint32(uint8(precision)))
+	pkg.SetRootDelay(0x0001_8000)
+	pkg.SetRootDispersion(0x0000_0042)
+	now := time.Now()
+	pkg.SetReferenceTimestamp(now.Add(-time.Minute))
+	pkg.SetOriginateTimestamp(now.Add(-time.Second))
+	pkg.SetReceiveTimestamp(now.Add(-time.Millisecond))
+	pkg.SetTransmitTimestamp(now)
+
+	// Round trip the package with both kinds of reference id.
+	for _, refId := range []string{"GPS", "192.168.1.10"} {
+		id, _ := parseReferenceId(refId)
+		pkg.SetReferenceClockId(id)
+		data, err := json.Marshal(&pkg)
+		if err != nil {
+			t.Fatalf("%s can not marshal: %s", refId, err)
+		}
+		if !bytes.Contains(data, []byte(`"referenceId":"`+refId+`"`)) {
+			t.Errorf("%s invalid reference id: %s", refId, data)
+		}
+		var get Package
+		err = json.Unmarshal(data, &get)
+		if err != nil {
+			t.Fatalf("%s can not unmarshal: %s", refId, err)
+		}
+		want, _ := pkg.ToBytes()
+		getBytes, _ := get.ToBytes()
+		if !bytes.Equal(want, getBytes) {
+			t.Errorf("%s invalid round trip:\nwant %x\nget  %x",
+				refId, want, getBytes)
+		}
+		if !get.GetTransmitTimestamp().Equal(now) {
+			t.Errorf("%s invalid transmit timestamp: %s",
+				refId, get.GetTransmitTimestamp())
+		}
+	}
+
+	// Invalid fields of a marshaled package are rejected.
+	for field, value := range map[string]any{
+		"leap":              4,
+		"stratum":           256,
+		"referenceId":       "TOOLONG",
+		"transmitTimestamp": "yesterday",
+	} {
+		data, _ := json.Marshal(&pkg)
+		var fields map[string]any
+		_ = json.Unmarshal(data, &fields)
+		fields[field] = value
+		data, _ = json.Marshal(fields)
+		var get Package
+		if err := json.Unmarshal(data, &get); err == nil {
+			t.Errorf("%s=%v unmarshal without error", field, value)
+		}
+	}
+}
+
+func TestQueryStaleReference(t *testing.T) {
+	// Create a fake server, that answers with a reference timestamp of
+	// the given age.
+	conn, err := net.ListenUDP(
+		"udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("can not listen udp: %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	serve := func(age time.Duration) {
+		data := make([]byte, PackageSize)
+		_, addr, err := conn.ReadFromUDP(data)
+		if err != nil {
+			return
+		}
+		req, _ := PackageFromBytes(data)
+		now := time.Now()
+		var pkg Package
+		pkg.SetMode(ModeServer)
+		pkg.SetStratum(2)
+		pkg.SetReferenceTimestamp(now.Add(-age))
+		pkg.SetOriginateTimestamp(req.GetTransmitTimestamp())
+		pkg.SetTransmitTimestamp(now)
+		res, _ := pkg.ToBytes()
+		_, _ = conn.WriteToUDP(res, addr)
+	}
+	addr := conn.LocalAddr().(*net.UDPAddr)
+
+	// Create test table; each reference age maps to stale.
+	table := []struct {
+		age   time.Duration
+		stale bool
+	}{
+		{time.Minute, false},
+		{2 * time.Hour, true},
+	}
+	for _, e := range table {
+		go serve(e.age)
+		result, err := Query(addr.IP.String(), addr.Port, RequestOptions{})
+		if err != nil {
+			t.Fatalf("query err: %s", err)
+		}
+		pkg := result.Package
+		if diff := pkg.ReferenceAge() - e.age; diff.Abs() > time.Millisecond {
+			t.Errorf("invalid reference age: want %s get %s",
+				e.age, pkg.ReferenceAge())
+		}
+		if pkg.IsStale(time.Hour) != e.stale {
+			t.Errorf("%s invalid stale: want %t", e.age, e.stale)
+		}
+	}
+
+	// A package without reference timestamp is stale.
+	var pkg Package
+	pkg.SetTransmitTimestamp(time.Now())
+	if !pkg.IsStale(time.Hour) {
+		t.Errorf("package without reference timestamp not stale")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	// Create a fake server, that answers with a clock ahead by an hour
+	// and the leap indicator set.
+	conn, err := net.ListenUDP(
+		"udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("can not listen udp: %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		data := make([]byte, PackageSize)
+		_, addr, err := conn.ReadFromUDP(data)
+		if err != nil {
+			return
+		}
+		req, _ := PackageFromBytes(data)
+		now := time.Now().Add(time.Hour)
+		var pkg Package
+		pkg.SetMode(ModeServer)
+		pkg.SetStratum(2)
+		pkg.SetLeap(LeapAddSec)
+		pkg.SetOriginateTimestamp(req.GetTransmitTimestamp())
+		pkg.SetReceiveTimestamp(now)
+		pkg.SetTransmitTimestamp(now)
+		res, _ := pkg.ToBytes()
+		_, _ = conn.WriteToUDP(res, addr)
+	}()
+
+	// Query the server.
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	result, err := Query(addr.IP.String(), addr.Port, RequestOptions{})
+	if err != nil {
+		t.Fatalf("query err: %s", err)
+	}
+	if result.Stratum != 2 || result.LeapIndicator != LeapAddSec {
+		t.Errorf("invalid stratum %d or leap indicator %d",
+			result.Stratum, result.LeapIndicator)
+	}
+
+	// The client times are taken around the request.
+	if result.T4.Before(result.T1) {
+		t.Errorf("receive time %s before transmit time %s",
+			result.T4, result.T1)
+	}
+	origin := result.Package.GetOriginateTimestamp()
+	if diff := origin.Sub(result.T1); diff.Abs() > DefaultOriginTolerance {
+		t.Errorf("transmit time %s not echoed", result.T1)
+	}
+
+	// Offset and delay are computed from the four timestamps.
+	wantOffset := (result.T2.Sub(result.T1) + result.T3.Sub(result.T4)) / 2
+	wantRTT := result.T4.Sub(result.T1) - result.T3.Sub(result.T2)
+	if result.Offset != wantOffset || result.RTT != wantRTT {
+		t.Errorf("invalid offset %s or rtt %s: want %s and %s",
+			result.Offset, result.RTT, wantOffset, wantRTT)
+	}
+	if diff := result.Offset - time.Hour; diff.Abs() > 10*time.Millisecond {
+		t.Errorf("invalid offset: want %s get %s", time.Hour, result.Offset)
+	}
+}
+
+func TestQueryResultJSON(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var pkg Package
+	pkg.SetMode(ModeServer)
+	pkg.SetStratum(2)
+	result := QueryResult{
+		Package:       &pkg,
+		Offset:        1500 * time.Millisecond,
+		RTT:           20 * time.Millisecond,
+		Dispersion:    time.Microsecond,
+		Stratum:       2,
+		LeapIndicator: LeapAddSec,
+		T1:            t1,
+		T2:            t1.Add(time.Second),
+		T3:            t1.Add(time.Second),
+		T4:            t1.Add(20 * time.Millisecond),
+	}
+	data, err := json.Marshal(&result)
+	if err != nil {
+		t.Fatalf("marshal err: %s", err)
+	}
+
+	// Decode the document generic to check the exact shape.
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("unmarshal err: %s", err)
+	}
+	want := map[string]any{
+		"offset":        1.5,
+		"rtt":           0.02,
+		"dispersion":    1e-6,
+		"stratum":       2.0,
+		"leapIndicator": float64(LeapAddSec),
+		"t1":            "2024-01-01T00:00:00Z",
+		"t2":            "2024-01-01T00:00:01Z",
+		"t3":            "2024-01-01T00:00:01Z",
+		"t4":            "2024-01-01T00:00:00.02Z",
+	}
+	for key, value := range want {
+		if doc[key] != value {
+			t.Errorf("invalid %s: want %v get %v", key, value, doc[key])
+		}
+	}
+	if len(doc) != len(want)+1 {
+		t.Errorf("invalid number of fields: %d", len(doc))
+	}
+	packageDoc, ok := doc["package"].(map[string]any)
+	if !ok || packageDoc["stratum"] != 2.0 {
+		t.Errorf("invalid package: %v", doc["package"])
+	}
+}
+
+func TestComputeMetrics(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := time.Millisecond
+
+	// Create test table; the server clock is ahead by offset, the request
+	// takes up and the response down, the server holds the request for
+	// hold.
+	table := []struct {
+		offset time.Duration
+		up     time.Duration
+		down   time.Duration
+		hold   time.Duration
+	}{
+		{0, 10 * ms, 10 * ms, 0},
+		{500 * ms, 10 * ms, 10 * ms, 1 * ms},
+		{-2 * time.Second, 5 * ms, 5 * ms, 3 * ms},
+		{100 * ms, 20 * ms, 10 * ms, 0},
+	}
+
+	for _, e := range table {
+		t2 := t1.Add(e.up).Add(e.offset)
+		t3 := t2.Add(e.hold)
+		t4 := t3.Add(-e.offset).Add(e.down)
+		m := QueryResult{T1: t1, T2: t2, T3: t3, T4: t4}
+		m.computeMetrics(-20)
+
+		// An asymmetric path adds half its difference to the offset.
+		wantOffset := e.offset + (e.up-e.down)/2
+		if m.Offset != wantOffset {
+			t.Errorf("%s invalid offset: want %s get %s",
+				e.offset, wantOffset, m.Offset)
+		}
+		if m.RTT != e.up+e.down {
+			t.Errorf("%s invalid delay: want %s get %s",
+				e.offset, e.up+e.down, m.RTT)
+		}
+		// The dispersion is the precision and the frequency tolerance
+		// over the elapsed time.
+		elapsed := t4.Sub(t1)
+		precision := 1e9 / float64(1<<20)
+		want := time.Duration(precision) +
+			time.Duration(15e-6*float64(elapsed))
+		if m.Dispersion != want {
+			t.Errorf("%s invalid dispersion: want %s get %s",
+				e.offset, want, m.Dispersion)
+		}
+	}
+}
+
+func TestPackageCorrectLeapStratum(t *testing.T) {
+	// Create test table; each leap and stratum maps to the corrected
+	// stratum.
+	table := []struct {
+		leap      uint32
+		stratum   uint32
+		want      uint32
+		corrected bool
+	}{
+		{LeapNotSyn, 1, UnsyncStratum, true},
+		{LeapNotSyn, MaxStratum, UnsyncStratum, true},
+		{LeapNotSyn, 0, 0, false},
+		{LeapNotSyn, UnsyncStratum, UnsyncStratum, false},
+		{LeapNotSet, 1, 1, false},
+		{LeapAddSec, 2, 2, false},
+	}
+	for _, e := range table {
+		var pkg Package
+		pkg.SetLeap(e.leap)
+		pkg.SetStratum(e.stratum)
+		corrected := pkg.CorrectLeapStratum()
+		if corrected != e.corrected || pkg.GetStratum() != e.want {
+			t.Errorf("leap %d stratum %d: want %d (%t) get %d (%t)",
+				e.leap, e.stratum, e.want, e.corrected,
+				pkg.GetStratum(), corrected)
+		}
+		if pkg.GetLeap() != e.leap {
+			t.Errorf("leap %d changed to %d", e.leap, pkg.GetLeap())
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ntp
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// ErrNoSamples is returned by QueryN, when not a single sample could be
+// queried.
+var ErrNoSamples = errors.New("ntp no samples requested")
+
+// SampleStats are the statistics of multiple samples of a remote host. A
+// sample with a round trip delay of more than twice the median delay is
+// an outlier, that is delayed by queuing in the network. The Best sample
+// is the sample with the minimum delay. The MedianOffset and the Jitter,
+// the root mean square of the offset differences to the Best sample, are
+// computed from the Samples without outliers.
+type SampleStats struct {
+	Samples      []*QueryResult
+	Outliers     []*QueryResult
+	Failed       int
+	Best         *QueryResult
+	MedianOffset time.Duration
+	Jitter       time.Duration
+}
+
+// QueryN query remote host n times with opts and interval between the
+// queries and compute the SampleStats of the samples. A single sample is
+// noisy, because queuing in the network adds to the delay and skews the
+// offset. The sample with the minimum delay has the least error, so it is
+// the Best sample. A failed query is counted, but does not abort the
+// sampling. When all queries fail, the error of the last query is returned.
+// The sampling stops, when ctx is done.
+func QueryN(
+	ctx context.Context,
+	host string,
+	port, n int,
+	interval time.Duration,
+	opts RequestOptions,
+) (*SampleStats, error) {
+	results, failed, err := querySamples(
+		ctx, host, port, n, interval, opts)
+	if err != nil {
+		return nil, err
+	}
+	stats := computeSampleStats(results)
+	stats.Failed = failed
+	return stats, nil
+}
+
+// Query n samples from remote host with opts and interval between the
+// queries and return the successful samples and the number of failed queries. When no
+// query succeeds, the error of the last query is returned. The sampling
+// stops, when ctx is done.
+func querySamples(
+	ctx context.Context,
+	host string,
+	port, n int,
+	interval time.Duration,
+	opts RequestOptions,
+) ([]*QueryResult, int, error) {
+	results := make([]*QueryResult, 0, max(n, 0))
+	failed := 0
+	err := ErrNoSamples
+	for i := 0; i < n; i++ {
+		// Wait interval between the queries.
+		if i > 0 {
+			timer := time.NewTimer(interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, failed, ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, failed, ctx.Err()
+		}
+
+		// Skip failed queries.
+		var result *QueryResult
+		result, err = Query(host, port, opts)
+		if err != nil {
+			failed++
+			continue
+		}
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		return nil, failed, err
+	}
+	return results, failed, nil
+}
+
+// Get the sample with the minimum delay of samples.
+func bestSample(samples []*QueryResult) *QueryResult {
+	return slices.MinFunc(samples, func(a, b *QueryResult) int {
+		return cmp.Compare(a.RTT, b.RTT)
+	})
+}
+
+// Get the median of durations. The durations are sorted in place.
+func medianDuration(durations []time.Duration) time.Duration {
+	slices.Sort(durations)
+	mid := len(durations) / 2
+	if len(durations)%2 == 0 {
+		return (durations[mid-1] + durations[mid]) / 2
+	}
+	return durations[mid]
+}
+
+// Compute the SampleStats of samples. At least one sample is required.
+func computeSampleStats(samples []*QueryResult) *SampleStats {
+	stats := SampleStats{Best: bestSample(samples)}
+
+	// Discard samples with more than twice the median delay. The best
+	// sample is kept, also with a negative median delay.
+	delays := make([]time.Duration, 0, len(samples))
+	for _, sample := range samples {
+		delays = append(delays, sample.RTT)
+	}
+	limit := 2 * medianDuration(delays)
+	for _, sample := range samples {
+		if sample.RTT > limit && sample != stats.Best {
+			stats.Outliers = append(stats.Outliers, sample)
+		} else {
+			stats.Samples = append(stats.Samples, sample)
+		}
+	}
+
+	// Compute median offset and jitter of the remaining samples.
+	offsets := make([]time.Duration, 0, len(stats.Samples))
+	var squares float64
+	for _, sample := range stats.Samples {
+		offsets = append(offsets, sample.Offset)
+		diff := (sample.Offset - stats.Best.Offset).Seconds()
+		squares += diff * diff
+	}
+	stats.MedianOffset = medianDuration(offsets)
+	stats.Jitter = time.Duration(
+		math.Sqrt(squares/float64(len(stats.Samples))) * float64(time.Second))
+	return &stats
+}
+
+// SamplesCSVHeader is the header row of WriteSamplesCSV.
+var SamplesCSVHeader = []string{"timestamp", "offset", "delay", "stratum"}
+
+// WriteSamplesCSV write samples as CSV with a SamplesCSVHeader row to w.
+// Each sample is a row with the client receive time in RFC3339 format, the
+// offset and the round trip delay in seconds and the stratum. The rows are
+// ordered by the client receive time, so that the samples and outliers of
+// SampleStats can be written together.
+func WriteSamplesCSV(w io.Writer, samples []*QueryResult) error {
+	samples = slices.Clone(samples)
+	slices.SortFunc(samples, func(a, b *QueryResult) int {
+		return a.T4.Compare(b.T4)
+	})
+	writer := csv.NewWriter(w)
+	if err := writer.Write(SamplesCSVHeader); err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		err := writer.Write([]string{
+			sample.T4.Format(time.RFC3339Nano),
+			strconv.FormatFloat(sample.Offset.Seconds(), 'f', -1, 64),
+			strconv.FormatFloat(sample.RTT.Seconds(), 'f', -1, 64),
+			strconv.FormatUint(uint64(sample.Stratum), 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,230 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ntp
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A sample answered by the fake server of TestQueryNBest.
+type fakeSample struct {
+	delay  time.Duration
+	offset time.Duration
+}
+
+func TestQueryNBest(t *testing.T) {
+	// Create test table; each sample is answered after delay, with the
+	// server clock ahead by offset.
+	table := []fakeSample{
+		{30 * time.Millisecond, time.Second},
+		{0, 100 * time.Millisecond},
+		{20 * time.Millisecond, -time.Second},
+	}
+
+	// Create a fake server, that answers the samples in order.
+	conn, err := net.ListenUDP(
+		"udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("can not listen udp: %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		for _, e := range table {
+			data := make([]byte, PackageSize)
+			_, addr, err := conn.ReadFromUDP(data)
+			if err != nil {
+				return
+			}
+			// The delay is added before the server receive time, so
+			// it counts to the network delay.
+			time.Sleep(e.delay)
+			req, _ := PackageFromBytes(data)
+			now := time.Now().Add(e.offset)
+			var pkg Package
+			pkg.SetMode(ModeServer)
+			pkg.SetStratum(2)
+			pkg.SetOriginateTimestamp(req.GetTransmitTimestamp())
+			pkg.SetReceiveTimestamp(now)
+			pkg.SetTransmitTimestamp(now)
+			res, _ := pkg.ToBytes()
+			_, _ = conn.WriteToUDP(res, addr)
+		}
+	}()
+
+	// The sample with the minimum delay wins.
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	stats, err := QueryN(context.Background(), addr.IP.String(),
+		addr.Port, len(table), time.Millisecond, RequestOptions{})
+	if err != nil {
+		t.Fatalf("query samples err: %s", err)
+	}
+	best := stats.Best
+	if best.RTT >= 20*time.Millisecond {
+		t.Errorf("sample with delay %s is best", best.RTT)
+	}
+	want := table[1].offset
+	if diff := best.Offset - want; diff.Abs() > 10*time.Millisecond {
+		t.Errorf("invalid offset: want %s get %s", want, best.Offset)
+	}
+}
+
+func TestQueryNCancel(t *testing.T) {
+	// A done context stops the sampling before a query.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := QueryN(ctx, "127.0.0.1", 1, 3, time.Second, RequestOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("invalid error: want %s get %v", context.Canceled, err)
+	}
+
+	// Without samples, an error is returned.
+	_, err = QueryN(
+		context.Background(), "127.0.0.1", 1, 0, 0, RequestOptions{})
+	if !errors.Is(err, ErrNoSamples) {
+		t.Errorf("invalid error: want %s get %v", ErrNoSamples, err)
+	}
+}
+
+func TestQueryN(t *testing.T) {
+	// Create test table; each sample is answered after delay, with the
+	// server clock ahead by offset. The sample with a negative delay is
+	// answered with a short response and fails.
+	table := []fakeSample{
+		{10 * time.Millisecond, 10 * time.Millisecond},
+		{-1, 0},
+		{0, 0},
+		{10 * time.Millisecond, 20 * time.Millisecond},
+		{100 * time.Millisecond, time.Second},
+	}
+
+	// Create a fake server, that answers the samples in order.
+	conn, err := net.ListenUDP(
+		"udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("can not listen udp: %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		for _, e := range table {
+			data := make([]byte, PackageSize)
+			_, addr, err := conn.ReadFromUDP(data)
+			if err != nil {
+				return
+			}
+			if e.delay < 0 {
+				_, _ = conn.WriteToUDP(data[:8], addr)
+				continue
+			}
+			time.Sleep(e.delay)
+			req, _ := PackageFromBytes(data)
+			now := time.Now().Add(e.offset)
+			var pkg Package
+			pkg.SetMode(ModeServer)
+			pkg.SetStratum(2)
+			pkg.SetOriginateTimestamp(req.GetTransmitTimestamp())
+			pkg.SetReceiveTimestamp(now)
+			pkg.SetTransmitTimestamp(now)
+			res, _ := pkg.ToBytes()
+			_, _ = conn.WriteToUDP(res, addr)
+		}
+	}()
+
+	// The failed sample must not abort the sampling.
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	stats, err := QueryN(context.Background(), addr.IP.String(),
+		addr.Port, len(table), time.Millisecond, RequestOptions{})
+	if err != nil {
+		t.Fatalf("query samples err: %s", err)
+	}
+	if stats.Failed != 1 {
+		t.Errorf("invalid failed samples: want 1 get %d", stats.Failed)
+	}
+	if len(stats.Samples)+len(stats.Outliers) != len(table)-1 {
+		t.Errorf("invalid samples: %d and %d outliers",
+			len(stats.Samples), len(stats.Outliers))
+	}
+
+	// The sample without delay wins; the slowest sample is an outlier.
+	if stats.Best.Offset.Abs() > 5*time.Millisecond {
+		t.Errorf("invalid best offset: %s", stats.Best.Offset)
+	}
+	if len(stats.Outliers) != 1 ||
+		stats.Outliers[0].RTT < 100*time.Millisecond {
+		t.Errorf("slow sample is no outlier: %v", stats.Outliers)
+	}
+}
+
+func TestComputeSampleStats(t *testing.T) {
+	ms := time.Millisecond
+	samples := []*QueryResult{
+		{Offset: 4 * ms, RTT: 10 * ms},
+		{Offset: 1 * ms, RTT: 2 * ms},
+		{Offset: 3 * ms, RTT: 4 * ms},
+		{Offset: 100 * ms, RTT: 50 * ms},
+		{Offset: 2 * ms, RTT: 3 * ms},
+	}
+	stats := computeSampleStats(samples)
+
+	// The median delay is 4ms, so that 10ms and 50ms are outliers.
+	if stats.Best != samples[1] {
+		t.Errorf("invalid best sample: %+v", stats.Best)
+	}
+	if len(stats.Outliers) != 2 || len(stats.Samples) != 3 {
+		t.Errorf("invalid outliers: %d of %d samples",
+			len(stats.Outliers), len(samples))
+	}
+	if stats.MedianOffset != 2*ms {
+		t.Errorf("invalid median offset: want %s get %s",
+			2*ms, stats.MedianOffset)
+	}
+
+	// The jitter is sqrt((0² + 2² + 1²) / 3) ms.
+	want := time.Duration(math.Sqrt(5.0/3.0) * float64(ms))
+	if diff := stats.Jitter - want; diff.Abs() > time.Microsecond {
+		t.Errorf("invalid jitter: want %s get %s", want, stats.Jitter)
+	}
+}
+
+func TestWriteSamplesCSV(t *testing.T) {
+	base := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	samples := []*QueryResult{
+		{T4: base.Add(time.Second), Offset: -1500 * time.Microsecond,
+			RTT: 2 * time.Millisecond, Stratum: 2},
+		{T4: base, Offset: 250 * time.Millisecond,
+			RTT: 10 * time.Millisecond, Stratum: 1},
+	}
+	var b strings.Builder
+	if err := WriteSamplesCSV(&b, samples); err != nil {
+		t.Fatalf("can not write csv: %s", err)
+	}
+
+	// Parse the csv; the rows are ordered by time.
+	records, err := csv.NewReader(strings.NewReader(b.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("can not parse csv: %s", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("invalid number of rows: %d", len(records))
+	}
+	if !slices.Equal(records[0], SamplesCSVHeader) {
+		t.Errorf("invalid header: %v", records[0])
+	}
+	want := []string{"2024-01-01T00:00:00Z", "0.25", "0.01", "1"}
+	if !slices.Equal(records[1], want) {
+		t.Errorf("invalid row: want %v get %v", want, records[1])
+	}
+	want = []string{"2024-01-01T00:00:01Z", "-0.0015", "0.002", "2"}
+	if !slices.Equal(records[2], want) {
+		t.Errorf("invalid row: want %v get %v", want, records[2])
+	}
+}
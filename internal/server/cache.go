@@ -0,0 +1,111 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"container/list"
+	"time"
+)
+
+// An entry of a TTLCache.
+type cacheEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// TTLCache is a cache with a bounded size, where each entry expires a TTL
+// after it is set. When the cache is full, the oldest entry is evicted to
+// set a new one. This bounds the memory of per client state under a large
+// client population. The cache is not safe for concurrent use.
+type TTLCache[K comparable, V any] struct {
+	ttl     time.Duration
+	maxSize int
+	entries map[K]*list.Element
+	order   *list.List // Entries ordered by expiry; oldest first.
+	now     func() time.Time
+}
+
+// NewTTLCache create a new TTLCache with maxSize entries, that expire ttl
+// after they are set. A maxSize of zero is unlimited.
+func NewTTLCache[K comparable, V any](
+	ttl time.Duration,
+	maxSize int,
+) *TTLCache[K, V] {
+	return &TTLCache[K, V]{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[K]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Get the value of key. An expired entry is not returned.
+func (c *TTLCache[K, V]) Get(key K) (V, bool) {
+	elem, ok := c.entries[key]
+	if !ok || c.expired(elem, c.now()) {
+		var zero V
+		return zero, false
+	}
+	return elem.Value.(*cacheEntry[K, V]).value, true
+}
+
+// Set the value of key. The entry expires ttl from now. Expired entries
+// are evicted and when the cache is full, the oldest entry is evicted.
+func (c *TTLCache[K, V]) Set(key K, value V) {
+	now := c.now()
+	c.evictExpired(now)
+	// Move an existing entry to the end of the expiry order.
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry[K, V])
+		entry.value = value
+		entry.expires = now.Add(c.ttl)
+		c.order.MoveToBack(elem)
+		return
+	}
+	if c.maxSize > 0 && len(c.entries) >= c.maxSize {
+		c.remove(c.order.Front())
+	}
+	c.entries[key] = c.order.PushBack(&cacheEntry[K, V]{
+		key:     key,
+		value:   value,
+		expires: now.Add(c.ttl),
+	})
+}
+
+// Delete the entry of key.
+func (c *TTLCache[K, V]) Delete(key K) {
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// Len get the number of entries, including expired entries, that are not
+// evicted yet.
+func (c *TTLCache[K, V]) Len() int {
+	return len(c.entries)
+}
+
+// Check whether the entry of elem is expired at now.
+func (c *TTLCache[K, V]) expired(elem *list.Element, now time.Time) bool {
+	return !now.Before(elem.Value.(*cacheEntry[K, V]).expires)
+}
+
+// Evict the expired entries. All entries have the same ttl, so the
+// expired entries are at the front of the order.
+func (c *TTLCache[K, V]) evictExpired(now time.Time) {
+	for elem := c.order.Front(); elem != nil && c.expired(elem, now); {
+		next := elem.Next()
+		c.remove(elem)
+		elem = next
+	}
+}
+
+// Remove the entry of elem.
+func (c *TTLCache[K, V]) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry[K, V]).key)
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLCacheSizeEviction(t *testing.T) {
+	cache := NewTTLCache[string, int](time.Hour, 2)
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	// Setting an existing key does not evict.
+	cache.Set("a", 3)
+	if cache.Len() != 2 {
+		t.Fatalf("invalid length: %d", cache.Len())
+	}
+	// A new key evicts the oldest entry.
+	cache.Set("c", 4)
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("oldest entry not evicted")
+	}
+	if v, ok := cache.Get("a"); !ok || v != 3 {
+		t.Errorf("invalid value of updated entry: %d %t", v, ok)
+	}
+	if v, ok := cache.Get("c"); !ok || v != 4 {
+		t.Errorf("invalid value of new entry: %d %t", v, ok)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("invalid length after eviction: %d", cache.Len())
+	}
+}
+
+func TestTTLCacheExpiry(t *testing.T) {
+	now := time.Now()
+	cache := NewTTLCache[string, int](time.Minute, 0)
+	cache.now = func() time.Time { return now }
+	cache.Set("a", 1)
+	now = now.Add(30 * time.Second)
+	cache.Set("b", 2)
+
+	// The first entry expires a ttl after it is set.
+	now = now.Add(30 * time.Second)
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("expired entry returned")
+	}
+	if _, ok := cache.Get("b"); !ok {
+		t.Errorf("entry expired before ttl")
+	}
+	// Expired entries are evicted on the next set.
+	cache.Set("c", 3)
+	if cache.Len() != 2 {
+		t.Errorf("expired entry not evicted: %d", cache.Len())
+	}
+	// Setting an entry again extends its ttl.
+	cache.Set("b", 4)
+	now = now.Add(45 * time.Second)
+	if v, ok := cache.Get("b"); !ok || v != 4 {
+		t.Errorf("invalid value of refreshed entry: %d %t", v, ok)
+	}
+	cache.Delete("b")
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("deleted entry returned")
+	}
+}
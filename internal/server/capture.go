@@ -0,0 +1,204 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// CaptureMagic is written at the start of each capture file.
+const CaptureMagic = "ZGCAP\x00\x00\x01"
+
+// DefaultCaptureSize is the default maximum size of a capture file.
+const DefaultCaptureSize = 16 << 20
+
+// ErrInvalidCapture is returned by ReadCapture for a file, that is not a
+// capture file or has a truncated record.
+var ErrInvalidCapture = errors.New("invalid capture file")
+
+// CaptureRecord is a response packet captured by a CaptureWriter.
+type CaptureRecord struct {
+	Client net.IP    // ip address of the client.
+	Time   time.Time // Time the response was sent.
+	Data   []byte    // The encoded response package.
+}
+
+// CaptureWriter appends the served response packets to a capture file for
+// offline analysis, without a separate packet capture tool. A record is
+// framed by the length of the client ip address, the ip address, the send
+// time as unix nanoseconds, the length of the packet and the packet, all
+// in network byte order. When the file exceeds its maximum size, it is
+// rotated. The writer is safe for concurrent use.
+type CaptureWriter struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// NewCaptureWriter create a new CaptureWriter, that appends to the capture
+// file at path. When a record would exceed maxSize bytes, the file is
+// rotated to path.1 and the older files are shifted up to path.maxFiles.
+// With maxFiles of zero, a full file is replaced. A maxSize of zero is
+// unlimited.
+func NewCaptureWriter(
+	path string,
+	maxSize int64,
+	maxFiles int,
+) (*CaptureWriter, error) {
+	w := &CaptureWriter{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: max(maxFiles, 0),
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Open the capture file for append. A new file starts with CaptureMagic.
+func (w *CaptureWriter) open() error {
+	file, err := os.OpenFile(
+		w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	w.file, w.size = file, info.Size()
+	if w.size == 0 {
+		n, err := file.WriteString(CaptureMagic)
+		w.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rotate the capture file and open a new file. When the rotation fails,
+// the writer is closed.
+func (w *CaptureWriter) rotate() error {
+	err := w.file.Close()
+	w.file = nil
+	if err != nil {
+		return err
+	}
+	// Shift the rotated files; the oldest file is overwritten.
+	if w.maxFiles == 0 {
+		if err := os.Remove(w.path); err != nil {
+			return err
+		}
+	}
+	for idx := w.maxFiles - 1; idx >= 0; idx-- {
+		src := w.rotatedPath(idx)
+		err := os.Rename(src, w.rotatedPath(idx+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return w.open()
+}
+
+// Get the path of the capture file rotated idx times.
+func (w *CaptureWriter) rotatedPath(idx int) string {
+	if idx == 0 {
+		return w.path
+	}
+	return fmt.Sprintf("%s.%d", w.path, idx)
+}
+
+// Write the response packet data sent to the client with ip at t.
+func (w *CaptureWriter) Write(ip net.IP, t time.Time, data []byte) error {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	record := make([]byte, 0, 1+len(ip)+8+2+len(data))
+	record = append(record, byte(len(ip)))
+	record = append(record, ip...)
+	record = binary.BigEndian.AppendUint64(record, uint64(t.UnixNano()))
+	record = binary.BigEndian.AppendUint16(record, uint16(len(data)))
+	record = append(record, data...)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	// Rotate a full file; a single record is always written.
+	if w.maxSize > 0 && w.size > int64(len(CaptureMagic)) &&
+		w.size+int64(len(record)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(record)
+	w.size += int64(n)
+	return err
+}
+
+// Close the capture file.
+func (w *CaptureWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// ReadCapture read all records of a capture file from r.
+func ReadCapture(r io.Reader) ([]CaptureRecord, error) {
+	reader := bufio.NewReader(r)
+	magic := make([]byte, len(CaptureMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil ||
+		string(magic) != CaptureMagic {
+		return nil, ErrInvalidCapture
+	}
+
+	var records []CaptureRecord
+	for {
+		ipLen, err := reader.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		if ipLen != net.IPv4len && ipLen != net.IPv6len {
+			return records, ErrInvalidCapture
+		}
+		header := make([]byte, int(ipLen)+8+2)
+		if _, err = io.ReadFull(reader, header); err != nil {
+			return records, ErrInvalidCapture
+		}
+		record := CaptureRecord{
+			Client: net.IP(header[:ipLen]),
+			Time: time.Unix(0,
+				int64(binary.BigEndian.Uint64(header[ipLen:]))),
+			Data: make([]byte, binary.BigEndian.Uint16(header[ipLen+8:])),
+		}
+		if _, err = io.ReadFull(reader, record.Data); err != nil {
+			return records, ErrInvalidCapture
+		}
+		records = append(records, record)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/ntp"
+)
+
+// Read all records of the capture file at path.
+func readTestCapture(t *testing.T, path string) []CaptureRecord {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("can not open capture: %s", err)
+	}
+	defer file.Close()
+	records, err := ReadCapture(file)
+	if err != nil {
+		t.Fatalf("can not read capture: %s", err)
+	}
+	return records
+}
+
+func TestCaptureWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "responses.cap")
+	w, err := NewCaptureWriter(path, 0, 0)
+	if err != nil {
+		t.Fatalf("can not create capture: %s", err)
+	}
+
+	// Create test table; each record is written in order.
+	now := time.Now()
+	table := []CaptureRecord{
+		{net.ParseIP("192.168.1.10"), now, bytes.Repeat([]byte{1}, 48)},
+		{net.ParseIP("2001:db8::1"), now.Add(time.Second),
+			bytes.Repeat([]byte{2}, 68)},
+	}
+	for _, e := range table {
+		if err = w.Write(e.Client, e.Time, e.Data); err != nil {
+			t.Fatalf("can not write record: %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("can not close capture: %s", err)
+	}
+	if err = w.Write(table[0].Client, now, nil); err == nil {
+		t.Errorf("record written to closed capture")
+	}
+
+	// The records are re-readable.
+	records := readTestCapture(t, path)
+	if len(records) != len(table) {
+		t.Fatalf("invalid number of records: want %d get %d",
+			len(table), len(records))
+	}
+	for idx, e := range table {
+		record := records[idx]
+		if !record.Client.Equal(e.Client) || !record.Time.Equal(e.Time) ||
+			!bytes.Equal(record.Data, e.Data) {
+			t.Errorf("invalid record %d: %+v", idx, record)
+		}
+	}
+
+	// A reopened capture is appended.
+	w, _ = NewCaptureWriter(path, 0, 0)
+	_ = w.Write(table[0].Client, now, table[0].Data)
+	_ = w.Close()
+	if records = readTestCapture(t, path); len(records) != 3 {
+		t.Errorf("capture not appended: %d records", len(records))
+	}
+
+	// A file without magic or with a truncated record is invalid.
+	for _, data := range []string{"pcap", CaptureMagic + "\x04\x7f"} {
+		_, err = ReadCapture(strings.NewReader(data))
+		if !errors.Is(err, ErrInvalidCapture) {
+			t.Errorf("%q invalid error: %v", data, err)
+		}
+	}
+}
+
+func TestCaptureWriterRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "responses.cap")
+	// A record of a IPv4 client and a 48 bytes package has 63 bytes, so
+	// that two records fit into a file.
+	size := int64(len(CaptureMagic) + 2*63)
+	w, err := NewCaptureWriter(path, size, 1)
+	if err != nil {
+		t.Fatalf("can not create capture: %s", err)
+	}
+	ip := net.ParseIP("10.0.0.1")
+	for i := 0; i < 7; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, ntp.PackageSize)
+		if err = w.Write(ip, time.Now(), data); err != nil {
+			t.Fatalf("can not write record: %s", err)
+		}
+	}
+	_ = w.Close()
+
+	// The current file has the last record and the rotated file the two
+	// records before; older records are dropped.
+	current := readTestCapture(t, path)
+	rotated := readTestCapture(t, path+".1")
+	if len(current) != 1 || current[0].Data[0] != 6 {
+		t.Errorf("invalid current records: %v", current)
+	}
+	if len(rotated) != 2 || rotated[0].Data[0] != 4 {
+		t.Errorf("invalid rotated records: %v", rotated)
+	}
+	if _, err = os.Stat(path + ".2"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("too many rotated files: %v", err)
+	}
+}
+
+// TestCaptureWriterRotateError test that the writer is closed, when the
+// capture file can not be rotated.
+func TestCaptureWriterRotateError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "responses.cap")
+	// A directory, that is not empty, can not be replaced by the rotated
+	// file.
+	if err := os.MkdirAll(filepath.Join(path+".1", "dir"), 0o755); err != nil {
+		t.Fatalf("can not create directory: %s", err)
+	}
+	size := int64(len(CaptureMagic) + 63)
+	w, err := NewCaptureWriter(path, size, 1)
+	if err != nil {
+		t.Fatalf("can not create capture: %s", err)
+	}
+	ip := net.ParseIP("10.0.0.1")
+	data := bytes.Repeat([]byte{1}, ntp.PackageSize)
+	if err = w.Write(ip, time.Now(), data); err != nil {
+		t.Fatalf("can not write record: %s", err)
+	}
+
+	// The second record rotates the file and fails.
+	if err = w.Write(ip, time.Now(), data); err == nil {
+		t.Fatalf("record written without rotation")
+	}
+	if err = w.Write(ip, time.Now(), data); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("invalid error after rotation: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Errorf("can not close capture: %s", err)
+	}
+
+	// The records before the rotation are kept.
+	if records := readTestCapture(t, path); len(records) != 1 {
+		t.Errorf("invalid number of records: %d", len(records))
+	}
+}
+
+// TestHandleRequestCapture test that a sent response is captured.
+func TestHandleRequestCapture(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+	path := filepath.Join(t.TempDir(), "responses.cap")
+	capture, err := NewCaptureWriter(path, DefaultCaptureSize, 0)
+	if err != nil {
+		t.Fatalf("can not create capture: %s", err)
+	}
+	s := newTestServer()
+	s.SetCaptureWriter(capture)
+
+	data, _ := newTestRequest().ToBytes()
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	_ = clientConn.SetReadDeadline(time.Now().Add(time.Second))
+	response := make([]byte, ntp.PackageSize+ntp.MaxMACSize)
+	n, err := clientConn.Read(response)
+	if err != nil {
+		t.Fatalf("can not read response: %s", err)
+	}
+	_ = capture.Close()
+
+	records := readTestCapture(t, path)
+	if len(records) != 1 || !records[0].Client.Equal(clientAddr.IP) {
+		t.Fatalf("invalid records: %v", records)
+	}
+	if !bytes.Equal(records[0].Data, response[:n]) {
+		t.Errorf("captured package differs from response")
+	}
+}
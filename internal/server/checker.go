@@ -0,0 +1,187 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/ntp"
+)
+
+var (
+	// ErrNotServing is reported by NtpServerChecker before Serve runs.
+	ErrNotServing = errors.New("ntp server is not serving")
+	// ErrServerShutdown is reported by NtpServerChecker after Shutdown.
+	ErrServerShutdown = errors.New("ntp server is shut down")
+	// ErrUnexpectedStop is reported by NtpServerChecker, when the
+	// connections of the server are closed without Shutdown.
+	ErrUnexpectedStop = errors.New("ntp server stopped unexpectedly")
+	// ErrNotChecked is reported by UpstreamChecker before the first
+	// check of the upstream server.
+	ErrNotChecked = errors.New("upstream server not checked")
+)
+
+// NtpServerChecker implements the Healthy interface of the health
+// endpoint. The checker is healthy, while the Server serves requests. It
+// records when the last request was answered. The checker is safe for
+// concurrent use.
+type NtpServerChecker struct {
+	mu          sync.Mutex
+	err         error     // the reason the server is not serving.
+	lastRequest time.Time // time of the last answered request.
+}
+
+// NewNtpServerChecker create a new NtpServerChecker, that is unhealthy
+// until the Server serves.
+func NewNtpServerChecker() *NtpServerChecker {
+	return &NtpServerChecker{err: ErrNotServing}
+}
+
+// SetServing mark the server as serving.
+func (c *NtpServerChecker) SetServing() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = nil
+}
+
+// SetStopped mark the server as stopped with the reason err. A nil err
+// is reported as ErrUnexpectedStop.
+func (c *NtpServerChecker) SetStopped(err error) {
+	if err == nil {
+		err = ErrUnexpectedStop
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+// SetHandled record that a request was answered at t.
+func (c *NtpServerChecker) SetHandled(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastRequest = t
+}
+
+// LastRequest get the time of the last answered request. Before the first
+// request, the zero time is returned.
+func (c *NtpServerChecker) LastRequest() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastRequest
+}
+
+// IsHealthy implements Healthy.IsHealthy interface.
+func (c *NtpServerChecker) IsHealthy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err == nil
+}
+
+// Error implements Healthy.error interface.
+func (c *NtpServerChecker) Error() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		return ""
+	}
+	return c.err.Error()
+}
+
+// UpstreamChecker implements the Healthy interface of the health endpoint.
+// The checker is healthy, when the upstream ntp server answered the last
+// request. The upstream server is requested in background by CheckLoop, so
+// that a health request only reads the cached result. Each answer of the
+// upstream server syncs the NtpTimer instances of the timers collection.
+// The checker is safe for concurrent use.
+type UpstreamChecker struct {
+	host    string
+	port    int
+	timers  *TimerCollection   // synced with the upstream server; or nil.
+	options ntp.RequestOptions // timeout and retries of a request.
+
+	mu      sync.Mutex
+	err     error     // the error of the last request.
+	checked time.Time // time of the last request.
+}
+
+// NewUpstreamChecker create a new UpstreamChecker of the upstream ntp server
+// on host and port, that syncs the timers collection. The checker is
+// unhealthy until the first check.
+func NewUpstreamChecker(
+	host string,
+	port int,
+	timers *TimerCollection,
+) *UpstreamChecker {
+	return &UpstreamChecker{
+		host:   host,
+		port:   port,
+		timers: timers,
+		err:    ErrNotChecked,
+	}
+}
+
+// Check request the upstream server once, sync the timers and cache the
+// result. The error of the request is returned.
+func (c *UpstreamChecker) Check() error {
+	pkg, err := ntp.RequestWithOptions(c.host, c.port, c.options)
+	if err != nil {
+		err = fmt.Errorf("upstream %s unreachable: %w",
+			joinHostPort(c.host, c.port), err)
+	} else if c.timers != nil {
+		c.timers.SyncNtpTimers(pkg)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err, c.checked = err, time.Now()
+	return err
+}
+
+// CheckLoop check the upstream server immediately and then on each interval
+// until done is closed.
+func (c *UpstreamChecker) CheckLoop(
+	interval time.Duration,
+	done <-chan struct{},
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_ = c.Check()
+		select {
+		// On ticker ticks, check again.
+		case <-ticker.C:
+		// On done, stop checking.
+		case <-done:
+			return
+		}
+	}
+}
+
+// Checked get the time of the last check. Before the first check, the zero
+// time is returned.
+func (c *UpstreamChecker) Checked() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checked
+}
+
+// IsHealthy implements Healthy.IsHealthy interface.
+func (c *UpstreamChecker) IsHealthy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err == nil
+}
+
+// Error implements Healthy.error interface.
+func (c *UpstreamChecker) Error() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		return ""
+	}
+	return c.err.Error()
+}
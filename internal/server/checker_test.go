@@ -0,0 +1,171 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/ntp"
+)
+
+// Wait until the checker reports err, at most one second.
+func waitChecker(t *testing.T, checker *NtpServerChecker, err error) {
+	for deadline := time.Now().Add(time.Second); ; {
+		if checker.Error() == err.Error() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("invalid checker error: want %q get %q",
+				err, checker.Error())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestNtpServerChecker test that the checker is healthy while the server
+// serves, records the answered requests and reports an unexpected stop.
+func TestNtpServerChecker(t *testing.T) {
+	checker := NewNtpServerChecker()
+	if checker.IsHealthy() || checker.Error() != ErrNotServing.Error() {
+		t.Errorf("checker healthy before serving: %q", checker.Error())
+	}
+	s := newTestServer()
+	s.SetChecker(checker)
+	addrs := serveTestServer(t, s, 1)
+	if !checker.IsHealthy() {
+		t.Fatalf("checker unhealthy while serving: %q", checker.Error())
+	}
+
+	// An answered request is recorded.
+	before := time.Now()
+	queryTestServer(t, addrs[0].(*net.UDPAddr))
+	if last := checker.LastRequest(); last.Before(before) {
+		t.Errorf("last request not recorded: %s", last)
+	}
+
+	// Closing the socket without Shutdown is an unexpected stop.
+	s.mu.Lock()
+	_ = s.conns[0].Close()
+	s.mu.Unlock()
+	waitChecker(t, checker, ErrUnexpectedStop)
+}
+
+// TestNtpServerCheckerShutdown test that the checker reports a shut down
+// server and a server, that can not listen.
+func TestNtpServerCheckerShutdown(t *testing.T) {
+	checker := NewNtpServerChecker()
+	s := newTestServer()
+	s.SetChecker(checker)
+	serveTestServer(t, s, 1)
+	if err := s.Shutdown(); err != nil {
+		t.Fatalf("shutdown err: %s", err)
+	}
+	waitChecker(t, checker, ErrServerShutdown)
+
+	// A listen error is reported.
+	serverConn, _ := newTestConnPair(t)
+	s = NewServer("127.0.0.1",
+		serverConn.LocalAddr().(*net.UDPAddr).Port, failingRouting{})
+	s.SetChecker(checker)
+	err := s.Serve()
+	if err == nil || checker.IsHealthy() {
+		t.Fatalf("checker healthy on listen error: %v", err)
+	}
+	if checker.Error() != err.Error() {
+		t.Errorf("invalid checker error: want %q get %q",
+			err, checker.Error())
+	}
+}
+
+// TestUpstreamChecker test that the checker syncs the NtpTimer instances
+// with the upstream server and reports an upstream server, that stops
+// answering, as unhealthy.
+func TestUpstreamChecker(t *testing.T) {
+	// Create a stub upstream server, that answers until it is down.
+	conn, err := net.ListenUDP(
+		"udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("can not listen udp: %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	var down atomic.Bool
+	go func() {
+		for {
+			data := make([]byte, ntp.PackageSize)
+			_, addr, err := conn.ReadFromUDP(data)
+			if err != nil {
+				return
+			}
+			if down.Load() {
+				continue
+			}
+			req, _ := ntp.PackageFromBytes(data)
+			var pkg ntp.Package
+			pkg.SetMode(ntp.ModeServer)
+			pkg.SetStratum(1)
+			pkg.SetReferenceClockId([]byte("GPS\x00"))
+			pkg.SetOriginateTimestamp(req.GetTransmitTimestamp())
+			pkg.SetReceiveTimestamp(time.Now())
+			pkg.SetTransmitTimestamp(time.Now())
+			res, _ := pkg.ToBytes()
+			_, _ = conn.WriteToUDP(res, addr)
+		}
+	}()
+
+	// Check the upstream server in background.
+	timer := &NtpTimer{}
+	timers := NewTimerCollection(1)
+	timers.Add(timer)
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	checker := NewUpstreamChecker(addr.IP.String(), addr.Port, timers)
+	checker.options.Timeout = 50 * time.Millisecond
+	if checker.IsHealthy() {
+		t.Errorf("checker healthy before first check")
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		checker.CheckLoop(10*time.Millisecond, done)
+	}()
+	t.Cleanup(func() {
+		close(done)
+		<-stopped
+	})
+
+	// Wait until the checker reports the state of the upstream server.
+	waitHealthy := func(healthy bool) {
+		for deadline := time.Now().Add(time.Second); ; {
+			if checker.IsHealthy() == healthy {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("checker not healthy %t: %q",
+					healthy, checker.Error())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitHealthy(true)
+	if checker.Checked().IsZero() {
+		t.Errorf("check time not recorded")
+	}
+	var dst ntp.Package
+	pkg, _ := timers.PackageFromTimer(&dst, timer)
+	if refId := pkg.GetReferenceClockId(); string(refId) != "GPS\x00" {
+		t.Errorf("timer not synced with upstream: %q", refId)
+	}
+
+	// The upstream server goes down.
+	down.Store(true)
+	waitHealthy(false)
+	if !strings.Contains(checker.Error(), "unreachable") {
+		t.Errorf("invalid checker error: %q", checker.Error())
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/ntp"
+	"github.com/donsprallo/zeitgeist/pkg/config"
+)
+
+// NewConfigTimer create the Timer of a predefined timer of a configuration
+// file with the ntp package pkg. A modify timer without time starts at the
+// current time. An error is returned for an unknown timer type.
+func NewConfigTimer(tc config.TimerConfig, pkg ntp.Package) (Timer, error) {
+	now := time.Now()
+	switch tc.Type {
+	case "system":
+		return &SystemTimer{NTPPackage: pkg}, nil
+	case "modify":
+		start := tc.Time
+		if start.IsZero() {
+			start = now
+		}
+		return NewModifyTimer(pkg, start), nil
+	case "rate":
+		return NewRateTimer(pkg, now, tc.Rate), nil
+	case "drift":
+		return NewDriftTimer(pkg, now, tc.Rate), nil
+	case "stepping":
+		return &SteppingTimer{
+			NTPPackage: pkg, Time: now, Step: tc.StepDuration()}, nil
+	default:
+		return nil, fmt.Errorf("unknown timer type %q", tc.Type)
+	}
+}
+
+// AddConfigTimers add the predefined timers of cfg with the ntp package
+// pkg to timers and the predefined routes to table. The configuration
+// must be validated with config.Config.Validate. The identifiers of the
+// added timers are returned in the order of the configuration.
+func AddConfigTimers(
+	cfg *config.Config,
+	timers *TimerCollection,
+	table *RoutingTable,
+	pkg ntp.Package,
+) ([]int, error) {
+	entries := make([]TimerCollectionEntry, 0, len(cfg.Timers))
+	ids := make([]int, 0, len(cfg.Timers))
+	for idx, tc := range cfg.Timers {
+		timer, err := NewConfigTimer(tc, pkg)
+		if err != nil {
+			return ids, fmt.Errorf("timer %d: %w", idx, err)
+		}
+		id := timers.Add(timer)
+		entries = append(entries, TimerCollectionEntry{Id: id, Timer: timer})
+		ids = append(ids, id)
+	}
+	for _, rc := range cfg.Routes {
+		_, ipNet, err := net.ParseCIDR(rc.Subnet)
+		if err != nil {
+			return ids, err
+		}
+		if rc.Timer < 0 || rc.Timer >= len(entries) {
+			return ids, fmt.Errorf("route %s: unknown timer %d",
+				rc.Subnet, rc.Timer)
+		}
+		entry := entries[rc.Timer]
+		if err = table.Add(*ipNet, entry.Timer, entry.Id); err != nil {
+			return ids, fmt.Errorf("route %s: %w", rc.Subnet, err)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/ntp"
+	"github.com/donsprallo/zeitgeist/pkg/config"
+)
+
+// A sample configuration file with a timer of each type and a route to
+// the stepping timer.
+const testConfig = `{
+	"ntp": {"host": "0.0.0.0", "port": 1123},
+	"timers": [
+		{"type": "system"},
+		{"type": "modify", "time": "2000-01-01T00:00:00Z"},
+		{"type": "rate", "rate": 2},
+		{"type": "drift", "rate": 1.5},
+		{"type": "stepping", "step": "1m"}
+	],
+	"routes": [{"subnet": "10.1.0.0/16", "timer": 4}]
+}`
+
+func TestAddConfigTimers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(testConfig), 0o600); err != nil {
+		t.Fatalf("can not write config: %s", err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("can not load config: %s", err)
+	}
+
+	// Add the timers after the default timer and the default routes.
+	defaultTimer := &SystemTimer{}
+	timers := NewTimerCollection(10)
+	table := NewRoutingTable(10)
+	strategy := NewStaticRouting(table, defaultTimer, timers.Add(defaultTimer))
+	var pkg ntp.Package
+	pkg.SetStratum(3)
+	ids, err := AddConfigTimers(cfg, timers, table, pkg)
+	if err != nil {
+		t.Fatalf("can not add config timers: %s", err)
+	}
+
+	// Create test table; each timer of the configuration in order.
+	names := []string{"SystemTimer", "ModifyTimer", "RateTimer",
+		"DriftTimer", "SteppingTimer"}
+	if len(ids) != len(names) {
+		t.Fatalf("invalid number of timers: want %d get %d",
+			len(names), len(ids))
+	}
+	for idx, name := range names {
+		entry, ok := timers.Get(ids[idx])
+		if !ok || TimerName(entry.Timer) != name {
+			t.Errorf("timer %d: want %s get %+v", idx, name, entry.Timer)
+			continue
+		}
+		if entry.Timer.Package().GetStratum() != 3 {
+			t.Errorf("timer %d: package not applied", idx)
+		}
+	}
+	entry, _ := timers.Get(ids[1])
+	start := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if diff := entry.Timer.Get().Sub(start); diff < 0 || diff > time.Minute {
+		t.Errorf("invalid modify timer time: %s", entry.Timer.Get())
+	}
+
+	// The route selects the stepping timer; other clients are served by
+	// the default timer.
+	stepping, _ := timers.Get(ids[4])
+	timer, err := strategy.FindTimer(net.ParseIP("10.1.2.3"))
+	if err != nil || timer != stepping.Timer {
+		t.Errorf("route does not select stepping timer: %v %v", timer, err)
+	}
+	timer, err = strategy.FindTimer(net.ParseIP("10.2.0.1"))
+	if err != nil || timer != Timer(defaultTimer) {
+		t.Errorf("invalid default timer: %v %v", timer, err)
+	}
+
+	// An unknown timer type is not created.
+	_, err = NewConfigTimer(config.TimerConfig{Type: "atomic"}, pkg)
+	if err == nil {
+		t.Errorf("timer with unknown type created")
+	}
+}
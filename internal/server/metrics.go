@@ -0,0 +1,79 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/metrics"
+)
+
+// MetricsCollector collects the metrics of the request handling of a
+// Server. The collector must be safe for concurrent use by the workers.
+type MetricsCollector interface {
+
+	// Request count a parsed request with the request mode.
+	Request(mode uint32)
+
+	// RouteMatch count a request answered by route.
+	RouteMatch(route RoutingTableEntry)
+
+	// Response count a sent response of timer and observe the latency
+	// from receiving the request.
+	Response(timer Timer, latency time.Duration)
+
+	// Error count a failed request at the handling stage, like
+	// metrics.StageParse.
+	Error(stage string)
+
+	// Correction count a response corrected for reason, like
+	// metrics.CorrectionLeapStratum.
+	Correction(reason string)
+}
+
+// DefaultMetricsCollector is the MetricsCollector of a Server. The metrics
+// are counted in the metrics package and scraped from the
+// metrics.DefaultRegistry.
+var DefaultMetricsCollector MetricsCollector = defaultMetricsCollector{}
+
+// Implements the MetricsCollector interface with the metrics package.
+type defaultMetricsCollector struct{}
+
+// Request implements MetricsCollector.Request interface.
+func (defaultMetricsCollector) Request(mode uint32) {
+	metrics.RequestsTotal.WithLabelValues(strconv.Itoa(int(mode))).Inc()
+}
+
+// RouteMatch implements MetricsCollector.RouteMatch interface.
+func (defaultMetricsCollector) RouteMatch(route RoutingTableEntry) {
+	metrics.RouteMatchesTotal.WithLabelValues(RouteName(route)).Inc()
+}
+
+// Response implements MetricsCollector.Response interface.
+func (defaultMetricsCollector) Response(timer Timer, latency time.Duration) {
+	metrics.ResponsesTotal.WithLabelValues(TimerName(timer)).Inc()
+	metrics.HandlerSeconds.Observe(latency.Seconds())
+}
+
+// Error implements MetricsCollector.Error interface.
+func (defaultMetricsCollector) Error(stage string) {
+	metrics.ErrorsTotal.WithLabelValues(stage).Inc()
+}
+
+// Correction implements MetricsCollector.Correction interface.
+func (defaultMetricsCollector) Correction(reason string) {
+	metrics.CorrectionsTotal.WithLabelValues(reason).Inc()
+}
+
+// RouteName get the name of route for metrics, that is the network prefix
+// of the route. A route without prefix, like the routing fallback, is
+// named "none".
+func RouteName(route RoutingTableEntry) string {
+	if route.IPNet.IP == nil {
+		return "none"
+	}
+	return route.IPNet.String()
+}
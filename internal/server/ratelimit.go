@@ -0,0 +1,91 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// The maximum number of clients with a request budget. When more clients
+// send requests, the budget of the oldest client is reset.
+const rateLimitMaxClients = 1 << 16
+
+// The request budget of a client.
+type rateBucket struct {
+	tokens float64   // Available requests of the client.
+	last   time.Time // Time of the last refill.
+}
+
+// RateLimiter limits the request rate of each client ip address. Each
+// client has a budget of Burst requests, that is refilled with one request
+// each Interval. The limiter is safe for concurrent use.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+	clients  *TTLCache[string, *rateBucket]
+	now      func() time.Time
+}
+
+// NewRateLimiter create a new RateLimiter, that allows a client an average
+// of one request each interval and bursts of up to burst requests.
+func NewRateLimiter(interval time.Duration, burst int) *RateLimiter {
+	limiter := &RateLimiter{
+		interval: interval,
+		burst:    max(burst, 1),
+		now:      time.Now,
+	}
+	// The budget of a client is full again after burst intervals, so that
+	// the state of the client expires.
+	limiter.clients = NewTTLCache[string, *rateBucket](
+		interval*time.Duration(limiter.burst), rateLimitMaxClients)
+	limiter.clients.now = func() time.Time { return limiter.now() }
+	return limiter
+}
+
+// Allow check whether a request of the client with ip is allowed. An
+// allowed request is taken from the budget of the client.
+func (l *RateLimiter) Allow(ip net.IP) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	key := ip.String()
+	bucket, ok := l.clients.Get(key)
+	if !ok {
+		bucket = &rateBucket{tokens: float64(l.burst), last: now}
+	}
+	// Refill the budget by the time since the last request.
+	l.refill(bucket, now)
+	l.clients.Set(key, bucket)
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// Reset clear the budget state of the client with ip, so that the client
+// has the full budget again. When the client has no state, false is
+// returned.
+func (l *RateLimiter) Reset(ip net.IP) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := ip.String()
+	if _, ok := l.clients.Get(key); !ok {
+		return false
+	}
+	l.clients.Delete(key)
+	return true
+}
+
+// Refill the budget of bucket up to the burst size.
+func (l *RateLimiter) refill(bucket *rateBucket, now time.Time) {
+	elapsed := now.Sub(bucket.last)
+	bucket.last = now
+	bucket.tokens += float64(elapsed) / float64(l.interval)
+	bucket.tokens = min(bucket.tokens, float64(l.burst))
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(time.Second, 2)
+	limiter.now = func() time.Time { return now }
+	client := net.ParseIP("10.0.0.1")
+	other := net.ParseIP("10.0.0.2")
+
+	// The burst is allowed, further requests are limited.
+	if !limiter.Allow(client) || !limiter.Allow(client) {
+		t.Fatalf("burst not allowed")
+	}
+	if limiter.Allow(client) {
+		t.Errorf("request exceeding burst allowed")
+	}
+	// Other clients have their own budget.
+	if !limiter.Allow(other) {
+		t.Errorf("request of other client not allowed")
+	}
+	// The budget is refilled each interval.
+	now = now.Add(time.Second)
+	if !limiter.Allow(client) {
+		t.Errorf("request after interval not allowed")
+	}
+	if limiter.Allow(client) {
+		t.Errorf("request exceeding refill allowed")
+	}
+}
+
+func TestRateLimiterExpire(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(time.Second, 1)
+	limiter.now = func() time.Time { return now }
+
+	// Idle clients with a full budget are expired.
+	for i := 0; i < 4096; i++ {
+		limiter.Allow(net.IPv4(10, 0, byte(i/256), byte(i%256)))
+	}
+	now = now.Add(time.Second)
+	limiter.Allow(net.ParseIP("10.1.0.1"))
+	if limiter.clients.Len() != 1 {
+		t.Errorf("idle clients not expired: %d", limiter.clients.Len())
+	}
+}
+
+func TestRateLimiterReset(t *testing.T) {
+	limiter := NewRateLimiter(time.Hour, 1)
+	client := net.ParseIP("10.0.0.1")
+
+	// A client without state can not be reset.
+	if limiter.Reset(client) {
+		t.Errorf("client without state reset")
+	}
+	// A limited client has the full budget after reset.
+	limiter.Allow(client)
+	if limiter.Allow(client) {
+		t.Fatalf("request exceeding burst allowed")
+	}
+	if !limiter.Reset(client) {
+		t.Fatalf("limited client not reset")
+	}
+	if !limiter.Allow(client) {
+		t.Errorf("request after reset not allowed")
+	}
+}
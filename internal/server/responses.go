@@ -0,0 +1,62 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/ntp"
+)
+
+// DefaultResponseLogSize is the default number of clients of a ResponseLog.
+const DefaultResponseLogSize = 1 << 16
+
+// ResponseRecord is the last response served to a client.
+type ResponseRecord struct {
+	Client  string      // ip address of the client.
+	Time    time.Time   // Time the response was sent.
+	Timer   string      // Name of the Timer, that answered the request.
+	Package ntp.Package // The response package.
+}
+
+// ResponseLog records the last response served to each client ip address
+// for support debugging. A record expires a ttl after the response and the
+// number of clients is bounded. The log is safe for concurrent use.
+type ResponseLog struct {
+	mu      sync.Mutex
+	records *TTLCache[string, ResponseRecord]
+}
+
+// NewResponseLog create a new ResponseLog, that keeps the last response of
+// up to maxClients clients for ttl. A maxClients of zero is unlimited.
+func NewResponseLog(ttl time.Duration, maxClients int) *ResponseLog {
+	return &ResponseLog{
+		records: NewTTLCache[string, ResponseRecord](ttl, maxClients),
+	}
+}
+
+// Record pkg as the last response of the client with ip, that is answered
+// by timer.
+func (l *ResponseLog) Record(ip net.IP, timer Timer, pkg *ntp.Package) {
+	record := ResponseRecord{
+		Client:  ip.String(),
+		Time:    time.Now(),
+		Timer:   TimerName(timer),
+		Package: *pkg,
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records.Set(record.Client, record)
+}
+
+// Last get the last response of the client with ip. When no response is
+// recorded or the record is expired, false is returned.
+func (l *ResponseLog) Last(ip net.IP) (ResponseRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.records.Get(ip.String())
+}
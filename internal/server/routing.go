@@ -7,6 +7,7 @@ package server
 import (
 	"errors"
 	"net"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -30,10 +31,29 @@ func (e *RoutingTableEntry) SetIPNet(ipNet net.IPNet) {
 	e.IPNet = ipNet
 }
 
-// RoutingTable is a collection of RoutingTableEntry.
+// Errors returned by RoutingTable.
+var (
+	// ErrRouteExists is returned when a route with the same address is
+	// added to a RoutingTable.
+	ErrRouteExists = errors.New("key exist in routing Table")
+	// ErrRoutingTableFull is returned when a route is added to a
+	// RoutingTable that reached its RoutingTable.MaxSize.
+	ErrRoutingTableFull = errors.New("routing Table is full")
+	// ErrRouteNotFound is returned when a route id is not part of a
+	// RoutingTable.
+	ErrRouteNotFound = errors.New("no route found")
+)
+
+// RoutingTable is a collection of RoutingTableEntry. The table is safe for
+// concurrent use. Holders of a table, like the web endpoints, keep a
+// reference to the table; therefore the table state must be replaced in
+// place with RoutingTable.Replace instead of replacing the table.
 type RoutingTable struct {
+	mu      sync.RWMutex
 	nextId  int
+	version uint64 // Incremented on each modification of entries.
 	entries []RoutingTableEntry
+	MaxSize int // The maximum number of entries; zero is unlimited.
 }
 
 // NewRoutingTable create a new RoutingTable instance with size.
@@ -44,9 +64,44 @@ func NewRoutingTable(size int) *RoutingTable {
 	}
 }
 
-// All return all RoutingTableEntry objects from RoutingTable.
+// All return a copy of all RoutingTableEntry objects from RoutingTable.
 func (t *RoutingTable) All() []RoutingTableEntry {
-	return t.entries
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	entries := make([]RoutingTableEntry, len(t.entries))
+	copy(entries, t.entries)
+	return entries
+}
+
+// Replace all entries of the RoutingTable in place. The entries keep their
+// identifiers; new entries get identifiers after the highest one.
+func (t *RoutingTable) Replace(entries []RoutingTableEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = append(
+		make([]RoutingTableEntry, 0, cap(t.entries)), entries...)
+	t.nextId = 0
+	for _, entry := range entries {
+		t.nextId = max(t.nextId, entry.Id+1)
+	}
+	t.version++
+}
+
+// Version get the modification counter of the RoutingTable. The version
+// changes on each modification of the table entries.
+func (t *RoutingTable) Version() uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.version
+}
+
+// Get a copy of all entries together with the table version.
+func (t *RoutingTable) snapshot() ([]RoutingTableEntry, uint64) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	entries := make([]RoutingTableEntry, len(t.entries))
+	copy(entries, t.entries)
+	return entries, t.version
 }
 
 // Add adds a net.IP address and Timer to the Table. This address maps
@@ -56,10 +111,15 @@ func (t *RoutingTable) Add(
 	timer Timer,
 	timerId int,
 ) error {
-	// IP address must be unique in routing Table.
-	if t.Contains(ipNet) {
-		return errors.New(
-			"key exist in routing Table")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Subnet must be unique in routing Table.
+	if t.contains(ipNet) {
+		return ErrRouteExists
+	}
+	// Routing Table size must be in limit.
+	if t.MaxSize > 0 && len(t.entries) >= t.MaxSize {
+		return ErrRoutingTableFull
 	}
 	// Add entry to routing Table.
 	t.entries = append(t.entries, RoutingTableEntry{
@@ -69,10 +129,27 @@ func (t *RoutingTable) Add(
 		TimerId: timerId,
 	})
 	t.nextId++
+	t.version++
 	return nil
 }
 
+// RoutesForTimer get all RoutingTableEntry objects that reference the Timer
+// with timerId.
+func (t *RoutingTable) RoutesForTimer(timerId int) []RoutingTableEntry {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	entries := make([]RoutingTableEntry, 0)
+	for _, entry := range t.entries {
+		if entry.TimerId == timerId {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
 func (t *RoutingTable) Get(id int) *RoutingTableEntry {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	for _, entry := range t.entries {
 		if entry.Id == id {
 			return &entry
@@ -82,17 +159,54 @@ func (t *RoutingTable) Get(id int) *RoutingTableEntry {
 }
 
 func (t *RoutingTable) Set(id int, timer Timer, timerId int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	for idx, entry := range t.entries {
 		if entry.Id == id {
 			t.entries[idx].Timer = timer
 			t.entries[idx].TimerId = timerId
+			t.version++
 			return nil
 		}
 	}
 	return errors.New("no route found by id")
 }
 
+// SetAll set the Timer of all entries with ids in a single transaction.
+// When an entry of ids is not found, no entry is modified.
+func (t *RoutingTable) SetAll(ids []int, timer Timer, timerId int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Find all entries before any entry is modified.
+	indices := make([]int, 0, len(ids))
+	for _, id := range ids {
+		index := -1
+		for idx, entry := range t.entries {
+			if entry.Id == id {
+				index = idx
+				break
+			}
+		}
+		if index < 0 {
+			return errors.New("no route found by id")
+		}
+		indices = append(indices, index)
+	}
+	// Modify all entries.
+	for _, idx := range indices {
+		t.entries[idx].Timer = timer
+		t.entries[idx].TimerId = timerId
+	}
+	t.version++
+	return nil
+}
+
+// Remove the entry with id from the Table. The Table does not protect
+// the default routes; this is up to the caller. When no entry has the
+// id, ErrRouteNotFound is returned.
 func (t *RoutingTable) Remove(id int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	// Find route by id.
 	index := -1
 	for idx, entry := range t.entries {
@@ -103,11 +217,11 @@ func (t *RoutingTable) Remove(id int) error {
 	}
 	// Check that route is found.
 	if index < 0 {
-		return errors.New(
-			"no route found")
+		return ErrRouteNotFound
 	}
 	// Remove route the inefficient way, but keep ordering.
 	t.entries = append(t.entries[:index], t.entries[index+1:]...)
+	t.version++
 	return nil
 }
 
@@ -126,16 +240,52 @@ func (t *RoutingTable) MustAdd(
 }
 
 // Contains checks if a net.IPNet value exists in the collection. Returns true
-// if net.IPNet value exists in RoutingTable, otherwise return false.
+// if net.IPNet value exists in RoutingTable, otherwise return false. A value
+// exists, when the network address and the prefix length are equal, so
+// that 192.168.1.0/24 and 192.168.1.0/16 are different routes.
 func (t *RoutingTable) Contains(value net.IPNet) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.contains(value)
+}
+
+// Check if a net.IPNet value exists in the collection without locking.
+func (t *RoutingTable) contains(value net.IPNet) bool {
+	for _, entry := range t.entries {
+		if equalIPNet(entry.IPNet, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Overlaps checks if the addresses of a net.IPNet value are partly matched
+// by another route of the collection, like 192.168.1.0/24 by 192.168.0.0/16.
+// The equal route and the routes without prefix, that match all addresses,
+// are no overlap.
+func (t *RoutingTable) Overlaps(value net.IPNet) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	for _, entry := range t.entries {
-		if entry.IPNet.IP.Equal(value.IP) {
+		if ones, _ := entry.IPNet.Mask.Size(); ones == 0 ||
+			equalIPNet(entry.IPNet, value) {
+			continue
+		}
+		if entry.IPNet.Contains(value.IP) || value.Contains(entry.IPNet.IP) {
 			return true
 		}
 	}
 	return false
 }
 
+// Check if a and b have the same network address and prefix length.
+func equalIPNet(a, b net.IPNet) bool {
+	aOnes, aBits := a.Mask.Size()
+	bOnes, bBits := b.Mask.Size()
+	return aOnes == bOnes && aBits == bBits &&
+		a.IP.Mask(a.Mask).Equal(b.IP.Mask(b.Mask))
+}
+
 // RoutingStrategy is an interface to define a strategy for routing net.IP
 // addresses to a Timer instance. Each request can get a specified response,
 // depends on the response from RoutingStrategy. A net.IP address is mapped
@@ -144,13 +294,17 @@ type RoutingStrategy interface {
 
 	// FindTimer find a Timer by a net.IP address.
 	FindTimer(ip net.IP) (Timer, error)
+
+	// FindRoute find the RoutingTableEntry matching a net.IP address.
+	FindRoute(ip net.IP) (RoutingTableEntry, error)
 }
 
 // StaticRouting is a specific RoutingStrategy for simple static routing. This
 // means that each net.IP address is managed in a list. To this list net.IP
-// addresses and timers are attached. The list is traversed in reverse order
-// and checked for a match. If a match is found, then the corresponding timer
-// is returned. When no timer is found, a default timer is returned.
+// addresses and timers are attached. The whole list is checked for matches
+// and the timer of the most specific match, the one with the longest prefix,
+// is returned. On equal prefixes the later added route wins. The default
+// route only matches, when no other route matches.
 type StaticRouting struct {
 	Table *RoutingTable
 }
@@ -161,28 +315,47 @@ type StaticRouting struct {
 func (r *StaticRouting) FindTimer(
 	ip net.IP,
 ) (Timer, error) {
-	// First search for a match by equal; We must reverse the
-	// static routing Table entries.
-	for i := len(r.Table.entries) - 1; i >= 0; i-- {
-		entry := r.Table.entries[i]
-		if ip.Mask(entry.IPNet.Mask).Equal(entry.IPNet.IP) {
-			log.Debugf("host with ip[%s] equal mask[%s] match",
-				ip, entry.IPNet.String())
-			return entry.Timer, nil
-		}
+	entry, err := r.FindRoute(ip)
+	if err != nil {
+		return nil, err
 	}
-	// Next search for a match by contain; We must reverse the
-	// static routing Table entries.
+	return entry.Timer, nil
+}
+
+// FindRoute search for the RoutingTableEntry of a net.IP address like
+// StaticRouting.FindTimer.
+func (r *StaticRouting) FindRoute(
+	ip net.IP,
+) (RoutingTableEntry, error) {
+	// Normalize IPv4-mapped IPv6 addresses, so that they only match the
+	// IPv4 routes like in TrieRouting.
+	ip = trieKey(ip)
+
+	r.Table.mu.RLock()
+	defer r.Table.mu.RUnlock()
+	// Search for the match with the longest prefix; We must reverse
+	// the static routing Table entries, so that later routes win on
+	// equal prefixes. An IPv4 address only matches IPv4 routes and an
+	// IPv6 address only IPv6 routes.
+	var match *RoutingTableEntry
+	matchOnes := -1
 	for i := len(r.Table.entries) - 1; i >= 0; i-- {
-		entry := r.Table.entries[i]
-		if entry.IPNet.Contains(ip) {
-			log.Debugf("host with ip[%s] contains mask[%s] match",
-				ip, entry.IPNet.String())
-			return entry.Timer, nil
+		entry := &r.Table.entries[i]
+		if len(entry.IPNet.Mask) != len(ip) ||
+			!entry.IPNet.Contains(ip) {
+			continue
 		}
+		if ones, _ := entry.IPNet.Mask.Size(); ones > matchOnes {
+			match, matchOnes = entry, ones
+		}
+	}
+	if match != nil {
+		log.Debugf("host with ip[%s] prefix mask[%s] match",
+			ip, match.IPNet.String())
+		return *match, nil
 	}
 	// No match found. Should never have reached.
-	return nil, errors.New(
+	return RoutingTableEntry{}, errors.New(
 		"no handler found in routing Table")
 }
 
@@ -196,8 +369,12 @@ var (
 		IP:   net.ParseIP("127.0.0.0"),
 	}
 	ipv6Route = net.IPNet{
-		Mask: net.CIDRMask(120, 128),
-		IP:   net.ParseIP("::"),
+		Mask: net.CIDRMask(128, 128),
+		IP:   net.IPv6loopback,
+	}
+	ipv6DefaultRoute = net.IPNet{
+		Mask: net.CIDRMask(0, 128),
+		IP:   net.IPv6unspecified,
 	}
 )
 
@@ -214,11 +391,23 @@ func NewStaticRouting(
 	routing := StaticRouting{
 		Table: table,
 	}
+	addDefaultRoutes(routing.Table, defaultTimer, timerId)
+	return &routing
+}
+
+// Add the default routes with the default Timer to the RoutingTable.
+func addDefaultRoutes(
+	table *RoutingTable,
+	defaultTimer Timer,
+	timerId int,
+) {
 	// Add the default response timer to router.
-	routing.Table.MustAdd(defaultRoute, defaultTimer, timerId)
+	table.MustAdd(defaultRoute, defaultTimer, timerId)
 	// Add IPv4 loop back address.
-	routing.Table.MustAdd(ipv4Route, defaultTimer, timerId)
+	table.MustAdd(ipv4Route, defaultTimer, timerId)
 	// Add IPv6 loop back address.
-	routing.Table.MustAdd(ipv6Route, defaultTimer, timerId)
-	return &routing
+	table.MustAdd(ipv6Route, defaultTimer, timerId)
+	// Add the default response timer for all IPv6 addresses. The route
+	// is added last to keep the ids of the other default routes.
+	table.MustAdd(ipv6DefaultRoute, defaultTimer, timerId)
 }
@@ -5,6 +5,7 @@
 package server
 
 import (
+	"errors"
 	"net"
 	"testing"
 )
@@ -64,3 +65,305 @@ func TestFindTimer(t *testing.T) {
 		}
 	}
 }
+
+func TestFindTimerLongestPrefix(t *testing.T) {
+	// Create overlapping routes; The message is an identifier, to check
+	// which response timer is returned from routing strategy.
+	routes := []string{"10.0.0.0/8", "10.1.2.0/24", "10.1.2.3/32"}
+	tables := []struct {
+		Message string
+		IP      net.IP
+	}{
+		{"10.1.2.3/32", net.ParseIP("10.1.2.3")},
+		{"10.1.2.0/24", net.ParseIP("10.1.2.4")},
+		{"10.0.0.0/8", net.ParseIP("10.1.3.3")},
+		{"default", net.ParseIP("11.1.2.3")},
+	}
+
+	// Test the routes in every insertion order.
+	orders := [][]int{
+		{0, 1, 2}, {0, 2, 1}, {1, 0, 2},
+		{1, 2, 0}, {2, 0, 1}, {2, 1, 0},
+	}
+	for _, order := range orders {
+		strategy := NewStaticRouting(
+			NewRoutingTable(10), DummyTimer{Message: "default"}, 0)
+		for _, idx := range order {
+			_, ipNet, _ := net.ParseCIDR(routes[idx])
+			strategy.Table.MustAdd(
+				*ipNet, DummyTimer{Message: routes[idx]}, idx+1)
+		}
+		for _, table := range tables {
+			timer, err := strategy.FindTimer(table.IP)
+			if err != nil {
+				t.Errorf("order %v ip[%s] err: %s",
+					order, table.IP, err)
+				continue
+			}
+			dummy := timer.(DummyTimer)
+			if dummy.Message != table.Message {
+				t.Errorf("order %v ip[%s] found incorrect timer: "+
+					"want '%s' get '%s'",
+					order, table.IP, table.Message, dummy.Message)
+			}
+		}
+	}
+}
+
+func TestRoutingTableReplace(t *testing.T) {
+	defaultTimer := DummyTimer{Message: "default"}
+	netTimer := DummyTimer{Message: "net"}
+
+	table := NewRoutingTable(10)
+	strategy := NewStaticRouting(
+		table, defaultTimer, 0)
+
+	// Replace table in place; the strategy must use the new entries
+	// without recreating the strategy.
+	_, ipNet, _ := net.ParseCIDR("10.0.0.0/8")
+	entries := table.All()
+	entries = append(entries, RoutingTableEntry{
+		Id: 7, IPNet: *ipNet, Timer: netTimer, TimerId: 1,
+	})
+	table.Replace(entries)
+
+	timer, err := strategy.FindTimer(net.ParseIP("10.1.2.3"))
+	if err != nil {
+		t.Fatalf("find timer after replace err: %s", err)
+	}
+	if timer.(DummyTimer).Message != "net" {
+		t.Errorf("replaced route not found: get '%s'",
+			timer.(DummyTimer).Message)
+	}
+
+	// New entries must get an identifier after the highest one.
+	_, ipNet, _ = net.ParseCIDR("10.1.0.0/16")
+	table.MustAdd(*ipNet, netTimer, 1)
+	if table.Get(8) == nil {
+		t.Errorf("invalid identifier of route added after replace")
+	}
+}
+
+func TestRoutingTableMaxSize(t *testing.T) {
+	timer := DummyTimer{Message: "test"}
+	table := NewRoutingTable(10)
+	table.MaxSize = 2
+
+	// Add routes until limit is reached.
+	_, ipNet1, _ := net.ParseCIDR("10.0.0.0/8")
+	_, ipNet2, _ := net.ParseCIDR("10.1.0.0/16")
+	_, ipNet3, _ := net.ParseCIDR("10.2.0.0/16")
+	table.MustAdd(*ipNet1, timer, 0)
+	table.MustAdd(*ipNet2, timer, 0)
+
+	// The limit must be enforced.
+	err := table.Add(*ipNet3, timer, 0)
+	if !errors.Is(err, ErrRoutingTableFull) {
+		t.Errorf("routing table limit not enforced: %v", err)
+	}
+	// Existing routes have precedence over the limit.
+	err = table.Add(*ipNet1, timer, 0)
+	if !errors.Is(err, ErrRouteExists) {
+		t.Errorf("invalid error on existing route: %v", err)
+	}
+
+	// On unlimited mode, the route must be added.
+	table.MaxSize = 0
+	err = table.Add(*ipNet3, timer, 0)
+	if err != nil {
+		t.Errorf("routing table unlimited mode err: %s", err)
+	}
+}
+
+func TestRoutingTableRoutesForTimer(t *testing.T) {
+	defaultTimer := DummyTimer{Message: "default"}
+	netTimer := DummyTimer{Message: "net"}
+	table := NewRoutingTable(10)
+	NewStaticRouting(table, defaultTimer, 0)
+
+	_, ipNet1, _ := net.ParseCIDR("10.0.0.0/8")
+	_, ipNet2, _ := net.ParseCIDR("192.168.0.0/16")
+	table.MustAdd(*ipNet1, netTimer, 1)
+	table.MustAdd(*ipNet2, netTimer, 1)
+
+	// The default timer is referenced by the four default routes.
+	if routes := table.RoutesForTimer(0); len(routes) != 4 {
+		t.Errorf("invalid routes for default timer: %d", len(routes))
+	}
+
+	// The net timer is referenced by both added routes.
+	routes := table.RoutesForTimer(1)
+	if len(routes) != 2 ||
+		routes[0].IPNet.String() != ipNet1.String() ||
+		routes[1].IPNet.String() != ipNet2.String() {
+		t.Errorf("invalid routes for net timer: %v", routes)
+	}
+
+	// An unreferenced timer has no routes.
+	if routes := table.RoutesForTimer(2); len(routes) != 0 {
+		t.Errorf("invalid routes for unreferenced timer: %d", len(routes))
+	}
+}
+
+func TestRoutingTableSetAll(t *testing.T) {
+	defaultTimer := DummyTimer{Message: "default"}
+	newTimer := DummyTimer{Message: "new"}
+	table := NewRoutingTable(10)
+	NewStaticRouting(table, defaultTimer, 0)
+
+	// One update fails; none of the entries must be modified.
+	err := table.SetAll([]int{0, 1, 99, 2}, newTimer, 1)
+	if err == nil {
+		t.Errorf("set all with missing id succeeded")
+	}
+	for _, entry := range table.All() {
+		if entry.TimerId != 0 || entry.Timer != defaultTimer {
+			t.Errorf("route %d modified by failed set all", entry.Id)
+		}
+	}
+
+	// All updates succeed; all entries must be modified.
+	err = table.SetAll([]int{0, 1, 2, 3}, newTimer, 1)
+	if err != nil {
+		t.Fatalf("set all err: %s", err)
+	}
+	for _, entry := range table.All() {
+		if entry.TimerId != 1 || entry.Timer != newTimer {
+			t.Errorf("route %d not modified by set all", entry.Id)
+		}
+	}
+}
+
+func TestRoutingTableRemove(t *testing.T) {
+	timer := DummyTimer{Message: "test"}
+	table := NewRoutingTable(10)
+	strategy := NewStaticRouting(table, DummyTimer{Message: "default"}, 0)
+	_, ipNet, _ := net.ParseCIDR("10.0.0.0/8")
+	table.MustAdd(*ipNet, timer, 1)
+	id := table.RoutesForTimer(1)[0].Id
+
+	// Remove an existing route; the route must not match anymore.
+	if err := table.Remove(id); err != nil {
+		t.Fatalf("remove route err: %s", err)
+	}
+	if table.Get(id) != nil {
+		t.Errorf("route %d not removed", id)
+	}
+	found, _ := strategy.FindTimer(net.ParseIP("10.1.2.3"))
+	if found.(DummyTimer).Message != "default" {
+		t.Errorf("removed route still matches")
+	}
+
+	// The table itself does not protect the default route.
+	if err := table.Remove(0); err != nil {
+		t.Errorf("remove default route err: %s", err)
+	}
+
+	// Remove a nonexistent route.
+	if err := table.Remove(99); !errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("invalid error on nonexistent route: %v", err)
+	}
+}
+
+func TestRoutingTableContainsOverlaps(t *testing.T) {
+	table := NewRoutingTable(10)
+	NewStaticRouting(table, DummyTimer{Message: "default"}, 0)
+	_, ipNet, _ := net.ParseCIDR("192.168.1.0/24")
+	table.MustAdd(*ipNet, DummyTimer{Message: "net"}, 1)
+
+	// Create test table; each subnet maps to whether it equals or
+	// overlaps a route of the routing table. The default route 0.0.0.0/0
+	// is skipped as overlapping route.
+	tables := []struct {
+		subnet   string
+		contains bool
+		overlaps bool
+	}{
+		{"192.168.1.0/24", true, false},
+		{"192.168.1.0/16", false, true},
+		{"192.168.1.128/25", false, true},
+		{"192.168.2.0/24", false, false},
+		{"10.0.0.0/8", false, false},
+		{"127.0.0.0/8", false, true},
+		{"0.0.0.0/0", true, true},
+		{"2001:db8::/32", false, false},
+	}
+	for _, e := range tables {
+		_, value, _ := net.ParseCIDR(e.subnet)
+		if contains := table.Contains(*value); contains != e.contains {
+			t.Errorf("%s invalid contains: want %t get %t",
+				e.subnet, e.contains, contains)
+		}
+		if overlaps := table.Overlaps(*value); overlaps != e.overlaps {
+			t.Errorf("%s invalid overlaps: want %t get %t",
+				e.subnet, e.overlaps, overlaps)
+		}
+	}
+
+	// A route with the same address and another prefix can be added.
+	_, ipNet, _ = net.ParseCIDR("192.168.0.0/16")
+	if err := table.Add(*ipNet, DummyTimer{Message: "wide"}, 2); err != nil {
+		t.Errorf("add route with other prefix err: %s", err)
+	}
+	_, ipNet, _ = net.ParseCIDR("192.168.1.0/24")
+	err := table.Add(*ipNet, DummyTimer{Message: "net"}, 1)
+	if !errors.Is(err, ErrRouteExists) {
+		t.Errorf("invalid error on equal route: %v", err)
+	}
+}
+
+func TestFindTimerIPv6(t *testing.T) {
+	// Create test table; each client address maps to the message of the
+	// timer, that answers the client. An IPv4-mapped IPv6 address is
+	// routed like the IPv4 address.
+	tables := []struct {
+		Message string
+		IP      net.IP
+	}{
+		{"default", net.ParseIP("2a00:1450:4001:80b::200e")},
+		{"default", net.ParseIP("fe80::1")},
+		{"default", net.ParseIP("::1")},
+		{"net6", net.ParseIP("2001:db8::1")},
+		{"link", net.ParseIP("fe80::1:2")},
+		{"net4", net.ParseIP("::ffff:192.168.1.10")},
+		{"default", net.ParseIP("::ffff:10.0.0.1")},
+	}
+
+	// Create both strategies with an IPv4, a global IPv6 and a
+	// link-local IPv6 route.
+	defaultTimer := DummyTimer{Message: "default"}
+	strategies := map[string]RoutingStrategy{
+		"static": NewStaticRouting(NewRoutingTable(10), defaultTimer, 0),
+		"trie":   NewTrieRouting(NewRoutingTable(10), defaultTimer, 0),
+	}
+	for name, strategy := range strategies {
+		var table *RoutingTable
+		switch s := strategy.(type) {
+		case *StaticRouting:
+			table = s.Table
+		case *TrieRouting:
+			table = s.Table
+		}
+		for subnet, message := range map[string]string{
+			"192.168.1.0/24": "net4",
+			"2001:db8::/32":  "net6",
+			"fe80::1:0/112":  "link",
+		} {
+			_, ipNet, _ := net.ParseCIDR(subnet)
+			table.MustAdd(*ipNet, DummyTimer{Message: message}, 1)
+		}
+
+		// Test all values; each address must resolve to a timer.
+		for _, e := range tables {
+			timer, err := strategy.FindTimer(e.IP)
+			if err != nil {
+				t.Errorf("%s ip[%s] err: %s", name, e.IP, err)
+				continue
+			}
+			if timer.(DummyTimer).Message != e.Message {
+				t.Errorf("%s ip[%s] invalid timer: want %s get %s",
+					name, e.IP, e.Message, timer.(DummyTimer).Message)
+			}
+		}
+	}
+}
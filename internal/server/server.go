@@ -5,10 +5,18 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/donsprallo/zeitgeist/internal/metrics"
 	"github.com/donsprallo/zeitgeist/internal/ntp"
 	log "github.com/sirupsen/logrus"
 )
@@ -22,50 +30,467 @@ func NewServer(
 	routing RoutingStrategy,
 ) *Server {
 	return &Server{
-		host:    host,
-		port:    port,
-		routing: routing,
+		host:       host,
+		port:       port,
+		network:    "udp",
+		routing:    routing,
+		validation: ntp.ValidationLenient,
+		workers:    runtime.NumCPU(),
+		queueSize:  DefaultQueueSize,
+		collector:  DefaultMetricsCollector,
+	}
+}
+
+// DefaultQueueSize is the default number of requests, that are queued for
+// the workers of a Server.
+const DefaultQueueSize = 1024
+
+// A received request, that is queued for a worker.
+type requestJob struct {
+	addr        *net.UDPAddr
+	data        []byte
+	rxTimestamp time.Time
+	buf         *[]byte // pooled buffer of data; nil if not pooled.
+}
+
+// Release the pooled buffer of the job. The data must not be used after.
+func (job requestJob) release() {
+	if job.buf != nil {
+		receiveBuffers.Put(job.buf)
 	}
 }
 
+// The maximum size of a received request. A request may carry extension
+// fields and a MAC, so the buffer holds a datagram of the Ethernet MTU.
+// Bytes exceeding the size are discarded.
+const maxRequestSize = 1500
+
+// Pool of buffers to receive requests. A buffer is returned to the pool,
+// after its request is handled; the package parsed from the buffer does
+// not reference it.
+var receiveBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, maxRequestSize)
+		return &buf
+	},
+}
+
 // Server is the ntp server structure.
 type Server struct {
-	host    string          // host name of ntp server to listen.
-	port    int             // port of ntp server to listen.
-	routing RoutingStrategy // routing strategy to find Timer.
+	host    string           // host name of ntp server to listen.
+	port    int              // port of ntp server to listen.
+	network string           // network of the addresses; udp, udp4 or udp6.
+	routing RoutingStrategy  // routing strategy to find Timer.
+	inline  bool             // handle requests in the read loop.
+	timers  *TimerCollection // timers to track the last served time.
+	limiter *RateLimiter     // limiter of the client request rate.
+
+	keys        *ntp.KeyStore // keys to verify and sign packages.
+	fallback    Timer         // timer to answer requests, when routing fails.
+	fallbackKoD bool          // answer with kiss code, when routing fails.
+	loopback    Timer         // timer to answer loopback clients.
+
+	validation ntp.ValidationLevel // strictness of request validation.
+	addrs      []string            // additional addresses of the server.
+	listeners  []listener          // additional ports of the server.
+	collector  MetricsCollector    // collector of the request metrics.
+	responses  *ResponseLog        // last response of each client.
+	capture    *CaptureWriter      // capture of the sent responses.
+	checker    *NtpServerChecker   // health of the serving server.
+
+	workers   int  // number of workers handling requests.
+	queueSize int  // number of requests queued for the workers.
+	queueKoD  bool // answer requests with kiss code, when queue is full.
+
+	maintenance    atomic.Bool // drop all requests in maintenance.
+	maintenanceKoD atomic.Bool // answer dropped requests with kiss code.
+
+	mu       sync.Mutex     // guards conns and shutdown.
+	conns    []*net.UDPConn // connections of a serving server.
+	shutdown bool           // Shutdown is called while serving.
+}
+
+// KissCodeRestricted is the kiss code to tell clients, that access is
+// restricted. The code is sent in maintenance mode.
+const KissCodeRestricted = ntp.KissCodeRestricted
+
+// SetMaintenance set the maintenance mode of the server. In maintenance
+// mode, all ntp requests are dropped, so that clients fail over to other
+// servers. When kissOfDeath is true, dropped requests are answered with a
+// Kiss-o'-Death package with the KissCodeRestricted code instead.
+func (s *Server) SetMaintenance(enabled bool, kissOfDeath bool) {
+	s.maintenanceKoD.Store(kissOfDeath)
+	s.maintenance.Store(enabled)
+}
+
+// Maintenance get the maintenance mode of the server and whether requests
+// are answered with a Kiss-o'-Death package.
+func (s *Server) Maintenance() (enabled bool, kissOfDeath bool) {
+	return s.maintenance.Load(), s.maintenanceKoD.Load()
+}
+
+// SetInline set whether requests are handled inline in the read loop. By
+// default, requests are queued for a pool of workers, which maximizes
+// the throughput under load but adds scheduling jitter to each response.
+// Inline handling avoids the queue and gives a lower and more consistent
+// latency, but requests are answered one after another. This fits low
+// traffic and latency sensitive deployments.
+func (s *Server) SetInline(inline bool) {
+	s.inline = inline
+}
+
+// SetWorkerPool set the number of workers handling requests and the number
+// of requests queued for them. The pool bounds the goroutines and memory
+// under a burst of requests. When the queue is full, a request is dropped
+// or, when kissOfDeath is true, answered with a Kiss-o'-Death package with
+// the ntp.KissCodeRate code. By default, there is one worker per cpu and
+// DefaultQueueSize requests are queued.
+func (s *Server) SetWorkerPool(workers int, queueSize int, kissOfDeath bool) {
+	s.workers = max(workers, 1)
+	s.queueSize = max(queueSize, 0)
+	s.queueKoD = kissOfDeath
+}
+
+// SetTimers set the TimerCollection of the served timers. The server sets
+// the last served time of the collection entry, when a Timer is selected
+// to answer a request. Without a collection, nothing is tracked.
+func (s *Server) SetTimers(timers *TimerCollection) {
+	s.timers = timers
+}
+
+// SetRateLimiter set the RateLimiter of the client requests. A client, that
+// exceeds its request rate, is answered with a Kiss-o'-Death package with
+// the ntp.KissCodeRate code. Without a limiter, all requests are answered.
+func (s *Server) SetRateLimiter(limiter *RateLimiter) {
+	s.limiter = limiter
+}
+
+// SetKeyStore set the ntp.KeyStore of the symmetric key authentication. A
+// request with a MAC is verified with the key of its key identifier and
+// the response is signed with the same key. A request with an invalid MAC
+// or an unknown key is dropped. Requests without MAC are answered without
+// MAC.
+func (s *Server) SetKeyStore(keys *ntp.KeyStore) {
+	s.keys = keys
+}
+
+// SetRoutingFallback set the fallback of a failed routing. When the
+// RoutingStrategy finds no Timer for a client, the request is answered by
+// the fallback Timer. Without a fallback Timer, the request is dropped or,
+// when kissOfDeath is true, answered with a Kiss-o'-Death package with the
+// ntp.KissCodeDeny code.
+func (s *Server) SetRoutingFallback(timer Timer, kissOfDeath bool) {
+	s.fallback = timer
+	s.fallbackKoD = kissOfDeath
+}
+
+// SetLoopbackTimer set the diagnostic Timer of the loopback clients. The
+// requests of a loopback client, like the self monitoring of the server
+// host, are answered by timer instead of the routed Timer and logged with
+// the diagnostic field on info level. A nil timer routes loopback clients
+// like all other clients. The additional listeners are not affected.
+func (s *Server) SetLoopbackTimer(timer Timer) {
+	s.loopback = timer
+}
+
+// SetMetricsCollector set the MetricsCollector of the request handling. By
+// default, the metrics are collected by DefaultMetricsCollector.
+func (s *Server) SetMetricsCollector(collector MetricsCollector) {
+	s.collector = collector
+}
+
+// SetResponseLog set the ResponseLog, that records the last response
+// served to each client. Without ResponseLog, no response is recorded.
+func (s *Server) SetResponseLog(responses *ResponseLog) {
+	s.responses = responses
+}
+
+// SetCaptureWriter set the CaptureWriter of the sent response packets.
+// Each packet sent to a client, also a Kiss-o'-Death package, is captured
+// for offline analysis. Without CaptureWriter, nothing is captured.
+func (s *Server) SetCaptureWriter(capture *CaptureWriter) {
+	s.capture = capture
+}
+
+// SetChecker set the NtpServerChecker, that reports the health of the
+// server. Serve marks the checker as serving and as stopped, when the
+// connections are closed. Each answered request is recorded.
+func (s *Server) SetChecker(checker *NtpServerChecker) {
+	s.checker = checker
+}
+
+// SetValidationLevel set the ntp.ValidationLevel of the requests. A request,
+// that is rejected at the level, is dropped. By default, requests are
+// validated with ntp.ValidationLenient.
+func (s *Server) SetValidationLevel(level ntp.ValidationLevel) {
+	s.validation = level
+}
+
+// ResponseOptions configure the responses of an additional listener of a
+// Server. The zero value answers like the server port.
+type ResponseOptions struct {
+	// OmitOriginate answers with an unset originate timestamp instead of
+	// echoing the request transmit timestamp.
+	OmitOriginate bool
+}
+
+// An additional listener of a Server, that answers all requests of its
+// port with one Timer.
+type listener struct {
+	port    int
+	timer   Timer
+	options ResponseOptions
+}
+
+// AddListener add an additional port, on which the server answers all
+// requests with timer instead of the routed Timer. The options are applied
+// to the responses of the port, so that the same Timer can be served with
+// different response policies side by side. The listener is bound on Serve.
+func (s *Server) AddListener(port int, timer Timer, options ResponseOptions) {
+	s.listeners = append(s.listeners, listener{
+		port:    port,
+		timer:   timer,
+		options: options,
+	})
+}
+
+// SetNetwork set the network of the server addresses. The network is udp
+// for IPv4 and IPv6, udp4 for IPv4 only or udp6 for IPv6 only. A host name,
+// like localhost, is resolved to an address of the network. By default,
+// the network is udp. An error is returned for an unknown network.
+func (s *Server) SetNetwork(network string) error {
+	switch network {
+	case "udp", "udp4", "udp6":
+		s.network = network
+		return nil
+	default:
+		return fmt.Errorf("unknown ntp network %q", network)
+	}
+}
+
+// AddAddress add an additional address, on which the server answers the
+// requests like on the server address. This allows to serve multiple
+// interfaces, like an IPv4 and an IPv6 address of a dual-stack host. The
+// address is bound on Serve.
+func (s *Server) AddAddress(host string, port int) {
+	s.addrs = append(s.addrs, joinHostPort(host, port))
+}
+
+// ParseListener parse the port and ResponseOptions of an additional
+// listener from spec. The spec is a port followed by colon separated
+// options, like "1123:omit-origin".
+func ParseListener(spec string) (int, ResponseOptions, error) {
+	var options ResponseOptions
+	fields := strings.Split(spec, ":")
+	port, err := strconv.Atoi(fields[0])
+	if err != nil || port < 0 || port > 65535 {
+		return 0, options, fmt.Errorf("invalid listener port %q", fields[0])
+	}
+	for _, option := range fields[1:] {
+		switch option {
+		case "omit-origin":
+			options.OmitOriginate = true
+		default:
+			return 0, options, fmt.Errorf(
+				"unknown listener option %q", option)
+		}
+	}
+	return port, options, nil
 }
 
 // Serve start serving of the ntp server. The function is not returning until
-// the server received an unhandled error. All known errors are write to log
-// and skip the current connection,
-func (s *Server) Serve() {
+// the server connection is closed or the server is shut down. The additional
+// addresses and listeners are served in background and closed with the
+// server connection. All known errors are write to log and skip the current
+// connection. When the server can not listen on one of its addresses, an
+// error is returned.
+func (s *Server) Serve() error {
+	// Listen on the server address, all additional addresses and all
+	// additional ports, before any request is served. All connections are
+	// closed on return.
+	addrs := append([]string{s.getAddrStr(s.port)}, s.addrs...)
+	for _, l := range s.listeners {
+		addrs = append(addrs, s.getAddrStr(l.port))
+	}
+	conns := make([]*net.UDPConn, 0, len(addrs))
+	defer func() {
+		s.stopped()
+		for _, conn := range conns {
+			err := conn.Close()
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Error(err)
+			}
+		}
+	}()
+	for _, addr := range addrs {
+		conn, err := s.listen(addr)
+		if err != nil {
+			if s.checker != nil {
+				s.checker.SetStopped(err)
+			}
+			return err
+		}
+		conns = append(conns, conn)
+	}
+	s.setConns(conns)
+	if s.checker != nil {
+		s.checker.SetServing()
+	}
+
+	// Serve the additional addresses and listeners in background until
+	// the server connection is closed. Each connection has its own read
+	// loop and answers on its own socket.
+	var wg sync.WaitGroup
+	served := 1 + len(s.addrs)
+	for idx, conn := range conns[1:] {
+		var l *listener
+		if idx+1 >= served {
+			l = &s.listeners[idx+1-served]
+		}
+		wg.Add(1)
+		go func(conn *net.UDPConn, l *listener) {
+			defer wg.Done()
+			s.serveListener(conn, l)
+		}(conn, l)
+	}
+	s.serve(conns[0])
+	for _, conn := range conns[1:] {
+		_ = conn.Close()
+	}
+	wg.Wait()
+	return nil
+}
+
+// Shutdown close all connections of the server, so that Serve returns.
+// The queued requests are handled before Serve returns. When the server
+// is not serving, nothing is done.
+func (s *Server) Shutdown() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = len(s.conns) != 0
+	var errs []error
+	for _, conn := range s.conns {
+		err := conn.Close()
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Addrs get the local addresses of the server connections, while the
+// server is serving. The server address is the first address, followed
+// by the additional addresses and the additional ports.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]net.Addr, 0, len(s.conns))
+	for _, conn := range s.conns {
+		addrs = append(addrs, conn.LocalAddr())
+	}
+	return addrs
+}
+
+// Set the connections of a serving server.
+func (s *Server) setConns(conns []*net.UDPConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns = conns
+}
+
+// Clear the connections of a stopped server and mark the checker as
+// stopped. Without Shutdown, the server stopped unexpectedly.
+func (s *Server) stopped() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checker != nil && s.conns != nil {
+		err := ErrUnexpectedStop
+		if s.shutdown {
+			err = ErrServerShutdown
+		}
+		s.checker.SetStopped(err)
+	}
+	s.conns, s.shutdown = nil, false
+}
+
+// Listen with an udp socket of the server network on address.
+func (s *Server) listen(address string) (*net.UDPConn, error) {
 	// Setup socket server address.
-	addr := s.getAddr()
+	addr, err := net.ResolveUDPAddr(s.network, address)
+	if err != nil {
+		return nil, err
+	}
 
 	// Listen to address with udp socket.
-	conn, err := net.ListenUDP(addr.Network(), addr)
+	conn, err := net.ListenUDP(s.network, addr)
 	if err != nil {
-		log.Panic(err)
+		return nil, listenError(addr, err)
 	}
+	log.Infof("server listening on %s", conn.LocalAddr())
+	return conn, nil
+}
 
-	// Ready for listening, make secure socket closing.
-	defer func(conn *net.UDPConn) {
-		err := conn.Close()
-		if err != nil {
-			log.Error(err)
+// Explain a listen error of addr. Ports below 1024, like the ntp port 123,
+// are privileged; binding them is not permitted without root or the
+// CAP_NET_BIND_SERVICE capability.
+func listenError(addr *net.UDPAddr, err error) error {
+	if errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("can not listen on %s: permission denied; "+
+			"run as root, grant the capability with "+
+			"'setcap cap_net_bind_service=+ep <binary>' "+
+			"or use a port above 1023: %w", addr, err)
+	}
+	return fmt.Errorf("can not listen on %s: %w", addr, err)
+}
+
+// Serve requests from conn until conn is closed.
+func (s *Server) serve(conn *net.UDPConn) {
+	s.serveListener(conn, nil)
+}
+
+// Serve requests from conn of the additional listener l until conn is
+// closed. Without listener, requests are served like on the server port.
+func (s *Server) serveListener(conn *net.UDPConn, l *listener) {
+	// Start the workers, that handle the queued requests. The queued
+	// requests are handled before serving ends.
+	var jobs chan requestJob
+	if !s.inline {
+		jobs = make(chan requestJob, s.queueSize)
+		var wg sync.WaitGroup
+		for i := 0; i < s.workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for job := range jobs {
+					s.handleListenerRequest(conn, l,
+						job.addr, job.data, job.rxTimestamp)
+					job.release()
+				}
+			}()
 		}
-	}(conn)
-	log.Infof("server listening on %s", s.getAddrStr())
+		defer wg.Wait()
+		defer close(jobs)
+	}
 
 	for {
-		// Read received data from remote udp socket.
-		data := make([]byte, 48)
+		// Read received data from remote udp socket into a pooled
+		// buffer.
+		buf := receiveBuffers.Get().(*[]byte)
+		data := *buf
 		rLen, rAddr, err := conn.ReadFromUDP(data)
 		if err != nil {
-			// It is possible that the connection is closed. On this
-			// case a panic must be logged, because it is not expected
-			// and handled by the current server implementation.
-			log.Panic(err)
+			receiveBuffers.Put(buf)
+			// A closed connection ends serving. Timeouts and other
+			// temporary read errors skip the current datagram.
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			log.Error(err)
+			continue
 		}
 
 		// Get receive timestamp so fast as possible.
@@ -73,31 +498,78 @@ func (s *Server) Serve() {
 
 		// Be sure that remote address is set.
 		if rAddr == nil {
+			receiveBuffers.Put(buf)
 			log.Warn("request has missing remote address")
 			continue
 		}
-		log.Infof("read %d bytes of data from %s", rLen, rAddr)
+		log.WithFields(log.Fields{
+			"client": clientAddr(rAddr),
+			"bytes":  rLen,
+		}).Info("read request data")
+
+		// Drop datagrams, that are too short for a ntp package.
+		if rLen < ntp.PackageSize {
+			receiveBuffers.Put(buf)
+			log.WithFields(log.Fields{
+				"client": clientAddr(rAddr),
+				"bytes":  rLen,
+			}).Warn("drop short request")
+			continue
+		}
 
-		// Handle connections in background.
-		go s.handleRequest(conn, rAddr, data, rxTimestamp)
+		// Handle connections inline or queue them for the workers.
+		job := requestJob{rAddr, data[:rLen], rxTimestamp, buf}
+		if s.inline {
+			s.handleListenerRequest(conn, l,
+				job.addr, job.data, job.rxTimestamp)
+			job.release()
+		} else {
+			s.enqueue(conn, jobs, job)
+		}
 	}
 
-	// TODO: Need to gracefully shutdown
-	// log.Info("shutting down")
+	log.Info("shutting down")
+}
+
+// Queue job for the workers. When the queue is full, the request is
+// dropped or answered with a kiss code.
+func (s *Server) enqueue(
+	conn *net.UDPConn,
+	jobs chan<- requestJob,
+	job requestJob,
+) {
+	select {
+	case jobs <- job:
+		return
+	default:
+	}
+	defer job.release()
+	s.collector.Error(metrics.StageQueue)
+	logger := requestLogger(job.addr)
+	if !s.queueKoD {
+		logger.Warn("drop ntp request with full queue")
+		return
+	}
+	pkg, err := ntp.PackageFromBytes(job.data)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	pkg.SetReceiveTimestamp(job.rxTimestamp)
+	pkg = ntp.NewKissPackage(pkg, ntp.KissCodeRate)
+	s.writeResponse(logger, conn, job.addr, pkg)
 }
 
 // Get the server address string from host and port.
-func (s *Server) getAddrStr() string {
-	return fmt.Sprintf("%s:%d", s.host, s.port)
+func (s *Server) getAddrStr(port int) string {
+	return joinHostPort(s.host, port)
 }
 
-// Get the server address from host and port.
-func (s *Server) getAddr() *net.UDPAddr {
-	addr, err := net.ResolveUDPAddr("udp", s.getAddrStr())
-	if err != nil {
-		log.Panic(err)
-	}
-	return addr
+// Join host and port to an address string. An IPv6 host is enclosed in
+// square brackets.
+func joinHostPort(host string, port int) string {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(port))
 }
 
 // Handle a ntp request from conn and remote addr. The connection must not
@@ -109,43 +581,265 @@ func (s *Server) handleRequest(
 	data []byte,
 	rxTimestamp time.Time,
 ) {
-	// Parse request data to a ntp package.
-	pkg, err := ntp.PackageFromBytes(data)
+	s.handleListenerRequest(conn, nil, addr, data, rxTimestamp)
+}
+
+// Handle a ntp request from conn of the additional listener l like
+// handleRequest. Without listener, the request is handled like on the
+// server port.
+func (s *Server) handleListenerRequest(
+	conn *net.UDPConn,
+	l *listener,
+	addr *net.UDPAddr,
+	data []byte,
+	rxTimestamp time.Time,
+) {
+	// All log entries of the request carry its id and the client, so
+	// that the steps of a request can be correlated.
+	logger := requestLogger(addr)
+
+	// Parse request data to a ntp package. Unless validation is
+	// permissive, a package with an unsupported header is dropped.
+	parse := ntp.PackageFromBytes
+	if s.validation > ntp.ValidationPermissive {
+		parse = ntp.PackageFromBytesStrict
+	}
+	pkg, err := parse(data)
+	if errors.Is(err, ntp.ErrInvalidVersion) ||
+		errors.Is(err, ntp.ErrInvalidMode) ||
+		errors.Is(err, ntp.ErrInvalidStratum) {
+		s.collector.Error(metrics.StageValidate)
+		logger.WithError(err).Info("drop invalid ntp request")
+		return
+	}
 	if err != nil {
-		log.Error(err)
+		s.collector.Error(metrics.StageParse)
+		logger.Error(err)
 		return
 	}
+	s.collector.Request(pkg.GetMode())
+	logger = logger.WithField("mode", pkg.GetMode())
 
 	pkg.SetReceiveTimestamp(rxTimestamp)
-	log.Infof("read ntp request %s", pkg)
+	logger.Info("read ntp request")
+	if log.IsLevelEnabled(log.DebugLevel) {
+		logger.WithFields(requestFields(addr, pkg)).
+			Debug("decoded ntp request")
+	}
+
+	// Drop requests, that are rejected at the validation level.
+	if err := pkg.Validate(s.validation); err != nil {
+		s.collector.Error(metrics.StageValidate)
+		logger.WithError(err).Info("drop invalid ntp request")
+		return
+	}
 
-	// Find response timer by client addr.
-	timer, err := s.routing.FindTimer(addr.IP)
+	// Drop requests in maintenance mode.
+	if enabled, kissOfDeath := s.Maintenance(); enabled {
+		if !kissOfDeath {
+			logger.Info("drop ntp request in maintenance")
+			return
+		}
+		pkg = ntp.NewKissPackage(pkg, KissCodeRestricted)
+		s.writeResponse(logger, conn, addr, pkg)
+		return
+	}
+
+	// Tell clients to back off, that exceed their request rate.
+	if s.limiter != nil && !s.limiter.Allow(addr.IP) {
+		logger.Info("limit ntp request rate")
+		pkg = ntp.NewKissPackage(pkg, ntp.KissCodeRate)
+		s.writeResponse(logger, conn, addr, pkg)
+		return
+	}
+
+	// Verify the MAC of a signed request. Without ntp.KeyStore, a signed
+	// request can not be verified.
+	_, signed := pkg.GetKeyId()
+	var keyId uint32
+	var key ntp.Key
+	if signed {
+		verified := false
+		if s.keys != nil {
+			keyId, key, verified = s.keys.Verify(pkg)
+		}
+		if !verified {
+			s.collector.Error(metrics.StageAuth)
+			logger.Info("drop ntp request with invalid MAC")
+			return
+		}
+	}
+
+	// Find response timer by client addr. The zone of an IPv6 link-local
+	// address is not part of addr.IP, so that link-local clients match
+	// the IPv6 routes without zone. An additional listener answers with
+	// its own timer and a loopback client with the diagnostic timer.
+	var route RoutingTableEntry
+	if l != nil {
+		route = s.timerRoute(l.timer)
+	} else if s.loopback != nil && addr.IP.IsLoopback() {
+		route = s.timerRoute(s.loopback)
+		logger.WithFields(requestFields(addr, pkg)).
+			WithField("diagnostic", true).
+			Info("diagnostic ntp request")
+	} else {
+		route, err = s.routing.FindRoute(addr.IP)
+	}
+	if err != nil {
+		s.collector.Error(metrics.StageRouting)
+		logger.Error(err)
+		route, err = s.fallbackRoute()
+	}
 	if err != nil {
-		log.Error(err)
+		if s.fallbackKoD {
+			pkg = ntp.NewKissPackage(pkg, ntp.KissCodeDeny)
+			s.writeResponse(logger, conn, addr, pkg)
+		}
 		return
 	}
+	s.collector.RouteMatch(route)
+	logger = logger.WithField("timer_id", route.TimerId)
+	timer := route.Timer
 
 	// Create response from requested package.
-	pkg, err = PackageFromTimer(
-		pkg, timer.Package(), timer)
+	if s.timers != nil {
+		s.timers.Served(route.TimerId, time.Now())
+		pkg, err = s.timers.PackageFromTimer(pkg, timer)
+	} else {
+		pkg, err = PackageFromTimer(pkg, timer.Package(), timer)
+	}
+	if err == nil {
+		// Strict clients reject a response, whose leap indicator and
+		// stratum contradict each other, like an unsynchronized timer
+		// at stratum 1.
+		if pkg.CorrectLeapStratum() {
+			s.collector.Correction(metrics.CorrectionLeapStratum)
+			logger.WithField("stratum", pkg.GetStratum()).
+				Debug("correct stratum of unsynchronized timer")
+		}
+		// The extension fields of the request are not answered. Sign
+		// the response with the key of the request.
+		_ = pkg.SetExtensionFields(nil)
+		if l != nil && l.options.OmitOriginate {
+			pkg.SetOriginateTimestamp(ntp.Epoch)
+		}
+		pkg.ClearMAC()
+		if signed {
+			err = pkg.AppendMAC(keyId, key.Secret, key.Algo)
+		}
+	}
 	if err != nil {
-		log.Error(err)
+		s.collector.Error(metrics.StageMarshal)
+		logger.Error(err)
 		return
 	}
 
+	// Send response package to client.
+	if s.writeResponse(logger, conn, addr, pkg) {
+		s.collector.Response(timer, time.Since(rxTimestamp))
+		if s.responses != nil {
+			s.responses.Record(addr.IP, timer, pkg)
+		}
+	}
+}
+
+// Get the route of the fallback Timer. The id of the fallback Timer is
+// looked up in the TimerCollection. Without a fallback Timer, an error is
+// returned.
+func (s *Server) fallbackRoute() (RoutingTableEntry, error) {
+	if s.fallback == nil {
+		return RoutingTableEntry{}, errors.New("no routing fallback timer")
+	}
+	return s.timerRoute(s.fallback), nil
+}
+
+// Get the route of timer. The id of timer is looked up in the
+// TimerCollection; an unknown timer has the id -1.
+func (s *Server) timerRoute(timer Timer) RoutingTableEntry {
+	route := RoutingTableEntry{Timer: timer, TimerId: -1}
+	if s.timers != nil {
+		if entry, ok := s.timers.Find(timer); ok {
+			route.TimerId = entry.Id
+		}
+	}
+	return route
+}
+
+// Write a ntp response package to the client addr on conn and log with
+// the request logger. On success, true is returned.
+func (s *Server) writeResponse(
+	logger *log.Entry,
+	conn *net.UDPConn,
+	addr *net.UDPAddr,
+	pkg *ntp.Package,
+) bool {
 	// Convert package data to bytes array.
 	resBytes, err := pkg.ToBytes()
 	if err != nil {
-		log.Error(err)
-		return
+		s.collector.Error(metrics.StageMarshal)
+		logger.Error(err)
+		return false
 	}
 
 	// Send response to client.
-	log.Infof("write ntp response to %s", addr)
+	logger.Info("write ntp response")
 	_, err = conn.WriteToUDP(resBytes, addr)
 	if err != nil {
-		log.Error(err)
-		return
+		s.collector.Error(metrics.StageWrite)
+		logger.Error(err)
+		return false
+	}
+	if s.checker != nil {
+		s.checker.SetHandled(time.Now())
+	}
+	// Capture the sent packet; a failed capture does not fail the
+	// response.
+	if s.capture != nil {
+		err = s.capture.Write(addr.IP, time.Now(), resBytes)
+		if err != nil {
+			logger.WithError(err).Error("can not capture ntp response")
+		}
+	}
+	return true
+}
+
+// Identifier of the last request; see requestLogger.
+var lastRequestId atomic.Uint64
+
+// Create the logger of a request from addr. The log entries of the logger
+// have a generated req_id unique for the process and the client address.
+// The zone of an IPv6 link-local address is a separate field.
+func requestLogger(addr *net.UDPAddr) *log.Entry {
+	fields := log.Fields{
+		"req_id": lastRequestId.Add(1),
+		"client": clientAddr(addr),
+	}
+	if addr.Zone != "" {
+		fields["zone"] = addr.Zone
+	}
+	return log.WithFields(fields)
+}
+
+// Get the client address of addr for logging. The zone of an IPv6
+// link-local address is stripped; it is logged in a separate field.
+func clientAddr(addr *net.UDPAddr) string {
+	return net.JoinHostPort(addr.IP.String(), strconv.Itoa(addr.Port))
+}
+
+// Get the decoded header fields of a ntp request package from addr as
+// structured log fields.
+func requestFields(addr *net.UDPAddr, pkg *ntp.Package) log.Fields {
+	fields := log.Fields{
+		"client":    clientAddr(addr),
+		"leap":      pkg.GetLeap(),
+		"version":   pkg.GetVersion(),
+		"mode":      pkg.GetMode(),
+		"stratum":   pkg.GetStratum(),
+		"poll":      pkg.GetPoll(),
+		"precision": int8(pkg.GetPrecision()),
+	}
+	if addr.Zone != "" {
+		fields["zone"] = addr.Zone
 	}
+	return fields
 }
@@ -0,0 +1,1525 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/metrics"
+	"github.com/donsprallo/zeitgeist/internal/ntp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// Create a connected pair of udp server and client connections on the
+// loopback interface. The connections are closed after test.
+func newTestConnPair(t testing.TB) (*net.UDPConn, *net.UDPConn) {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	serverConn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		t.Fatalf("can not listen udp: %s", err)
+	}
+	t.Cleanup(func() { _ = serverConn.Close() })
+	clientConn, err := net.DialUDP(
+		"udp", nil, serverConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("can not dial udp: %s", err)
+	}
+	t.Cleanup(func() { _ = clientConn.Close() })
+	return serverConn, clientConn
+}
+
+// Create a ntp client request package.
+func newTestRequest() *ntp.Package {
+	var pkg ntp.Package
+	precision := int8(-20)
+	pkg.SetLeap(ntp.LeapNotSyn)
+	pkg.SetVersion(ntp.VersionV4)
+	pkg.SetMode(ntp.ModeClient)
+	pkg.SetPoll(6)
+	pkg.SetPrecision(uint32(uint8(precision)))
+	pkg.SetTransmitTimestamp(time.Now())
+	return &pkg
+}
+
+// Read a ntp response package from conn.
+func readTestResponse(t *testing.T, conn *net.UDPConn) *ntp.Package {
+	err := conn.SetReadDeadline(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("can not set read deadline: %s", err)
+	}
+	data := make([]byte, ntp.PackageSize)
+	_, err = conn.Read(data)
+	if err != nil {
+		t.Fatalf("can not read response: %s", err)
+	}
+	pkg, err := ntp.PackageFromBytes(data)
+	if err != nil {
+		t.Fatalf("can not parse response: %s", err)
+	}
+	return pkg
+}
+
+// Create a server with a SystemTimer as default timer.
+func newTestServer() *Server {
+	timer := &SystemTimer{}
+	timer.NTPPackage.SetVersion(ntp.VersionV4)
+	timer.NTPPackage.SetMode(ntp.ModeServer)
+	timer.NTPPackage.SetStratum(1)
+	routing := NewStaticRouting(NewRoutingTable(10), timer, 0)
+	return NewServer("127.0.0.1", 0, routing)
+}
+
+// TestHandleRequestDebugLog test that the decoded request header fields
+// are logged on debug level.
+func TestHandleRequestDebugLog(t *testing.T) {
+	hook := test.NewGlobal()
+	level := log.GetLevel()
+	log.SetLevel(log.DebugLevel)
+	t.Cleanup(func() {
+		log.SetLevel(level)
+		hook.Reset()
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	})
+
+	serverConn, clientConn := newTestConnPair(t)
+	data, _ := newTestRequest().ToBytes()
+	s := newTestServer()
+	s.handleRequest(serverConn,
+		clientConn.LocalAddr().(*net.UDPAddr), data, time.Now())
+
+	// Find the decoded request log entry.
+	var entry *log.Entry
+	for _, e := range hook.AllEntries() {
+		if e.Message == "decoded ntp request" {
+			entry = e
+		}
+	}
+	if entry == nil {
+		t.Fatalf("no decoded request log entry")
+	}
+	if entry.Level != log.DebugLevel {
+		t.Errorf("invalid log level: %s", entry.Level)
+	}
+
+	// Test that all header fields are logged.
+	want := log.Fields{
+		"client":    clientConn.LocalAddr().String(),
+		"leap":      ntp.LeapNotSyn,
+		"version":   ntp.VersionV4,
+		"mode":      ntp.ModeClient,
+		"stratum":   uint32(0),
+		"poll":      uint32(6),
+		"precision": int8(-20),
+	}
+	for key, value := range want {
+		if entry.Data[key] != value {
+			t.Errorf("invalid log field %s: want %v get %v",
+				key, value, entry.Data[key])
+		}
+	}
+
+	// The request must still be answered.
+	readTestResponse(t, clientConn)
+}
+
+// TestServeMalformedRequest test that the server keeps serving after an
+// undersized datagram.
+func TestServeMalformedRequest(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	s := newTestServer()
+	done := make(chan struct{})
+	go func() {
+		s.serve(serverConn)
+		close(done)
+	}()
+
+	// Send an undersized datagram; it must be dropped.
+	_, err := clientConn.Write(make([]byte, 10))
+	if err != nil {
+		t.Fatalf("can not write request: %s", err)
+	}
+
+	// Send a valid request; it must be answered.
+	data, _ := newTestRequest().ToBytes()
+	_, err = clientConn.Write(data)
+	if err != nil {
+		t.Fatalf("can not write request: %s", err)
+	}
+	pkg := readTestResponse(t, clientConn)
+	if pkg.GetMode() != ntp.ModeServer {
+		t.Errorf("invalid response mode: %d", pkg.GetMode())
+	}
+
+	// Closing the connection must end serving.
+	_ = serverConn.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("server does not stop on closed connection")
+	}
+}
+
+// Benchmark the request latency of a server. The latency distribution is
+// reported as median and 99th percentile.
+func benchmarkServe(b *testing.B, inline bool) {
+	level := log.GetLevel()
+	log.SetLevel(log.WarnLevel)
+	b.Cleanup(func() { log.SetLevel(level) })
+
+	serverConn, clientConn := newTestConnPair(b)
+	s := newTestServer()
+	s.SetInline(inline)
+	go s.serve(serverConn)
+
+	data, _ := newTestRequest().ToBytes()
+	response := make([]byte, ntp.PackageSize)
+	latencies := make([]time.Duration, 0, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		_, err := clientConn.Write(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = clientConn.Read(response)
+		if err != nil {
+			b.Fatal(err)
+		}
+		latencies = append(latencies, time.Since(start))
+	}
+	b.StopTimer()
+
+	slices.Sort(latencies)
+	b.ReportMetric(float64(latencies[len(latencies)/2].Nanoseconds()),
+		"p50-ns")
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()),
+		"p99-ns")
+}
+
+func BenchmarkServePool(b *testing.B) {
+	benchmarkServe(b, false)
+}
+
+func BenchmarkServeInline(b *testing.B) {
+	benchmarkServe(b, true)
+}
+
+// Benchmark the allocations of a server under a burst of requests. The
+// requests of a burst are sent before the responses are read.
+func benchmarkServeBurst(b *testing.B, inline bool) {
+	const burst = 32
+	level := log.GetLevel()
+	log.SetLevel(log.WarnLevel)
+	b.Cleanup(func() { log.SetLevel(level) })
+
+	serverConn, clientConn := newTestConnPair(b)
+	s := newTestServer()
+	s.SetInline(inline)
+	s.SetWorkerPool(4, burst, false)
+	go s.serve(serverConn)
+
+	data, _ := newTestRequest().ToBytes()
+	response := make([]byte, ntp.PackageSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < burst; j++ {
+			if _, err := clientConn.Write(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for j := 0; j < burst; j++ {
+			if _, err := clientConn.Read(response); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkServeBurstPool(b *testing.B) {
+	benchmarkServeBurst(b, false)
+}
+
+func BenchmarkServeBurstInline(b *testing.B) {
+	benchmarkServeBurst(b, true)
+}
+
+// TestServeWorkerPool test that requests are answered by the workers and
+// that serving ends after the queued requests are handled.
+func TestServeWorkerPool(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	s := newTestServer()
+	s.SetWorkerPool(2, 8, false)
+	done := make(chan struct{})
+	go func() {
+		s.serve(serverConn)
+		close(done)
+	}()
+
+	// Send a burst of requests; all must be answered.
+	data, _ := newTestRequest().ToBytes()
+	for i := 0; i < 8; i++ {
+		if _, err := clientConn.Write(data); err != nil {
+			t.Fatalf("can not write request: %s", err)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		pkg := readTestResponse(t, clientConn)
+		if pkg.GetMode() != ntp.ModeServer {
+			t.Errorf("invalid response mode: %d", pkg.GetMode())
+		}
+	}
+
+	_ = serverConn.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("server does not stop on closed connection")
+	}
+}
+
+// TestEnqueueFull test that a request is dropped or answered with a kiss
+// code, when the queue is full.
+func TestEnqueueFull(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	data, _ := newTestRequest().ToBytes()
+	job := requestJob{
+		clientConn.LocalAddr().(*net.UDPAddr), data, time.Now(), nil}
+	s := newTestServer()
+	// A queue without capacity and workers is always full.
+	jobs := make(chan requestJob)
+
+	// By default, the request is dropped.
+	queueErrors := testutil.ToFloat64(
+		metrics.ErrorsTotal.WithLabelValues(metrics.StageQueue))
+	s.enqueue(serverConn, jobs, job)
+	_ = clientConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := clientConn.Read(make([]byte, ntp.PackageSize))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("request answered with full queue: %v", err)
+	}
+	if testutil.ToFloat64(metrics.ErrorsTotal.WithLabelValues(
+		metrics.StageQueue)) != queueErrors+1 {
+		t.Errorf("queue errors counter not incremented")
+	}
+
+	// With kiss of death, the request is answered with a kiss code.
+	s.SetWorkerPool(1, 0, true)
+	s.enqueue(serverConn, jobs, job)
+	pkg := readTestResponse(t, clientConn)
+	if code, ok := pkg.GetKissCode(); !ok || code != ntp.KissCodeRate {
+		t.Errorf("invalid kiss code: %q", code)
+	}
+}
+
+// TestHandleRequestMaintenance test that requests are dropped or answered
+// with a kiss code in maintenance mode.
+func TestHandleRequestMaintenance(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+	req := newTestRequest()
+	data, _ := req.ToBytes()
+	s := newTestServer()
+
+	// In maintenance mode, the server must be silent.
+	s.SetMaintenance(true, false)
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	_ = clientConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := clientConn.Read(make([]byte, ntp.PackageSize))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("server responds in maintenance mode: %v", err)
+	}
+
+	// With kiss of death, the server must answer with a kiss code.
+	s.SetMaintenance(true, true)
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	pkg := readTestResponse(t, clientConn)
+	if pkg.GetStratum() != 0 ||
+		string(pkg.GetReferenceClockId()) != KissCodeRestricted {
+		t.Errorf("invalid kiss package: stratum %d refid %q",
+			pkg.GetStratum(), pkg.GetReferenceClockId())
+	}
+
+	// Without maintenance mode, the server must answer.
+	s.SetMaintenance(false, false)
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	pkg = readTestResponse(t, clientConn)
+	if pkg.GetStratum() != 1 {
+		t.Errorf("invalid response stratum: %d", pkg.GetStratum())
+	}
+}
+
+// TestHandleRequestLastServed test that the last served time is set on
+// the timer, that is routed to answer a request.
+func TestHandleRequestLastServed(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+	data, _ := newTestRequest().ToBytes()
+
+	// Create a default timer and a loopback timer; requests from the
+	// client are routed to the loopback timer.
+	timers := NewTimerCollection(10)
+	defaultTimer := &SystemTimer{}
+	defaultId := timers.Add(defaultTimer)
+	clientTimer := &SystemTimer{}
+	clientId := timers.Add(clientTimer)
+	routing := NewStaticRouting(
+		NewRoutingTable(10), defaultTimer, defaultId)
+	routing.Table.MustAdd(net.IPNet{
+		IP:   clientAddr.IP,
+		Mask: net.CIDRMask(32, 32),
+	}, clientTimer, clientId)
+	s := NewServer("127.0.0.1", 0, routing)
+	s.SetTimers(timers)
+
+	// Serve a request; only the routed timer must be served.
+	before := time.Now()
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	readTestResponse(t, clientConn)
+	entry, _ := timers.Get(clientId)
+	if entry.LastServed.Before(before) {
+		t.Errorf("last served not updated: %s", entry.LastServed)
+	}
+	entry, _ = timers.Get(defaultId)
+	if !entry.LastServed.IsZero() {
+		t.Errorf("last served of unused timer: %s", entry.LastServed)
+	}
+
+	// A later request must update the last served time.
+	served, _ := timers.Get(clientId)
+	time.Sleep(time.Millisecond)
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	readTestResponse(t, clientConn)
+	entry, _ = timers.Get(clientId)
+	if !entry.LastServed.After(served.LastServed) {
+		t.Errorf("last served not updated: %s", entry.LastServed)
+	}
+}
+
+// TestHandleRequestCount test that the requests of each timer are counted
+// by the routed timer, also for concurrent requests.
+func TestHandleRequestCount(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+	data, _ := newTestRequest().ToBytes()
+
+	// Create a default timer and a client timer; requests from the
+	// client are routed to the client timer.
+	timers := NewTimerCollection(10)
+	defaultTimer := &SystemTimer{}
+	defaultId := timers.Add(defaultTimer)
+	clientTimer := &SystemTimer{}
+	clientId := timers.Add(clientTimer)
+	routing := NewStaticRouting(
+		NewRoutingTable(10), defaultTimer, defaultId)
+	routing.Table.MustAdd(net.IPNet{
+		IP:   clientAddr.IP,
+		Mask: net.CIDRMask(32, 32),
+	}, clientTimer, clientId)
+	s := NewServer("127.0.0.1", 0, routing)
+	s.SetTimers(timers)
+
+	// Handle requests concurrently; the responses are not read.
+	const requests = 20
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleRequest(serverConn, clientAddr, data, time.Now())
+		}()
+	}
+	wg.Wait()
+
+	if entry, _ := timers.Get(clientId); entry.Requests != requests {
+		t.Errorf("invalid client timer requests: want %d get %d",
+			requests, entry.Requests)
+	}
+	if entry, _ := timers.Get(defaultId); entry.Requests != 0 {
+		t.Errorf("invalid default timer requests: %d", entry.Requests)
+	}
+}
+
+// TestHandleRequestUpstreamReference test that a synced NtpTimer serves
+// the reference id and reference timestamp of the upstream server.
+func TestHandleRequestUpstreamReference(t *testing.T) {
+	// Create a fake upstream server with a GPS reference.
+	upstreamConn, err := net.ListenUDP(
+		"udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("can not listen udp: %s", err)
+	}
+	t.Cleanup(func() { _ = upstreamConn.Close() })
+	reference := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	go func() {
+		data := make([]byte, ntp.PackageSize)
+		_, addr, err := upstreamConn.ReadFromUDP(data)
+		if err != nil {
+			return
+		}
+		req, _ := ntp.PackageFromBytes(data)
+		var pkg ntp.Package
+		pkg.SetOriginateTimestamp(req.GetTransmitTimestamp())
+		pkg.SetVersion(ntp.VersionV4)
+		pkg.SetMode(ntp.ModeServer)
+		pkg.SetStratum(1)
+		pkg.SetReferenceClockId([]byte("GPS\x00"))
+		pkg.SetReferenceTimestamp(reference)
+		pkg.SetTransmitTimestamp(time.Now())
+		res, _ := pkg.ToBytes()
+		_, _ = upstreamConn.WriteToUDP(res, addr)
+	}()
+
+	// Sync the timer with the upstream server.
+	upstreamAddr := upstreamConn.LocalAddr().(*net.UDPAddr)
+	result, err := ntp.Query(
+		upstreamAddr.IP.String(), upstreamAddr.Port, ntp.RequestOptions{})
+	if err != nil {
+		t.Fatalf("can not query upstream: %s", err)
+	}
+	upstream := result.Package
+	timer := &NtpTimer{}
+	timer.NTPPackage.SetReferenceClockId([]byte("NICO"))
+	timer.Sync(upstream)
+
+	// The client response must carry the upstream reference.
+	serverConn, clientConn := newTestConnPair(t)
+	data, _ := newTestRequest().ToBytes()
+	routing := NewStaticRouting(NewRoutingTable(10), timer, 0)
+	s := NewServer("127.0.0.1", 0, routing)
+	s.handleRequest(serverConn,
+		clientConn.LocalAddr().(*net.UDPAddr), data, time.Now())
+	pkg := readTestResponse(t, clientConn)
+	if refId := string(pkg.GetReferenceClockId()); refId != "GPS\x00" {
+		t.Errorf("invalid reference id: %q", refId)
+	}
+	if get := pkg.GetReferenceTimestamp(); !get.Equal(reference) {
+		t.Errorf("invalid reference timestamp: want %s get %s",
+			reference, get)
+	}
+}
+
+// TestHandleRequestLogFields test that the log entries of a request carry
+// the request id, the client, the mode and the timer id.
+func TestHandleRequestLogFields(t *testing.T) {
+	hook := test.NewGlobal()
+	level := log.GetLevel()
+	log.SetLevel(log.InfoLevel)
+	t.Cleanup(func() {
+		log.SetLevel(level)
+		hook.Reset()
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	})
+
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+	s := newTestServer()
+	for i := 0; i < 2; i++ {
+		data, _ := newTestRequest().ToBytes()
+		s.handleRequest(serverConn, clientAddr, data, time.Now())
+		readTestResponse(t, clientConn)
+	}
+
+	// Find the log entries of both requests by message.
+	var reads, writes []*log.Entry
+	for _, e := range hook.AllEntries() {
+		switch e.Message {
+		case "read ntp request":
+			reads = append(reads, e)
+		case "write ntp response":
+			writes = append(writes, e)
+		}
+	}
+	if len(reads) != 2 || len(writes) != 2 {
+		t.Fatalf("invalid log entries: %d reads %d writes",
+			len(reads), len(writes))
+	}
+	for idx, entry := range writes {
+		want := log.Fields{
+			"req_id":   reads[idx].Data["req_id"],
+			"client":   clientAddr.String(),
+			"mode":     ntp.ModeClient,
+			"timer_id": 0,
+		}
+		for key, value := range want {
+			if entry.Data[key] != value {
+				t.Errorf("invalid log field %s: want %v get %v",
+					key, value, entry.Data[key])
+			}
+		}
+	}
+	// Each request has its own id.
+	if reads[0].Data["req_id"] == reads[1].Data["req_id"] {
+		t.Errorf("requests with same id: %v", reads[0].Data["req_id"])
+	}
+}
+
+// TestLinkLocalClient test that a zoned IPv6 link-local client matches
+// the IPv6 route without zone and is logged without zone in the address.
+func TestLinkLocalClient(t *testing.T) {
+	addr := &net.UDPAddr{
+		IP: net.ParseIP("fe80::1"), Port: 123, Zone: "eth0"}
+	defaultTimer := DummyTimer{Message: "default"}
+	_, linkLocal, _ := net.ParseCIDR("fe80::/64")
+
+	// Both strategies must route the client to the link-local route.
+	strategies := []RoutingStrategy{
+		NewStaticRouting(NewRoutingTable(10), defaultTimer, 0),
+		NewTrieRouting(NewRoutingTable(10), defaultTimer, 0),
+	}
+	strategies[0].(*StaticRouting).Table.MustAdd(
+		*linkLocal, DummyTimer{Message: "link"}, 1)
+	strategies[1].(*TrieRouting).Table.MustAdd(
+		*linkLocal, DummyTimer{Message: "link"}, 1)
+	for _, strategy := range strategies {
+		timer, err := strategy.FindTimer(addr.IP)
+		if err != nil {
+			t.Errorf("%T find timer err: %s", strategy, err)
+			continue
+		}
+		if timer.(DummyTimer).Message != "link" {
+			t.Errorf("%T invalid timer: %v", strategy, timer)
+		}
+	}
+
+	// The zone must be logged in a separate field.
+	fields := requestFields(addr, newTestRequest())
+	if fields["client"] != "[fe80::1]:123" {
+		t.Errorf("invalid client field: %v", fields["client"])
+	}
+	if fields["zone"] != "eth0" {
+		t.Errorf("invalid zone field: %v", fields["zone"])
+	}
+	fields = requestFields(
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 123},
+		newTestRequest())
+	if _, ok := fields["zone"]; ok {
+		t.Errorf("zone field without zone: %v", fields["zone"])
+	}
+}
+
+// TestHandleRequestOriginate test that the response echoes the request
+// transmit timestamp as originate timestamp.
+func TestHandleRequestOriginate(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	req := newTestRequest()
+	data, _ := req.ToBytes()
+	s := newTestServer()
+	s.handleRequest(serverConn,
+		clientConn.LocalAddr().(*net.UDPAddr), data, time.Now())
+	pkg := readTestResponse(t, clientConn)
+	diff := pkg.GetOriginateTimestamp().Sub(req.GetTransmitTimestamp())
+	if diff.Abs() > ntp.DefaultOriginTolerance {
+		t.Errorf("invalid originate timestamp: want %s get %s",
+			req.GetTransmitTimestamp(), pkg.GetOriginateTimestamp())
+	}
+}
+
+// TestHandleRequestRateLimit test that a client exceeding its request
+// rate is answered with a RATE kiss code.
+func TestHandleRequestRateLimit(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+	data, _ := newTestRequest().ToBytes()
+	s := newTestServer()
+	s.SetRateLimiter(NewRateLimiter(time.Hour, 1))
+
+	// The first request is answered.
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	pkg := readTestResponse(t, clientConn)
+	if _, ok := pkg.GetKissCode(); ok {
+		t.Fatalf("first request answered with kiss code")
+	}
+	// The next request exceeds the rate.
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	pkg = readTestResponse(t, clientConn)
+	if code, ok := pkg.GetKissCode(); !ok || code != ntp.KissCodeRate {
+		t.Errorf("invalid kiss code: %q", code)
+	}
+}
+
+// TestHandleRequestMetrics test that a handled request moves the request,
+// response and latency metrics.
+func TestHandleRequestMetrics(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	data, _ := newTestRequest().ToBytes()
+	s := newTestServer()
+
+	// Remember the metrics before the request.
+	mode := strconv.Itoa(int(ntp.ModeClient))
+	requests := testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues(mode))
+	responses := testutil.ToFloat64(
+		metrics.ResponsesTotal.WithLabelValues("SystemTimer"))
+	observed := histogramCount(t, metrics.HandlerSeconds)
+	parseErrors := testutil.ToFloat64(
+		metrics.ErrorsTotal.WithLabelValues(metrics.StageParse))
+
+	// Handle a valid and an invalid request.
+	s.handleRequest(serverConn,
+		clientConn.LocalAddr().(*net.UDPAddr), data, time.Now())
+	readTestResponse(t, clientConn)
+	s.handleRequest(serverConn,
+		clientConn.LocalAddr().(*net.UDPAddr), data[:10], time.Now())
+
+	if testutil.ToFloat64(
+		metrics.RequestsTotal.WithLabelValues(mode)) != requests+1 {
+		t.Errorf("requests counter not incremented")
+	}
+	if testutil.ToFloat64(metrics.ResponsesTotal.WithLabelValues(
+		"SystemTimer")) != responses+1 {
+		t.Errorf("responses counter not incremented")
+	}
+	if histogramCount(t, metrics.HandlerSeconds) != observed+1 {
+		t.Errorf("latency not observed")
+	}
+	if testutil.ToFloat64(metrics.ErrorsTotal.WithLabelValues(
+		metrics.StageParse)) != parseErrors+1 {
+		t.Errorf("parse errors counter not incremented")
+	}
+
+	// The metrics must be scraped from the registry.
+	rec := httptest.NewRecorder()
+	metrics.Handler(metrics.DefaultRegistry).ServeHTTP(
+		rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(),
+		`ntp_responses_total{timer_type="SystemTimer"}`) {
+		t.Errorf("responses counter not scraped: %s", rec.Body.String())
+	}
+}
+
+// Get the number of observations of histogram.
+func histogramCount(t *testing.T, histogram prometheus.Histogram) uint64 {
+	var metric dto.Metric
+	if err := histogram.Write(&metric); err != nil {
+		t.Fatalf("can not write histogram: %s", err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+// TestHandleRequestValidation test that borderline requests are answered
+// or dropped by the validation level.
+func TestHandleRequestValidation(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+
+	// Create the borderline requests.
+	v2 := newTestRequest()
+	v2.SetVersion(2)
+	server := newTestRequest()
+	server.SetMode(ntp.ModeServer)
+	v0 := newTestRequest()
+	v0.SetVersion(0)
+	unsync := newTestRequest()
+	unsync.SetStratum(16)
+
+	// Create test table; a request is answered at the levels up to the
+	// given level.
+	table := []struct {
+		name string
+		req  *ntp.Package
+		upTo ntp.ValidationLevel
+	}{
+		{"client", newTestRequest(), ntp.ValidationStrict},
+		{"client v2", v2, ntp.ValidationPermissive},
+		{"server", server, ntp.ValidationPermissive},
+		{"version 0", v0, ntp.ValidationPermissive},
+		{"stratum 16", unsync, ntp.ValidationPermissive},
+	}
+
+	levels := []ntp.ValidationLevel{
+		ntp.ValidationPermissive, ntp.ValidationLenient,
+		ntp.ValidationStrict}
+	for _, e := range table {
+		data, _ := e.req.ToBytes()
+		for _, level := range levels {
+			s := newTestServer()
+			s.SetValidationLevel(level)
+			s.handleRequest(serverConn, clientAddr, data, time.Now())
+
+			// Read the response or the timeout of a dropped request.
+			_ = clientConn.SetReadDeadline(
+				time.Now().Add(100 * time.Millisecond))
+			_, err := clientConn.Read(make([]byte, ntp.PackageSize))
+			var netErr net.Error
+			dropped := errors.As(err, &netErr) && netErr.Timeout()
+			if level <= e.upTo && dropped {
+				t.Errorf("%s dropped at %s", e.name, level)
+			}
+			if level > e.upTo && !dropped {
+				t.Errorf("%s answered at %s: %v", e.name, level, err)
+			}
+		}
+	}
+}
+
+// TestHandleRequestInvalidHeader test that requests with a reserved mode
+// or an unsupported version are dropped while parsing.
+func TestHandleRequestInvalidHeader(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+	s := newTestServer()
+
+	reserved := newTestRequest()
+	reserved.SetMode(ntp.ModeReserved)
+	v7 := newTestRequest()
+	v7.SetVersion(7)
+	for _, req := range []*ntp.Package{reserved, v7} {
+		validateErrors := testutil.ToFloat64(
+			metrics.ErrorsTotal.WithLabelValues(metrics.StageValidate))
+		data, _ := req.ToBytes()
+		s.handleRequest(serverConn, clientAddr, data, time.Now())
+		_ = clientConn.SetReadDeadline(
+			time.Now().Add(100 * time.Millisecond))
+		_, err := clientConn.Read(make([]byte, ntp.PackageSize))
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("%s answered: %v", req, err)
+		}
+		if testutil.ToFloat64(metrics.ErrorsTotal.WithLabelValues(
+			metrics.StageValidate)) != validateErrors+1 {
+			t.Errorf("%s validate errors counter not incremented", req)
+		}
+	}
+}
+
+// A RoutingStrategy, that finds no route.
+type failingRouting struct{}
+
+// FindTimer implements RoutingStrategy.FindTimer interface.
+func (failingRouting) FindTimer(net.IP) (Timer, error) {
+	return nil, ErrRouteNotFound
+}
+
+// FindRoute implements RoutingStrategy.FindRoute interface.
+func (failingRouting) FindRoute(net.IP) (RoutingTableEntry, error) {
+	return RoutingTableEntry{}, ErrRouteNotFound
+}
+
+// TestHandleRequestRoutingFallback test that a request is dropped, answered
+// with a kiss code or answered by the fallback timer, when routing fails.
+func TestHandleRequestRoutingFallback(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+	data, _ := newTestRequest().ToBytes()
+	s := NewServer("127.0.0.1", 0, failingRouting{})
+
+	// Without fallback, the request is dropped.
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	_ = clientConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := clientConn.Read(make([]byte, ntp.PackageSize))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("request answered without route: %v", err)
+	}
+
+	// With kiss of death, the request is answered with a kiss code.
+	s.SetRoutingFallback(nil, true)
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	pkg := readTestResponse(t, clientConn)
+	if code, ok := pkg.GetKissCode(); !ok || code != ntp.KissCodeDeny {
+		t.Errorf("invalid kiss code: %q", code)
+	}
+
+	// With a fallback timer, the timer answers the request and is
+	// tracked as served.
+	fallback := &SystemTimer{}
+	fallback.NTPPackage.SetStratum(2)
+	timers := NewTimerCollection(1)
+	id := timers.Add(fallback)
+	s.SetTimers(timers)
+	s.SetRoutingFallback(fallback, true)
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	pkg = readTestResponse(t, clientConn)
+	if pkg.GetStratum() != 2 {
+		t.Errorf("invalid response stratum: %d", pkg.GetStratum())
+	}
+	if entry, _ := timers.Get(id); entry.LastServed.IsZero() {
+		t.Errorf("fallback timer not served")
+	}
+}
+
+// TestServeListenError test that a failed listen returns an error instead
+// of a panic. A permission error explains how to bind privileged ports.
+func TestServeListenError(t *testing.T) {
+	// Listen on a port, that is used by another connection.
+	serverConn, _ := newTestConnPair(t)
+	port := serverConn.LocalAddr().(*net.UDPAddr).Port
+	s := NewServer("127.0.0.1", port, failingRouting{})
+	if err := s.Serve(); err == nil {
+		t.Errorf("serve on used port without error")
+	}
+
+	// Simulate a bind without the privilege to use the port.
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 123}
+	err := listenError(addr, &net.OpError{
+		Op:  "listen",
+		Net: "udp",
+		Err: os.NewSyscallError("bind", syscall.EACCES),
+	})
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("permission error not wrapped: %s", err)
+	}
+	if !strings.Contains(err.Error(), "cap_net_bind_service") {
+		t.Errorf("permission error without capability hint: %s", err)
+	}
+}
+
+// TestServePooledBuffers test that the pooled receive buffers do not leak
+// data between requests. Each response must echo the transmit timestamp
+// of its own request, also after an undersized datagram is received into
+// a reused buffer.
+func TestServePooledBuffers(t *testing.T) {
+	for _, inline := range []bool{true, false} {
+		serverConn, clientConn := newTestConnPair(t)
+		s := newTestServer()
+		s.SetInline(inline)
+		s.SetWorkerPool(1, 8, false)
+		go s.serve(serverConn)
+
+		for i := 0; i < 4; i++ {
+			req := newTestRequest()
+			req.SetTransmitTimestamp(
+				time.Now().Add(time.Duration(i) * time.Hour))
+			data, _ := req.ToBytes()
+			// The undersized datagram only overwrites the buffer start.
+			_, _ = clientConn.Write([]byte{0xff, 0xff})
+			if _, err := clientConn.Write(data); err != nil {
+				t.Fatalf("can not write request: %s", err)
+			}
+			pkg := readTestResponse(t, clientConn)
+			diff := pkg.GetOriginateTimestamp().Sub(
+				req.GetTransmitTimestamp())
+			if diff.Abs() > ntp.DefaultOriginTolerance {
+				t.Errorf("inline[%t] request %d invalid originate: "+
+					"want %s get %s", inline, i,
+					req.GetTransmitTimestamp(), pkg.GetOriginateTimestamp())
+			}
+			if pkg.GetMode() != ntp.ModeServer {
+				t.Errorf("inline[%t] request %d invalid mode: %d",
+					inline, i, pkg.GetMode())
+			}
+		}
+		_ = serverConn.Close()
+	}
+}
+
+// TestHandleRequestLocalClock test that a SystemTimer in local clock mode
+// serves the LOCL reference id and the configured stratum.
+func TestHandleRequestLocalClock(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	data, _ := newTestRequest().ToBytes()
+	timer := &SystemTimer{}
+	timer.NTPPackage.SetVersion(ntp.VersionV4)
+	timer.NTPPackage.SetMode(ntp.ModeServer)
+	stratum := uint32(12)
+	timer.SetLocalClock(stratum)
+	routing := NewStaticRouting(NewRoutingTable(10), timer, 0)
+	s := NewServer("127.0.0.1", 0, routing)
+
+	s.handleRequest(serverConn,
+		clientConn.LocalAddr().(*net.UDPAddr), data, time.Now())
+	pkg := readTestResponse(t, clientConn)
+	if refId := pkg.GetReferenceClockIdString(); refId != LocalClockRefId {
+		t.Errorf("invalid reference id: %q", refId)
+	}
+	if pkg.GetStratum() != stratum {
+		t.Errorf("invalid stratum: %d", pkg.GetStratum())
+	}
+}
+
+// TestHandleRequestMAC test that signed requests are verified and their
+// responses signed with the same key. Tampered requests are dropped.
+func TestHandleRequestMAC(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+	keys := ntp.NewKeyStore()
+	_ = keys.Set(3, ntp.Key{Algo: ntp.MACAlgoSHA1, Secret: []byte("secret")})
+	s := newTestServer()
+	s.SetKeyStore(keys)
+
+	// Read a response with MAC from conn.
+	readSigned := func() *ntp.Package {
+		_ = clientConn.SetReadDeadline(time.Now().Add(time.Second))
+		data := make([]byte, ntp.PackageSize+ntp.MaxMACSize)
+		n, err := clientConn.Read(data)
+		if err != nil {
+			t.Fatalf("can not read response: %s", err)
+		}
+		pkg, _ := ntp.PackageFromBytes(data[:n])
+		return pkg
+	}
+
+	// A signed request is answered with a signed response.
+	req := newTestRequest()
+	_ = req.AppendMAC(3, []byte("secret"), ntp.MACAlgoSHA1)
+	data, _ := req.ToBytes()
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	pkg := readSigned()
+	if !pkg.VerifyMAC(3, []byte("secret")) {
+		t.Errorf("response MAC not verified")
+	}
+
+	// An unsigned request is answered without MAC.
+	data, _ = newTestRequest().ToBytes()
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	if _, ok := readSigned().GetKeyId(); ok {
+		t.Errorf("unsigned request answered with MAC")
+	}
+
+	// A tampered request is dropped.
+	data, _ = req.ToBytes()
+	data[2] ^= 0x01
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	_ = clientConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := clientConn.Read(make([]byte, ntp.PackageSize))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("tampered request answered: %v", err)
+	}
+}
+
+// TestServeExtensionFields test that a signed request with extension
+// fields, that is larger than a package with MAC, is received completely
+// and answered with a signed response.
+func TestServeExtensionFields(t *testing.T) {
+	keys := ntp.NewKeyStore()
+	_ = keys.Set(3, ntp.Key{Algo: ntp.MACAlgoSHA1, Secret: []byte("secret")})
+	s := newTestServer()
+	s.SetKeyStore(keys)
+	addrs := serveTestServer(t, s, 1)
+
+	// Send a request with extension fields and MAC.
+	clientConn, err := net.DialUDP("udp", nil, addrs[0].(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("can not dial udp: %s", err)
+	}
+	defer clientConn.Close()
+	req := newTestRequest()
+	err = req.SetExtensionFields([]ntp.ExtensionField{
+		{Type: 0x0104, Value: make([]byte, 256)},
+		{Type: 0x0204, Value: make([]byte, 512)},
+	})
+	if err != nil {
+		t.Fatalf("can not set extension fields: %s", err)
+	}
+	_ = req.AppendMAC(3, []byte("secret"), ntp.MACAlgoSHA1)
+	data, _ := req.ToBytes()
+	if _, err = clientConn.Write(data); err != nil {
+		t.Fatalf("can not write request: %s", err)
+	}
+
+	// The response must be signed with the key of the request.
+	_ = clientConn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, ntp.DefaultMaxResponseSize)
+	n, err := clientConn.Read(buf)
+	if err != nil {
+		t.Fatalf("request with extension fields not answered: %s", err)
+	}
+	pkg, err := ntp.PackageFromBytes(buf[:n])
+	if err != nil {
+		t.Fatalf("can not parse response: %s", err)
+	}
+	if !pkg.VerifyMAC(3, []byte("secret")) {
+		t.Errorf("response MAC not verified")
+	}
+}
+
+// TestServeListeners test that two ports backed by one timer answer with
+// their own response options.
+func TestServeListeners(t *testing.T) {
+	timer := &SystemTimer{}
+	timer.NTPPackage.SetMode(ntp.ModeServer)
+	timer.NTPPackage.SetStratum(3)
+	s := NewServer("127.0.0.1", 0, failingRouting{})
+	s.AddListener(0, timer, ResponseOptions{})
+	s.AddListener(0, timer, ResponseOptions{OmitOriginate: true})
+
+	// Serve both listeners on their own connection.
+	echoConn, echoClient := newTestConnPair(t)
+	omitConn, omitClient := newTestConnPair(t)
+	go s.serveListener(echoConn, &s.listeners[0])
+	go s.serveListener(omitConn, &s.listeners[1])
+
+	// Create test table; each client maps to an echoed originate.
+	table := []struct {
+		client *net.UDPConn
+		echo   bool
+	}{
+		{echoClient, true},
+		{omitClient, false},
+	}
+	for _, e := range table {
+		req := newTestRequest()
+		data, _ := req.ToBytes()
+		if _, err := e.client.Write(data); err != nil {
+			t.Fatalf("can not write request: %s", err)
+		}
+		pkg := readTestResponse(t, e.client)
+		// Both listeners answer with the timer instead of the routing.
+		if pkg.GetStratum() != 3 {
+			t.Errorf("echo[%t] invalid stratum: %d",
+				e.echo, pkg.GetStratum())
+		}
+		diff := pkg.GetOriginateTimestamp().Sub(req.GetTransmitTimestamp())
+		if echoed := diff.Abs() <= ntp.DefaultOriginTolerance; echoed != e.echo {
+			t.Errorf("echo[%t] invalid originate: %s",
+				e.echo, pkg.GetOriginateTimestamp())
+		}
+	}
+}
+
+// TestServeListenerError test that Serve fails, when an additional port
+// can not be bound.
+func TestServeListenerError(t *testing.T) {
+	serverConn, _ := newTestConnPair(t)
+	port := serverConn.LocalAddr().(*net.UDPAddr).Port
+	s := NewServer("127.0.0.1", 0, failingRouting{})
+	s.AddListener(port, &SystemTimer{}, ResponseOptions{})
+	if err := s.Serve(); err == nil {
+		t.Errorf("serve on used listener port without error")
+	}
+}
+
+// Serve s in background until the test ends and wait until n addresses
+// are bound. The bound addresses are returned.
+func serveTestServer(t *testing.T, s *Server, n int) []net.Addr {
+	addrs, _ := startTestServer(t, s, n)
+	return addrs
+}
+
+// Serve s in background like serveTestServer. In addition, a function is
+// returned, that waits at most one second until Serve returns and gets
+// the error of Serve.
+func startTestServer(
+	t *testing.T,
+	s *Server,
+	n int,
+) ([]net.Addr, func() error) {
+	var err error
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		err = s.Serve()
+	}()
+	t.Cleanup(func() {
+		_ = s.Shutdown()
+		<-served
+	})
+
+	var addrs []net.Addr
+	for deadline := time.Now().Add(time.Second); len(addrs) < n; {
+		select {
+		case <-served:
+			t.Fatalf("serve err: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server addresses not bound: %v", addrs)
+		}
+		time.Sleep(time.Millisecond)
+		addrs = s.Addrs()
+	}
+	wait := func() error {
+		select {
+		case <-served:
+			return err
+		case <-time.After(time.Second):
+			t.Fatalf("serve not returned")
+			return nil
+		}
+	}
+	return addrs, wait
+}
+
+// TestServeUpdateLoop test that the server answers requests from a timer,
+// while the timers are updated by the collection. A SteppingTimer changes
+// on each update.
+func TestServeUpdateLoop(t *testing.T) {
+	timer := &SteppingTimer{Time: time.Now(), Step: time.Second}
+	timer.NTPPackage.SetVersion(ntp.VersionV4)
+	timer.NTPPackage.SetMode(ntp.ModeServer)
+	timer.NTPPackage.SetStratum(1)
+	timers := NewTimerCollection(1)
+	id := timers.Add(timer)
+	s := NewServer("127.0.0.1", 0,
+		NewStaticRouting(NewRoutingTable(10), timer, id))
+	s.SetTimers(timers)
+	addrs := serveTestServer(t, s, 1)
+
+	// Update the timers in background, while requests are answered.
+	done := make(chan struct{})
+	defer close(done)
+	go timers.UpdateLoop(time.Millisecond, done)
+	for i := 0; i < 20; i++ {
+		pkg := queryTestServer(t, addrs[0].(*net.UDPAddr))
+		if pkg.GetStratum() != 1 {
+			t.Fatalf("invalid response: %s", pkg)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Send a request to the server at addr and return the response.
+func queryTestServer(t *testing.T, addr *net.UDPAddr) *ntp.Package {
+	clientConn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatalf("can not dial udp: %s", err)
+	}
+	defer clientConn.Close()
+	data, _ := newTestRequest().ToBytes()
+	if _, err = clientConn.Write(data); err != nil {
+		t.Fatalf("can not write request: %s", err)
+	}
+	return readTestResponse(t, clientConn)
+}
+
+// TestServeAddresses test that the server answers requests on all of its
+// addresses and that Shutdown ends serving.
+func TestServeAddresses(t *testing.T) {
+	s := newTestServer()
+	s.AddAddress("127.0.0.1", 0)
+	addrs, wait := startTestServer(t, s, 2)
+
+	// Each address answers a request on its own socket.
+	for _, addr := range addrs {
+		udpAddr := addr.(*net.UDPAddr)
+		pkg := queryTestServer(t, udpAddr)
+		if pkg.GetMode() != ntp.ModeServer || pkg.GetStratum() != 1 {
+			t.Errorf("%s invalid response: %s", udpAddr, pkg)
+		}
+		if pkg.GetReceiveTimestamp().IsZero() {
+			t.Errorf("%s response without receive timestamp", udpAddr)
+		}
+	}
+
+	// Shutdown closes all addresses and Serve returns without error.
+	if err := s.Shutdown(); err != nil {
+		t.Fatalf("can not shutdown server: %s", err)
+	}
+	if err := wait(); err != nil {
+		t.Errorf("serve err: %s", err)
+	}
+	for deadline := time.Now().Add(time.Second); len(s.Addrs()) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("addresses after shutdown: %v", s.Addrs())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestServeNetwork test to serve IPv6 and host name addresses of the
+// server network.
+func TestServeNetwork(t *testing.T) {
+	// Create test table; each host and network binds an IPv4 or IPv6
+	// address.
+	table := []struct {
+		host    string
+		network string
+		ipv6    bool
+	}{
+		{"::1", "udp", true},
+		{"[::1]", "udp6", true},
+		{"localhost", "udp4", false},
+		{"127.0.0.1", "udp", false},
+	}
+	for _, e := range table {
+		s := newTestServer()
+		s.host = e.host
+		if err := s.SetNetwork(e.network); err != nil {
+			t.Fatalf("can not set network: %s", err)
+		}
+		addr := serveTestServer(t, s, 1)[0].(*net.UDPAddr)
+		if ipv6 := addr.IP.To4() == nil; ipv6 != e.ipv6 {
+			t.Errorf("%s %s invalid address: %s", e.host, e.network, addr)
+		}
+		if pkg := queryTestServer(t, addr); pkg.GetMode() != ntp.ModeServer {
+			t.Errorf("%s %s invalid response: %s", e.host, e.network, pkg)
+		}
+	}
+
+	// A stream network is not supported.
+	if err := newTestServer().SetNetwork("tcp"); err == nil {
+		t.Errorf("network tcp set without error")
+	}
+}
+
+// TestJoinHostPort test to join IPv4, IPv6 and host names with a port.
+func TestJoinHostPort(t *testing.T) {
+	// Create test table; each host maps to an address.
+	table := []struct {
+		host string
+		addr string
+	}{
+		{"127.0.0.1", "127.0.0.1:123"},
+		{"localhost", "localhost:123"},
+		{"::", "[::]:123"},
+		{"[::1]", "[::1]:123"},
+	}
+	for _, e := range table {
+		if addr := joinHostPort(e.host, 123); addr != e.addr {
+			t.Errorf("%s invalid address: want %s get %s",
+				e.host, e.addr, addr)
+		}
+	}
+}
+
+// TestParseListener test to parse the port and options of a listener.
+func TestParseListener(t *testing.T) {
+	// Create test table; each spec maps to port, options and valid.
+	table := []struct {
+		spec    string
+		port    int
+		options ResponseOptions
+		valid   bool
+	}{
+		{"1123", 1123, ResponseOptions{}, true},
+		{"1124:omit-origin", 1124, ResponseOptions{OmitOriginate: true}, true},
+		{"ntp", 0, ResponseOptions{}, false},
+		{"70000", 0, ResponseOptions{}, false},
+		{"1123:echo", 0, ResponseOptions{}, false},
+	}
+	for _, e := range table {
+		port, options, err := ParseListener(e.spec)
+		if (err == nil) != e.valid {
+			t.Errorf("%s invalid error: %v", e.spec, err)
+			continue
+		}
+		if port != e.port || options != e.options {
+			t.Errorf("%s invalid listener: want %d %+v get %d %+v",
+				e.spec, e.port, e.options, port, options)
+		}
+	}
+}
+
+// A MetricsCollector, that records the collected metrics.
+type recordingCollector struct {
+	mu          sync.Mutex
+	requests    int
+	responses   int
+	routes      map[string]int
+	errors      map[string]int
+	corrections map[string]int
+}
+
+// Request implements MetricsCollector.Request interface.
+func (c *recordingCollector) Request(uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+}
+
+// RouteMatch implements MetricsCollector.RouteMatch interface.
+func (c *recordingCollector) RouteMatch(route RoutingTableEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.routes[RouteName(route)]++
+}
+
+// Response implements MetricsCollector.Response interface.
+func (c *recordingCollector) Response(Timer, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.responses++
+}
+
+// Error implements MetricsCollector.Error interface.
+func (c *recordingCollector) Error(stage string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors[stage]++
+}
+
+// Correction implements MetricsCollector.Correction interface.
+func (c *recordingCollector) Correction(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.corrections[reason]++
+}
+
+// TestHandleRequestMetricsCollector test that the request handling counts
+// requests, responses, parse errors and route matches in the collector.
+func TestHandleRequestMetricsCollector(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+	data, _ := newTestRequest().ToBytes()
+	s := newTestServer()
+	collector := &recordingCollector{
+		routes: make(map[string]int),
+		errors: make(map[string]int),
+	}
+	s.SetMetricsCollector(collector)
+
+	// Handle two valid requests and one invalid request.
+	for i := 0; i < 2; i++ {
+		s.handleRequest(serverConn, clientAddr, data, time.Now())
+		readTestResponse(t, clientConn)
+	}
+	s.handleRequest(serverConn, clientAddr, data[:10], time.Now())
+
+	if collector.requests != 2 || collector.responses != 2 {
+		t.Errorf("invalid requests %d or responses %d",
+			collector.requests, collector.responses)
+	}
+	if collector.errors[metrics.StageParse] != 1 {
+		t.Errorf("invalid parse errors: %v", collector.errors)
+	}
+	// The loopback client matches the default loopback route.
+	if collector.routes["127.0.0.0/24"] != 2 {
+		t.Errorf("invalid route matches: %v", collector.routes)
+	}
+}
+
+// TestHandleRequestLoopbackTimer test that loopback clients are answered
+// by the diagnostic timer with tagged logs, when it is set.
+func TestHandleRequestLoopbackTimer(t *testing.T) {
+	hook := test.NewGlobal()
+	t.Cleanup(func() {
+		hook.Reset()
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	})
+
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+	data, _ := newTestRequest().ToBytes()
+	s := newTestServer()
+
+	// Without diagnostic timer, the loopback route answers.
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	if pkg := readTestResponse(t, clientConn); pkg.GetStratum() != 1 {
+		t.Errorf("invalid response stratum: %d", pkg.GetStratum())
+	}
+
+	// With diagnostic timer, the diagnostic timer answers.
+	diagnostic := &SystemTimer{}
+	diagnostic.NTPPackage.SetStratum(3)
+	diagnostic.NTPPackage.SetReferenceClockId([]byte("DIAG"))
+	timers := NewTimerCollection(1)
+	id := timers.Add(diagnostic)
+	s.SetTimers(timers)
+	s.SetLoopbackTimer(diagnostic)
+	hook.Reset()
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	pkg := readTestResponse(t, clientConn)
+	if pkg.GetStratum() != 3 {
+		t.Errorf("invalid response stratum: %d", pkg.GetStratum())
+	}
+	if entry, _ := timers.Get(id); entry.LastServed.IsZero() {
+		t.Errorf("diagnostic timer not served")
+	}
+
+	// The request is logged with the diagnostic tag.
+	var entry *log.Entry
+	for _, e := range hook.AllEntries() {
+		if e.Message == "diagnostic ntp request" {
+			entry = e
+		}
+	}
+	if entry == nil {
+		t.Fatalf("no diagnostic request log entry")
+	}
+	if entry.Level != log.InfoLevel || entry.Data["diagnostic"] != true {
+		t.Errorf("invalid diagnostic log entry: %s %v",
+			entry.Level, entry.Data)
+	}
+}
+
+// TestHandleRequestResponseLog test that the last response served to a
+// client is recorded.
+func TestHandleRequestResponseLog(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+	data, _ := newTestRequest().ToBytes()
+	s := newTestServer()
+	responses := NewResponseLog(time.Minute, 10)
+	s.SetResponseLog(responses)
+
+	// Without request, no response is recorded.
+	if _, ok := responses.Last(clientAddr.IP); ok {
+		t.Fatalf("response recorded without request")
+	}
+
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	pkg := readTestResponse(t, clientConn)
+	record, ok := responses.Last(clientAddr.IP)
+	if !ok {
+		t.Fatalf("no response recorded")
+	}
+	if record.Client != clientAddr.IP.String() ||
+		record.Timer != "SystemTimer" {
+		t.Errorf("invalid record: %+v", record)
+	}
+	// The recorded package is not encoded; compare the encoded package,
+	// because the encoding loses precision below a microsecond.
+	data, err := record.Package.ToBytes()
+	if err != nil {
+		t.Fatalf("can not encode recorded package: %s", err)
+	}
+	recorded, _ := ntp.PackageFromBytes(data)
+	if !recorded.GetTransmitTimestamp().Equal(pkg.GetTransmitTimestamp()) ||
+		recorded.GetStratum() != pkg.GetStratum() {
+		t.Errorf("recorded package differs from response: %s %s",
+			recorded.GetTransmitTimestamp(), pkg.GetTransmitTimestamp())
+	}
+}
+
+// TestHandleRequestLeapStratum test that an unsynchronized timer at a
+// synchronized stratum is answered with a coherent stratum.
+func TestHandleRequestLeapStratum(t *testing.T) {
+	serverConn, clientConn := newTestConnPair(t)
+	clientAddr := clientConn.LocalAddr().(*net.UDPAddr)
+	data, _ := newTestRequest().ToBytes()
+	timer := &SystemTimer{}
+	timer.NTPPackage.SetVersion(ntp.VersionV4)
+	timer.NTPPackage.SetMode(ntp.ModeServer)
+	timer.NTPPackage.SetStratum(1)
+	routing := NewStaticRouting(NewRoutingTable(10), timer, 0)
+	s := NewServer("127.0.0.1", 0, routing)
+	collector := &recordingCollector{
+		routes:      make(map[string]int),
+		errors:      make(map[string]int),
+		corrections: make(map[string]int),
+	}
+	s.SetMetricsCollector(collector)
+
+	// A synchronized timer is answered unchanged.
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	if pkg := readTestResponse(t, clientConn); pkg.GetStratum() != 1 {
+		t.Errorf("invalid synchronized stratum: %d", pkg.GetStratum())
+	}
+
+	// The contradicting timer is corrected and counted; the timer
+	// package itself is not changed.
+	timer.NTPPackage.SetLeap(ntp.LeapNotSyn)
+	s.handleRequest(serverConn, clientAddr, data, time.Now())
+	pkg := readTestResponse(t, clientConn)
+	if pkg.GetLeap() != ntp.LeapNotSyn ||
+		pkg.GetStratum() != ntp.UnsyncStratum {
+		t.Errorf("invalid leap %d and stratum %d",
+			pkg.GetLeap(), pkg.GetStratum())
+	}
+	if collector.corrections[metrics.CorrectionLeapStratum] != 1 {
+		t.Errorf("invalid corrections: %v", collector.corrections)
+	}
+	if timer.NTPPackage.GetStratum() != 1 {
+		t.Errorf("timer package changed: %d", timer.NTPPackage.GetStratum())
+	}
+}
@@ -6,6 +6,8 @@ package server
 
 import (
 	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/donsprallo/zeitgeist/internal/ntp"
@@ -30,15 +32,73 @@ type Timer interface {
 	Get() time.Time
 }
 
+// Wrapper is an optional interface for a Timer that composes another
+// Timer. The wrapped Timer can be obtained to introspect the chain of
+// timers.
+type Wrapper interface {
+
+	// Unwrap get the wrapped Timer.
+	Unwrap() Timer
+}
+
+// Unwrap get the Timer wrapped by timer. When timer does not implement
+// the Wrapper interface, nil is returned.
+func Unwrap(timer Timer) Timer {
+	if wrapper, ok := timer.(Wrapper); ok {
+		return wrapper.Unwrap()
+	}
+	return nil
+}
+
 type TimerCollectionEntry struct {
-	Id    int   // Index of the Timer
-	Timer Timer // Timer of the entry
+	Id         int       // Index of the Timer
+	Timer      Timer     // Timer of the entry
+	LastServed time.Time // Time of the last response; zero if never served.
+	Requests   uint64    // Number of requests answered by the Timer.
 }
 
-// TimerCollection is a collection of Timer instances.
+// TimerCollection is a collection of Timer instances. The collection is
+// safe for concurrent use. Holders of a collection, like the web endpoints,
+// keep a reference to the collection; therefore the collection state must
+// be replaced in place with TimerCollection.Replace.
 type TimerCollection struct {
+	mu      sync.RWMutex
 	idx     int                    // Index value of the next Timer
 	entries []TimerCollectionEntry // A slice of Timer
+	stats   map[int]*timerStats    // Served statistics by Timer id
+}
+
+// timerStats is the served statistics of a Timer. The statistics are
+// updated atomically, so the server counts a request under the read lock.
+type timerStats struct {
+	requests   atomic.Uint64 // Number of requests answered by the Timer.
+	lastServed atomic.Int64  // Unix nanoseconds of the last response (UTC).
+}
+
+// Create timerStats from the statistics of entry.
+func newTimerStats(entry TimerCollectionEntry) *timerStats {
+	stats := &timerStats{}
+	stats.requests.Store(entry.Requests)
+	if !entry.LastServed.IsZero() {
+		stats.lastServed.Store(entry.LastServed.UnixNano())
+	}
+	return stats
+}
+
+// Set the served statistics of entry from the collection without locking.
+func (c *TimerCollection) entry(
+	entry TimerCollectionEntry,
+) TimerCollectionEntry {
+	stats, ok := c.stats[entry.Id]
+	if !ok {
+		return entry
+	}
+	entry.Requests = stats.requests.Load()
+	entry.LastServed = time.Time{}
+	if nanos := stats.lastServed.Load(); nanos != 0 {
+		entry.LastServed = time.Unix(0, nanos).UTC()
+	}
+	return entry
 }
 
 // NewTimerCollection creates a new TimerCollection with a predefined size.
@@ -47,39 +107,99 @@ func NewTimerCollection(size int) *TimerCollection {
 	return &TimerCollection{
 		idx:     0,
 		entries: make([]TimerCollectionEntry, 0, size),
+		stats:   make(map[int]*timerStats, size),
 	}
 }
 
 // Add append a Timer to the collection. Here each Timer get a unique entry
 // to identify the Timer.
 func (c *TimerCollection) Add(timer Timer) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	id := c.idx
 	c.idx++
 	c.entries = append(c.entries, TimerCollectionEntry{
 		Id:    id,
 		Timer: timer,
 	})
+	if c.stats == nil {
+		c.stats = make(map[int]*timerStats)
+	}
+	c.stats[id] = &timerStats{}
 	return id
 }
 
-// Get the TimerCollectionEntry by id.
-func (c *TimerCollection) Get(id int) TimerCollectionEntry {
+// Get the TimerCollectionEntry by id. When no Timer with id is found,
+// false is returned.
+func (c *TimerCollection) Get(id int) (TimerCollectionEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	// Iterate all timers until id is found.
 	for _, entry := range c.entries {
 		if entry.Id == id {
-			return entry
+			return c.entry(entry), true
 		}
 	}
 	// No timer found.
-	return TimerCollectionEntry{}
+	return TimerCollectionEntry{}, false
+}
+
+// ErrTimerNotFound is returned by TimerCollection.WithTimer, when no Timer
+// with the id is found.
+var ErrTimerNotFound = errors.New("timer not found")
+
+// WithTimer call fn with the TimerCollectionEntry of id, while the Timer
+// can not be deleted from the collection. This allows to reference the
+// Timer, like in a route, without racing a concurrent delete. The fn must
+// not call methods of the collection. When no Timer with id is found,
+// ErrTimerNotFound is returned, otherwise the error of fn.
+func (c *TimerCollection) WithTimer(
+	id int,
+	fn func(entry TimerCollectionEntry) error,
+) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, entry := range c.entries {
+		if entry.Id == id {
+			return fn(c.entry(entry))
+		}
+	}
+	return ErrTimerNotFound
+}
+
+// Find the TimerCollectionEntry by Timer instance. When the Timer is
+// not added to the collection, false is returned.
+func (c *TimerCollection) Find(timer Timer) (TimerCollectionEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, entry := range c.entries {
+		if entry.Timer == timer {
+			return c.entry(entry), true
+		}
+	}
+	return TimerCollectionEntry{}, false
+}
+
+// Served set the last served time of the Timer with id to t and count the
+// request. The server calls this method, when the Timer is selected to
+// answer a request. When id is not found, nothing is done.
+func (c *TimerCollection) Served(id int, t time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if stats, ok := c.stats[id]; ok {
+		stats.lastServed.Store(t.UnixNano())
+		stats.requests.Add(1)
+	}
 }
 
 // Delete a Timer from collection by id.
 func (c *TimerCollection) Delete(id int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	// Iterate all timers until id is found.
 	for idx, entry := range c.entries {
 		if entry.Id == id {
-			c.Remove(idx)
+			c.remove(idx)
 			return nil
 		}
 	}
@@ -88,36 +208,203 @@ func (c *TimerCollection) Delete(id int) error {
 		"can not delete timer by id")
 }
 
+// ErrTimerInUse is returned by TimerCollection.DeleteUnused, when a route
+// or another timer references the Timer.
+var ErrTimerInUse = errors.New("timer is in use")
+
+// DeleteUnused delete a Timer from collection by id like Delete, but only
+// when no route of routes and no other Timer of the collection, that wraps
+// it, references the Timer. The references are checked under the lock of
+// the collection, so that no route is added for the Timer concurrently
+// with TimerCollection.WithTimer. When no Timer with id is found,
+// ErrTimerNotFound is returned; when the Timer is referenced,
+// ErrTimerInUse.
+func (c *TimerCollection) DeleteUnused(id int, routes *RoutingTable) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	index := -1
+	for idx, entry := range c.entries {
+		if entry.Id == id {
+			index = idx
+		}
+	}
+	if index < 0 {
+		return ErrTimerNotFound
+	}
+	if routes != nil && len(routes.RoutesForTimer(id)) != 0 {
+		return ErrTimerInUse
+	}
+	// A wrapper timer serves the time of the timer.
+	timer := c.entries[index].Timer
+	for _, entry := range c.entries {
+		for base := Unwrap(entry.Timer); base != nil; base = Unwrap(base) {
+			if base == timer {
+				return ErrTimerInUse
+			}
+		}
+	}
+	c.remove(index)
+	return nil
+}
+
 // Remove a Timer from collection by index.
 func (c *TimerCollection) Remove(index int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(index)
+}
+
+// Remove a Timer from collection by index without locking.
+func (c *TimerCollection) remove(index int) {
+	delete(c.stats, c.entries[index].Id)
 	length := len(c.entries) - 1
 	entries := make([]TimerCollectionEntry, 0, length)
 	entries = append(entries, c.entries[:index]...)
 	c.entries = append(entries, c.entries[index+1:]...)
 }
 
-// All return all TimerCollectionEntry instances added to collection.
+// All return a copy of all TimerCollectionEntry instances added to
+// collection.
 func (c *TimerCollection) All() []TimerCollectionEntry {
-	return c.entries
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entries := make([]TimerCollectionEntry, len(c.entries))
+	for idx, entry := range c.entries {
+		entries[idx] = c.entry(entry)
+	}
+	return entries
+}
+
+// Replace all entries of the collection in place. The entries keep their
+// identifiers and served statistics; new timers get identifiers after
+// the highest one.
+func (c *TimerCollection) Replace(entries []TimerCollectionEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(
+		make([]TimerCollectionEntry, 0, cap(c.entries)), entries...)
+	c.stats = make(map[int]*timerStats, len(entries))
+	c.idx = 0
+	for _, entry := range entries {
+		c.stats[entry.Id] = newTimerStats(entry)
+		c.idx = max(c.idx, entry.Id+1)
+	}
 }
 
-// AllUpdate updates all Timer instances added to collection.
+// AllUpdate updates all Timer instances added to collection. The timers
+// are updated under the write lock, so that no response is created from
+// a timer while it is updated.
 func (c *TimerCollection) AllUpdate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	for _, entry := range c.entries {
 		entry.Timer.Update()
 	}
 }
 
+// UpdateLoop updates all Timer instances added to collection every
+// interval, until done is closed.
+func (c *TimerCollection) UpdateLoop(
+	interval time.Duration,
+	done <-chan struct{},
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		// On ticker ticks, update all timers.
+		case <-ticker.C:
+			c.AllUpdate()
+		// On done, stop updating.
+		case <-done:
+			return
+		}
+	}
+}
+
+// UpdatePackage call fn with the package of the Timer with id, while no
+// response is created from the package. This allows to change the package
+// of a served Timer. When no Timer with id is found, ErrTimerNotFound is
+// returned, otherwise the error of fn.
+func (c *TimerCollection) UpdatePackage(
+	id int,
+	fn func(pkg *ntp.Package) error,
+) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		if entry.Id == id {
+			return fn(entry.Timer.Package())
+		}
+	}
+	return ErrTimerNotFound
+}
+
+// SetTime set the Timer with id to t, while no response is created from
+// the timer. When no Timer with id is found, ErrTimerNotFound is returned.
+func (c *TimerCollection) SetTime(id int, t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		if entry.Id == id {
+			entry.Timer.Set(t)
+			return nil
+		}
+	}
+	return ErrTimerNotFound
+}
+
+// SyncNtpTimers sync all NtpTimer instances of the collection with the
+// upstream server response like NtpTimer.Sync. The number of synced timers
+// is returned.
+func (c *TimerCollection) SyncNtpTimers(upstream *ntp.Package) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	synced := 0
+	for _, entry := range c.entries {
+		if timer, ok := entry.Timer.(*NtpTimer); ok {
+			timer.Sync(upstream)
+			synced++
+		}
+	}
+	return synced
+}
+
+// PackageFromTimer convert dst to a response of timer like the function
+// PackageFromTimer, while the package of the timer can not be changed by
+// the collection.
+func (c *TimerCollection) PackageFromTimer(
+	dst *ntp.Package,
+	timer Timer,
+) (*ntp.Package, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return PackageFromTimer(dst, timer.Package(), timer)
+}
+
 // Length return the collection entry length.
 func (c *TimerCollection) Length() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return len(c.entries)
 }
 
 // NtpTimer implements the Timer interface. A NtpTimer generates time values
 // from the remote ntp server as source. The timer can be used to generate
-// ntp.Package.
+// ntp.Package. After NtpTimer.Sync, the timer serves the reference id and
+// reference timestamp of the upstream server, like a transparent proxy.
 type NtpTimer struct {
 	NTPPackage ntp.Package
+	synced     bool // The reference of the upstream server is set.
+}
+
+// Sync take over the reference id and reference timestamp of an upstream
+// server response. The values are served instead of local values. A timer
+// of a TimerCollection is synced with TimerCollection.SyncNtpTimers.
+func (timer *NtpTimer) Sync(upstream *ntp.Package) {
+	timer.NTPPackage.SetReferenceClockId(upstream.GetReferenceClockId())
+	timer.NTPPackage.SetReferenceTimestamp(upstream.GetReferenceTimestamp())
+	timer.synced = true
 }
 
 // Package implements Timer.Package interface.
@@ -167,12 +454,38 @@ func (timer *SystemTimer) Get() time.Time {
 	return time.Now()
 }
 
+// LocalClockRefId is the reference id of a server, that serves its own
+// clock without upstream synchronization.
+const LocalClockRefId = "LOCL"
+
+// DefaultLocalStratum is the stratum conventionally advertised by a local
+// clock, so that clients prefer synchronized servers.
+const DefaultLocalStratum uint32 = 10
+
+// SetLocalClock set the timer to serve the system time as local clock
+// fallback. The package advertises stratum like ApplyStratum and the
+// LocalClockRefId reference id instead of claiming a primary server.
+func (timer *SystemTimer) SetLocalClock(stratum uint32) {
+	ApplyStratum(&timer.NTPPackage, stratum)
+	timer.NTPPackage.SetReferenceClockId([]byte(LocalClockRefId))
+}
+
 // ModifyTimer implements the Timer interface. A ModifyTimer generates time
-// values from free settable timestamp as source. The timer can be used to#
-// generate ntp.Package.
+// values from free settable timestamp as source. The timer advances in real
+// time from the moment it was set. The timer can be used to generate
+// ntp.Package.
 type ModifyTimer struct {
 	NTPPackage ntp.Package
-	Time       time.Time
+	Time       time.Time // The time value of the timer when set.
+	anchor     time.Time // The real time when the timer was set.
+}
+
+// NewModifyTimer creates a new ModifyTimer with ntp.Package pkg, that
+// starts advancing from t.
+func NewModifyTimer(pkg ntp.Package, t time.Time) *ModifyTimer {
+	timer := &ModifyTimer{NTPPackage: pkg}
+	timer.Set(t)
+	return timer
 }
 
 // Package implements Timer.Package interface.
@@ -182,18 +495,235 @@ func (timer *ModifyTimer) Package() *ntp.Package {
 
 // Update implements Timer.Update interface.
 func (timer *ModifyTimer) Update() {
-	// Increment timer by one
-	timer.Time = timer.Time.Add(1 * time.Second)
+	// Do nothing here; the timer advances in real time.
 }
 
 // Set implements Timer.Set interface.
 func (timer *ModifyTimer) Set(t time.Time) {
 	timer.Time = t
+	timer.anchor = time.Now()
 }
 
-// Get implements Timer.Get interface.
+// Get implements Timer.Get interface. The time value is advanced by the
+// real time elapsed since the timer was set. A timer that was never set
+// returns its Time.
 func (timer *ModifyTimer) Get() time.Time {
-	return timer.Time
+	if timer.anchor.IsZero() {
+		return timer.Time
+	}
+	return timer.Time.Add(time.Since(timer.anchor))
+}
+
+// RateTimer implements the Timer interface. A RateTimer generates time
+// values from a base timestamp, that runs faster or slower than real time.
+// The Rate is a multiplier of real time, so a rate of 2.0 means that two
+// seconds pass on each real second. The scaled time accumulates over the
+// real time elapsed since the timer was set, like a DriftTimer, so that it
+// does not depend on the update interval. The timer can be used to
+// generate ntp.Package.
+type RateTimer struct {
+	DriftTimer
+}
+
+// NewRateTimer creates a new RateTimer with ntp.Package pkg, that starts
+// advancing from t scaled with rate.
+func NewRateTimer(pkg ntp.Package, t time.Time, rate float64) *RateTimer {
+	return &RateTimer{DriftTimer: *NewDriftTimer(pkg, t, rate)}
+}
+
+// DriftTimer implements the Timer interface. A DriftTimer simulates a
+// skewed clock, that runs with a frequency error relative to real time.
+// The Rate is the frequency of the clock, where 1.0 is real time and 2.0
+// is double speed. The frequency error accumulates over the wall time
+// elapsed since the timer was set. The timer can be used to generate
+// ntp.Package.
+type DriftTimer struct {
+	NTPPackage ntp.Package
+	Time       time.Time // The time value of the timer when set.
+	Rate       float64   // The frequency of the clock relative to real time.
+	anchor     time.Time // The real time when the timer was set.
+}
+
+// NewDriftTimer creates a new DriftTimer with ntp.Package pkg, that starts
+// drifting from t with the frequency rate.
+func NewDriftTimer(pkg ntp.Package, t time.Time, rate float64) *DriftTimer {
+	timer := &DriftTimer{NTPPackage: pkg, Rate: rate}
+	timer.Set(t)
+	return timer
+}
+
+// Package implements Timer.Package interface.
+func (timer *DriftTimer) Package() *ntp.Package {
+	return &timer.NTPPackage
+}
+
+// Update implements Timer.Update interface.
+func (timer *DriftTimer) Update() {
+	// Do nothing here; the drift accumulates with the real time.
+}
+
+// Set implements Timer.Set interface. The accumulated drift is reset.
+func (timer *DriftTimer) Set(t time.Time) {
+	timer.Time = t
+	timer.anchor = time.Now()
+}
+
+// Drift get the frequency error accumulated since the timer was set. That
+// is the difference between the timer and real time, advanced from Time.
+func (timer *DriftTimer) Drift() time.Duration {
+	if timer.anchor.IsZero() {
+		return 0
+	}
+	elapsed := time.Since(timer.anchor)
+	return time.Duration((timer.Rate - 1) * float64(elapsed))
+}
+
+// Get implements Timer.Get interface. The time value is advanced by the
+// real time elapsed since the timer was set and the accumulated drift. A
+// timer that was never set returns its Time.
+func (timer *DriftTimer) Get() time.Time {
+	if timer.anchor.IsZero() {
+		return timer.Time
+	}
+	elapsed := time.Since(timer.anchor)
+	return timer.Time.Add(
+		elapsed + time.Duration((timer.Rate-1)*float64(elapsed)))
+}
+
+// SteppingTimer implements the Timer interface. A SteppingTimer generates
+// time values from a base timestamp, that jumps by Step on each update
+// instead of advancing in real time. A negative Step steps the timer
+// backwards, like a clock stepped by a misbehaving daemon. The timer can
+// be used to generate ntp.Package.
+type SteppingTimer struct {
+	NTPPackage ntp.Package
+	Time       time.Time     // The base time of the timer.
+	Step       time.Duration // The step of each update.
+	Elapsed    time.Duration // The accumulated steps.
+}
+
+// Package implements Timer.Package interface.
+func (timer *SteppingTimer) Package() *ntp.Package {
+	return &timer.NTPPackage
+}
+
+// Update implements Timer.Update interface. In a TimerCollection, the
+// timer is updated under the write lock of the collection.
+func (timer *SteppingTimer) Update() {
+	timer.Elapsed += timer.Step
+}
+
+// Set implements Timer.Set interface.
+func (timer *SteppingTimer) Set(t time.Time) {
+	timer.Time = t
+	timer.Elapsed = 0
+}
+
+// Get implements Timer.Get interface.
+func (timer *SteppingTimer) Get() time.Time {
+	return timer.Time.Add(timer.Elapsed)
+}
+
+// HeaderOverrideTimer implements the Timer and Wrapper interface. A
+// HeaderOverrideTimer generates time values from a Base timer, but
+// serves its own ntp.Package instead of the package of the Base timer.
+type HeaderOverrideTimer struct {
+	NTPPackage ntp.Package
+	Base       Timer // The wrapped timer as time source.
+}
+
+// Package implements Timer.Package interface.
+func (timer *HeaderOverrideTimer) Package() *ntp.Package {
+	return &timer.NTPPackage
+}
+
+// Update implements Timer.Update interface.
+func (timer *HeaderOverrideTimer) Update() {
+	// The base timer is updated by its collection.
+}
+
+// Set implements Timer.Set interface.
+func (timer *HeaderOverrideTimer) Set(t time.Time) {
+	timer.Base.Set(t)
+}
+
+// Get implements Timer.Get interface.
+func (timer *HeaderOverrideTimer) Get() time.Time {
+	return timer.Base.Get()
+}
+
+// Unwrap implements Wrapper.Unwrap interface.
+func (timer *HeaderOverrideTimer) Unwrap() Timer {
+	return timer.Base
+}
+
+// StratumTimer implements the Timer and Wrapper interface. A StratumTimer
+// generates time values from a Base timer, but serves the package of the
+// Base timer with precision, root dispersion and poll interval typical for
+// a server at Stratum. This can be used to simulate downstream servers.
+type StratumTimer struct {
+	Base    Timer  // The wrapped timer as time source.
+	Stratum uint32 // The simulated stratum level.
+}
+
+// Package implements Timer.Package interface. The package is derived from
+// the package of the Base timer into a copy on each call, so that
+// concurrent requests do not modify the timer.
+func (timer *StratumTimer) Package() *ntp.Package {
+	var pkg ntp.Package
+	if base := timer.Base.Package(); base != nil {
+		pkg = *base
+	}
+	ApplyStratum(&pkg, timer.Stratum)
+	return &pkg
+}
+
+// Update implements Timer.Update interface.
+func (timer *StratumTimer) Update() {
+	// The base timer is updated by its collection.
+}
+
+// Set implements Timer.Set interface.
+func (timer *StratumTimer) Set(t time.Time) {
+	timer.Base.Set(t)
+}
+
+// Get implements Timer.Get interface.
+func (timer *StratumTimer) Get() time.Time {
+	return timer.Base.Get()
+}
+
+// Unwrap implements Wrapper.Unwrap interface.
+func (timer *StratumTimer) Unwrap() Timer {
+	return timer.Base
+}
+
+// MinStratum is the lowest stratum of a synchronized server. The highest
+// stratum is ntp.MaxStratum.
+const MinStratum uint32 = 1
+
+// ApplyStratum set stratum, precision, root dispersion and poll interval
+// of pkg to values typical for a server at stratum. Each stratum level
+// degrades the quality of the server: the precision starts at 2^-20
+// seconds and loses one bit, the root dispersion grows by one millisecond
+// and the poll interval starts at 2^6 seconds and grows every second
+// stratum up to 2^10 seconds. The stratum is clamped to the range of a
+// synchronized server.
+func ApplyStratum(pkg *ntp.Package, stratum uint32) {
+	stratum = max(MinStratum, min(stratum, ntp.MaxStratum))
+	level := int(stratum - MinStratum)
+
+	// The precision is a signed log2 seconds value.
+	precision := int8(-20 + level)
+	// The root dispersion grows by one millisecond each stratum.
+	dispersion := time.Duration(stratum) * time.Millisecond
+	// The poll interval is a log2 seconds value.
+	poll := min(6+level/2, 10)
+
+	pkg.SetStratum(stratum)
+	pkg.SetPrecision(uint32(uint8(precision)))
+	pkg.SetRootDispersionDuration(dispersion)
+	pkg.SetPoll(uint32(poll))
 }
 
 // PackageFromTimer convert a ntp.Package from dst ntp.Package to
@@ -216,9 +746,14 @@ func PackageFromTimer(
 	dst.SetRootDispersion(src.GetRootDispersion())
 	dst.SetReferenceClockId(src.GetReferenceClockId())
 	dst.SetReferenceTimestamp(timer.Get())
-	dst.SetOriginateTimestamp(timer.Get())
-	// Set transmit timestamp at least before sent
-	//dst.SetTransmitTimestamp(timer.Get())
+	// Keep the upstream reference timestamp of a synced NtpTimer.
+	if ntpTimer, ok := timer.(*NtpTimer); ok && ntpTimer.synced {
+		dst.SetReferenceTimestamp(src.GetReferenceTimestamp())
+	}
+	// Echo the request transmit timestamp as originate timestamp, so
+	// that the client can match the response to the request.
+	dst.SetOriginateTimestamp(dst.GetTransmitTimestamp())
+	dst.SetTransmitTimestamp(timer.Get())
 
 	return dst, nil
 }
@@ -232,6 +767,16 @@ func TimerName(timer Timer) string {
 		return "SystemTimer"
 	case *ModifyTimer:
 		return "ModifyTimer"
+	case *RateTimer:
+		return "RateTimer"
+	case *DriftTimer:
+		return "DriftTimer"
+	case *SteppingTimer:
+		return "SteppingTimer"
+	case *HeaderOverrideTimer:
+		return "HeaderOverrideTimer"
+	case *StratumTimer:
+		return "StratumTimer"
 	default:
 		return "UnknownTimer"
 	}
@@ -7,6 +7,8 @@ package server
 import (
 	"fmt"
 	"github.com/donsprallo/zeitgeist/internal/ntp"
+	"net"
+	"sync"
 	"testing"
 	"time"
 )
@@ -83,7 +85,7 @@ func TestTimerCollectionIndex(t *testing.T) {
 	}
 }
 
-// TestTimerCollectionIndex test to get Timer by id.
+// TestTimerCollectionGet test to get Timer by id.
 func TestTimerCollectionGet(t *testing.T) {
 	timer := DummyTimer{Message: "test"}
 
@@ -93,15 +95,29 @@ func TestTimerCollectionGet(t *testing.T) {
 	collection.Add(timer)
 	collection.Add(timer)
 
-	// Test identifier.
-	if collection.Get(0).Id != 0 {
-		t.Errorf("collection entry invalid id")
+	// Test identifier; the timer with id 0 must be found.
+	for id := 0; id < 3; id++ {
+		entry, ok := collection.Get(id)
+		if !ok {
+			t.Errorf("collection entry with id %d not found", id)
+		}
+		if entry.Id != id {
+			t.Errorf("collection entry invalid id")
+		}
 	}
-	if collection.Get(1).Id != 1 {
-		t.Errorf("collection entry invalid id")
+
+	// Test that a missing id is not found.
+	if _, ok := collection.Get(3); ok {
+		t.Errorf("collection entry with id 3 found")
 	}
-	if collection.Get(2).Id != 2 {
-		t.Errorf("collection entry invalid id")
+
+	// Test that a removed id 0 is not found.
+	err := collection.Delete(0)
+	if err != nil {
+		t.Fatalf("can not delete timer: %s", err)
+	}
+	if _, ok := collection.Get(0); ok {
+		t.Errorf("collection entry with id 0 found after delete")
 	}
 }
 
@@ -122,13 +138,513 @@ func TestTimerCollectionRemove(t *testing.T) {
 
 	// Test that timer 0 and 2 are in collection and timer with
 	// id 1 is removed.
-	if collection.Get(0).Timer == nil {
+	if _, ok := collection.Get(0); !ok {
 		t.Errorf("no timer with id 0")
 	}
-	if collection.Get(1).Timer != nil {
+	if _, ok := collection.Get(1); ok {
 		t.Errorf("no timer with id 1 removed")
 	}
-	if collection.Get(2).Timer == nil {
+	if _, ok := collection.Get(2); !ok {
 		t.Errorf("no timer with id 2")
 	}
 }
+
+// TestRateTimerUpdate test that a RateTimer advance scaled by rate over
+// real time instead of one rate per update.
+func TestRateTimerUpdate(t *testing.T) {
+	base := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	timer := NewRateTimer(ntp.Package{}, base, 2.0)
+
+	// Updates must not advance the timer.
+	for i := 0; i < 10; i++ {
+		timer.Update()
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// At double speed, the timer advances by twice the real time.
+	elapsed := timer.Get().Sub(base)
+	if elapsed < 400*time.Millisecond || elapsed > 800*time.Millisecond {
+		t.Errorf("invalid rate timer elapsed: want ~400ms get %s",
+			elapsed)
+	}
+	if TimerName(timer) != "RateTimer" {
+		t.Errorf("invalid rate timer name: %s", TimerName(timer))
+	}
+}
+
+// TestRateTimerSet test that setting a RateTimer reset the base.
+func TestRateTimerSet(t *testing.T) {
+	base := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	timer := NewRateTimer(ntp.Package{}, base, 0.5)
+	time.Sleep(100 * time.Millisecond)
+
+	// Set timer; the accumulated duration must be dropped.
+	value := time.Date(2000, time.June, 1, 12, 0, 0, 0, time.UTC)
+	timer.Set(value)
+	time.Sleep(200 * time.Millisecond)
+
+	// At half speed, the timer advances by half the real time.
+	elapsed := timer.Get().Sub(value)
+	if elapsed < 100*time.Millisecond || elapsed > 300*time.Millisecond {
+		t.Errorf("invalid rate timer elapsed after set: want ~100ms "+
+			"get %s", elapsed)
+	}
+}
+
+// TestSteppingTimerUpdate test that a SteppingTimer jumps by its step on
+// each update.
+func TestSteppingTimerUpdate(t *testing.T) {
+	base := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// Create test table; each step is applied n times.
+	table := []struct {
+		step time.Duration
+		want time.Time
+	}{
+		{time.Minute, base.Add(3 * time.Minute)},
+		{-2 * time.Second, base.Add(-6 * time.Second)},
+		{0, base},
+	}
+	for _, e := range table {
+		timer := SteppingTimer{Time: base, Step: e.step}
+		prev := timer.Get()
+		for i := 0; i < 3; i++ {
+			timer.Update()
+			// A backward step must produce an earlier time.
+			if e.step < 0 && !timer.Get().Before(prev) {
+				t.Errorf("step %s not backwards: %s after %s",
+					e.step, timer.Get(), prev)
+			}
+			prev = timer.Get()
+		}
+		if !timer.Get().Equal(e.want) {
+			t.Errorf("step %s invalid value: want %s get %s",
+				e.step, e.want, timer.Get())
+		}
+
+		// Set timer; the accumulated steps must be dropped.
+		timer.Set(base)
+		if !timer.Get().Equal(base) {
+			t.Errorf("step %s invalid value after set: %s",
+				e.step, timer.Get())
+		}
+	}
+}
+
+// TestHeaderOverrideTimerUnwrap test that a HeaderOverrideTimer reports
+// its base timer.
+func TestHeaderOverrideTimerUnwrap(t *testing.T) {
+	base := &ModifyTimer{
+		Time: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)}
+	timer := &HeaderOverrideTimer{Base: base}
+
+	// The wrapped timer must be the base timer.
+	if Unwrap(timer) != base {
+		t.Errorf("header override timer does not report its base")
+	}
+	// A timer without wrapping has no base.
+	if Unwrap(base) != nil {
+		t.Errorf("modify timer reports a base")
+	}
+	// Time values must be served from base timer.
+	if !timer.Get().Equal(base.Get()) {
+		t.Errorf("invalid header override timer value: want %s get %s",
+			base.Get(), timer.Get())
+	}
+}
+
+// TestTimerCollectionFind test to find a TimerCollectionEntry by Timer.
+func TestTimerCollectionFind(t *testing.T) {
+	base := &SystemTimer{}
+	timer := &HeaderOverrideTimer{Base: base}
+
+	// Create instance to test.
+	collection := NewTimerCollection(10)
+	collection.Add(&SystemTimer{})
+	collection.Add(base)
+
+	// Test that the base timer is found by instance.
+	entry, ok := collection.Find(Unwrap(timer))
+	if !ok || entry.Id != 1 {
+		t.Errorf("can not find base timer in collection")
+	}
+	// Test that timer not in collection is not found.
+	if _, ok := collection.Find(timer); ok {
+		t.Errorf("found timer that is not in collection")
+	}
+}
+
+// TestTimerCollectionDeleteUnused test that a timer is only deleted, when
+// no route and no wrapper timer references it.
+func TestTimerCollectionDeleteUnused(t *testing.T) {
+	collection := NewTimerCollection(10)
+	routed := &SystemTimer{}
+	routedId := collection.Add(routed)
+	base := &SystemTimer{}
+	baseId := collection.Add(base)
+	wrapperId := collection.Add(&StratumTimer{Base: base, Stratum: 2})
+	table := NewRoutingTable(10)
+	_, ipNet, _ := net.ParseCIDR("10.0.0.0/8")
+	table.MustAdd(*ipNet, routed, routedId)
+
+	// Create test table; each timer is deleted in order.
+	steps := []struct {
+		id  int
+		err error
+	}{
+		{99, ErrTimerNotFound},
+		{routedId, ErrTimerInUse},
+		{baseId, ErrTimerInUse},
+		{wrapperId, nil},
+		{baseId, nil},
+	}
+	for _, e := range steps {
+		if err := collection.DeleteUnused(e.id, table); err != e.err {
+			t.Errorf("timer %d invalid error: want %v get %v",
+				e.id, e.err, err)
+		}
+	}
+	if _, ok := collection.Get(routedId); !ok {
+		t.Errorf("routed timer deleted")
+	}
+}
+
+// TestTimerCollectionServed test that the served requests of a timer are
+// counted, also while the collection is read concurrently.
+func TestTimerCollectionServed(t *testing.T) {
+	collection := NewTimerCollection(10)
+	id := collection.Add(&SystemTimer{})
+	unused := collection.Add(&SystemTimer{})
+
+	// Count the requests concurrently.
+	served := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				collection.Served(id, served)
+				_ = collection.All()
+			}
+		}()
+	}
+	wg.Wait()
+	entry, _ := collection.Get(id)
+	if entry.Requests != 1000 {
+		t.Errorf("invalid requests: want 1000 get %d", entry.Requests)
+	}
+	if !entry.LastServed.Equal(served) {
+		t.Errorf("invalid last served: %s", entry.LastServed)
+	}
+	if entry, _ := collection.Get(unused); entry.Requests != 0 ||
+		!entry.LastServed.IsZero() {
+		t.Errorf("unused timer served: %+v", entry)
+	}
+
+	// A deleted timer is not counted.
+	_ = collection.Delete(id)
+	collection.Served(id, served)
+	if _, ok := collection.Get(id); ok {
+		t.Errorf("deleted timer found")
+	}
+}
+
+// TestTimerCollectionUpdatePackage test that the package of a timer is
+// changed, while responses are created from the package.
+func TestTimerCollectionUpdatePackage(t *testing.T) {
+	timer := &SystemTimer{}
+	collection := NewTimerCollection(10)
+	id := collection.Add(timer)
+
+	// Create responses while the package is updated.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			var dst ntp.Package
+			_, _ = collection.PackageFromTimer(&dst, timer)
+		}
+	}()
+	for i := 1; i <= 100; i++ {
+		err := collection.UpdatePackage(id, func(pkg *ntp.Package) error {
+			pkg.SetStratum(uint32(i))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("can not update package: %s", err)
+		}
+	}
+	wg.Wait()
+	if stratum := timer.Package().GetStratum(); stratum != 100 {
+		t.Errorf("invalid stratum: want 100 get %d", stratum)
+	}
+
+	// A timer not in the collection is not found.
+	err := collection.UpdatePackage(99, func(*ntp.Package) error {
+		return nil
+	})
+	if err != ErrTimerNotFound {
+		t.Errorf("invalid error: want %q get %v", ErrTimerNotFound, err)
+	}
+}
+
+// TestTimerCollectionSetTime test that a timer is set, while responses
+// are created from the timer.
+func TestTimerCollectionSetTime(t *testing.T) {
+	timer := NewModifyTimer(ntp.Package{}, time.Now())
+	collection := NewTimerCollection(10)
+	id := collection.Add(timer)
+
+	// Create responses while the timer is set.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			var dst ntp.Package
+			_, _ = collection.PackageFromTimer(&dst, timer)
+		}
+	}()
+	value := time.Date(2000, time.June, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		if err := collection.SetTime(id, value); err != nil {
+			t.Fatalf("can not set time: %s", err)
+		}
+	}
+	wg.Wait()
+	if diff := timer.Get().Sub(value); diff < 0 || diff > time.Second {
+		t.Errorf("invalid timer value: %s", timer.Get())
+	}
+
+	// A timer not in the collection is not found.
+	if err := collection.SetTime(99, value); err != ErrTimerNotFound {
+		t.Errorf("invalid error: want %q get %v", ErrTimerNotFound, err)
+	}
+}
+
+// TestTimerCollectionSyncNtpTimers test that only the NtpTimer instances
+// of the collection serve the upstream reference, also while the server
+// creates responses.
+func TestTimerCollectionSyncNtpTimers(t *testing.T) {
+	ntpTimer := &NtpTimer{}
+	systemTimer := &SystemTimer{}
+	collection := NewTimerCollection(10)
+	collection.Add(ntpTimer)
+	collection.Add(systemTimer)
+
+	// Create responses while the timers are synced.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			var dst ntp.Package
+			_, _ = collection.PackageFromTimer(&dst, ntpTimer)
+		}
+	}()
+	var upstream ntp.Package
+	upstream.SetReferenceClockId([]byte("GPS\x00"))
+	synced := collection.SyncNtpTimers(&upstream)
+	wg.Wait()
+	if synced != 1 {
+		t.Errorf("invalid number of synced timers: %d", synced)
+	}
+
+	// Only the NtpTimer serves the upstream reference id.
+	var dst ntp.Package
+	pkg, _ := collection.PackageFromTimer(&dst, ntpTimer)
+	if id := string(pkg.GetReferenceClockId()); id != "GPS\x00" {
+		t.Errorf("invalid ntp timer reference id: %q", id)
+	}
+	if id := string(systemTimer.Package().GetReferenceClockId()); id != "" &&
+		id != "\x00\x00\x00\x00" {
+		t.Errorf("system timer synced: %q", id)
+	}
+}
+
+// TestApplyStratum test the derived package fields for stratum levels.
+func TestApplyStratum(t *testing.T) {
+	// Create test table; each stratum maps to expected precision, root
+	// dispersion and poll values.
+	table := []struct {
+		stratum    uint32
+		wantStrat  uint32
+		precision  int8
+		dispersion uint32
+		poll       uint32
+	}{
+		{1, 1, -20, 65, 6},
+		{10, 10, -11, 655, 10},
+		{0, 1, -20, 65, 6},
+		{16, 15, -6, 983, 10},
+	}
+
+	// Test all entries in test table.
+	for _, e := range table {
+		var pkg ntp.Package
+		ApplyStratum(&pkg, e.stratum)
+
+		if pkg.GetStratum() != e.wantStrat {
+			t.Errorf("stratum[%d] invalid stratum: want %d get %d",
+				e.stratum, e.wantStrat, pkg.GetStratum())
+		}
+		if int8(pkg.GetPrecision()) != e.precision {
+			t.Errorf("stratum[%d] invalid precision: want %d get %d",
+				e.stratum, e.precision, int8(pkg.GetPrecision()))
+		}
+		if pkg.GetRootDispersion() != e.dispersion {
+			t.Errorf("stratum[%d] invalid dispersion: want %d get %d",
+				e.stratum, e.dispersion, pkg.GetRootDispersion())
+		}
+		if pkg.GetPoll() != e.poll {
+			t.Errorf("stratum[%d] invalid poll: want %d get %d",
+				e.stratum, e.poll, pkg.GetPoll())
+		}
+	}
+}
+
+// TestStratumTimerPackage test that a StratumTimer serves the package of
+// its base with stratum derived fields.
+func TestStratumTimerPackage(t *testing.T) {
+	base := &SystemTimer{}
+	base.NTPPackage.SetVersion(ntp.VersionV4)
+	base.NTPPackage.SetStratum(1)
+	timer := &StratumTimer{Base: base, Stratum: 10}
+
+	pkg := timer.Package()
+	if pkg.GetVersion() != ntp.VersionV4 {
+		t.Errorf("stratum timer does not serve base package")
+	}
+	if pkg.GetStratum() != 10 {
+		t.Errorf("invalid stratum timer stratum: want %d get %d",
+			10, pkg.GetStratum())
+	}
+	// The base package must not be modified.
+	if base.Package().GetStratum() != 1 {
+		t.Errorf("stratum timer modified base package")
+	}
+	if Unwrap(timer) != base {
+		t.Errorf("stratum timer does not report its base")
+	}
+
+	// Concurrent requests read the package without modifying the timer;
+	// run with -race to detect unsynchronized access.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if timer.Package().GetStratum() != 10 {
+				t.Errorf("invalid concurrent stratum")
+			}
+		}()
+	}
+	wg.Wait()
+	if base.Package().GetStratum() != 1 {
+		t.Errorf("base package modified by concurrent read")
+	}
+}
+
+// TestDriftTimerUpdate test that a DriftTimer accumulates the frequency
+// error over real time instead of one rate per update.
+func TestDriftTimerUpdate(t *testing.T) {
+	base := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	timer := NewDriftTimer(ntp.Package{}, base, 2.0)
+
+	// Updates must not advance the timer.
+	timer.Update()
+	time.Sleep(200 * time.Millisecond)
+
+	// At double speed, the timer drifts by the elapsed real time.
+	elapsed := timer.Get().Sub(base)
+	if elapsed < 400*time.Millisecond || elapsed > 800*time.Millisecond {
+		t.Errorf("invalid drift timer elapsed: want ~400ms get %s",
+			elapsed)
+	}
+	drift := timer.Drift()
+	if drift < 200*time.Millisecond || drift > 400*time.Millisecond {
+		t.Errorf("invalid drift timer drift: want ~200ms get %s", drift)
+	}
+
+	// Setting the timer must reset the drift.
+	timer.Set(base)
+	if drift := timer.Drift(); drift < 0 || drift > 100*time.Millisecond {
+		t.Errorf("invalid drift timer drift after set: %s", drift)
+	}
+	if TimerName(timer) != "DriftTimer" {
+		t.Errorf("invalid drift timer name: %s", TimerName(timer))
+	}
+}
+
+// TestModifyTimerRealTime test that a ModifyTimer advances in real time
+// instead of one second per update.
+func TestModifyTimerRealTime(t *testing.T) {
+	base := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	timer := NewModifyTimer(ntp.Package{}, base)
+
+	// Updates must not advance the timer.
+	timer.Update()
+	time.Sleep(200 * time.Millisecond)
+
+	elapsed := timer.Get().Sub(base)
+	if elapsed < 200*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("invalid modify timer elapsed: want ~200ms get %s",
+			elapsed)
+	}
+
+	// Setting the timer must re-anchor the timer.
+	timer.Set(base)
+	elapsed = timer.Get().Sub(base)
+	if elapsed < 0 || elapsed > 100*time.Millisecond {
+		t.Errorf("invalid modify timer elapsed after set: %s", elapsed)
+	}
+}
+
+// Just a timer to count the updates.
+type countTimer struct {
+	DummyTimer
+	updates chan struct{}
+}
+
+// Update implements Timer.Update interface.
+func (t countTimer) Update() {
+	t.updates <- struct{}{}
+}
+
+// TestTimerCollectionUpdateLoop test that the loop updates all timers and
+// returns, when done is closed.
+func TestTimerCollectionUpdateLoop(t *testing.T) {
+	timer := countTimer{updates: make(chan struct{}, 1)}
+	collection := NewTimerCollection(1)
+	collection.Add(timer)
+
+	// Run loop in background.
+	done := make(chan struct{})
+	returned := make(chan struct{})
+	go func() {
+		collection.UpdateLoop(time.Millisecond, done)
+		close(returned)
+	}()
+
+	// Wait for an update of the timer.
+	select {
+	case <-timer.updates:
+	case <-time.After(time.Second):
+		t.Fatalf("timer not updated")
+	}
+
+	// The loop must return, when done is closed.
+	close(done)
+	for {
+		select {
+		case <-timer.updates:
+			// Drain pending updates.
+		case <-returned:
+			return
+		case <-time.After(time.Second):
+			t.Fatalf("loop not returned after done closed")
+		}
+	}
+}
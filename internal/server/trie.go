@@ -0,0 +1,165 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A node of a binary trie over the bits of network prefixes. A node holds
+// a route when a prefix ends at the node.
+type trieNode struct {
+	children [2]*trieNode
+	entry    *RoutingTableEntry
+}
+
+// A binary trie built from a RoutingTable version. IPv4 and IPv6 prefixes
+// are stored in separate tries.
+type routingTrie struct {
+	version uint64
+	ipv4    *trieNode
+	ipv6    *trieNode
+}
+
+// Get the trie key of an ip address. IPv4 addresses use their 4-byte
+// representation, all other addresses their 16-byte representation.
+func trieKey(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+// Get the bit of key at position idx, where position zero is the most
+// significant bit.
+func trieBit(key net.IP, idx int) int {
+	return int(key[idx/8]>>(7-idx%8)) & 1
+}
+
+// Build a routingTrie from entries. Later entries override earlier entries
+// with the same prefix, like in StaticRouting.
+func newRoutingTrie(entries []RoutingTableEntry, version uint64) *routingTrie {
+	trie := &routingTrie{
+		version: version,
+		ipv4:    &trieNode{},
+		ipv6:    &trieNode{},
+	}
+	for idx := range entries {
+		entry := &entries[idx]
+		ones, bits := entry.IPNet.Mask.Size()
+		// Select trie by mask size. Entries with a non-canonical
+		// mask can not be stored.
+		node := trie.ipv4
+		key := entry.IPNet.IP.Mask(entry.IPNet.Mask)
+		switch {
+		case bits == 8*net.IPv4len && key.To4() != nil:
+			key = key.To4()
+		case bits == 8*net.IPv6len:
+			node = trie.ipv6
+			key = key.To16()
+		default:
+			log.Warnf("route with mask[%s] can not be added to trie",
+				entry.IPNet.String())
+			continue
+		}
+		// Walk prefix bits and create missing nodes.
+		for i := 0; i < ones; i++ {
+			bit := trieBit(key, i)
+			if node.children[bit] == nil {
+				node.children[bit] = &trieNode{}
+			}
+			node = node.children[bit]
+		}
+		node.entry = entry
+	}
+	return trie
+}
+
+// Find the entry with the longest prefix matching ip.
+func (t *routingTrie) find(ip net.IP) *RoutingTableEntry {
+	key := trieKey(ip)
+	node := t.ipv6
+	if len(key) == net.IPv4len {
+		node = t.ipv4
+	}
+	// Walk key bits and remember the last route on the way.
+	var match *RoutingTableEntry
+	for i := 0; node != nil; i++ {
+		if node.entry != nil {
+			match = node.entry
+		}
+		if i == 8*len(key) {
+			break
+		}
+		node = node.children[trieBit(key, i)]
+	}
+	return match
+}
+
+// TrieRouting is a specific RoutingStrategy for large routing tables. The
+// RoutingTable is indexed by a binary trie over the network prefixes. The
+// trie finds the most specific route of a net.IP address in O(prefix
+// length). The trie is rebuilt on the next lookup after the RoutingTable
+// is modified.
+type TrieRouting struct {
+	Table *RoutingTable
+	trie  atomic.Pointer[routingTrie]
+}
+
+// FindTimer search for a Timer by a net.IP address. When no address matches
+// one of the timers network mask, an error is returned. But this should
+// never have reached in normal system.
+func (r *TrieRouting) FindTimer(
+	ip net.IP,
+) (Timer, error) {
+	entry, err := r.FindRoute(ip)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Timer, nil
+}
+
+// FindRoute search for the RoutingTableEntry of a net.IP address like
+// TrieRouting.FindTimer.
+func (r *TrieRouting) FindRoute(
+	ip net.IP,
+) (RoutingTableEntry, error) {
+	// Rebuild trie when the routing Table is modified.
+	trie := r.trie.Load()
+	if trie == nil || trie.version != r.Table.Version() {
+		trie = newRoutingTrie(r.Table.snapshot())
+		r.trie.Store(trie)
+	}
+	// Find the most specific match.
+	if entry := trie.find(ip); entry != nil {
+		log.Debugf("host with ip[%s] prefix mask[%s] match",
+			ip, entry.IPNet.String())
+		return *entry, nil
+	}
+	// No match found. Should never have reached.
+	return RoutingTableEntry{}, errors.New(
+		"no handler found in routing Table")
+}
+
+// NewTrieRouting create a new TrieRouting instance. A default Timer must
+// be added to be sure that we have a default ntp timer. The default Timer
+// is added to the RoutingTable as default route, that handle all net.IP
+// addresses without need to add other routes.
+func NewTrieRouting(
+	table *RoutingTable,
+	defaultTimer Timer,
+	timerId int,
+) *TrieRouting {
+	// Create basic structure
+	routing := TrieRouting{
+		Table: table,
+	}
+	addDefaultRoutes(routing.Table, defaultTimer, timerId)
+	return &routing
+}
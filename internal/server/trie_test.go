@@ -0,0 +1,111 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+// Add the routes of the TestFindTimer test to table.
+func addTestRoutes(table *RoutingTable) {
+	table.MustAdd(net.IPNet{
+		Mask: net.CIDRMask(24, 32),
+		IP:   net.ParseIP("192.168.1.0"),
+	}, DummyTimer{Message: "net1"}, 1)
+	table.MustAdd(net.IPNet{
+		Mask: net.CIDRMask(32, 32),
+		IP:   net.ParseIP("192.168.2.11"),
+	}, DummyTimer{Message: "net2"}, 2)
+}
+
+func TestTrieRoutingFindTimer(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("0.0.0.0"),
+		net.ParseIP("127.0.0.1"),
+		net.ParseIP("::1"),
+		net.ParseIP("192.168.1.10"),
+		net.ParseIP("192.168.1.11"),
+		net.ParseIP("192.168.2.11"),
+		net.ParseIP("192.168.2.10"),
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("fe80::1"),
+		net.ParseIP("::ffff:192.168.1.10"),
+	}
+
+	// Create both strategies with the same routes.
+	defaultTimer := DummyTimer{Message: "default"}
+	static := NewStaticRouting(NewRoutingTable(10), defaultTimer, 0)
+	addTestRoutes(static.Table)
+	trie := NewTrieRouting(NewRoutingTable(10), defaultTimer, 0)
+	addTestRoutes(trie.Table)
+
+	// Test that both strategies return the same timer.
+	for _, ip := range ips {
+		want, wantErr := static.FindTimer(ip)
+		get, err := trie.FindTimer(ip)
+		if (err != nil) != (wantErr != nil) {
+			t.Errorf("ip[%s] different errors: want %v get %v",
+				ip, wantErr, err)
+			continue
+		}
+		if get != want {
+			t.Errorf("ip[%s] found different timer: want '%v' get '%v'",
+				ip, want, get)
+		}
+	}
+}
+
+func TestTrieRoutingTableModified(t *testing.T) {
+	defaultTimer := DummyTimer{Message: "default"}
+	trie := NewTrieRouting(NewRoutingTable(10), defaultTimer, 0)
+	ip := net.ParseIP("10.1.2.3")
+
+	// Find the default timer before the route is added.
+	timer, err := trie.FindTimer(ip)
+	if err != nil || timer.(DummyTimer).Message != "default" {
+		t.Fatalf("ip[%s] invalid timer: %v %v", ip, timer, err)
+	}
+
+	// The trie must be rebuilt after the table is modified.
+	_, ipNet, _ := net.ParseCIDR("10.0.0.0/8")
+	trie.Table.MustAdd(*ipNet, DummyTimer{Message: "net"}, 1)
+	timer, err = trie.FindTimer(ip)
+	if err != nil || timer.(DummyTimer).Message != "net" {
+		t.Errorf("ip[%s] invalid timer after add: %v %v", ip, timer, err)
+	}
+}
+
+// Add n /24 routes to table.
+func addBenchmarkRoutes(table *RoutingTable, n int) {
+	for i := 0; i < n; i++ {
+		_, ipNet, _ := net.ParseCIDR(
+			fmt.Sprintf("10.%d.%d.0/24", i/256, i%256))
+		table.MustAdd(*ipNet, DummyTimer{Message: ipNet.String()}, i)
+	}
+}
+
+func BenchmarkStaticRouting(b *testing.B) {
+	table := NewRoutingTable(10003)
+	routing := NewStaticRouting(table, DummyTimer{Message: "default"}, 0)
+	addBenchmarkRoutes(table, 10000)
+	ip := net.ParseIP("10.0.1.1")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = routing.FindTimer(ip)
+	}
+}
+
+func BenchmarkTrieRouting(b *testing.B) {
+	table := NewRoutingTable(10003)
+	routing := NewTrieRouting(table, DummyTimer{Message: "default"}, 0)
+	addBenchmarkRoutes(table, 10000)
+	ip := net.ParseIP("10.0.1.1")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = routing.FindTimer(ip)
+	}
+}
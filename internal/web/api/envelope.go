@@ -0,0 +1,35 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+)
+
+// Envelope is the uniform response of the API endpoints. A successful
+// response has the response value as Data. A failed response has the
+// error message as Error, or Data with the details of the failure.
+type Envelope struct {
+	Success bool   `json:"success"`
+	Data    any    `json:"data,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// MustEnvelopeResponse write data in an Envelope with status to response.
+// A status of 400 http.StatusBadRequest and above is not successful.
+func MustEnvelopeResponse(w http.ResponseWriter, data any, status int) {
+	MustJsonResponse(w, Envelope{
+		Success: status < http.StatusBadRequest,
+		Data:    data,
+	}, status)
+}
+
+// MustEnvelopeError write the error message in a failed Envelope with
+// status to response.
+func MustEnvelopeError(w http.ResponseWriter, message string, status int) {
+	MustJsonResponse(w, Envelope{
+		Error: message,
+	}, status)
+}
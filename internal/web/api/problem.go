@@ -0,0 +1,64 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ProblemContentType is the media type of a ProblemResponse.
+const ProblemContentType = "application/problem+json"
+
+// ProblemResponse is an error response in the problem details format of
+// RFC 7807.
+type ProblemResponse struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// AcceptsProblem check whether the client of r accepts a ProblemResponse
+// by the Accept header.
+func AcceptsProblem(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(part)
+			if err == nil && mediaType == ProblemContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MustProblemResponse write a ProblemResponse with detail and status to
+// response. The problem type is not further specified and the instance
+// is the request path of r.
+func MustProblemResponse(
+	w http.ResponseWriter, r *http.Request, detail string, status int,
+) {
+	// Set response header.
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(status)
+
+	// Encode problem into json string and write to response.
+	err := json.NewEncoder(w).Encode(ProblemResponse{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	})
+	if err != nil {
+		log.Error(err)
+	}
+}
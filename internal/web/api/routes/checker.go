@@ -0,0 +1,214 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/ntp"
+)
+
+// Types of the built-in Healthy checkers.
+const (
+	CheckerTypeNtp  = "ntp"  // Check that an upstream ntp server answers.
+	CheckerTypeFile = "file" // Check that a file is modified recently.
+)
+
+// DefaultCheckInterval is the interval between the background checks of a
+// NtpChecker without configured interval.
+const DefaultCheckInterval = time.Minute
+
+// ErrNotChecked is reported by NtpChecker before the first check of the
+// ntp server.
+var ErrNotChecked = errors.New("ntp server not checked")
+
+// CheckerConfig is the configuration of a built-in Healthy checker. The
+// Target is the "host:port" address of a ntp checker or the path of a file
+// checker. The MaxAge is the maximum modification age of a file checker
+// like "1h". The Interval is the time between the checks of a ntp checker
+// like "30s", by default DefaultCheckInterval.
+type CheckerConfig struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Target   string `json:"target"`
+	MaxAge   string `json:"maxAge,omitempty"`
+	Interval string `json:"interval,omitempty"`
+}
+
+// ParseCheckerConfigs parse a JSON array of CheckerConfig objects.
+func ParseCheckerConfigs(data []byte) ([]CheckerConfig, error) {
+	var configs []CheckerConfig
+	err := json.Unmarshal(data, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse checkers: %w", err)
+	}
+	return configs, nil
+}
+
+// NewChecker creates a built-in Healthy checker from CheckerConfig.
+func NewChecker(cfg CheckerConfig) (Healthy, error) {
+	switch cfg.Type {
+	case CheckerTypeNtp:
+		host, portStr, err := net.SplitHostPort(cfg.Target)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"checker %s invalid target: %w", cfg.Name, err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"checker %s invalid port: %w", cfg.Name, err)
+		}
+		interval := DefaultCheckInterval
+		if cfg.Interval != "" {
+			interval, err = time.ParseDuration(cfg.Interval)
+			if err != nil || interval <= 0 {
+				return nil, fmt.Errorf(
+					"checker %s invalid interval %q", cfg.Name, cfg.Interval)
+			}
+		}
+		return NewNtpChecker(host, port, interval), nil
+	case CheckerTypeFile:
+		maxAge, err := time.ParseDuration(cfg.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"checker %s invalid max age: %w", cfg.Name, err)
+		}
+		return &FileChecker{Path: cfg.Target, MaxAge: maxAge}, nil
+	default:
+		return nil, fmt.Errorf(
+			"checker %s has unknown type %q", cfg.Name, cfg.Type)
+	}
+}
+
+// NtpChecker implements the Healthy interface. The checker is healthy,
+// when the ntp server on Host and Port answered the last request. The ntp
+// server is requested in background by CheckLoop on each Interval, so that
+// a healthcheck does not wait for the ntp server. The checker is safe for
+// concurrent use.
+type NtpChecker struct {
+	Host     string
+	Port     int
+	Interval time.Duration
+	options  ntp.RequestOptions // timeout and retries of a request.
+
+	mu      sync.Mutex
+	err     error     // the error of the last request.
+	checked time.Time // time of the last request.
+}
+
+// NewNtpChecker create a new NtpChecker of the ntp server on host and port,
+// that is checked on each interval. The checker is unhealthy until the
+// first check.
+func NewNtpChecker(
+	host string, port int, interval time.Duration,
+) *NtpChecker {
+	return &NtpChecker{
+		Host:     host,
+		Port:     port,
+		Interval: interval,
+		err:      ErrNotChecked,
+	}
+}
+
+// Check request the ntp server once and cache the result. The error of the
+// request is returned.
+func (c *NtpChecker) Check() error {
+	_, err := ntp.Query(c.Host, c.Port, c.options)
+	if err != nil {
+		err = fmt.Errorf("ntp server %s unreachable: %w",
+			net.JoinHostPort(c.Host, strconv.Itoa(c.Port)), err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err, c.checked = err, time.Now()
+	return err
+}
+
+// CheckLoop check the ntp server immediately and then on each Interval
+// until done is closed.
+func (c *NtpChecker) CheckLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		_ = c.Check()
+		select {
+		// On ticker ticks, check again.
+		case <-ticker.C:
+		// On done, stop checking.
+		case <-done:
+			return
+		}
+	}
+}
+
+// Checked get the time of the last check. Before the first check, the zero
+// time is returned.
+func (c *NtpChecker) Checked() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checked
+}
+
+// IsHealthy implements Healthy.IsHealthy interface.
+func (c *NtpChecker) IsHealthy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err == nil
+}
+
+// Error implements Healthy.error interface.
+func (c *NtpChecker) Error() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		return ""
+	}
+	return c.err.Error()
+}
+
+// FileChecker implements the Healthy interface. The checker is healthy,
+// when the file on Path is modified within MaxAge. The checker is safe for
+// concurrent use.
+type FileChecker struct {
+	Path   string
+	MaxAge time.Duration
+
+	mu  sync.Mutex
+	err error
+}
+
+// IsHealthy implements Healthy.IsHealthy interface.
+func (c *FileChecker) IsHealthy() bool {
+	var err error
+	info, statErr := os.Stat(c.Path)
+	if statErr != nil {
+		err = statErr
+	} else if age := time.Since(info.ModTime()); age > c.MaxAge {
+		err = fmt.Errorf("file %s is stale since %s",
+			c.Path, age.Truncate(time.Second))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+	return err == nil
+}
+
+// Error implements Healthy.error interface.
+func (c *FileChecker) Error() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		return ""
+	}
+	return c.err.Error()
+}
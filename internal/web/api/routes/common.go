@@ -5,10 +5,16 @@
 package routes
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/donsprallo/zeitgeist/internal/ntp"
 	"github.com/donsprallo/zeitgeist/internal/server"
 	"github.com/donsprallo/zeitgeist/internal/web/api"
+	"io"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -26,34 +32,285 @@ var (
 		Message: "invalid query parameter"}
 	BodyDecodeError = ErrorResponse{
 		Message: "can not decode body data"}
+	EmptyBodyError = ErrorResponse{
+		Message: "request body is empty"}
 	NotFoundError = ErrorResponse{
 		Message: "entity not found"}
 )
 
-// Create a ntp.Package from request data.
-func packageFromReq(_ *http.Request) *ntp.Package {
+// Write v as api.Envelope with status to response. The message of an
+// ErrorResponse is the error of the envelope, any other value is the data.
+func jsonResponse(w http.ResponseWriter, v any, status int) {
+	if response, ok := v.(ErrorResponse); ok {
+		api.MustEnvelopeError(w, response.Message, status)
+		return
+	}
+	api.MustEnvelopeResponse(w, v, status)
+}
+
+// Decode the JSON request body into v. On failure, an error response is
+// written and false is returned. An empty body is reported with the
+// EmptyBodyError, malformed body data with the BodyDecodeError and the
+// decoding error as detail.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, io.EOF):
+		jsonResponse(
+			w, EmptyBodyError, http.StatusBadRequest)
+	default:
+		jsonResponse(w, ErrorResponse{
+			Message: fmt.Sprintf(
+				"%s: %s", BodyDecodeError.Message, err),
+		}, http.StatusBadRequest)
+	}
+	return false
+}
+
+// Decode the JSON request body into v like decodeBody, but an empty body
+// is accepted and leaves v unchanged.
+func decodeOptionalBody(
+	w http.ResponseWriter, r *http.Request, v any,
+) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if errors.Is(err, io.EOF) {
+		return true
+	}
+	if err != nil {
+		jsonResponse(w, ErrorResponse{
+			Message: fmt.Sprintf(
+				"%s: %s", BodyDecodeError.Message, err),
+		}, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// TimeLayout is a named time layout accepted by the api.
+type TimeLayout struct {
+	Name   string
+	Layout string
+}
+
+// TimeLayouts are the time layouts accepted by the api in order.
+var TimeLayouts = []TimeLayout{
+	{"RFC3339", time.RFC3339},
+	{"RFC3339Nano", time.RFC3339Nano},
+	{"RFC822", time.RFC822},
+}
+
+// Parse a time value with the first matching layout of TimeLayouts. When
+// no layout matches, the error lists all layouts tried.
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range TimeLayouts {
+		if t, err := time.Parse(layout.Layout, value); err == nil {
+			return t, nil
+		}
+	}
+	tried := make([]string, len(TimeLayouts))
+	for idx, layout := range TimeLayouts {
+		tried[idx] = fmt.Sprintf("%s (%s)", layout.Name, layout.Layout)
+	}
+	return time.Time{}, fmt.Errorf(
+		"can not parse time %q; accepted formats: %s",
+		value, strings.Join(tried, ", "))
+}
+
+// PackageRequest is the ntp package header of a new timer. Absent fields
+// keep the default of packageFromReq. The precision is a signed log2
+// seconds exponent and the referenceId up to four ASCII characters. The
+// rootDelay and rootDispersion are durations like "50ms", advertised to
+// simulate a server at a network distance.
+type PackageRequest struct {
+	Version        *uint32 `json:"version,omitempty"`
+	Mode           *uint32 `json:"mode,omitempty"`
+	Stratum        *uint32 `json:"stratum,omitempty"`
+	Leap           *uint32 `json:"leap,omitempty"`
+	Poll           *int    `json:"poll,omitempty"`
+	Precision      *int    `json:"precision,omitempty"`
+	ReferenceId    *string `json:"referenceId,omitempty"`
+	RootDelay      *string `json:"rootDelay,omitempty"`
+	RootDispersion *string `json:"rootDispersion,omitempty"`
+}
+
+// MaxRootDuration is the maximum root delay and root dispersion of a
+// PackageRequest. The values are 16.16 fixed point seconds.
+const MaxRootDuration = math.MaxInt16 * time.Second
+
+// Parse the root delay or root dispersion value of a PackageRequest.
+func parseRootDuration(name, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 || d > MaxRootDuration {
+		return 0, fmt.Errorf(
+			"%s must be a duration between 0s and %s", name, MaxRootDuration)
+	}
+	return d, nil
+}
+
+// Build the ntp.Package of request. The default package is a version 3
+// server package with stratum 1 and reference id "NICO". An error is
+// returned, when a field is out of range.
+func (request PackageRequest) Package() (*ntp.Package, error) {
 	// Create default ntp package.
 	var pkg ntp.Package
 	pkg.SetVersion(ntp.VersionV3)
 	pkg.SetMode(ntp.ModeServer)
 	pkg.SetStratum(1)
 	pkg.SetReferenceClockId([]byte("NICO"))
-	return &pkg
+	if err := request.Apply(&pkg); err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+// Apply the fields of request to pkg. Absent fields keep the value of
+// pkg. When a field is out of range, an error is returned and pkg is
+// not modified.
+func (request PackageRequest) Apply(pkg *ntp.Package) error {
+	// Modify a copy, so that pkg is unchanged on error.
+	result := *pkg
+	// Override package by request fields.
+	if v := request.Version; v != nil {
+		if *v < ntp.VersionV3 || *v > ntp.VersionV4 {
+			return fmt.Errorf(
+				"version must be between %d and %d",
+				ntp.VersionV3, ntp.VersionV4)
+		}
+		result.SetVersion(*v)
+	}
+	if v := request.Mode; v != nil {
+		if *v > ntp.ModePrivate {
+			return fmt.Errorf(
+				"mode must be between %d and %d",
+				ntp.ModeReserved, ntp.ModePrivate)
+		}
+		result.SetMode(*v)
+	}
+	if v := request.Stratum; v != nil {
+		if *v > 255 {
+			return errors.New("stratum must be between 0 and 255")
+		}
+		result.SetStratum(*v)
+	}
+	if v := request.Leap; v != nil {
+		if *v > ntp.LeapNotSyn {
+			return fmt.Errorf(
+				"leap must be between %d and %d",
+				ntp.LeapNotSet, ntp.LeapNotSyn)
+		}
+		result.SetLeap(*v)
+	}
+	if v := request.Poll; v != nil {
+		if *v < ntp.MinPoll || *v > ntp.MaxPoll {
+			return fmt.Errorf(
+				"poll must be between %d and %d",
+				ntp.MinPoll, ntp.MaxPoll)
+		}
+		result.SetPoll(uint32(*v))
+	}
+	if v := request.Precision; v != nil {
+		if *v < math.MinInt8 || *v > math.MaxInt8 {
+			return fmt.Errorf(
+				"precision must be between %d and %d",
+				math.MinInt8, math.MaxInt8)
+		}
+		result.SetPrecision(uint32(uint8(int8(*v))))
+	}
+	if v := request.ReferenceId; v != nil {
+		if len(*v) == 0 || len(*v) > 4 {
+			return errors.New(
+				"referenceId must have 1 to 4 characters")
+		}
+		// The reference id is padded with zero bytes.
+		result.SetReferenceClockId([]byte(*v))
+	}
+	if v := request.RootDelay; v != nil {
+		d, err := parseRootDuration("rootDelay", *v)
+		if err != nil {
+			return err
+		}
+		result.SetRootDelayDuration(d)
+	}
+	if v := request.RootDispersion; v != nil {
+		d, err := parseRootDuration("rootDispersion", *v)
+		if err != nil {
+			return err
+		}
+		result.SetRootDispersionDuration(d)
+	}
+	*pkg = result
+	return nil
+}
+
+// Create a ntp.Package from request data. On invalid request data, an
+// error response is written and false is returned.
+func packageFromReq(
+	w http.ResponseWriter, request PackageRequest,
+) (*ntp.Package, bool) {
+	pkg, err := request.Package()
+	if err != nil {
+		jsonResponse(w, ErrorResponse{
+			Message: err.Error(),
+		}, http.StatusBadRequest)
+		return nil, false
+	}
+	return pkg, true
+}
+
+// Build the TimerBaseResponse chain of timers wrapped by timer. The id of
+// each wrapped timer is looked up in timers. When a wrapped timer is not
+// part of the collection, the id is -1. When timer does not wrap another
+// timer, nil is returned.
+func timerBaseResponse(
+	timers *server.TimerCollection,
+	timer server.Timer,
+) *TimerBaseResponse {
+	base := server.Unwrap(timer)
+	if base == nil {
+		return nil
+	}
+	id := -1
+	if entry, ok := timers.Find(base); ok {
+		id = entry.Id
+	}
+	return &TimerBaseResponse{
+		Id:   id,
+		Type: server.TimerName(base),
+		Base: timerBaseResponse(timers, base),
+	}
 }
 
 // mustJsonTimerResponse encode a Timer instance to json string and write the
 // result to response. This must always be made. An error will log with panic.
 func mustJsonTimerResponse(
 	w http.ResponseWriter,
+	timers *server.TimerCollection,
 	timer server.Timer,
 	id int,
 	status int,
 ) {
 	// Build response with timer data.
 	response := TimerValueResponse{
-		Id:    id,
-		Type:  server.TimerName(timer),
-		Value: timer.Get().Format(time.RFC3339),
+		Id:      id,
+		Type:    server.TimerName(timer),
+		Value:   timer.Get().Format(time.RFC3339),
+		Package: newPackageResponse(timer.Package()),
+		Base:    timerBaseResponse(timers, timer),
+	}
+	if entry, ok := timers.Get(id); ok {
+		response.LastServed = formatLastServed(entry.LastServed)
+		response.Requests = entry.Requests
+	}
+	jsonResponse(w, response, status)
+}
+
+// Format the last served time of a timer. A timer that was never served
+// has an empty last served time.
+func formatLastServed(t time.Time) string {
+	if t.IsZero() {
+		return ""
 	}
-	api.MustJsonResponse(w, response, status)
+	return t.Format(time.RFC3339Nano)
 }
@@ -0,0 +1,199 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/server"
+	"github.com/gorilla/mux"
+)
+
+func TestParseTime(t *testing.T) {
+	// Create test table; each value maps to a parsed time.
+	table := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-01-02T03:04:05Z",
+			time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{"2024-01-02T03:04:05.123456789Z",
+			time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)},
+		{"02 Jan 24 03:04 UTC",
+			time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)},
+	}
+
+	// Test all entries in test table.
+	for _, e := range table {
+		value, err := parseTime(e.value)
+		if err != nil {
+			t.Errorf("%q parse err: %s", e.value, err)
+			continue
+		}
+		if !value.Equal(e.want) {
+			t.Errorf("%q invalid time: want %s get %s",
+				e.value, e.want, value)
+		}
+	}
+
+	// An unparseable value must list all tried layouts.
+	_, err := parseTime("yesterday")
+	if err == nil {
+		t.Fatalf("unparseable time parsed")
+	}
+	for _, layout := range TimeLayouts {
+		if !strings.Contains(err.Error(), layout.Name) {
+			t.Errorf("error does not list layout %s: %s",
+				layout.Name, err)
+		}
+	}
+}
+
+func TestPackageRequest(t *testing.T) {
+	// Create test table; each body maps to the expected package header
+	// fields version, mode, stratum, leap, poll, precision and reference
+	// id or to an error.
+	table := []struct {
+		body  string
+		want  [6]uint32
+		refId string
+		err   bool
+	}{
+		{`{}`, [6]uint32{3, 4, 1, 0, 0, 0}, "NICO", false},
+		{`{"stratum": 2, "referenceId": "GPS"}`,
+			[6]uint32{3, 4, 2, 0, 0, 0}, "GPS\x00", false},
+		{`{"version": 4, "mode": 4, "stratum": 3, "leap": 1, ` +
+			`"poll": 6, "precision": -20, "referenceId": "PPS"}`,
+			[6]uint32{4, 4, 3, 1, 6, 0xEC}, "PPS\x00", false},
+		{`{"version": 2}`, [6]uint32{}, "", true},
+		{`{"version": 5}`, [6]uint32{}, "", true},
+		{`{"mode": 8}`, [6]uint32{}, "", true},
+		{`{"stratum": 256}`, [6]uint32{}, "", true},
+		{`{"leap": 4}`, [6]uint32{}, "", true},
+		{`{"poll": 3}`, [6]uint32{}, "", true},
+		{`{"precision": 128}`, [6]uint32{}, "", true},
+		{`{"referenceId": ""}`, [6]uint32{}, "", true},
+		{`{"referenceId": "TOOLONG"}`, [6]uint32{}, "", true},
+	}
+
+	// Test all entries in test table.
+	for _, e := range table {
+		var request PackageRequest
+		if err := json.Unmarshal([]byte(e.body), &request); err != nil {
+			t.Fatalf("%s can not decode: %s", e.body, err)
+		}
+		pkg, err := request.Package()
+		if (err != nil) != e.err {
+			t.Errorf("%s invalid error: %v", e.body, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		get := [6]uint32{
+			pkg.GetVersion(), pkg.GetMode(), pkg.GetStratum(),
+			pkg.GetLeap(), pkg.GetPoll(), pkg.GetPrecision(),
+		}
+		if get != e.want {
+			t.Errorf("%s invalid header: want %v get %v",
+				e.body, e.want, get)
+		}
+		if refId := string(pkg.GetReferenceClockId()); refId != e.refId {
+			t.Errorf("%s invalid reference id: want %q get %q",
+				e.body, e.refId, refId)
+		}
+	}
+}
+
+func TestNewTimerPackageBody(t *testing.T) {
+	router, _ := newTimerTestRouter()
+
+	// Create test table; each request maps to a status code.
+	table := []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"/timer/ntp", "", http.StatusCreated},
+		{"/timer/system", `{"stratum": 2}`, http.StatusCreated},
+		{"/timer/modify", `{"version": 9}`, http.StatusBadRequest},
+		{"/timer/rate", `{"rate": 2, "leap": 3}`, http.StatusCreated},
+		{"/timer/drift", `{"rate": 2, "poll": 99}`, http.StatusBadRequest},
+		{"/timer/override", `{"timerId": 0, "stratum": 4}`,
+			http.StatusCreated},
+		{"/timer/override", `{"timerId": 0, "referenceId": "TOOLONG"}`,
+			http.StatusBadRequest},
+	}
+
+	// Test all entries in test table.
+	for _, e := range table {
+		rec := serveTestRequest(router, http.MethodPut, e.path, e.body)
+		if rec.Code != e.status {
+			t.Errorf("%s %q invalid status code: want %d get %d",
+				e.path, e.body, e.status, rec.Code)
+		}
+	}
+}
+
+// TestEnvelopeResponse test the envelope of successful and failed
+// responses of the timer, route and health endpoints.
+func TestEnvelopeResponse(t *testing.T) {
+	timerRouter, _ := newTimerTestRouter()
+	routeRouter, _, _ := newRouteTestRouter()
+	healthRouter := mux.NewRouter()
+	health := NewHealthEndpoint()
+	health.AddChecker("ntp", server.NewNtpServerChecker())
+	health.RegisterRoutes(healthRouter)
+
+	// Create test table; each request maps to a status code and the keys
+	// of the envelope. A failed response has an error or failure data.
+	table := []struct {
+		router  http.Handler
+		path    string
+		status  int
+		success bool
+		data    bool
+		error   string
+	}{
+		{timerRouter, "/timer/0", http.StatusOK, true, true, ""},
+		{timerRouter, "/timer/x", http.StatusBadRequest, false, false,
+			"invalid query id"},
+		{timerRouter, "/timer/99", http.StatusNotFound, false, false,
+			"can not find timer by id"},
+		{routeRouter, "/route/", http.StatusOK, true, true, ""},
+		{routeRouter, "/route/99", http.StatusBadRequest, false, false,
+			NotFoundError.Message},
+		{healthRouter, "/", http.StatusBadRequest, false, true, ""},
+		{healthRouter, "/ping", http.StatusOK, true, true, ""},
+	}
+	for _, e := range table {
+		rec := serveTestRequest(e.router, http.MethodGet, e.path, "")
+		if rec.Code != e.status {
+			t.Errorf("%s invalid status code: want %d get %d",
+				e.path, e.status, rec.Code)
+		}
+		var envelope map[string]json.RawMessage
+		if err := json.NewDecoder(rec.Body).Decode(&envelope); err != nil {
+			t.Fatalf("%s can not decode envelope: %s", e.path, err)
+		}
+		if string(envelope["success"]) != strconv.FormatBool(e.success) {
+			t.Errorf("%s invalid success: %s", e.path, envelope["success"])
+		}
+		if _, ok := envelope["data"]; ok != e.data {
+			t.Errorf("%s invalid data: %s", e.path, envelope["data"])
+		}
+		var message string
+		_ = json.Unmarshal(envelope["error"], &message)
+		if message != e.error {
+			t.Errorf("%s invalid error: want %q get %q",
+				e.path, e.error, message)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/donsprallo/zeitgeist/internal/ntp"
+	"github.com/donsprallo/zeitgeist/internal/server"
+	"github.com/donsprallo/zeitgeist/internal/web/api"
+	"github.com/gorilla/mux"
+)
+
+// Names of the ntp leap indicator values in the api.
+var leapNames = map[uint32]string{
+	ntp.LeapNotSet: "none",
+	ntp.LeapAddSec: "add",
+	ntp.LeapSubSec: "sub",
+	ntp.LeapNotSyn: "unsync",
+}
+
+// Parse a leap indicator by its api name.
+func parseLeap(name string) (uint32, error) {
+	for leap, leapName := range leapNames {
+		if leapName == name {
+			return leap, nil
+		}
+	}
+	return 0, fmt.Errorf(
+		"leap must be one of none, add, sub or unsync; got %q", name)
+}
+
+type LeapResponse struct {
+	Leap string `json:"leap"`
+}
+
+type LeapRequest struct {
+	Leap string `json:"leap"`
+}
+
+// ConfigEndpoint is used to configure the default timer of the ntp server
+// while it is running, like the leap indicator for leap testing.
+type ConfigEndpoint struct {
+	handler http.Handler
+	timer   server.Timer // The default timer
+}
+
+func NewConfigEndpoint(
+	timer server.Timer,
+) api.Endpoint {
+	return &ConfigEndpoint{
+		timer: timer,
+	}
+}
+
+func (e *ConfigEndpoint) RegisterRoutes(router *mux.Router) {
+	e.handler = router
+
+	// Leap indicator management.
+	router.HandleFunc("/leap",
+		e.getLeap).Methods(http.MethodGet)
+	router.HandleFunc("/leap",
+		e.updateLeap).Methods(http.MethodPost)
+}
+
+// Get the leap indicator of the default timer.
+func (e *ConfigEndpoint) getLeap(
+	w http.ResponseWriter, _ *http.Request,
+) {
+	jsonResponse(w, LeapResponse{
+		Leap: leapNames[e.timer.Package().GetLeap()],
+	}, http.StatusOK)
+}
+
+// Set the leap indicator of the default timer. All responses of the
+// default timer carry the leap indicator, so that clients can be tested
+// to handle a leap second without recreating the timer.
+func (e *ConfigEndpoint) updateLeap(
+	w http.ResponseWriter, r *http.Request,
+) {
+	// Decode body data.
+	var request LeapRequest
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	leap, err := parseLeap(request.Leap)
+	if err != nil {
+		jsonResponse(w, ErrorResponse{
+			Message: err.Error(),
+		}, http.StatusBadRequest)
+		return
+	}
+	// Set leap indicator and return the new leap indicator.
+	e.timer.Package().SetLeap(leap)
+	e.getLeap(w, r)
+}
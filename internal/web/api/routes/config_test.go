@@ -0,0 +1,68 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/ntp"
+	"github.com/donsprallo/zeitgeist/internal/server"
+	"github.com/gorilla/mux"
+)
+
+// TestConfigEndpointLeap test to set the leap indicator of the default
+// timer through the api.
+func TestConfigEndpointLeap(t *testing.T) {
+	timer := &server.SystemTimer{}
+	router := mux.NewRouter()
+	NewConfigEndpoint(timer).RegisterRoutes(
+		router.PathPrefix("/config").Subrouter())
+
+	// Create test table; each body maps to a status code.
+	table := []struct {
+		body string
+		code int
+	}{
+		{`{"leap": "add"}`, http.StatusOK},
+		{`{"leap": "insert"}`, http.StatusBadRequest},
+		{`{"leap": 2}`, http.StatusBadRequest},
+		{``, http.StatusBadRequest},
+	}
+	for _, e := range table {
+		rec := serveTestRequest(
+			router, http.MethodPost, "/config/leap", e.body)
+		if rec.Code != e.code {
+			t.Errorf("%s invalid status code: want %d get %d",
+				e.body, e.code, rec.Code)
+		}
+	}
+
+	// The leap indicator of the valid request is kept.
+	rec := serveTestRequest(router, http.MethodGet, "/config/leap", "")
+	var response LeapResponse
+	err := decodeTestData(rec, &response)
+	if err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if response.Leap != "add" {
+		t.Errorf("invalid leap: want add get %s", response.Leap)
+	}
+
+	// The served response must carry the leap indicator.
+	var request ntp.Package
+	request.SetMode(ntp.ModeClient)
+	request.SetTransmitTimestamp(time.Now())
+	served, err := server.PackageFromTimer(
+		&request, timer.Package(), timer)
+	if err != nil {
+		t.Fatalf("can not serve package: %s", err)
+	}
+	if served.GetLeap() != ntp.LeapAddSec {
+		t.Errorf("invalid served leap: want %d get %d",
+			ntp.LeapAddSec, served.GetLeap())
+	}
+}
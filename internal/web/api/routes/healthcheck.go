@@ -5,7 +5,6 @@
 package routes
 
 import (
-	"github.com/donsprallo/zeitgeist/internal/web/api"
 	"github.com/gorilla/mux"
 	"net/http"
 )
@@ -30,13 +29,16 @@ type Healthy interface {
 type HealthEndpoint struct {
 	handler  http.Handler       // The http handler
 	checkers map[string]Healthy // A map of health checkers
+	identity string             // The identity of the instance
+	done     chan struct{}      // Stops the background checks on Close
 }
 
 // NewHealthEndpoint creates a new api.Endpoint for healthcheck
 // capabilities. The endpoint must be registered with a http.server.
-func NewHealthEndpoint() api.Endpoint {
+func NewHealthEndpoint() *HealthEndpoint {
 	return &HealthEndpoint{
 		checkers: make(map[string]Healthy),
+		done:     make(chan struct{}),
 	}
 }
 
@@ -59,6 +61,39 @@ func (e *HealthEndpoint) AddChecker(
 	e.checkers[name] = checker
 }
 
+// AddCheckers creates built-in Healthy checkers from configs and adds them
+// to the HealthEndpoint. On an invalid config, no checker is added. The ntp
+// checkers check in background until Close.
+func (e *HealthEndpoint) AddCheckers(configs []CheckerConfig) error {
+	checkers := make(map[string]Healthy, len(configs))
+	for _, cfg := range configs {
+		checker, err := NewChecker(cfg)
+		if err != nil {
+			return err
+		}
+		checkers[cfg.Name] = checker
+	}
+	for name, checker := range checkers {
+		if c, ok := checker.(*NtpChecker); ok {
+			go c.CheckLoop(e.done)
+		}
+		e.AddChecker(name, checker)
+	}
+	return nil
+}
+
+// Close stops the background checks of the checkers added by AddCheckers.
+func (e *HealthEndpoint) Close() {
+	close(e.done)
+}
+
+// SetIdentity sets the identity of the instance, that is reported in the
+// health responses. The identity distinguishes instances behind a load
+// balancer, like the hostname.
+func (e *HealthEndpoint) SetIdentity(identity string) {
+	e.identity = identity
+}
+
 // RemoveChecker deletes a Healthy checkers from the HealthEndpoint.
 func (e *HealthEndpoint) RemoveChecker(name string) {
 	delete(e.checkers, name)
@@ -66,17 +101,19 @@ func (e *HealthEndpoint) RemoveChecker(name string) {
 
 // HealthcheckResponse is the response type for the HealthEndpoint
 // healthcheck route. The response contains a boolean to display the API
-// status and a map of errors.
+// status, a map of errors and the identity of the instance.
 type HealthcheckResponse struct {
-	Status bool              `json:"status"`
-	Errors map[string]string `json:"errors"`
+	Status   bool              `json:"status"`
+	Errors   map[string]string `json:"errors"`
+	Identity string            `json:"identity,omitempty"`
 }
 
 // PingResponse is the response type for the HealthEndpoint ping
-// route. The response contains only a string to display that the API
-// is available.
+// route. The response contains a string to display that the API is
+// available and the identity of the instance.
 type PingResponse struct {
-	Status string `json:"status"`
+	Status   string `json:"status"`
+	Identity string `json:"identity,omitempty"`
 }
 
 // The healthcheck route of the HealthEndpoint verifies multiple items
@@ -101,9 +138,10 @@ func (e *HealthEndpoint) healthcheck(
 	}
 	// Disable cache to prevent http caching from serving the request.
 	w.Header().Add("Cache-Control", "no-cache")
-	api.MustJsonResponse(w, HealthcheckResponse{
-		Status: !hasErrors,
-		Errors: apiErrors,
+	jsonResponse(w, HealthcheckResponse{
+		Status:   !hasErrors,
+		Errors:   apiErrors,
+		Identity: e.identity,
 	}, statusCode)
 }
 
@@ -116,7 +154,8 @@ func (e *HealthEndpoint) ping(
 ) {
 	// Disable cache to prevent http caching from serving the request.
 	w.Header().Add("Cache-Control", "no-cache")
-	api.MustJsonResponse(w, PingResponse{
-		Status: "running",
+	jsonResponse(w, PingResponse{
+		Status:   "running",
+		Identity: e.identity,
 	}, http.StatusOK)
 }
@@ -0,0 +1,259 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/ntp"
+	"github.com/donsprallo/zeitgeist/internal/server"
+	"github.com/gorilla/mux"
+)
+
+// TestHealthEndpointCheckers test to build checkers from a config snippet
+// and to use them in the healthcheck route.
+func TestHealthEndpointCheckers(t *testing.T) {
+	dir := t.TempDir()
+	fresh := filepath.Join(dir, "fresh")
+	stale := filepath.Join(dir, "stale")
+	for _, name := range []string{fresh, stale} {
+		err := os.WriteFile(name, []byte("data"), 0o600)
+		if err != nil {
+			t.Fatalf("can not write file: %s", err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatalf("can not change file time: %s", err)
+	}
+
+	// Build checkers from config snippet.
+	snippet := fmt.Sprintf(`[
+		{"name": "fresh", "type": "file", "target": %q, "maxAge": "1h"},
+		{"name": "stale", "type": "file", "target": %q, "maxAge": "1h"}
+	]`, fresh, stale)
+	configs, err := ParseCheckerConfigs([]byte(snippet))
+	if err != nil {
+		t.Fatalf("can not parse checkers: %s", err)
+	}
+	endpoint := NewHealthEndpoint()
+	err = endpoint.AddCheckers(configs)
+	if err != nil {
+		t.Fatalf("can not add checkers: %s", err)
+	}
+	if len(endpoint.checkers) != 2 {
+		t.Fatalf("invalid number of checkers: %d", len(endpoint.checkers))
+	}
+
+	// Only the stale checker must report an error.
+	router := mux.NewRouter()
+	endpoint.RegisterRoutes(router)
+	rec := serveTestRequest(router, http.MethodGet, "/", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid status code: want %d get %d",
+			http.StatusBadRequest, rec.Code)
+	}
+	var response HealthcheckResponse
+	err = decodeTestData(rec, &response)
+	if err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if _, ok := response.Errors["stale"]; !ok {
+		t.Errorf("stale checker reports no error")
+	}
+	if _, ok := response.Errors["fresh"]; ok {
+		t.Errorf("fresh checker reports an error")
+	}
+}
+
+// TestNewCheckerInvalid test that invalid configs are rejected.
+func TestNewCheckerInvalid(t *testing.T) {
+	configs := []CheckerConfig{
+		{Name: "type", Type: "unknown", Target: "x"},
+		{Name: "target", Type: CheckerTypeNtp, Target: "localhost"},
+		{Name: "port", Type: CheckerTypeNtp, Target: "localhost:ntp"},
+		{Name: "age", Type: CheckerTypeFile, Target: "x", MaxAge: "old"},
+		{Name: "interval", Type: CheckerTypeNtp,
+			Target: "localhost:123", Interval: "-1s"},
+	}
+	for _, cfg := range configs {
+		if _, err := NewChecker(cfg); err == nil {
+			t.Errorf("checker %s with invalid config created", cfg.Name)
+		}
+	}
+
+	// A valid ntp checker must be created.
+	checker, err := NewChecker(CheckerConfig{
+		Name: "ntp", Type: CheckerTypeNtp, Target: "localhost:123"})
+	if err != nil {
+		t.Fatalf("can not create ntp checker: %s", err)
+	}
+	c := checker.(*NtpChecker)
+	if c.Host != "localhost" || c.Port != 123 ||
+		c.Interval != DefaultCheckInterval {
+		t.Errorf("invalid ntp checker: %s:%d %s",
+			c.Host, c.Port, c.Interval)
+	}
+}
+
+// TestHealthEndpointIdentity test that the configured identity appears in
+// the health responses.
+func TestHealthEndpointIdentity(t *testing.T) {
+	endpoint := NewHealthEndpoint()
+	endpoint.SetIdentity("zeitgeist-1")
+	router := mux.NewRouter()
+	endpoint.RegisterRoutes(router)
+
+	// The healthcheck route reports the identity.
+	rec := serveTestRequest(router, http.MethodGet, "/", "")
+	var health HealthcheckResponse
+	err := decodeTestData(rec, &health)
+	if err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if health.Identity != "zeitgeist-1" {
+		t.Errorf("invalid healthcheck identity: %q", health.Identity)
+	}
+
+	// The ping route reports the identity.
+	rec = serveTestRequest(router, http.MethodGet, "/ping", "")
+	var ping PingResponse
+	err = decodeTestData(rec, &ping)
+	if err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if ping.Identity != "zeitgeist-1" {
+		t.Errorf("invalid ping identity: %q", ping.Identity)
+	}
+}
+
+// TestHealthEndpointNtpServerChecker test that a stopped ntp server fails
+// the healthcheck with the error of the checker.
+func TestHealthEndpointNtpServerChecker(t *testing.T) {
+	checker := server.NewNtpServerChecker()
+	checker.SetServing()
+	endpoint := NewHealthEndpoint()
+	endpoint.AddChecker("ntp", checker)
+	router := mux.NewRouter()
+	endpoint.RegisterRoutes(router)
+
+	// Create test table; the checker is flipped before each request.
+	steps := []struct {
+		stop   error
+		status int
+	}{
+		{nil, http.StatusOK},
+		{server.ErrUnexpectedStop, http.StatusBadRequest},
+	}
+	for _, e := range steps {
+		if e.stop != nil {
+			checker.SetStopped(e.stop)
+		}
+		rec := serveTestRequest(router, http.MethodGet, "/", "")
+		if rec.Code != e.status {
+			t.Errorf("invalid status code: want %d get %d",
+				e.status, rec.Code)
+		}
+		var response HealthcheckResponse
+		err := decodeTestData(rec, &response)
+		if err != nil {
+			t.Fatalf("can not decode response: %s", err)
+		}
+		want := ""
+		if e.stop != nil {
+			want = e.stop.Error()
+		}
+		if response.Errors["ntp"] != want {
+			t.Errorf("invalid error: want %q get %q",
+				want, response.Errors["ntp"])
+		}
+	}
+}
+
+// TestNtpChecker test that the checker checks the ntp server in background
+// and reports a ntp server, that stops answering, as unhealthy.
+func TestNtpChecker(t *testing.T) {
+	// Create a stub ntp server, that answers until it is down.
+	conn, err := net.ListenUDP(
+		"udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("can not listen udp: %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	var down atomic.Bool
+	go func() {
+		for {
+			data := make([]byte, ntp.PackageSize)
+			_, addr, err := conn.ReadFromUDP(data)
+			if err != nil {
+				return
+			}
+			if down.Load() {
+				continue
+			}
+			req, _ := ntp.PackageFromBytes(data)
+			var pkg ntp.Package
+			pkg.SetMode(ntp.ModeServer)
+			pkg.SetStratum(1)
+			pkg.SetOriginateTimestamp(req.GetTransmitTimestamp())
+			pkg.SetReceiveTimestamp(time.Now())
+			pkg.SetTransmitTimestamp(time.Now())
+			res, _ := pkg.ToBytes()
+			_, _ = conn.WriteToUDP(res, addr)
+		}
+	}()
+
+	// Check the ntp server in background.
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	checker := NewNtpChecker(
+		addr.IP.String(), addr.Port, 10*time.Millisecond)
+	checker.options.Timeout = 50 * time.Millisecond
+	if checker.IsHealthy() {
+		t.Errorf("checker healthy before first check")
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		checker.CheckLoop(done)
+	}()
+	t.Cleanup(func() {
+		close(done)
+		<-stopped
+	})
+
+	// Wait until the checker reports the state of the ntp server.
+	waitHealthy := func(healthy bool) {
+		for deadline := time.Now().Add(time.Second); ; {
+			if checker.IsHealthy() == healthy {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("checker not healthy %t: %q",
+					healthy, checker.Error())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitHealthy(true)
+	if checker.Checked().IsZero() {
+		t.Errorf("check time not recorded")
+	}
+
+	// The ntp server goes down.
+	down.Store(true)
+	waitHealthy(false)
+	if !strings.Contains(checker.Error(), "unreachable") {
+		t.Errorf("invalid checker error: %q", checker.Error())
+	}
+}
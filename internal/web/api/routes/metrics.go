@@ -0,0 +1,36 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"github.com/donsprallo/zeitgeist/internal/metrics"
+	"github.com/donsprallo/zeitgeist/internal/web/api"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+)
+
+// MetricsEndpoint is used to scrape the metrics of the ntp server in the
+// Prometheus exposition format.
+type MetricsEndpoint struct {
+	handler  http.Handler
+	registry *prometheus.Registry // The registry of the scraped metrics
+}
+
+func NewMetricsEndpoint(
+	registry *prometheus.Registry,
+) api.Endpoint {
+	return &MetricsEndpoint{
+		registry: registry,
+	}
+}
+
+func (e *MetricsEndpoint) RegisterRoutes(router *mux.Router) {
+	e.handler = router
+
+	// Metrics scraping.
+	router.Handle("/",
+		metrics.Handler(e.registry)).Methods(http.MethodGet)
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "test_total",
+		Help: "Test counter.",
+	}, []string{"kind"})
+	counter.WithLabelValues("a").Inc()
+	registry.MustRegister(counter)
+
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+	endpoint := NewMetricsEndpoint(registry)
+	endpoint.RegisterRoutes(router.PathPrefix("/metrics").Subrouter())
+
+	// Scrape the metrics.
+	rec := serveTestRequest(router, http.MethodGet, "/metrics/", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("invalid status code: %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(
+		ct, "text/plain") {
+		t.Errorf("invalid content type: %s", ct)
+	}
+	if !strings.Contains(rec.Body.String(), `test_total{kind="a"} 1`) {
+		t.Errorf("counter not scraped: %s", rec.Body.String())
+	}
+}
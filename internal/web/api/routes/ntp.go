@@ -0,0 +1,83 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/server"
+	"github.com/donsprallo/zeitgeist/internal/web/api"
+	"github.com/gorilla/mux"
+)
+
+// LastResponse describe the last response served to a client. The
+// timestamps are formatted as RFC 3339 with nanoseconds.
+type LastResponse struct {
+	Client             string           `json:"client"`
+	Time               string           `json:"time"`
+	Timer              string           `json:"timer"`
+	Package            *PackageResponse `json:"package"`
+	ReferenceTimestamp string           `json:"referenceTimestamp"`
+	OriginateTimestamp string           `json:"originateTimestamp"`
+	ReceiveTimestamp   string           `json:"receiveTimestamp"`
+	TransmitTimestamp  string           `json:"transmitTimestamp"`
+}
+
+// NtpEndpoint is used to inspect the responses of the ntp server for
+// support debugging.
+type NtpEndpoint struct {
+	handler   http.Handler
+	responses *server.ResponseLog // The last responses of the clients
+}
+
+func NewNtpEndpoint(
+	responses *server.ResponseLog,
+) api.Endpoint {
+	return &NtpEndpoint{
+		responses: responses,
+	}
+}
+
+func (e *NtpEndpoint) RegisterRoutes(router *mux.Router) {
+	e.handler = router
+
+	// Last response of a client.
+	router.HandleFunc("/last",
+		e.getLast).Methods(http.MethodGet)
+}
+
+// Get the last response served to the client with the ip query parameter.
+func (e *NtpEndpoint) getLast(
+	w http.ResponseWriter, r *http.Request,
+) {
+	// Parse query parameters.
+	ip := net.ParseIP(r.URL.Query().Get("ip"))
+	if ip == nil {
+		jsonResponse(w, ErrorResponse{
+			Message: "invalid query ip",
+		}, http.StatusBadRequest)
+		return
+	}
+	// Find the last response of the client.
+	record, ok := e.responses.Last(ip)
+	if !ok {
+		jsonResponse(
+			w, NotFoundError, http.StatusNotFound)
+		return
+	}
+	pkg := &record.Package
+	jsonResponse(w, LastResponse{
+		Client:             record.Client,
+		Time:               record.Time.Format(time.RFC3339Nano),
+		Timer:              record.Timer,
+		Package:            newPackageResponse(pkg),
+		ReferenceTimestamp: pkg.GetReferenceTimestamp().Format(time.RFC3339Nano),
+		OriginateTimestamp: pkg.GetOriginateTimestamp().Format(time.RFC3339Nano),
+		ReceiveTimestamp:   pkg.GetReceiveTimestamp().Format(time.RFC3339Nano),
+		TransmitTimestamp:  pkg.GetTransmitTimestamp().Format(time.RFC3339Nano),
+	}, http.StatusOK)
+}
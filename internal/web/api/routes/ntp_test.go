@@ -0,0 +1,65 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/ntp"
+	"github.com/donsprallo/zeitgeist/internal/server"
+	"github.com/gorilla/mux"
+)
+
+// TestNtpEndpointLast test to fetch the last response served to a client
+// through the api.
+func TestNtpEndpointLast(t *testing.T) {
+	responses := server.NewResponseLog(time.Minute, 10)
+	router := mux.NewRouter()
+	NewNtpEndpoint(responses).RegisterRoutes(
+		router.PathPrefix("/ntp").Subrouter())
+
+	// Record a response of a client.
+	transmit := time.Now().Truncate(time.Second).UTC()
+	var pkg ntp.Package
+	pkg.SetMode(ntp.ModeServer)
+	pkg.SetStratum(2)
+	pkg.SetTransmitTimestamp(transmit)
+	responses.Record(net.ParseIP("10.0.0.1"), &server.SystemTimer{}, &pkg)
+
+	// Create test table; each path maps to a status code.
+	table := []struct {
+		path   string
+		status int
+	}{
+		{"/ntp/last?ip=10.0.0.1", http.StatusOK},
+		{"/ntp/last?ip=10.0.0.2", http.StatusNotFound},
+		{"/ntp/last?ip=invalid", http.StatusBadRequest},
+		{"/ntp/last", http.StatusBadRequest},
+	}
+	for _, e := range table {
+		rec := serveTestRequest(router, http.MethodGet, e.path, "")
+		if rec.Code != e.status {
+			t.Errorf("%s invalid status code: want %d get %d",
+				e.path, e.status, rec.Code)
+		}
+	}
+
+	// The decoded fields of the recorded response are returned.
+	rec := serveTestRequest(
+		router, http.MethodGet, "/ntp/last?ip=10.0.0.1", "")
+	var response LastResponse
+	err := decodeTestData(rec, &response)
+	if err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if response.Client != "10.0.0.1" || response.Timer != "SystemTimer" ||
+		response.Package.Stratum != 2 ||
+		response.TransmitTimestamp != transmit.Format(time.RFC3339Nano) {
+		t.Errorf("invalid last response: %+v", response)
+	}
+}
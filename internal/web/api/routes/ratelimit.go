@@ -0,0 +1,65 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"github.com/donsprallo/zeitgeist/internal/server"
+	"github.com/donsprallo/zeitgeist/internal/web/api"
+	"github.com/gorilla/mux"
+	"net"
+	"net/http"
+)
+
+// RateLimitEndpoint is used to manage the request budgets of the ntp
+// clients, like to clear the penalty of a client after fixing a
+// misbehaving device.
+type RateLimitEndpoint struct {
+	handler http.Handler
+	limiter *server.RateLimiter // The limiter of the ntp server; can be nil
+}
+
+// NewRateLimitEndpoint creates a new api.Endpoint to manage the limiter of
+// the ntp server. Without limiter, no client has a budget state.
+func NewRateLimitEndpoint(
+	limiter *server.RateLimiter,
+) api.Endpoint {
+	return &RateLimitEndpoint{
+		limiter: limiter,
+	}
+}
+
+// RegisterRoutes implements api.Endpoint interface.
+func (e *RateLimitEndpoint) RegisterRoutes(router *mux.Router) {
+	e.handler = router
+
+	// Client budget management.
+	router.HandleFunc("/{ip}",
+		e.deleteClient).Methods(http.MethodDelete)
+}
+
+// Clear the budget state of a client, so that the client can query again
+// immediately.
+func (e *RateLimitEndpoint) deleteClient(
+	w http.ResponseWriter, r *http.Request,
+) {
+	// Parse query parameters.
+	vars := mux.Vars(r)
+	ip := net.ParseIP(vars["ip"])
+	if ip == nil {
+		jsonResponse(w, ErrorResponse{
+			Message: "invalid query ip",
+		}, http.StatusBadRequest)
+		return
+	}
+	if e.limiter == nil || !e.limiter.Reset(ip) {
+		jsonResponse(w, ErrorResponse{
+			Message: "client has no rate limit state",
+		}, http.StatusNotFound)
+		return
+	}
+	jsonResponse(w, MessageResponse{
+		Message: "rate limit state deleted successful",
+	}, http.StatusOK)
+}
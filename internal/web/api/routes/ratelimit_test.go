@@ -0,0 +1,56 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/server"
+	"github.com/gorilla/mux"
+)
+
+// TestRateLimitEndpointDelete test that a limited client can query again
+// immediately after its state is deleted.
+func TestRateLimitEndpointDelete(t *testing.T) {
+	limiter := server.NewRateLimiter(time.Hour, 1)
+	router := mux.NewRouter()
+	NewRateLimitEndpoint(limiter).RegisterRoutes(
+		router.PathPrefix("/ratelimit").Subrouter())
+
+	// Limit the client.
+	client := net.ParseIP("192.0.2.1")
+	limiter.Allow(client)
+	if limiter.Allow(client) {
+		t.Fatalf("request exceeding burst allowed")
+	}
+
+	// Create test table; each request maps to a status code.
+	table := []struct {
+		path   string
+		status int
+	}{
+		{"/ratelimit/not-an-ip", http.StatusBadRequest},
+		{"/ratelimit/192.0.2.2", http.StatusNotFound},
+		{"/ratelimit/192.0.2.1", http.StatusOK},
+		{"/ratelimit/192.0.2.1", http.StatusNotFound},
+	}
+
+	// Test all entries in test table.
+	for _, e := range table {
+		rec := serveTestRequest(router, http.MethodDelete, e.path, "")
+		if rec.Code != e.status {
+			t.Errorf("%s invalid status code: want %d get %d",
+				e.path, e.status, rec.Code)
+		}
+	}
+
+	// The cleared client can query again immediately.
+	if !limiter.Allow(client) {
+		t.Errorf("request of cleared client not allowed")
+	}
+}
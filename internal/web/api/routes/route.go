@@ -5,10 +5,11 @@
 package routes
 
 import (
-	"encoding/json"
+	"errors"
 	"github.com/donsprallo/zeitgeist/internal/server"
 	"github.com/donsprallo/zeitgeist/internal/web/api"
 	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
 	"net"
 	"net/http"
 	"strconv"
@@ -21,11 +22,55 @@ type RouteResponse struct {
 	Timer  TimerResponse `json:"timer"`
 }
 
+// NewRouteResponse is the response of a created route. The Warning tells
+// about a route, that overlaps existing routes.
+type NewRouteResponse struct {
+	Message string `json:"message"`
+	Warning string `json:"warning,omitempty"`
+}
+
 type RouteAllResponse struct {
 	Length int             `json:"length"`
 	Routes []RouteResponse `json:"routes"`
 }
 
+// Build a RouteResponse from a server.RoutingTableEntry. The served
+// statistics of the timer are taken from timers.
+func newRouteResponse(
+	timers *server.TimerCollection,
+	entry server.RoutingTableEntry,
+) RouteResponse {
+	response := RouteResponse{
+		Id:     entry.Id,
+		Subnet: entry.IPNet.String(),
+		Timer: TimerResponse{
+			Id:    entry.TimerId,
+			Type:  server.TimerName(entry.Timer),
+			Value: entry.Timer.Get().Format(time.RFC3339),
+		},
+	}
+	if timer, ok := timers.Get(entry.TimerId); ok {
+		response.Timer.LastServed = formatLastServed(timer.LastServed)
+		response.Timer.Requests = timer.Requests
+	}
+	return response
+}
+
+// Build a RouteAllResponse from server.RoutingTableEntry objects.
+func newRouteAllResponse(
+	timers *server.TimerCollection,
+	entries []server.RoutingTableEntry,
+) RouteAllResponse {
+	response := RouteAllResponse{
+		Length: len(entries),
+		Routes: make([]RouteResponse, len(entries)),
+	}
+	for idx, entry := range entries {
+		response.Routes[idx] = newRouteResponse(timers, entry)
+	}
+	return response
+}
+
 type RouteEndpoint struct {
 	handler http.Handler
 	timers  *server.TimerCollection // The registered timers
@@ -92,23 +137,12 @@ func (e *RouteEndpoint) getDefaultRoute(
 		if isDefaultRoute(entry.IPNet) {
 			response.Length++
 			response.Routes = append(
-				// Build RouteResponse from entry and append to
-				// response.
-				response.Routes, RouteResponse{
-					Id:     entry.Id,
-					Subnet: entry.IPNet.String(),
-					Timer: TimerResponse{
-						Id:    entry.TimerId,
-						Type:  server.TimerName(entry.Timer),
-						Value: entry.Timer.Get().Format(time.RFC3339),
-					},
-				},
-			)
+				response.Routes, newRouteResponse(e.timers, entry))
 		}
 	}
 
 	// Return as JSON response.
-	api.MustJsonResponse(
+	jsonResponse(
 		w, response, http.StatusOK)
 }
 
@@ -119,35 +153,31 @@ func (e *RouteEndpoint) updateDefaultRoute(
 ) {
 	// Decode body data.
 	var request UpdateRouteRequest
-	err := json.NewDecoder(r.Body).Decode(&request)
-	if err != nil {
-		api.MustJsonResponse(
-			w, BodyDecodeError, http.StatusBadRequest)
+	if !decodeBody(w, r, &request) {
 		return
 	}
 
-	// Find timer by id.
-	timer := e.timers.Get(request.TimerId)
-	if timer.Timer == nil {
-		api.MustJsonResponse(
+	// Find all default routes and update their timer. The update is
+	// applied to all default routes or none. The timer can not be deleted,
+	// until the routes are updated.
+	err := e.timers.WithTimer(request.TimerId,
+		func(timer server.TimerCollectionEntry) error {
+			ids := make([]int, 0, 3)
+			for _, entry := range e.routes.All() {
+				if isDefaultRoute(entry.IPNet) {
+					ids = append(ids, entry.Id)
+				}
+			}
+			return e.routes.SetAll(ids, timer.Timer, timer.Id)
+		})
+	if err != nil {
+		jsonResponse(
 			w, NotFoundError, http.StatusBadRequest)
 		return
 	}
 
-	// Find route by id and update its timer.
-	for _, entry := range e.routes.All() {
-		if isDefaultRoute(entry.IPNet) {
-			err := e.routes.Set(entry.Id, timer.Timer, timer.Id)
-			if err != nil {
-				api.MustJsonResponse(
-					w, NotFoundError, http.StatusBadRequest)
-				return
-			}
-		}
-	}
-
 	// Send success response.
-	api.MustJsonResponse(w, MessageResponse{
+	jsonResponse(w, MessageResponse{
 		Message: "default route update success",
 	}, http.StatusOK)
 }
@@ -156,31 +186,12 @@ func (e *RouteEndpoint) updateDefaultRoute(
 func (e *RouteEndpoint) getAllRoutes(
 	w http.ResponseWriter, _ *http.Request,
 ) {
-	routes := e.routes.All()
-	lenRoutes := len(routes)
-	// Build response from routing table entries. We know the size
-	// of routing entries here. So we can allocate the size.
-	response := RouteAllResponse{
-		Length: lenRoutes,
-		Routes: make([]RouteResponse, lenRoutes),
-	}
-	// Iterate through routing entries and add each entry to response.
-	// As subnet, we return the CIDR string representation of the ip net.
-	// For timer mode, an extra function is converting the timer to its
-	// string representation.
-	for idx, entry := range routes {
-		response.Routes[idx] = RouteResponse{
-			Id:     entry.Id,
-			Subnet: entry.IPNet.String(),
-			Timer: TimerResponse{
-				Id:    entry.TimerId,
-				Type:  server.TimerName(entry.Timer),
-				Value: entry.Timer.Get().Format(time.RFC3339),
-			},
-		}
-	}
+	// Build response from routing table entries. As subnet, we return
+	// the CIDR string representation of the ip net. For timer mode, an
+	// extra function is converting the timer to its string representation.
+	response := newRouteAllResponse(e.timers, e.routes.All())
 	// Return as JSON response.
-	api.MustJsonResponse(
+	jsonResponse(
 		w, response, http.StatusOK)
 }
 
@@ -195,47 +206,58 @@ func (e *RouteEndpoint) newRoute(
 ) {
 	// Parse body data.
 	var routeRequest NewRouteRequest
-	err := json.NewDecoder(r.Body).Decode(&routeRequest)
-	if err != nil {
-		api.MustJsonResponse(
-			w, BodyDecodeError, http.StatusBadRequest)
-		return
-	}
-
-	// Find timer by id.
-	timer := e.timers.Get(routeRequest.TimerId)
-	if timer.Timer == nil {
-		api.MustJsonResponse(w, ErrorResponse{
-			Message: "can not find timer",
-		}, http.StatusBadRequest)
+	if !decodeBody(w, r, &routeRequest) {
 		return
 	}
 
 	// Parse subnet to net.IPNet.
 	_, ipNet, err := net.ParseCIDR(routeRequest.Subnet)
 	if err != nil {
-		api.MustJsonResponse(w, ErrorResponse{
+		jsonResponse(w, ErrorResponse{
 			Message: "can not parse subnet",
 		}, http.StatusBadRequest)
 		return
 	}
 
-	// Add net.IPNet to routing and map to timer instance.
-	err = e.routes.Add(*ipNet, timer.Timer, timer.Id)
+	// Add net.IPNet to routing and map to timer instance. The timer can
+	// not be deleted, until the route is added.
+	err = e.timers.WithTimer(routeRequest.TimerId,
+		func(timer server.TimerCollectionEntry) error {
+			return e.routes.Add(*ipNet, timer.Timer, timer.Id)
+		})
+	if errors.Is(err, server.ErrTimerNotFound) {
+		jsonResponse(w, ErrorResponse{
+			Message: "can not find timer",
+		}, http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, server.ErrRoutingTableFull) {
+		jsonResponse(w, ErrorResponse{
+			Message: "maximum number of routes reached",
+		}, http.StatusInsufficientStorage)
+		return
+	}
 	if err != nil {
-		api.MustJsonResponse(w, ErrorResponse{
+		jsonResponse(w, ErrorResponse{
 			Message: "route with subnet exist",
 		}, http.StatusConflict)
 		return
 	}
 
+	// An overlapping route is allowed, because the longest prefix wins,
+	// but may be unintended.
+	response := NewRouteResponse{Message: "create new route success"}
+	if e.routes.Overlaps(*ipNet) {
+		response.Warning = "route overlaps existing routes"
+		log.WithField("subnet", ipNet.String()).Warn(response.Warning)
+	}
+
 	// Build success response.
-	api.MustJsonResponse(w, MessageResponse{
-		Message: "create new route success",
-	}, http.StatusCreated)
+	jsonResponse(w, response, http.StatusCreated)
 }
 
-// Delete an existing route.
+// Delete an existing route. The ids of the other routes are kept, so that
+// clients can still address them. Default routes can not be deleted.
 func (e *RouteEndpoint) deleteRoute(
 	w http.ResponseWriter, r *http.Request,
 ) {
@@ -243,7 +265,7 @@ func (e *RouteEndpoint) deleteRoute(
 	var vars = mux.Vars(r)
 	routeId, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		api.MustJsonResponse(
+		jsonResponse(
 			w, QueryParameterError, http.StatusBadRequest)
 		return
 	}
@@ -251,32 +273,32 @@ func (e *RouteEndpoint) deleteRoute(
 	// Find route by id.
 	route := e.routes.Get(routeId)
 	if route == nil {
-		api.MustJsonResponse(
+		jsonResponse(
 			w, NotFoundError, http.StatusBadRequest)
 		return
 	}
 
 	// Protect default route from deletion.
 	if isDefaultRoute(route.IPNet) {
-		api.MustJsonResponse(
+		jsonResponse(
 			w, ErrorResponse{
 				Message: "can not delete default route",
 			}, http.StatusForbidden)
 		return
 	}
 
-	// Delete route from routing.
+	// Delete route from routing; the other routes keep their ids.
 	err = e.routes.Remove(routeId)
 	if err != nil {
-		api.MustJsonResponse(
+		jsonResponse(
 			w, NotFoundError, http.StatusBadRequest)
 		return
 	}
 
 	// Deletion success response.
-	api.MustJsonResponse(w, MessageResponse{
+	jsonResponse(w, MessageResponse{
 		Message: "deletion route success",
-	}, http.StatusCreated)
+	}, http.StatusOK)
 }
 
 // Get a specific route.
@@ -287,7 +309,7 @@ func (e *RouteEndpoint) getRoute(
 	var vars = mux.Vars(r)
 	routeId, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		api.MustJsonResponse(
+		jsonResponse(
 			w, QueryParameterError, http.StatusBadRequest)
 		return
 	}
@@ -295,21 +317,14 @@ func (e *RouteEndpoint) getRoute(
 	// Find route by id and update its timer.
 	route := e.routes.Get(routeId)
 	if route == nil {
-		api.MustJsonResponse(
+		jsonResponse(
 			w, NotFoundError, http.StatusBadRequest)
 		return
 	}
 
 	// Send success response.
-	api.MustJsonResponse(w, RouteResponse{
-		Id:     route.Id,
-		Subnet: route.IPNet.String(),
-		Timer: TimerResponse{
-			Id:    route.TimerId,
-			Type:  server.TimerName(route.Timer),
-			Value: route.Timer.Get().Format(time.RFC3339),
-		},
-	}, http.StatusOK)
+	jsonResponse(
+		w, newRouteResponse(e.timers, *route), http.StatusOK)
 }
 
 type UpdateRouteRequest struct {
@@ -324,39 +339,31 @@ func (e *RouteEndpoint) updateRoute(
 	var vars = mux.Vars(r)
 	routeId, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		api.MustJsonResponse(
+		jsonResponse(
 			w, QueryParameterError, http.StatusBadRequest)
 		return
 	}
 
 	// Decode body data.
 	var request UpdateRouteRequest
-	err = json.NewDecoder(r.Body).Decode(&request)
-	if err != nil {
-		api.MustJsonResponse(
-			w, BodyDecodeError, http.StatusBadRequest)
-		return
-	}
-
-	// Find timer by id.
-	timer := e.timers.Get(request.TimerId)
-	if timer.Timer == nil {
-		api.MustJsonResponse(
-			w, NotFoundError, http.StatusBadRequest)
+	if !decodeBody(w, r, &request) {
 		return
 	}
 
-	// Find route by id and update its timer.
-	err = e.routes.Set(
-		routeId, timer.Timer, timer.Id)
+	// Find route by id and update its timer. The timer can not be
+	// deleted, until the route is updated.
+	err = e.timers.WithTimer(request.TimerId,
+		func(timer server.TimerCollectionEntry) error {
+			return e.routes.Set(routeId, timer.Timer, timer.Id)
+		})
 	if err != nil {
-		api.MustJsonResponse(
+		jsonResponse(
 			w, NotFoundError, http.StatusBadRequest)
 		return
 	}
 
 	// Send success response.
-	api.MustJsonResponse(w, MessageResponse{
+	jsonResponse(w, MessageResponse{
 		Message: "route updated successful",
 	}, http.StatusOK)
 }
@@ -0,0 +1,270 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/donsprallo/zeitgeist/internal/server"
+	"github.com/gorilla/mux"
+)
+
+// Create a router with a RouteEndpoint and the default routes.
+func newRouteTestRouter() (
+	*mux.Router, *server.TimerCollection, *server.RoutingTable,
+) {
+	timers := server.NewTimerCollection(10)
+	defaultTimer := &server.SystemTimer{}
+	timerId := timers.Add(defaultTimer)
+	table := server.NewRoutingTable(10)
+	server.NewStaticRouting(table, defaultTimer, timerId)
+
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+	endpoint := NewRouteEndpoint(timers, table)
+	endpoint.RegisterRoutes(router.PathPrefix("/route").Subrouter())
+	return router, timers, table
+}
+
+// TestRouteEndpointReload test that the api reflects routes that are
+// replaced in place without recreating the endpoint.
+func TestRouteEndpointReload(t *testing.T) {
+	router, timers, table := newRouteTestRouter()
+
+	// Reload routing table with an additional route.
+	timer := &server.ModifyTimer{}
+	timerId := timers.Add(timer)
+	_, ipNet, _ := net.ParseCIDR("10.0.0.0/8")
+	entries := table.All()
+	entries = append(entries, server.RoutingTableEntry{
+		Id: 10, IPNet: *ipNet, Timer: timer, TimerId: timerId,
+	})
+	table.Replace(entries)
+
+	// Request all routes from api.
+	req := httptest.NewRequest(http.MethodGet, "/route/", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("invalid status code: %d", rec.Code)
+	}
+
+	var response RouteAllResponse
+	err := decodeTestData(rec, &response)
+	if err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if response.Length != len(entries) {
+		t.Errorf("invalid routes length: want %d get %d",
+			len(entries), response.Length)
+	}
+	last := response.Routes[len(response.Routes)-1]
+	if last.Id != 10 || last.Subnet != "10.0.0.0/8" ||
+		last.Timer.Id != timerId {
+		t.Errorf("reloaded route not in response: %+v", last)
+	}
+}
+
+// TestRouteEndpointMaxSize test that a full routing table is reported
+// with status insufficient storage.
+func TestRouteEndpointMaxSize(t *testing.T) {
+	router, _, table := newRouteTestRouter()
+	table.MaxSize = len(table.All())
+
+	// Try to create a new route.
+	body := strings.NewReader(`{"timerId": 0, "subnet": "10.0.0.0/8"}`)
+	req := httptest.NewRequest(http.MethodPut, "/route/", body)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInsufficientStorage {
+		t.Errorf("invalid status code: want %d get %d",
+			http.StatusInsufficientStorage, rec.Code)
+	}
+}
+
+// TestRouteEndpointCrud test to list, create, fetch, update and delete
+// routes through the api.
+func TestRouteEndpointCrud(t *testing.T) {
+	router, timers, table := newRouteTestRouter()
+	timerId := timers.Add(&server.ModifyTimer{})
+
+	// Create test table; the requests are served in order.
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPut, "/route/", `{"timerId": 0, "subnet": "10.0.0.0/8"}`,
+			http.StatusCreated},
+		{http.MethodPut, "/route/", `{"timerId": 0, "subnet": "10.0.0.0/8"}`,
+			http.StatusConflict},
+		{http.MethodPut, "/route/", `{"timerId": 0, "subnet": "10.0.0.0"}`,
+			http.StatusBadRequest},
+		{http.MethodPut, "/route/", `{"timerId": 99, "subnet": "10.0.0.0/8"}`,
+			http.StatusBadRequest},
+		{http.MethodGet, "/route/4", "", http.StatusOK},
+		{http.MethodPost, "/route/4", `{"timerId": 1}`, http.StatusOK},
+		{http.MethodPost, "/route/99", `{"timerId": 1}`, http.StatusBadRequest},
+		{http.MethodDelete, "/route/0", "", http.StatusForbidden},
+		{http.MethodDelete, "/route/4", "", http.StatusOK},
+		{http.MethodGet, "/route/4", "", http.StatusBadRequest},
+		{http.MethodPost, "/route/default", `{"timerId": 1}`, http.StatusOK},
+		{http.MethodGet, "/route/default", "", http.StatusOK},
+	}
+
+	// Test all steps in order.
+	for _, e := range steps {
+		rec := serveTestRequest(router, e.method, e.path, e.body)
+		if rec.Code != e.status {
+			t.Errorf("%s %s invalid status code: want %d get %d",
+				e.method, e.path, e.status, rec.Code)
+		}
+	}
+
+	// All default routes must reference the updated timer.
+	if routes := table.RoutesForTimer(timerId); len(routes) != 4 {
+		t.Errorf("invalid number of default routes: %d", len(routes))
+	}
+	if len(table.All()) != 4 {
+		t.Errorf("invalid number of routes: %d", len(table.All()))
+	}
+}
+
+// TestRouteEndpointConcurrentTimerDelete test that no route points at a
+// deleted timer, while routes are created concurrently. A timer with
+// routes is not deleted. Run with -race to detect unsynchronized access.
+func TestRouteEndpointConcurrentTimerDelete(t *testing.T) {
+	router, timers, table := newRouteTestRouter()
+	for i := 0; i < 20; i++ {
+		timerId := timers.Add(&server.ModifyTimer{})
+
+		// Create routes while the timer is deleted.
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				body := fmt.Sprintf(`{"timerId": %d, "subnet": "10.%d.%d.0/24"}`,
+					timerId, i, j)
+				rec := serveTestRequest(router, http.MethodPut, "/route/", body)
+				if rec.Code != http.StatusCreated &&
+					rec.Code != http.StatusBadRequest {
+					t.Errorf("invalid status code: %d", rec.Code)
+				}
+			}(j)
+		}
+		var err error
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err = timers.DeleteUnused(timerId, table)
+		}()
+		wg.Wait()
+
+		// Either the timer is deleted without routes or it is in use.
+		routes := table.RoutesForTimer(timerId)
+		switch {
+		case err == nil && len(routes) != 0:
+			t.Fatalf("%d routes point at deleted timer %d",
+				len(routes), timerId)
+		case errors.Is(err, server.ErrTimerInUse) && len(routes) == 0:
+			t.Fatalf("timer %d in use without routes", timerId)
+		case err != nil && !errors.Is(err, server.ErrTimerInUse):
+			t.Fatalf("can not delete timer: %s", err)
+		}
+	}
+}
+
+// TestRouteEndpointOverlapWarning test that a route, that overlaps an
+// existing route, is created with a warning.
+func TestRouteEndpointOverlapWarning(t *testing.T) {
+	router, _, _ := newRouteTestRouter()
+
+	// Create test table; each subnet is created in order.
+	steps := []struct {
+		subnet  string
+		warning bool
+	}{
+		{"192.168.1.0/24", false},
+		{"192.168.1.0/16", true},
+		{"10.0.0.0/8", false},
+	}
+	for _, e := range steps {
+		body := fmt.Sprintf(`{"timerId": 0, "subnet": %q}`, e.subnet)
+		rec := serveTestRequest(router, http.MethodPut, "/route/", body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("%s invalid status code: %d", e.subnet, rec.Code)
+		}
+		var res NewRouteResponse
+		if err := decodeTestData(rec, &res); err != nil {
+			t.Fatalf("can not decode response: %s", err)
+		}
+		if (res.Warning != "") != e.warning {
+			t.Errorf("%s invalid warning: %q", e.subnet, res.Warning)
+		}
+	}
+}
+
+// TestRouteEndpointDeleteKeepsIds test that deleting a route keeps the
+// ids of the other routes and that the id is not reused.
+func TestRouteEndpointDeleteKeepsIds(t *testing.T) {
+	router, _, table := newRouteTestRouter()
+	subnets := []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}
+	for _, subnet := range subnets {
+		body := fmt.Sprintf(`{"timerId": 0, "subnet": %q}`, subnet)
+		rec := serveTestRequest(router, http.MethodPut, "/route/", body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("%s invalid status code: %d", subnet, rec.Code)
+		}
+	}
+	ids := make(map[string]int, len(subnets))
+	for _, entry := range table.All() {
+		ids[entry.IPNet.String()] = entry.Id
+	}
+
+	// Delete the middle route.
+	path := fmt.Sprintf("/route/%d", ids[subnets[1]])
+	rec := serveTestRequest(router, http.MethodDelete, path, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("invalid delete status code: want %d get %d",
+			http.StatusOK, rec.Code)
+	}
+	rec = serveTestRequest(router, http.MethodGet, path, "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("deleted route found: %d", rec.Code)
+	}
+
+	// The other routes keep their ids.
+	for _, subnet := range []string{subnets[0], subnets[2]} {
+		path := fmt.Sprintf("/route/%d", ids[subnet])
+		rec := serveTestRequest(router, http.MethodGet, path, "")
+		var response RouteResponse
+		if err := decodeTestData(rec, &response); err != nil {
+			t.Fatalf("can not decode response: %s", err)
+		}
+		if rec.Code != http.StatusOK || response.Subnet != subnet {
+			t.Errorf("%s invalid route %d: %d %+v",
+				subnet, ids[subnet], rec.Code, response)
+		}
+	}
+
+	// A new route does not reuse the deleted id.
+	body := `{"timerId": 0, "subnet": "172.16.0.0/12"}`
+	serveTestRequest(router, http.MethodPut, "/route/", body)
+	for _, entry := range table.All() {
+		if entry.IPNet.String() == subnets[1] &&
+			entry.Id <= ids[subnets[2]] {
+			t.Errorf("deleted id reused: %d", entry.Id)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"github.com/donsprallo/zeitgeist/internal/server"
+	"github.com/donsprallo/zeitgeist/internal/web/api"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+type MaintenanceResponse struct {
+	Enabled     bool `json:"enabled"`
+	KissOfDeath bool `json:"kissOfDeath"`
+}
+
+type MaintenanceRequest struct {
+	Enabled     bool `json:"enabled"`
+	KissOfDeath bool `json:"kissOfDeath"`
+}
+
+// ServerEndpoint is used to manage the ntp server while it is running.
+type ServerEndpoint struct {
+	handler http.Handler
+	server  *server.Server // The ntp server
+}
+
+func NewServerEndpoint(
+	server *server.Server,
+) api.Endpoint {
+	return &ServerEndpoint{
+		server: server,
+	}
+}
+
+func (e *ServerEndpoint) RegisterRoutes(router *mux.Router) {
+	e.handler = router
+
+	// Maintenance mode management.
+	router.HandleFunc("/maintenance",
+		e.getMaintenance).Methods(http.MethodGet)
+	router.HandleFunc("/maintenance",
+		e.updateMaintenance).Methods(http.MethodPost)
+}
+
+// Get the maintenance mode of the ntp server.
+func (e *ServerEndpoint) getMaintenance(
+	w http.ResponseWriter, _ *http.Request,
+) {
+	enabled, kissOfDeath := e.server.Maintenance()
+	jsonResponse(w, MaintenanceResponse{
+		Enabled:     enabled,
+		KissOfDeath: kissOfDeath,
+	}, http.StatusOK)
+}
+
+// Set the maintenance mode of the ntp server. In maintenance mode the
+// ntp server stops answering requests, while the api stays available.
+func (e *ServerEndpoint) updateMaintenance(
+	w http.ResponseWriter, r *http.Request,
+) {
+	// Decode body data.
+	var request MaintenanceRequest
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	// Set maintenance mode and return the new mode.
+	e.server.SetMaintenance(request.Enabled, request.KissOfDeath)
+	e.getMaintenance(w, r)
+}
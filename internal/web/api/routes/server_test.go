@@ -0,0 +1,55 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/donsprallo/zeitgeist/internal/server"
+	"github.com/gorilla/mux"
+)
+
+// TestServerEndpointMaintenance test to toggle the maintenance mode of the
+// ntp server through the api.
+func TestServerEndpointMaintenance(t *testing.T) {
+	table := server.NewRoutingTable(10)
+	routing := server.NewStaticRouting(table, &server.SystemTimer{}, 0)
+	ntpServer := server.NewServer("localhost", 0, routing)
+
+	router := mux.NewRouter()
+	NewServerEndpoint(ntpServer).RegisterRoutes(
+		router.PathPrefix("/server").Subrouter())
+
+	// Enable maintenance mode; the api must respond.
+	rec := serveTestRequest(router, http.MethodPost, "/server/maintenance",
+		`{"enabled": true, "kissOfDeath": true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("invalid status code: want %d get %d",
+			http.StatusOK, rec.Code)
+	}
+	var response MaintenanceResponse
+	err := decodeTestData(rec, &response)
+	if err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if !response.Enabled || !response.KissOfDeath {
+		t.Errorf("invalid maintenance response: %+v", response)
+	}
+	if enabled, kissOfDeath := ntpServer.Maintenance(); !enabled || !kissOfDeath {
+		t.Errorf("maintenance mode not set on server")
+	}
+
+	// Disable maintenance mode.
+	rec = serveTestRequest(router, http.MethodPost, "/server/maintenance",
+		`{"enabled": false}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("invalid status code: want %d get %d",
+			http.StatusOK, rec.Code)
+	}
+	if enabled, _ := ntpServer.Maintenance(); enabled {
+		t.Errorf("maintenance mode not disabled on server")
+	}
+}
@@ -5,25 +5,73 @@
 package routes
 
 import (
-	"encoding/json"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/donsprallo/zeitgeist/internal/ntp"
 	"github.com/donsprallo/zeitgeist/internal/server"
-	"github.com/donsprallo/zeitgeist/internal/web/api"
 	"github.com/gorilla/mux"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 )
 
 type TimerResponse struct {
-	Id    int    `json:"id"`
-	Type  string `json:"type"`
-	Value string `json:"value"`
+	Id         int              `json:"id"`
+	Type       string           `json:"type"`
+	Value      string           `json:"value"`
+	LastServed string           `json:"lastServed,omitempty"`
+	Requests   uint64           `json:"requests"`
+	Package    *PackageResponse `json:"package,omitempty"`
 }
 
 type TimerValueResponse struct {
-	Id    int    `json:"id"`
-	Type  string `json:"type"`
-	Value string `json:"value"`
+	Id         int                `json:"id"`
+	Type       string             `json:"type"`
+	Value      string             `json:"value"`
+	LastServed string             `json:"lastServed,omitempty"`
+	Requests   uint64             `json:"requests"`
+	Package    *PackageResponse   `json:"package,omitempty"`
+	Base       *TimerBaseResponse `json:"base,omitempty"`
+}
+
+// PackageResponse describe the ntp.Package header fields a timer serves.
+// The precision is a signed log2 seconds exponent and the referenceId is
+// hex encoded.
+type PackageResponse struct {
+	Leap           uint32 `json:"leap"`
+	Version        uint32 `json:"version"`
+	Mode           uint32 `json:"mode"`
+	Stratum        uint32 `json:"stratum"`
+	Poll           uint32 `json:"poll"`
+	Precision      int8   `json:"precision"`
+	ReferenceId    string `json:"referenceId"`
+	RootDelay      string `json:"rootDelay"`
+	RootDispersion string `json:"rootDispersion"`
+}
+
+// Build the PackageResponse of pkg.
+func newPackageResponse(pkg *ntp.Package) *PackageResponse {
+	return &PackageResponse{
+		Leap:           pkg.GetLeap(),
+		Version:        pkg.GetVersion(),
+		Mode:           pkg.GetMode(),
+		Stratum:        pkg.GetStratum(),
+		Poll:           pkg.GetPoll(),
+		Precision:      int8(pkg.GetPrecision()),
+		ReferenceId:    hex.EncodeToString(pkg.GetReferenceClockId()),
+		RootDelay:      pkg.GetRootDelayDuration().String(),
+		RootDispersion: pkg.GetRootDispersionDuration().String(),
+	}
+}
+
+// TimerBaseResponse describe a timer wrapped by another timer. The chain
+// of wrapped timers is continued by Base.
+type TimerBaseResponse struct {
+	Id   int                `json:"id"`
+	Type string             `json:"type"`
+	Base *TimerBaseResponse `json:"base,omitempty"`
 }
 
 type TimersResponse struct {
@@ -31,16 +79,80 @@ type TimersResponse struct {
 	Timers []TimerResponse `json:"timers"`
 }
 
+// TimerOffsetResponse describe the signed offset of a timer from the host
+// clock in seconds.
+type TimerOffsetResponse struct {
+	Id     int     `json:"id"`
+	Type   string  `json:"type"`
+	Offset float64 `json:"offset"`
+}
+
+// TimerOffsetsResponse describe the offsets of all timers from the host
+// clock at Time.
+type TimerOffsetsResponse struct {
+	Time    string                `json:"time"`
+	Length  int                   `json:"length"`
+	Offsets []TimerOffsetResponse `json:"offsets"`
+}
+
 type TimerEndpoint struct {
 	handler http.Handler
 	timers  *server.TimerCollection // The registered timers
+	routes  *server.RoutingTable    // The registered routes
+	allowed map[string]bool         // Types allowed to create; nil is all
 }
 
 func NewTimerEndpoint(
 	timers *server.TimerCollection,
-) api.Endpoint {
+	routes *server.RoutingTable,
+) *TimerEndpoint {
 	return &TimerEndpoint{
 		timers: timers,
+		routes: routes,
+	}
+}
+
+// TimerTypes are the types of the timers, that can be created with the
+// TimerEndpoint.
+var TimerTypes = []string{
+	"NtpTimer", "SystemTimer", "ModifyTimer", "RateTimer", "DriftTimer",
+	"SteppingTimer", "HeaderOverrideTimer", "StratumTimer",
+}
+
+// SetAllowedTypes restrict the types of the timers, that can be created
+// through the api, to types, like SystemTimer. The creation of another
+// type is refused with status forbidden. Without types, all TimerTypes are
+// allowed. An error is returned for a type not in TimerTypes.
+func (e *TimerEndpoint) SetAllowedTypes(types []string) error {
+	if len(types) == 0 {
+		e.allowed = nil
+		return nil
+	}
+	allowed := make(map[string]bool, len(types))
+	for _, name := range types {
+		if !slices.Contains(TimerTypes, name) {
+			return fmt.Errorf("unknown timer type %q", name)
+		}
+		allowed[name] = true
+	}
+	e.allowed = allowed
+	return nil
+}
+
+// Wrap the handler, that creates a timer of type name. When the type is
+// not allowed, the request is refused.
+func (e *TimerEndpoint) allowType(
+	name string,
+	handler http.HandlerFunc,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if e.allowed != nil && !e.allowed[name] {
+			jsonResponse(w, ErrorResponse{
+				Message: fmt.Sprintf("timer type %s not allowed", name),
+			}, http.StatusForbidden)
+			return
+		}
+		handler(w, r)
 	}
 }
 
@@ -50,12 +162,25 @@ func (e *TimerEndpoint) RegisterRoutes(router *mux.Router) {
 	// TimerResponse collection management.
 	router.HandleFunc("/",
 		e.getAllTimers).Methods(http.MethodGet)
-	router.HandleFunc("/ntp",
-		e.newNtpTimer).Methods(http.MethodPut)
-	router.HandleFunc("/system",
-		e.newSystemTimer).Methods(http.MethodPut)
-	router.HandleFunc("/modify",
-		e.newModifyTimer).Methods(http.MethodPut)
+	router.HandleFunc("/ntp", e.allowType(
+		"NtpTimer", e.newNtpTimer)).Methods(http.MethodPut)
+	router.HandleFunc("/system", e.allowType(
+		"SystemTimer", e.newSystemTimer)).Methods(http.MethodPut)
+	router.HandleFunc("/modify", e.allowType(
+		"ModifyTimer", e.newModifyTimer)).Methods(http.MethodPut)
+	router.HandleFunc("/rate", e.allowType(
+		"RateTimer", e.newRateTimer)).Methods(http.MethodPut)
+	router.HandleFunc("/drift", e.allowType(
+		"DriftTimer", e.newDriftTimer)).Methods(http.MethodPut)
+	router.HandleFunc("/stepping", e.allowType(
+		"SteppingTimer", e.newSteppingTimer)).Methods(http.MethodPut)
+	router.HandleFunc("/override", e.allowType(
+		"HeaderOverrideTimer", e.newHeaderOverrideTimer)).
+		Methods(http.MethodPut)
+	router.HandleFunc("/stratum", e.allowType(
+		"StratumTimer", e.newStratumTimer)).Methods(http.MethodPut)
+	router.HandleFunc("/offsets",
+		e.getTimerOffsets).Methods(http.MethodGet)
 
 	// Specific timer management.
 	router.HandleFunc("/{id}",
@@ -64,6 +189,10 @@ func (e *TimerEndpoint) RegisterRoutes(router *mux.Router) {
 		e.getTimer).Methods(http.MethodGet)
 	router.HandleFunc("/{id}",
 		e.updateTimer).Methods(http.MethodPost)
+	router.HandleFunc("/{id}/routes",
+		e.getTimerRoutes).Methods(http.MethodGet)
+	router.HandleFunc("/{id}/package",
+		e.updateTimerPackage).Methods(http.MethodPost)
 }
 
 // Get all registered timers.
@@ -74,19 +203,47 @@ func (e *TimerEndpoint) getAllTimers(
 	// Build response from timers collection. We know the size
 	// of timer collection here. So we can allocate the size.
 	response := TimersResponse{
-		Length: e.timers.Length(),
-		Timers: make([]TimerResponse, e.timers.Length()),
+		Length: len(timers),
+		Timers: make([]TimerResponse, len(timers)),
 	}
 	// Iterate through timers and add each entry to response.
 	for idx, entry := range timers {
 		response.Timers[idx] = TimerResponse{
-			Id:    idx,
-			Type:  server.TimerName(entry.Timer),
-			Value: entry.Timer.Get().Format(time.RFC3339),
+			Id:         entry.Id,
+			Type:       server.TimerName(entry.Timer),
+			Value:      entry.Timer.Get().Format(time.RFC3339),
+			LastServed: formatLastServed(entry.LastServed),
+			Requests:   entry.Requests,
+			Package:    newPackageResponse(entry.Timer.Package()),
 		}
 	}
 	// Return as JSON response.
-	api.MustJsonResponse(
+	jsonResponse(
+		w, response, http.StatusOK)
+}
+
+// Get the offsets of all registered timers from the host clock. All timers
+// are compared to the same host time, so that the offsets are comparable.
+func (e *TimerEndpoint) getTimerOffsets(
+	w http.ResponseWriter, _ *http.Request,
+) {
+	timers := e.timers.All()
+	// Sample the host clock once for all timers.
+	now := time.Now()
+	response := TimerOffsetsResponse{
+		Time:    now.Format(time.RFC3339Nano),
+		Length:  len(timers),
+		Offsets: make([]TimerOffsetResponse, len(timers)),
+	}
+	for idx, entry := range timers {
+		response.Offsets[idx] = TimerOffsetResponse{
+			Id:     entry.Id,
+			Type:   server.TimerName(entry.Timer),
+			Offset: entry.Timer.Get().Sub(now).Seconds(),
+		}
+	}
+	// Return as JSON response.
+	jsonResponse(
 		w, response, http.StatusOK)
 }
 
@@ -94,46 +251,250 @@ func (e *TimerEndpoint) getAllTimers(
 func (e *TimerEndpoint) newNtpTimer(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	// Parse body data; without a body the default package is used.
+	var request PackageRequest
+	if !decodeOptionalBody(w, r, &request) {
+		return
+	}
 	// Create new timer from request data.
-	ntpPackage := packageFromReq(r)
+	ntpPackage, ok := packageFromReq(w, request)
+	if !ok {
+		return
+	}
 	timer := &server.NtpTimer{
 		NTPPackage: *ntpPackage,
 	}
 	// Add timer to collection.
 	idx := e.timers.Add(timer)
 	mustJsonTimerResponse(
-		w, timer, idx, http.StatusCreated)
+		w, e.timers, timer, idx, http.StatusCreated)
 }
 
 // Create a new SystemTimer.
 func (e *TimerEndpoint) newSystemTimer(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	// Parse body data; without a body the default package is used.
+	var request PackageRequest
+	if !decodeOptionalBody(w, r, &request) {
+		return
+	}
 	// Create new timer from request data.
-	ntpPackage := packageFromReq(r)
+	ntpPackage, ok := packageFromReq(w, request)
+	if !ok {
+		return
+	}
 	timer := &server.SystemTimer{
 		NTPPackage: *ntpPackage,
 	}
 	// Add timer to collection.
 	idx := e.timers.Add(timer)
 	mustJsonTimerResponse(
-		w, timer, idx, http.StatusCreated)
+		w, e.timers, timer, idx, http.StatusCreated)
 }
 
 // Create a new ModifyTimer.
 func (e *TimerEndpoint) newModifyTimer(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	// Parse body data; without a body the default package is used.
+	var request PackageRequest
+	if !decodeOptionalBody(w, r, &request) {
+		return
+	}
 	// Create new timer from request data.
-	ntpPackage := packageFromReq(r)
-	timer := &server.ModifyTimer{
+	ntpPackage, ok := packageFromReq(w, request)
+	if !ok {
+		return
+	}
+	timer := server.NewModifyTimer(*ntpPackage, time.Now())
+	// Add timer to collection.
+	idx := e.timers.Add(timer)
+	mustJsonTimerResponse(
+		w, e.timers, timer, idx, http.StatusCreated)
+}
+
+type NewRateTimerRequest struct {
+	PackageRequest
+	Rate float64 `json:"rate"`
+}
+
+// Parse a RateTimer or DriftTimer request from request body. On failure,
+// an error response is written and false is returned.
+func rateFromReq(
+	w http.ResponseWriter, r *http.Request,
+) (NewRateTimerRequest, bool) {
+	// Parse body data; without a rate the timer runs in real time.
+	request := NewRateTimerRequest{Rate: 1.0}
+	if !decodeBody(w, r, &request) {
+		return request, false
+	}
+	// A timer can not run backwards.
+	if request.Rate <= 0 {
+		jsonResponse(w, ErrorResponse{
+			Message: "rate must be positive",
+		}, http.StatusBadRequest)
+		return request, false
+	}
+	return request, true
+}
+
+// Create a new RateTimer.
+func (e *TimerEndpoint) newRateTimer(
+	w http.ResponseWriter, r *http.Request,
+) {
+	request, ok := rateFromReq(w, r)
+	if !ok {
+		return
+	}
+	// Create new timer from request data.
+	ntpPackage, ok := packageFromReq(w, request.PackageRequest)
+	if !ok {
+		return
+	}
+	timer := server.NewRateTimer(
+		*ntpPackage, time.Now(), request.Rate)
+	// Add timer to collection.
+	idx := e.timers.Add(timer)
+	mustJsonTimerResponse(
+		w, e.timers, timer, idx, http.StatusCreated)
+}
+
+// Create a new DriftTimer.
+func (e *TimerEndpoint) newDriftTimer(
+	w http.ResponseWriter, r *http.Request,
+) {
+	request, ok := rateFromReq(w, r)
+	if !ok {
+		return
+	}
+	// Create new timer from request data.
+	ntpPackage, ok := packageFromReq(w, request.PackageRequest)
+	if !ok {
+		return
+	}
+	timer := server.NewDriftTimer(
+		*ntpPackage, time.Now(), request.Rate)
+	// Add timer to collection.
+	idx := e.timers.Add(timer)
+	mustJsonTimerResponse(
+		w, e.timers, timer, idx, http.StatusCreated)
+}
+
+type NewSteppingTimerRequest struct {
+	PackageRequest
+	Step string `json:"step"`
+}
+
+// Create a new SteppingTimer. The step is a duration string like "-1s",
+// that can be negative to step the timer backwards.
+func (e *TimerEndpoint) newSteppingTimer(
+	w http.ResponseWriter, r *http.Request,
+) {
+	// Parse body data.
+	var request NewSteppingTimerRequest
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	step, err := time.ParseDuration(request.Step)
+	if err != nil {
+		jsonResponse(w, ErrorResponse{
+			Message: "invalid step duration",
+		}, http.StatusBadRequest)
+		return
+	}
+	// Create new timer from request data.
+	ntpPackage, ok := packageFromReq(w, request.PackageRequest)
+	if !ok {
+		return
+	}
+	timer := &server.SteppingTimer{
 		NTPPackage: *ntpPackage,
 		Time:       time.Now(),
+		Step:       step,
+	}
+	// Add timer to collection.
+	idx := e.timers.Add(timer)
+	mustJsonTimerResponse(
+		w, e.timers, timer, idx, http.StatusCreated)
+}
+
+type NewHeaderOverrideTimerRequest struct {
+	PackageRequest
+	TimerId int `json:"timerId"`
+}
+
+// Create a new HeaderOverrideTimer.
+func (e *TimerEndpoint) newHeaderOverrideTimer(
+	w http.ResponseWriter, r *http.Request,
+) {
+	// Parse body data.
+	var request NewHeaderOverrideTimerRequest
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	// Find base timer by id.
+	base, ok := e.timers.Get(request.TimerId)
+	if !ok {
+		jsonResponse(w, ErrorResponse{
+			Message: "can not find timer",
+		}, http.StatusBadRequest)
+		return
+	}
+	// Create new timer from request data.
+	ntpPackage, ok := packageFromReq(w, request.PackageRequest)
+	if !ok {
+		return
+	}
+	timer := &server.HeaderOverrideTimer{
+		NTPPackage: *ntpPackage,
+		Base:       base.Timer,
 	}
 	// Add timer to collection.
 	idx := e.timers.Add(timer)
 	mustJsonTimerResponse(
-		w, timer, idx, http.StatusCreated)
+		w, e.timers, timer, idx, http.StatusCreated)
+}
+
+type NewStratumTimerRequest struct {
+	TimerId int    `json:"timerId"`
+	Stratum uint32 `json:"stratum"`
+}
+
+// Create a new StratumTimer.
+func (e *TimerEndpoint) newStratumTimer(
+	w http.ResponseWriter, r *http.Request,
+) {
+	// Parse body data.
+	var request NewStratumTimerRequest
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	// Validate stratum of a synchronized server.
+	if request.Stratum < server.MinStratum ||
+		request.Stratum > ntp.MaxStratum {
+		jsonResponse(w, ErrorResponse{
+			Message: "stratum must be between 1 and 15",
+		}, http.StatusBadRequest)
+		return
+	}
+	// Find base timer by id.
+	base, ok := e.timers.Get(request.TimerId)
+	if !ok {
+		jsonResponse(w, ErrorResponse{
+			Message: "can not find timer",
+		}, http.StatusBadRequest)
+		return
+	}
+	// Create new timer from request data.
+	timer := &server.StratumTimer{
+		Base:    base.Timer,
+		Stratum: request.Stratum,
+	}
+	// Add timer to collection.
+	idx := e.timers.Add(timer)
+	mustJsonTimerResponse(
+		w, e.timers, timer, idx, http.StatusCreated)
 }
 
 // Delete an existing server.Timer instance from collection.
@@ -144,21 +505,28 @@ func (e *TimerEndpoint) deleteTimer(
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		api.MustJsonResponse(w, ErrorResponse{
+		jsonResponse(w, ErrorResponse{
 			Message: "invalid query id",
-		}, http.StatusOK)
+		}, http.StatusBadRequest)
+		return
+	}
+	// Delete timer by id. A timer, that is referenced by a route or a
+	// wrapper timer, is still served and can not be deleted.
+	err = e.timers.DeleteUnused(id, e.routes)
+	if errors.Is(err, server.ErrTimerInUse) {
+		jsonResponse(w, ErrorResponse{
+			Message: "timer is used by routes or timers",
+		}, http.StatusConflict)
 		return
 	}
-	// Delete timer by id.
-	err = e.timers.Delete(id)
 	if err != nil {
-		api.MustJsonResponse(w, ErrorResponse{
-			Message: err.Error(),
-		}, http.StatusOK)
+		jsonResponse(w, ErrorResponse{
+			Message: "can not delete timer by id",
+		}, http.StatusNotFound)
 		return
 	}
 	// Timer successful deleted.
-	api.MustJsonResponse(w, MessageResponse{
+	jsonResponse(w, MessageResponse{
 		Message: "delete timer success",
 	}, http.StatusAccepted)
 }
@@ -171,77 +539,187 @@ func (e *TimerEndpoint) getTimer(
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		api.MustJsonResponse(w, ErrorResponse{
+		jsonResponse(w, ErrorResponse{
 			Message: "invalid query id",
-		}, http.StatusOK)
+		}, http.StatusBadRequest)
 		return
 	}
 	// Get timer by id.
-	timer := e.timers.Get(id)
-	if timer.Timer == nil {
-		api.MustJsonResponse(w, ErrorResponse{
+	timer, ok := e.timers.Get(id)
+	if !ok {
+		jsonResponse(w, ErrorResponse{
 			Message: "can not find timer by id",
-		}, http.StatusOK)
+		}, http.StatusNotFound)
 		return
 	}
 	// Make response with timer.
 	mustJsonTimerResponse(
-		w, timer.Timer, id, http.StatusOK)
+		w, e.timers, timer.Timer, id, http.StatusOK)
 }
 
-// Update settings of specific route.
-func (e *TimerEndpoint) updateTimer(
+// Get all routes that reference a specific timer.
+func (e *TimerEndpoint) getTimerRoutes(
 	w http.ResponseWriter, r *http.Request,
 ) {
 	// Parse query parameters.
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		api.MustJsonResponse(w, ErrorResponse{
+		jsonResponse(w, ErrorResponse{
 			Message: "invalid query id",
 		}, http.StatusBadRequest)
 		return
 	}
-	// Get timer by id.
-	timer := e.timers.Get(id)
-	if timer.Timer == nil {
-		api.MustJsonResponse(w, ErrorResponse{
+	// Timer must exist.
+	if _, ok := e.timers.Get(id); !ok {
+		jsonResponse(w, ErrorResponse{
 			Message: "can not find timer by id",
-		}, http.StatusNoContent)
+		}, http.StatusNotFound)
 		return
 	}
+	// Return routes as JSON response.
+	jsonResponse(w, newRouteAllResponse(
+		e.timers, e.routes.RoutesForTimer(id)), http.StatusOK)
+}
 
-	// Build response from timer type.
-	switch timer.Timer.(type) {
-	case *server.ModifyTimer:
-		// Parse body parameters for ModifyTimer.
-		body := make(map[string]string, 0)
-		err := json.NewDecoder(r.Body).Decode(&body)
-		if err != nil {
-			api.MustJsonResponse(
-				w, BodyDecodeError, http.StatusBadRequest)
-			return
+type UpdateTimerRequest struct {
+	PackageRequest
+	Time *string `json:"time,omitempty"`
+}
+
+// Update settings of specific timer. The package fields can be updated on
+// all timers except a StratumTimer, the time only on settable timers.
+func (e *TimerEndpoint) updateTimer(
+	w http.ResponseWriter, r *http.Request,
+) {
+	if _, ok := e.updateTimerFields(w, r); !ok {
+		return
+	}
+	jsonResponse(w, MessageResponse{
+		Message: "timer update successful",
+	}, http.StatusOK)
+}
+
+// Update the ntp package fields of a specific timer like updateTimer and
+// return the updated timer.
+func (e *TimerEndpoint) updateTimerPackage(
+	w http.ResponseWriter, r *http.Request,
+) {
+	timer, ok := e.updateTimerFields(w, r)
+	if !ok {
+		return
+	}
+	mustJsonTimerResponse(
+		w, e.timers, timer.Timer, timer.Id, http.StatusOK)
+}
+
+// Update the fields of the timer with the id of the request from the
+// UpdateTimerRequest body. On failure, an error response is written and
+// false is returned.
+func (e *TimerEndpoint) updateTimerFields(
+	w http.ResponseWriter, r *http.Request,
+) (server.TimerCollectionEntry, bool) {
+	// Parse query parameters.
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		jsonResponse(w, ErrorResponse{
+			Message: "invalid query id",
+		}, http.StatusBadRequest)
+		return server.TimerCollectionEntry{}, false
+	}
+	// Get timer by id.
+	timer, ok := e.timers.Get(id)
+	if !ok {
+		jsonResponse(w, ErrorResponse{
+			Message: "can not find timer by id",
+		}, http.StatusNotFound)
+		return timer, false
+	}
+	// Parse body data.
+	var request UpdateTimerRequest
+	if !decodeBody(w, r, &request) {
+		return timer, false
+	}
+	updatePackage := request.PackageRequest != PackageRequest{}
+	if request.Time == nil && !updatePackage {
+		jsonResponse(w, ErrorResponse{
+			Message: "no timer fields to update",
+		}, http.StatusBadRequest)
+		return timer, false
+	}
+
+	// Parse time value from body for settable timers.
+	var timeVal time.Time
+	if request.Time != nil {
+		if !isSettableTimer(timer.Timer) {
+			jsonResponse(w, ErrorResponse{
+				Message: "timer can not modified",
+			}, http.StatusConflict)
+			return timer, false
 		}
-		// Parse time value from body
-		timeLayout := time.RFC3339
-		timeVal, err := time.Parse(
-			timeLayout, body["time"])
+		timeVal, err = parseTime(*request.Time)
 		if err != nil {
-			api.MustJsonResponse(w, ErrorResponse{
-				Message: "can not parse time",
+			jsonResponse(w, ErrorResponse{
+				Message: err.Error(),
 			}, http.StatusBadRequest)
-			return
+			return timer, false
 		}
-		// Set timer with value.
-		timer.Timer.Set(timeVal)
-		api.MustJsonResponse(w, MessageResponse{
-			Message: "timer update successful",
-		}, http.StatusOK)
-		return
+	}
+	// Apply package fields to timer.
+	if updatePackage && !e.applyTimerPackage(
+		w, timer, request.PackageRequest) {
+		return timer, false
+	}
+	// Set timer with value under the lock of the collection.
+	if request.Time != nil && e.timers.SetTime(timer.Id, timeVal) != nil {
+		jsonResponse(w, ErrorResponse{
+			Message: "can not find timer by id",
+		}, http.StatusNotFound)
+		return timer, false
+	}
+	return timer, true
+}
+
+// Check whether the time of timer can be set.
+func isSettableTimer(timer server.Timer) bool {
+	switch timer.(type) {
+	case *server.ModifyTimer, *server.RateTimer, *server.DriftTimer,
+		*server.SteppingTimer:
+		return true
 	default:
-		api.MustJsonResponse(w, ErrorResponse{
-			Message: "timer can not modified",
+		return false
+	}
+}
+
+// Apply the package fields of request to the package of timer. On
+// failure, an error response is written and false is returned. The
+// package of a StratumTimer is derived from its base timer and can not
+// be updated. The package is changed under the lock of the collection, so
+// that the server does not create a response from a partial package.
+func (e *TimerEndpoint) applyTimerPackage(
+	w http.ResponseWriter,
+	timer server.TimerCollectionEntry,
+	request PackageRequest,
+) bool {
+	if _, ok := timer.Timer.(*server.StratumTimer); ok {
+		jsonResponse(w, ErrorResponse{
+			Message: "timer package is derived from base timer",
 		}, http.StatusConflict)
-		return
+		return false
+	}
+	err := e.timers.UpdatePackage(timer.Id, request.Apply)
+	if errors.Is(err, server.ErrTimerNotFound) {
+		jsonResponse(w, ErrorResponse{
+			Message: "can not find timer by id",
+		}, http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		jsonResponse(w, ErrorResponse{
+			Message: err.Error(),
+		}, http.StatusBadRequest)
+		return false
 	}
+	return true
 }
@@ -0,0 +1,619 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"cmp"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/ntp"
+	"github.com/donsprallo/zeitgeist/internal/server"
+	"github.com/donsprallo/zeitgeist/internal/web/api"
+	"github.com/gorilla/mux"
+)
+
+// Create a router with a TimerEndpoint and a SystemTimer with id 0 and
+// a ModifyTimer with id 1. The SystemTimer is used for the default routes.
+func newTimerTestRouter() (*mux.Router, *server.TimerCollection) {
+	timers := server.NewTimerCollection(10)
+	defaultTimer := &server.SystemTimer{}
+	timerId := timers.Add(defaultTimer)
+	timers.Add(&server.ModifyTimer{})
+	table := server.NewRoutingTable(10)
+	server.NewStaticRouting(table, defaultTimer, timerId)
+
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+	endpoint := NewTimerEndpoint(timers, table)
+	endpoint.RegisterRoutes(router.PathPrefix("/timer").Subrouter())
+	return router, timers
+}
+
+// Serve a request with method, path and body by router.
+func serveTestRequest(
+	router http.Handler, method, path, body string,
+) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// Decode the api.Envelope of the response in rec with the data into v.
+func decodeTestData(rec *httptest.ResponseRecorder, v any) error {
+	return json.NewDecoder(rec.Body).Decode(&api.Envelope{Data: v})
+}
+
+// TestTimerEndpointStatus test the status codes of the timer handlers
+// for a bad id, a nonexistent id and a valid id. The timer 0 is routed.
+func TestTimerEndpointStatus(t *testing.T) {
+	// Create test table; each request maps to a status code.
+	table := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodGet, "/timer/abc", "", http.StatusBadRequest},
+		{http.MethodGet, "/timer/99", "", http.StatusNotFound},
+		{http.MethodGet, "/timer/0", "", http.StatusOK},
+		{http.MethodPost, "/timer/abc", "{}", http.StatusBadRequest},
+		{http.MethodPost, "/timer/99", "{}", http.StatusNotFound},
+		{http.MethodPost, "/timer/1",
+			`{"time": "2024-01-01T00:00:00Z"}`, http.StatusOK},
+		{http.MethodDelete, "/timer/abc", "", http.StatusBadRequest},
+		{http.MethodDelete, "/timer/99", "", http.StatusNotFound},
+		{http.MethodDelete, "/timer/0", "", http.StatusConflict},
+		{http.MethodDelete, "/timer/1", "", http.StatusAccepted},
+	}
+
+	// Test all entries in test table.
+	for _, e := range table {
+		router, _ := newTimerTestRouter()
+		rec := serveTestRequest(router, e.method, e.path, e.body)
+		if rec.Code != e.status {
+			t.Errorf("%s %s invalid status code: want %d get %d",
+				e.method, e.path, e.status, rec.Code)
+		}
+	}
+}
+
+// TestTimerEndpointNewDriftTimer test to create a DriftTimer.
+func TestTimerEndpointNewDriftTimer(t *testing.T) {
+	router, timers := newTimerTestRouter()
+
+	rec := serveTestRequest(
+		router, http.MethodPut, "/timer/drift", `{"rate": 2.5}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("invalid status code: want %d get %d",
+			http.StatusCreated, rec.Code)
+	}
+	entry, _ := timers.Get(2)
+	timer, ok := entry.Timer.(*server.DriftTimer)
+	if !ok || timer.Rate != 2.5 {
+		t.Errorf("drift timer not created with rate: %+v", entry.Timer)
+	}
+
+	// A rate must be positive.
+	rec = serveTestRequest(
+		router, http.MethodPut, "/timer/drift", `{"rate": -1}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid status code: want %d get %d",
+			http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestTimerEndpointNewSteppingTimer(t *testing.T) {
+	router, timers := newTimerTestRouter()
+
+	rec := serveTestRequest(
+		router, http.MethodPut, "/timer/stepping", `{"step": "-1.5s"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("invalid status code: want %d get %d",
+			http.StatusCreated, rec.Code)
+	}
+	entry, _ := timers.Get(2)
+	timer, ok := entry.Timer.(*server.SteppingTimer)
+	if !ok || timer.Step != -1500*time.Millisecond {
+		t.Errorf("stepping timer not created with step: %+v", entry.Timer)
+	}
+
+	// A step must be a duration.
+	for _, body := range []string{`{"step": "1x"}`, `{}`} {
+		rec = serveTestRequest(
+			router, http.MethodPut, "/timer/stepping", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s invalid status code: want %d get %d",
+				body, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+// TestTimerEndpointAllowedTypes test that only the allowed timer types can
+// be created.
+func TestTimerEndpointAllowedTypes(t *testing.T) {
+	timers := server.NewTimerCollection(10)
+	endpoint := NewTimerEndpoint(timers, server.NewRoutingTable(10))
+	if err := endpoint.SetAllowedTypes([]string{"SystemTimer"}); err != nil {
+		t.Fatalf("can not set allowed types: %s", err)
+	}
+	router := mux.NewRouter()
+	endpoint.RegisterRoutes(router.PathPrefix("/timer").Subrouter())
+
+	// Create test table; each path maps to a status code.
+	table := []struct {
+		path   string
+		status int
+	}{
+		{"/timer/system", http.StatusCreated},
+		{"/timer/ntp", http.StatusForbidden},
+		{"/timer/modify", http.StatusForbidden},
+		{"/timer/stratum", http.StatusForbidden},
+	}
+	for _, e := range table {
+		rec := serveTestRequest(router, http.MethodPut, e.path, "")
+		if rec.Code != e.status {
+			t.Errorf("%s invalid status code: want %d get %d",
+				e.path, e.status, rec.Code)
+		}
+	}
+	if entries := timers.All(); len(entries) != 1 {
+		t.Errorf("refused timer created: %d timers", len(entries))
+	}
+
+	// An unknown type can not be allowed; without types, all types are
+	// allowed.
+	if err := endpoint.SetAllowedTypes([]string{"AtomicTimer"}); err == nil {
+		t.Errorf("unknown timer type allowed")
+	}
+	_ = endpoint.SetAllowedTypes(nil)
+	rec := serveTestRequest(router, http.MethodPut, "/timer/ntp", "")
+	if rec.Code != http.StatusCreated {
+		t.Errorf("invalid status code: want %d get %d",
+			http.StatusCreated, rec.Code)
+	}
+}
+
+// TestTimerEndpointTimerRoutes test to get the routes of a timer.
+func TestTimerEndpointTimerRoutes(t *testing.T) {
+	router, _ := newTimerTestRouter()
+
+	// The default timer is referenced by the default routes.
+	rec := serveTestRequest(router, http.MethodGet, "/timer/0/routes", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("invalid status code: want %d get %d",
+			http.StatusOK, rec.Code)
+	}
+	var response RouteAllResponse
+	err := decodeTestData(rec, &response)
+	if err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if response.Length != 4 {
+		t.Errorf("invalid routes length: want 4 get %d", response.Length)
+	}
+	for _, route := range response.Routes {
+		if route.Timer.Id != 0 {
+			t.Errorf("route %d does not reference timer", route.Id)
+		}
+	}
+
+	// A missing timer is not found.
+	rec = serveTestRequest(router, http.MethodGet, "/timer/99/routes", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("invalid status code: want %d get %d",
+			http.StatusNotFound, rec.Code)
+	}
+}
+
+// TestUpdateEmptyBody test the responses of update handlers for empty,
+// malformed and valid bodies.
+func TestUpdateEmptyBody(t *testing.T) {
+	timerRouter, _ := newTimerTestRouter()
+	routeRouter, _, _ := newRouteTestRouter()
+
+	// Create test table; each body maps to a status code and message.
+	table := []struct {
+		router  http.Handler
+		path    string
+		body    string
+		status  int
+		message string
+	}{
+		{timerRouter, "/timer/1", "", http.StatusBadRequest,
+			EmptyBodyError.Message},
+		{timerRouter, "/timer/1", `{"time": `, http.StatusBadRequest,
+			BodyDecodeError.Message + ": unexpected EOF"},
+		{timerRouter, "/timer/1", `{"time": "2024-01-01T00:00:00Z"}`,
+			http.StatusOK, "timer update successful"},
+		{routeRouter, "/route/0", "", http.StatusBadRequest,
+			EmptyBodyError.Message},
+		{routeRouter, "/route/0", `{"timerId": "x"}`, http.StatusBadRequest,
+			""},
+		{routeRouter, "/route/0", `{"timerId": 0}`, http.StatusOK,
+			"route updated successful"},
+		{routeRouter, "/route/default", "", http.StatusBadRequest,
+			EmptyBodyError.Message},
+	}
+
+	// Test all entries in test table.
+	for _, e := range table {
+		rec := serveTestRequest(e.router, http.MethodPost, e.path, e.body)
+		if rec.Code != e.status {
+			t.Errorf("%s %q invalid status code: want %d get %d",
+				e.path, e.body, e.status, rec.Code)
+		}
+		// The message of an error is the error of the envelope.
+		var response MessageResponse
+		envelope := api.Envelope{Data: &response}
+		err := json.NewDecoder(rec.Body).Decode(&envelope)
+		if err != nil {
+			t.Fatalf("can not decode response: %s", err)
+		}
+		message := cmp.Or(envelope.Error, response.Message)
+		if e.message != "" && message != e.message {
+			t.Errorf("%s %q invalid message: want %q get %q",
+				e.path, e.body, e.message, message)
+		}
+		if e.message == "" && !strings.HasPrefix(
+			message, BodyDecodeError.Message+": ") {
+			t.Errorf("%s %q invalid message: %q",
+				e.path, e.body, message)
+		}
+	}
+}
+
+// TestUpdateTimeLayouts test that the ModifyTimer update accepts all
+// layouts of TimeLayouts and rejects an unparseable time.
+func TestUpdateTimeLayouts(t *testing.T) {
+	router, _ := newTimerTestRouter()
+
+	// Create test table; each time value maps to a status code.
+	table := []struct {
+		value  string
+		status int
+	}{
+		{"2024-01-02T03:04:05Z", http.StatusOK},
+		{"2024-01-02T03:04:05.123456789+01:00", http.StatusOK},
+		{"02 Jan 24 03:04 UTC", http.StatusOK},
+		{"yesterday", http.StatusBadRequest},
+	}
+
+	// Test all entries in test table.
+	for _, e := range table {
+		body := `{"time": "` + e.value + `"}`
+		rec := serveTestRequest(router, http.MethodPost, "/timer/1", body)
+		if rec.Code != e.status {
+			t.Errorf("%q invalid status code: want %d get %d",
+				e.value, e.status, rec.Code)
+		}
+	}
+}
+
+// TestTimerEndpointLastServed test that the last served time of a timer
+// is part of the timer responses.
+func TestTimerEndpointLastServed(t *testing.T) {
+	router, timers := newTimerTestRouter()
+	served := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	timers.Served(1, served)
+
+	// A served timer has a last served time.
+	rec := serveTestRequest(router, http.MethodGet, "/timer/1", "")
+	var response TimerValueResponse
+	if err := decodeTestData(rec, &response); err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if response.LastServed != served.Format(time.RFC3339Nano) {
+		t.Errorf("invalid last served: %q", response.LastServed)
+	}
+
+	// A timer never served has no last served time.
+	rec = serveTestRequest(router, http.MethodGet, "/timer/", "")
+	var all TimersResponse
+	if err := decodeTestData(rec, &all); err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if all.Timers[0].LastServed != "" || all.Timers[1].LastServed == "" {
+		t.Errorf("invalid last served: %+v", all.Timers)
+	}
+	// A timer never served reports zero requests.
+	rec = serveTestRequest(router, http.MethodGet, "/timer/0", "")
+	if !strings.Contains(rec.Body.String(), `"requests":0`) {
+		t.Errorf("zero requests not reported: %s", rec.Body.String())
+	}
+}
+
+// TestTimerEndpointAllIds test that all timers are listed with their
+// collection id, also after a timer is deleted.
+func TestTimerEndpointAllIds(t *testing.T) {
+	router, timers := newTimerTestRouter()
+	id := timers.Add(&server.SystemTimer{})
+	_ = timers.Delete(1)
+
+	rec := serveTestRequest(router, http.MethodGet, "/timer/", "")
+	var all TimersResponse
+	if err := decodeTestData(rec, &all); err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if all.Length != 2 || all.Timers[1].Id != id {
+		t.Errorf("invalid timer ids: %+v", all.Timers)
+	}
+}
+
+// TestTimerEndpointPackage test that the package header fields of a
+// timer are part of the timer responses.
+func TestTimerEndpointPackage(t *testing.T) {
+	router, _ := newTimerTestRouter()
+	want := PackageResponse{
+		Leap: 1, Version: 4, Mode: 4, Stratum: 2, Poll: 6,
+		Precision: -20, ReferenceId: hex.EncodeToString([]byte("GPS\x00")),
+		RootDelay: "0s", RootDispersion: "0s",
+	}
+
+	// Create a timer with package fields.
+	rec := serveTestRequest(router, http.MethodPut, "/timer/ntp",
+		`{"version": 4, "stratum": 2, "leap": 1, "poll": 6, `+
+			`"precision": -20, "referenceId": "GPS"}`)
+	var created TimerValueResponse
+	if err := decodeTestData(rec, &created); err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+
+	// The package must be part of the timer response.
+	path := "/timer/" + strconv.Itoa(created.Id)
+	rec = serveTestRequest(router, http.MethodGet, path, "")
+	var response TimerValueResponse
+	if err := decodeTestData(rec, &response); err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if response.Package == nil || *response.Package != want {
+		t.Errorf("invalid package: want %+v get %+v",
+			want, response.Package)
+	}
+
+	// The package must be part of the timers response.
+	rec = serveTestRequest(router, http.MethodGet, "/timer/", "")
+	var all TimersResponse
+	if err := decodeTestData(rec, &all); err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	last := all.Timers[len(all.Timers)-1]
+	if last.Package == nil || *last.Package != want {
+		t.Errorf("invalid package: want %+v get %+v", want, last.Package)
+	}
+}
+
+// TestUpdateTimerPackage test that the package fields of a timer can be
+// updated and a subsequent GET reflects the update.
+func TestUpdateTimerPackage(t *testing.T) {
+	router, timers := newTimerTestRouter()
+	timers.Add(&server.StratumTimer{
+		Base: &server.SystemTimer{}, Stratum: 2})
+
+	// Create test table; each request maps to a status code.
+	table := []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"/timer/abc/package", `{}`, http.StatusBadRequest},
+		{"/timer/99/package", `{}`, http.StatusNotFound},
+		{"/timer/0/package", ``, http.StatusBadRequest},
+		{"/timer/0/package", `{"stratum": 256}`, http.StatusBadRequest},
+		{"/timer/2/package", `{"stratum": 3}`, http.StatusConflict},
+		{"/timer/0/package", `{"stratum": 3, "referenceId": "GPS"}`,
+			http.StatusOK},
+		{"/timer/0/package", `{"stratum": 5, "leap": 9}`,
+			http.StatusBadRequest},
+		{"/timer/0/package", `{"time": "2024-01-01T00:00:00Z"}`,
+			http.StatusConflict},
+	}
+
+	// Test all entries in test table.
+	for _, e := range table {
+		rec := serveTestRequest(router, http.MethodPost, e.path, e.body)
+		if rec.Code != e.status {
+			t.Errorf("%s %q invalid status code: want %d get %d",
+				e.path, e.body, e.status, rec.Code)
+		}
+	}
+
+	// The package route responds with the updated timer like the update
+	// route.
+	rec := serveTestRequest(router, http.MethodPost, "/timer/1/package",
+		`{"time": "2024-01-01T00:00:00Z", "stratum": 7}`)
+	var updated TimerValueResponse
+	if err := decodeTestData(rec, &updated); err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if updated.Id != 1 || updated.Package.Stratum != 7 {
+		t.Errorf("invalid updated timer: %+v", updated)
+	}
+
+	// A subsequent GET must reflect the update; the last invalid update
+	// must not modify the package.
+	rec = serveTestRequest(router, http.MethodGet, "/timer/0", "")
+	var response TimerValueResponse
+	if err := decodeTestData(rec, &response); err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if response.Package.Stratum != 3 ||
+		response.Package.ReferenceId != hex.EncodeToString(
+			[]byte("GPS\x00")) {
+		t.Errorf("invalid package: %+v", response.Package)
+	}
+}
+
+// TestTimerEndpointRootDelay test that the root delay and root dispersion
+// of a timer are set on creation and update and served in the response.
+func TestTimerEndpointRootDelay(t *testing.T) {
+	router, timers := newTimerTestRouter()
+
+	rec := serveTestRequest(router, http.MethodPut, "/timer/system",
+		`{"rootDelay": "50ms", "rootDispersion": "2ms"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("invalid status code: want %d get %d",
+			http.StatusCreated, rec.Code)
+	}
+	var created TimerValueResponse
+	if err := decodeTestData(rec, &created); err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+
+	// The served response must carry the root delay and dispersion; the
+	// values are truncated to the 16.16 fixed point resolution.
+	entry, _ := timers.Get(created.Id)
+	var request ntp.Package
+	request.SetMode(ntp.ModeClient)
+	request.SetTransmitTimestamp(time.Now())
+	served, err := server.PackageFromTimer(
+		&request, entry.Timer.Package(), entry.Timer)
+	if err != nil {
+		t.Fatalf("can not serve package: %s", err)
+	}
+	const resolution = time.Second >> 16
+	for _, e := range []struct {
+		get  time.Duration
+		want time.Duration
+	}{
+		{served.GetRootDelayDuration(), 50 * time.Millisecond},
+		{served.GetRootDispersionDuration(), 2 * time.Millisecond},
+	} {
+		if diff := e.want - e.get; diff < 0 || diff > resolution {
+			t.Errorf("invalid served value: want %s get %s", e.want, e.get)
+		}
+	}
+
+	// Create test table; each update maps to a status code.
+	path := "/timer/" + strconv.Itoa(created.Id) + "/package"
+	table := []struct {
+		body   string
+		status int
+	}{
+		{`{"rootDelay": "1s"}`, http.StatusOK},
+		{`{"rootDelay": "-1ms"}`, http.StatusBadRequest},
+		{`{"rootDispersion": "10h"}`, http.StatusBadRequest},
+		{`{"rootDispersion": "fast"}`, http.StatusBadRequest},
+	}
+	for _, e := range table {
+		rec = serveTestRequest(router, http.MethodPost, path, e.body)
+		if rec.Code != e.status {
+			t.Errorf("%s invalid status code: want %d get %d",
+				e.body, e.status, rec.Code)
+		}
+	}
+	if d := entry.Timer.Package().GetRootDelayDuration(); d != time.Second {
+		t.Errorf("root delay not updated: want 1s get %s", d)
+	}
+}
+
+// TestUpdateModifyTimerSubSecond test that a ModifyTimer set with a
+// RFC3339 time keeps the sub-second precision.
+func TestUpdateModifyTimerSubSecond(t *testing.T) {
+	router, timers := newTimerTestRouter()
+	want := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+
+	// Set the ModifyTimer with sub-second precision.
+	body := `{"time": "` + want.Format(time.RFC3339Nano) + `"}`
+	rec := serveTestRequest(router, http.MethodPost, "/timer/1", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("invalid status code: want %d get %d",
+			http.StatusOK, rec.Code)
+	}
+
+	// The timer advances in real time from the set time.
+	entry, _ := timers.Get(1)
+	diff := entry.Timer.Get().Sub(want)
+	if diff < 0 || diff > 100*time.Millisecond {
+		t.Errorf("invalid timer value: want %s get %s",
+			want, entry.Timer.Get())
+	}
+}
+
+// TestUpdateTimerFields test that package fields can be updated on all
+// timers, but the time only on settable timers.
+func TestUpdateTimerFields(t *testing.T) {
+	router, timers := newTimerTestRouter()
+
+	// Create test table; each request maps to a status code.
+	table := []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"/timer/0", `{}`, http.StatusBadRequest},
+		{"/timer/0", `{"time": "2024-01-01T00:00:00Z"}`,
+			http.StatusConflict},
+		{"/timer/0", `{"stratum": 256}`, http.StatusBadRequest},
+		{"/timer/0", `{"stratum": 4, "referenceId": "GPS"}`,
+			http.StatusOK},
+		{"/timer/1", `{"time": "never", "stratum": 9}`,
+			http.StatusBadRequest},
+		{"/timer/1", `{"time": "2024-01-01T00:00:00Z", "stratum": 5}`,
+			http.StatusOK},
+	}
+
+	// Test all entries in test table.
+	for _, e := range table {
+		rec := serveTestRequest(router, http.MethodPost, e.path, e.body)
+		if rec.Code != e.status {
+			t.Errorf("%s %q invalid status code: want %d get %d",
+				e.path, e.body, e.status, rec.Code)
+		}
+	}
+
+	// Both timers must serve the updated package.
+	entry, _ := timers.Get(0)
+	if stratum := entry.Timer.Package().GetStratum(); stratum != 4 {
+		t.Errorf("invalid SystemTimer stratum: %d", stratum)
+	}
+	entry, _ = timers.Get(1)
+	if stratum := entry.Timer.Package().GetStratum(); stratum != 5 {
+		t.Errorf("invalid ModifyTimer stratum: %d", stratum)
+	}
+}
+
+// TestTimerEndpointOffsets test that the offset of each timer from the
+// host clock is reported.
+func TestTimerEndpointOffsets(t *testing.T) {
+	router, timers := newTimerTestRouter()
+	offset := time.Hour
+	id := timers.Add(server.NewModifyTimer(
+		ntp.Package{}, time.Now().Add(offset)))
+
+	rec := serveTestRequest(router, http.MethodGet, "/timer/offsets", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("invalid status code: %d", rec.Code)
+	}
+	var response TimerOffsetsResponse
+	err := decodeTestData(rec, &response)
+	if err != nil {
+		t.Fatalf("can not decode response: %s", err)
+	}
+	if response.Length != 3 || len(response.Offsets) != 3 {
+		t.Fatalf("invalid number of offsets: %d", response.Length)
+	}
+
+	// The system timer runs with the host clock, the modify timer is
+	// ahead by offset.
+	system := response.Offsets[0]
+	if system.Type != "SystemTimer" || system.Offset < -1 ||
+		system.Offset > 1 {
+		t.Errorf("invalid system timer offset: %+v", system)
+	}
+	modify := response.Offsets[2]
+	diff := modify.Offset - offset.Seconds()
+	if modify.Id != id || modify.Type != "ModifyTimer" ||
+		diff < -1 || diff > 1 {
+		t.Errorf("invalid modify timer offset: %+v", modify)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/web/api"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// TimeoutBody is the response body sent, when a handler exceeds its
+// deadline.
+const TimeoutBody = `{"success":false,"error":"request timeout"}`
+
+// Timeout creates a middleware that limits the time of a handler to
+// timeout. The request context of the handler is canceled after timeout.
+// When the handler exceeds the deadline, the status code 503
+// http.StatusServiceUnavailable is sent.
+func Timeout(timeout time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, timeout, TimeoutBody)
+	}
+}
+
+// A http.ResponseWriter that holds back error responses. Responses with
+// other status codes are written through.
+type problemWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader implements http.ResponseWriter.WriteHeader interface.
+func (w *problemWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if status < http.StatusBadRequest {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+// Write implements http.ResponseWriter.Write interface.
+func (w *problemWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status >= http.StatusBadRequest {
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// ProblemDetails is a middleware that converts error responses to the
+// problem details format of RFC 7807, when the client accepts the
+// api.ProblemContentType. The error of an api.Envelope or the message of
+// an error response is used as problem detail. Other clients get the
+// error response unchanged.
+func ProblemDetails(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !api.AcceptsProblem(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pw := &problemWriter{ResponseWriter: w}
+		next.ServeHTTP(pw, r)
+		if pw.status < http.StatusBadRequest {
+			return
+		}
+		// Use the error of the envelope or the message of the error
+		// response as detail.
+		var response struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		detail := strings.TrimSpace(pw.body.String())
+		if json.Unmarshal(pw.body.Bytes(), &response) == nil {
+			detail = cmp.Or(response.Error, response.Message)
+		}
+		api.MustProblemResponse(w, r, detail, pw.status)
+	})
+}
+
+// SecurityConfig is the configuration of the SecurityHeaders middleware.
+// An empty header value is not sent.
+type SecurityConfig struct {
+	FrameOptions          string // X-Frame-Options of all responses.
+	ContentSecurityPolicy string // Content-Security-Policy of non API responses.
+	APIPrefix             string // Path prefix of the API responses.
+}
+
+// DefaultSecurityConfig is a restrictive SecurityConfig. Pages can not be
+// framed and load resources from the own origin only.
+var DefaultSecurityConfig = SecurityConfig{
+	FrameOptions:          "DENY",
+	ContentSecurityPolicy: "default-src 'self'; frame-ancestors 'none'",
+	APIPrefix:             "/api/",
+}
+
+// SecurityHeaders creates a middleware that sets security headers on all
+// responses. The content type is never sniffed. API responses are not
+// cached, other responses like a dashboard get the content security
+// policy of config. A handler can override the headers.
+func SecurityHeaders(config SecurityConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			header.Set("X-Content-Type-Options", "nosniff")
+			if config.FrameOptions != "" {
+				header.Set("X-Frame-Options", config.FrameOptions)
+			}
+			if config.APIPrefix != "" &&
+				strings.HasPrefix(r.URL.Path, config.APIPrefix) {
+				header.Set("Cache-Control", "no-store")
+			} else if config.ContentSecurityPolicy != "" {
+				header.Set("Content-Security-Policy",
+					config.ContentSecurityPolicy)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RecoverBody is the response body sent, when a handler panics.
+const RecoverBody = `{"success":false,"error":"internal server error"}`
+
+// Recover is a middleware that turns a panic of a handler into an error
+// response with status code 500 http.StatusInternalServerError. The panic
+// is logged. A http.ErrAbortHandler panic aborts the response like without
+// middleware.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.WithFields(log.Fields{
+				"method": r.Method,
+				"path":   r.URL.Path,
+			}).Errorf("web handler panic: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(RecoverBody))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// A http.ResponseWriter that records the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter.WriteHeader interface.
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.Write interface.
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// RequestLogger is a middleware that logs each request with its method,
+// path, status code and duration on debug level. It must be used before
+// Recover to log the status code of a recovered panic.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		log.WithFields(log.Fields{
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"status":   sw.status,
+			"duration": time.Since(start),
+			"remote":   r.RemoteAddr,
+		}).Debug("web request")
+	})
+}
+
+// UnauthorizedBody is the response body sent, when a request is rejected
+// by APIKeyAuth.
+const UnauthorizedBody = `{"success":false,"error":"unauthorized"}`
+
+// APIKeyAuth creates a middleware that requires key for all requests,
+// that modify state. Requests with the methods GET, HEAD and OPTIONS are
+// allowed without key. The key is sent as bearer token in the
+// Authorization header or in the X-API-Key header. A request without the
+// key is rejected with status code 401 http.StatusUnauthorized. An empty
+// key disables the authentication.
+func APIKeyAuth(key string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if key == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !hasAPIKey(r, key) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(UnauthorizedBody))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Check whether request r carries key as bearer token or in the X-API-Key
+// header. The key is compared in constant time.
+func hasAPIKey(r *http.Request, key string) bool {
+	token := r.Header.Get("X-API-Key")
+	if bearer, ok := strings.CutPrefix(
+		r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+	return token != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1
+}
@@ -0,0 +1,350 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donsprallo/zeitgeist/internal/web/api"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// An endpoint with a fast and a slow route. The slow route blocks until
+// the request is canceled.
+type slowEndpoint struct{}
+
+// RegisterRoutes implements api.Endpoint interface.
+func (e slowEndpoint) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/fast", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	server := NewServer("localhost", 0, mux.NewRouter())
+	server.RegisterEndpoint("/limited", slowEndpoint{},
+		Timeout(10*time.Millisecond))
+	server.RegisterEndpoint("/unlimited", slowEndpoint{})
+
+	// Create test table; each path maps to a status code.
+	table := []struct {
+		path   string
+		status int
+	}{
+		{"/limited/fast", http.StatusOK},
+		{"/limited/slow", http.StatusServiceUnavailable},
+		{"/unlimited/fast", http.StatusOK},
+	}
+
+	// Test all entries in test table.
+	for _, e := range table {
+		req := httptest.NewRequest(http.MethodGet, e.path, nil)
+		rec := httptest.NewRecorder()
+		server.handler.ServeHTTP(rec, req)
+		if rec.Code != e.status {
+			t.Errorf("%s invalid status code: want %d get %d",
+				e.path, e.status, rec.Code)
+		}
+		if e.status == http.StatusServiceUnavailable &&
+			rec.Body.String() != TimeoutBody {
+			t.Errorf("%s invalid body: %s", e.path, rec.Body.String())
+		}
+	}
+}
+
+// An endpoint with a route that fails with an error response.
+type failingEndpoint struct{}
+
+// RegisterRoutes implements api.Endpoint interface.
+func (e failingEndpoint) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/ok", func(w http.ResponseWriter, _ *http.Request) {
+		api.MustJsonResponse(w, map[string]string{
+			"message": "ok"}, http.StatusOK)
+	})
+	router.HandleFunc("/fail", func(w http.ResponseWriter, _ *http.Request) {
+		api.MustJsonResponse(w, map[string]string{
+			"message": "entity not found"}, http.StatusNotFound)
+	})
+	router.HandleFunc("/envelope", func(w http.ResponseWriter, _ *http.Request) {
+		api.MustEnvelopeError(w, "entity not found", http.StatusNotFound)
+	})
+}
+
+func TestProblemDetails(t *testing.T) {
+	server := NewServer("localhost", 0, mux.NewRouter())
+	server.RegisterEndpoint("/test", failingEndpoint{}, ProblemDetails)
+
+	// A client accepting problem details gets a problem response.
+	req := httptest.NewRequest(http.MethodGet, "/test/fail", nil)
+	req.Header.Set("Accept", "application/json, "+api.ProblemContentType)
+	rec := httptest.NewRecorder()
+	server.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("invalid status code: %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != api.ProblemContentType {
+		t.Errorf("invalid content type: %s", ct)
+	}
+	var problem api.ProblemResponse
+	if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil {
+		t.Fatalf("can not decode problem: %s", err)
+	}
+	want := api.ProblemResponse{
+		Type:     "about:blank",
+		Title:    "Not Found",
+		Status:   http.StatusNotFound,
+		Detail:   "entity not found",
+		Instance: "/test/fail",
+	}
+	if problem != want {
+		t.Errorf("invalid problem: want %+v get %+v", want, problem)
+	}
+
+	// The error of an envelope is the problem detail.
+	req = httptest.NewRequest(http.MethodGet, "/test/envelope", nil)
+	req.Header.Set("Accept", api.ProblemContentType)
+	rec = httptest.NewRecorder()
+	server.handler.ServeHTTP(rec, req)
+	problem = api.ProblemResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil {
+		t.Fatalf("can not decode problem: %s", err)
+	}
+	if problem.Detail != "entity not found" {
+		t.Errorf("invalid envelope problem detail: %q", problem.Detail)
+	}
+
+	// Successful responses and other clients are unchanged.
+	req = httptest.NewRequest(http.MethodGet, "/test/ok", nil)
+	req.Header.Set("Accept", api.ProblemContentType)
+	rec = httptest.NewRecorder()
+	server.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK ||
+		!strings.Contains(rec.Body.String(), `"message":"ok"`) {
+		t.Errorf("invalid success response: %d %s",
+			rec.Code, rec.Body.String())
+	}
+	req = httptest.NewRequest(http.MethodGet, "/test/fail", nil)
+	rec = httptest.NewRecorder()
+	server.handler.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `"message"`) {
+		t.Errorf("invalid error response: %s", rec.Body.String())
+	}
+}
+
+// An endpoint serving a static page.
+type staticEndpoint struct{}
+
+// RegisterRoutes implements api.Endpoint interface.
+func (e staticEndpoint) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/index.html",
+		func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html></html>"))
+		})
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	server := NewServer("localhost", 0, mux.NewRouter())
+	server.Use(SecurityHeaders(DefaultSecurityConfig))
+	server.RegisterEndpoint("/api/v1/slow", slowEndpoint{})
+	server.RegisterEndpoint("/dashboard", staticEndpoint{})
+
+	// Create test table; each path maps to the expected headers.
+	csp := DefaultSecurityConfig.ContentSecurityPolicy
+	table := []struct {
+		path         string
+		cacheControl string
+		csp          string
+	}{
+		{"/api/v1/slow/fast", "no-store", ""},
+		{"/dashboard/index.html", "", csp},
+	}
+
+	// Test all entries in test table.
+	for _, e := range table {
+		req := httptest.NewRequest(http.MethodGet, e.path, nil)
+		rec := httptest.NewRecorder()
+		server.handler.ServeHTTP(rec, req)
+		header := rec.Header()
+		if header.Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s missing nosniff header", e.path)
+		}
+		if header.Get("X-Frame-Options") != "DENY" {
+			t.Errorf("%s invalid frame options: %q",
+				e.path, header.Get("X-Frame-Options"))
+		}
+		if header.Get("Cache-Control") != e.cacheControl {
+			t.Errorf("%s invalid cache control: want %q get %q",
+				e.path, e.cacheControl, header.Get("Cache-Control"))
+		}
+		if header.Get("Content-Security-Policy") != e.csp {
+			t.Errorf("%s invalid content security policy: %q",
+				e.path, header.Get("Content-Security-Policy"))
+		}
+	}
+}
+
+// An endpoint with a route that panics.
+type panicEndpoint struct{}
+
+// RegisterRoutes implements api.Endpoint interface.
+func (e panicEndpoint) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/panic", func(http.ResponseWriter, *http.Request) {
+		panic("handler failed")
+	})
+}
+
+func TestRecoverAndRequestLogger(t *testing.T) {
+	hook := test.NewGlobal()
+	level := log.GetLevel()
+	log.SetLevel(log.DebugLevel)
+	t.Cleanup(func() {
+		log.SetLevel(level)
+		hook.Reset()
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	})
+
+	server := NewServer("localhost", 0, mux.NewRouter())
+	server.Use(RequestLogger, Recover)
+	server.RegisterEndpoint("/test", failingEndpoint{})
+	server.RegisterEndpoint("/panic", panicEndpoint{},
+		Timeout(time.Second))
+
+	// Create test table; each path maps to a status code.
+	table := []struct {
+		path   string
+		status int
+	}{
+		{"/test/ok", http.StatusOK},
+		{"/test/fail", http.StatusNotFound},
+		{"/panic/panic", http.StatusInternalServerError},
+	}
+
+	// Each request is answered and logged with its status code.
+	for _, e := range table {
+		hook.Reset()
+		req := httptest.NewRequest(http.MethodGet, e.path, nil)
+		rec := httptest.NewRecorder()
+		server.handler.ServeHTTP(rec, req)
+		if rec.Code != e.status {
+			t.Errorf("%s invalid status code: want %d get %d",
+				e.path, e.status, rec.Code)
+		}
+		var entry *log.Entry
+		for _, logEntry := range hook.AllEntries() {
+			if logEntry.Message == "web request" {
+				entry = logEntry
+			}
+		}
+		if entry == nil {
+			t.Errorf("%s request not logged", e.path)
+			continue
+		}
+		if entry.Data["path"] != e.path || entry.Data["status"] != e.status {
+			t.Errorf("%s invalid log fields: %v", e.path, entry.Data)
+		}
+	}
+
+	// The panic is answered with the recover body.
+	req := httptest.NewRequest(http.MethodGet, "/panic/panic", nil)
+	rec := httptest.NewRecorder()
+	server.handler.ServeHTTP(rec, req)
+	if rec.Body.String() != RecoverBody {
+		t.Errorf("invalid panic body: %s", rec.Body.String())
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	server := NewServer("localhost", 0, mux.NewRouter())
+	server.Use(APIKeyAuth("secret"))
+	server.RegisterEndpoint("/test", anyMethodEndpoint{})
+
+	// Create test table; each request maps to a status code.
+	table := []struct {
+		method string
+		header string
+		value  string
+		status int
+	}{
+		{http.MethodGet, "", "", http.StatusOK},
+		{http.MethodPut, "", "", http.StatusUnauthorized},
+		{http.MethodDelete, "", "", http.StatusUnauthorized},
+		{http.MethodPut, "X-API-Key", "wrong", http.StatusUnauthorized},
+		{http.MethodPut, "Authorization", "secret", http.StatusUnauthorized},
+		{http.MethodPut, "X-API-Key", "secret", http.StatusOK},
+		{http.MethodDelete, "Authorization", "Bearer secret", http.StatusOK},
+	}
+	for _, e := range table {
+		req := httptest.NewRequest(e.method, "/test/", nil)
+		if e.header != "" {
+			req.Header.Set(e.header, e.value)
+		}
+		rec := httptest.NewRecorder()
+		server.handler.ServeHTTP(rec, req)
+		if rec.Code != e.status {
+			t.Errorf("%s %s=%q invalid status code: want %d get %d",
+				e.method, e.header, e.value, e.status, rec.Code)
+		}
+	}
+
+	// Without key, the authentication is disabled.
+	server = NewServer("localhost", 0, mux.NewRouter())
+	server.Use(APIKeyAuth(""))
+	server.RegisterEndpoint("/test", anyMethodEndpoint{})
+	req := httptest.NewRequest(http.MethodDelete, "/test/", nil)
+	rec := httptest.NewRecorder()
+	server.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("invalid status code without key: %d", rec.Code)
+	}
+}
+
+// An endpoint with a route, that answers all methods.
+type anyMethodEndpoint struct{}
+
+// RegisterRoutes implements api.Endpoint interface.
+func (e anyMethodEndpoint) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// TestMiddlewareBodies test that the response bodies of the middlewares
+// are failed api.Envelope responses.
+func TestMiddlewareBodies(t *testing.T) {
+	// Create test table; each body must be a failed envelope.
+	table := []struct {
+		body  string
+		error string
+	}{
+		{TimeoutBody, "request timeout"},
+		{RecoverBody, "internal server error"},
+		{UnauthorizedBody, "unauthorized"},
+	}
+	for _, e := range table {
+		var envelope api.Envelope
+		if err := json.Unmarshal([]byte(e.body), &envelope); err != nil {
+			t.Errorf("%s can not decode body: %s", e.body, err)
+			continue
+		}
+		if envelope.Success || envelope.Error != e.error {
+			t.Errorf("%s invalid envelope: %+v", e.body, envelope)
+		}
+	}
+}
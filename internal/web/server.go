@@ -6,6 +6,7 @@ package web
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/donsprallo/zeitgeist/internal/web/api"
 	"github.com/gorilla/mux"
@@ -49,7 +50,9 @@ func (s *Server) Serve() {
 	}
 	// Start the server by listening.
 	log.Infof("web server listening on %s", s.getAddrStr())
-	if err := s.server.ListenAndServe(); err != nil {
+	// The server is closed on Shutdown.
+	err := s.server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
 }
@@ -73,17 +76,26 @@ func (s *Server) getAddr() *net.TCPAddr {
 	return addr
 }
 
+// Use add middlewares to the server, that are applied to all routes of
+// all endpoints, for example SecurityHeaders.
+func (s *Server) Use(middlewares ...mux.MiddlewareFunc) {
+	s.handler.Use(middlewares...)
+}
+
 // RegisterEndpoint add an endpoint to the server. A prefix can be used to
-// specify a sub route that is handled by the endpoint.
+// specify a sub route that is handled by the endpoint. The middlewares are
+// applied to all routes of the endpoint, for example a Timeout.
 func (s *Server) RegisterEndpoint(
 	prefix string,
 	endpoint api.Endpoint,
+	middlewares ...mux.MiddlewareFunc,
 ) {
 	// Create sub router for an endpoint. The endpoint can register
 	// its routes to this router.
 	router := s.handler.
 		PathPrefix(prefix).
 		Subrouter()
+	router.Use(middlewares...)
 	// The endpoint must register its routes to the sub router.
 	endpoint.RegisterRoutes(router)
 }
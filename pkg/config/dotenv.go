@@ -0,0 +1,45 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+// LoadEnvFiles load dotenv files in order into the environment. Values from
+// later files override values from earlier files, so layered configurations
+// like ".env" and ".env.local" are possible. Variables already set in the
+// environment are never overridden. Files that do not exist are skipped.
+func LoadEnvFiles(filenames ...string) error {
+	// Merge all files in order; later files override earlier ones.
+	values := make(map[string]string)
+	for _, filename := range filenames {
+		fileValues, err := godotenv.Read(filename)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for key, value := range fileValues {
+			values[key] = value
+		}
+	}
+	// Set merged values that are not set in environment.
+	for key, value := range values {
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+		err := os.Setenv(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Write a dotenv file with content to dir.
+func writeEnvFile(t *testing.T, dir, name, content string) string {
+	filename := filepath.Join(dir, name)
+	err := os.WriteFile(filename, []byte(content), 0o600)
+	if err != nil {
+		t.Fatalf("can not write env file: %s", err)
+	}
+	return filename
+}
+
+// Unset environment keys after test.
+func unsetEnvAfter(t *testing.T, keys ...string) {
+	t.Cleanup(func() {
+		for _, key := range keys {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
+
+func TestLoadEnvFilesOverride(t *testing.T) {
+	dir := t.TempDir()
+	base := writeEnvFile(t, dir, ".env",
+		"ZG_TEST_HOST=base\nZG_TEST_PORT=123\n")
+	local := writeEnvFile(t, dir, ".env.local",
+		"ZG_TEST_HOST=local\n")
+	unsetEnvAfter(t, "ZG_TEST_HOST", "ZG_TEST_PORT")
+
+	// Load files in order; missing files are skipped.
+	missing := filepath.Join(dir, ".env.missing")
+	err := LoadEnvFiles(base, missing, local)
+	if err != nil {
+		t.Fatalf("load env files err: %s", err)
+	}
+
+	// The later file must override the earlier file.
+	if value := GetEnvStr("ZG_TEST_HOST", ""); value != "local" {
+		t.Errorf("invalid override value: want 'local' get '%s'", value)
+	}
+	// Values only in earlier file must be kept.
+	if value := GetEnvInt("ZG_TEST_PORT", 0); value != 123 {
+		t.Errorf("invalid base value: want 123 get %d", value)
+	}
+}
+
+func TestLoadEnvFilesEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	base := writeEnvFile(t, dir, ".env", "ZG_TEST_LEVEL=file\n")
+	t.Setenv("ZG_TEST_LEVEL", "env")
+
+	// Variables set in environment must not be overridden.
+	err := LoadEnvFiles(base)
+	if err != nil {
+		t.Fatalf("load env files err: %s", err)
+	}
+	if value := GetEnvStr("ZG_TEST_LEVEL", ""); value != "env" {
+		t.Errorf("invalid environment value: want 'env' get '%s'", value)
+	}
+}
+
+func TestGetEnvList(t *testing.T) {
+	t.Setenv("ZG_TEST_FILES", ".env, .env.local,,")
+	values := GetEnvList("ZG_TEST_FILES", nil)
+	if len(values) != 2 || values[0] != ".env" || values[1] != ".env.local" {
+		t.Errorf("invalid list value: %v", values)
+	}
+	// Fallback must be returned on missing key.
+	values = GetEnvList("ZG_TEST_MISSING", []string{".env"})
+	if len(values) != 1 || values[0] != ".env" {
+		t.Errorf("invalid fallback value: %v", values)
+	}
+}
@@ -7,6 +7,8 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // GetEnvStr load a string value from environment key. If environment key
@@ -28,3 +30,58 @@ func GetEnvInt(key string, fallback int) int {
 	}
 	return fallback
 }
+
+// GetEnvBool load a boolean value from environment key. If environment key
+// does not exist, a fallback value is returned.
+func GetEnvBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+// GetEnvFloat load a floating point value from environment key. If
+// environment key does not exist, a fallback value is returned.
+func GetEnvFloat(key string, fallback float64) float64 {
+	if value, ok := os.LookupEnv(key); ok {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+// GetEnvDuration load a time.Duration value like "10s" from environment
+// key. If environment key does not exist, a fallback value is returned.
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
+// GetEnvList load a comma separated list of string values from environment
+// key. Empty values are dropped. If environment key does not exist, a
+// fallback value is returned.
+func GetEnvList(key string, fallback []string) []string {
+	if value, ok := os.LookupEnv(key); ok {
+		return SplitList(value)
+	}
+	return fallback
+}
+
+// SplitList split a comma separated list of string values. The values are
+// trimmed and empty values are dropped.
+func SplitList(value string) []string {
+	values := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// Set environment key to value for the test. A missing value unsets key.
+func setTestEnv(t *testing.T, key, value string, missing bool) {
+	t.Setenv(key, value)
+	if missing {
+		_ = os.Unsetenv(key)
+	}
+}
+
+func TestGetEnvBool(t *testing.T) {
+	// Create test table; each value maps to the loaded value with the
+	// fallback true.
+	table := []struct {
+		value   string
+		missing bool
+		want    bool
+	}{
+		{"false", false, false},
+		{"0", false, false},
+		{"TRUE", false, true},
+		{"no", false, true},
+		{"", false, true},
+		{"", true, true},
+	}
+	for _, e := range table {
+		setTestEnv(t, "ZG_TEST_BOOL", e.value, e.missing)
+		if get := GetEnvBool("ZG_TEST_BOOL", true); get != e.want {
+			t.Errorf("%q invalid value: want %t get %t",
+				e.value, e.want, get)
+		}
+	}
+}
+
+func TestGetEnvFloat(t *testing.T) {
+	// Create test table; each value maps to the loaded value with the
+	// fallback 1.5.
+	table := []struct {
+		value   string
+		missing bool
+		want    float64
+	}{
+		{"0.25", false, 0.25},
+		{"-3", false, -3},
+		{"1e-3", false, 0.001},
+		{"fast", false, 1.5},
+		{"", false, 1.5},
+		{"", true, 1.5},
+	}
+	for _, e := range table {
+		setTestEnv(t, "ZG_TEST_FLOAT", e.value, e.missing)
+		if get := GetEnvFloat("ZG_TEST_FLOAT", 1.5); get != e.want {
+			t.Errorf("%q invalid value: want %g get %g",
+				e.value, e.want, get)
+		}
+	}
+}
+
+func TestGetEnvDuration(t *testing.T) {
+	// Create test table; each value maps to the loaded value with the
+	// fallback of one second.
+	table := []struct {
+		value   string
+		missing bool
+		want    time.Duration
+	}{
+		{"10s", false, 10 * time.Second},
+		{"-1m30s", false, -90 * time.Second},
+		{"10", false, time.Second},
+		{"soon", false, time.Second},
+		{"", true, time.Second},
+	}
+	for _, e := range table {
+		setTestEnv(t, "ZG_TEST_DURATION", e.value, e.missing)
+		get := GetEnvDuration("ZG_TEST_DURATION", time.Second)
+		if get != e.want {
+			t.Errorf("%q invalid value: want %s get %s",
+				e.value, e.want, get)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogLevels are the valid log level names of a Config.
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// LogFormats are the valid log format names of a Config.
+var LogFormats = []string{"text", "json"}
+
+// Config is the content of a JSON or YAML configuration file. Unset values
+// are zero and keep the defaults. The predefined timers and routes are
+// added on startup, in addition to the default timer and routes.
+type Config struct {
+	Ntp       NtpConfig     `json:"ntp" yaml:"ntp"`
+	Web       WebConfig     `json:"web" yaml:"web"`
+	Routing   string        `json:"routing" yaml:"routing"`
+	MaxRoutes int           `json:"maxRoutes" yaml:"maxRoutes"`
+	LogLevel  string        `json:"logLevel" yaml:"logLevel"`
+	LogFormat string        `json:"logFormat" yaml:"logFormat"`
+	Timers    []TimerConfig `json:"timers" yaml:"timers"`
+	Routes    []RouteConfig `json:"routes" yaml:"routes"`
+}
+
+// NtpConfig is the configuration of the ntp server. The durations are
+// strings like 10m.
+type NtpConfig struct {
+	Host             string        `json:"host" yaml:"host"`
+	Port             int           `json:"port" yaml:"port"`
+	Network          string        `json:"network" yaml:"network"`
+	Inline           bool          `json:"inline" yaml:"inline"`
+	Optional         bool          `json:"optional" yaml:"optional"`
+	LocalClock       bool          `json:"localClock" yaml:"localClock"`
+	LocalStratum     uint          `json:"localStratum" yaml:"localStratum"`
+	Workers          int           `json:"workers" yaml:"workers"`
+	Queue            int           `json:"queue" yaml:"queue"`
+	QueueKoD         bool          `json:"queueKod" yaml:"queueKod"`
+	RateLimit        string        `json:"rateLimit" yaml:"rateLimit"`
+	RateBurst        int           `json:"rateBurst" yaml:"rateBurst"`
+	Keys             string        `json:"keys" yaml:"keys"`
+	Validation       string        `json:"validation" yaml:"validation"`
+	Addresses        []string      `json:"addresses" yaml:"addresses"`
+	Listeners        []string      `json:"listeners" yaml:"listeners"`
+	LoopbackRefId    string        `json:"loopbackRefid" yaml:"loopbackRefid"`
+	ResponseTTL      string        `json:"responseTtl" yaml:"responseTtl"`
+	Upstream         string        `json:"upstream" yaml:"upstream"`
+	UpstreamInterval string        `json:"upstreamInterval" yaml:"upstreamInterval"`
+	Capture          CaptureConfig `json:"capture" yaml:"capture"`
+}
+
+// WebConfig is the configuration of the web server.
+type WebConfig struct {
+	Host       string   `json:"host" yaml:"host"`
+	Port       int      `json:"port" yaml:"port"`
+	Timeout    string   `json:"timeout" yaml:"timeout"`
+	TimerTypes []string `json:"timerTypes" yaml:"timerTypes"`
+	Identity   string   `json:"identity" yaml:"identity"`
+}
+
+// CaptureConfig is the capture file of the sent ntp responses.
+type CaptureConfig struct {
+	File  string `json:"file" yaml:"file"`
+	Size  int64  `json:"size" yaml:"size"`
+	Files int    `json:"files" yaml:"files"`
+}
+
+// TimerConfig is a predefined timer. The Type is one of system, modify,
+// rate, drift or stepping. A modify timer starts at Time, a rate or drift
+// timer runs with Rate and a stepping timer jumps by the Step duration.
+type TimerConfig struct {
+	Type string    `json:"type" yaml:"type"`
+	Time time.Time `json:"time" yaml:"time"`
+	Rate float64   `json:"rate" yaml:"rate"`
+	Step string    `json:"step" yaml:"step"`
+}
+
+// RouteConfig is a predefined route of the Subnet to the timer with the
+// index Timer of Config.Timers.
+type RouteConfig struct {
+	Subnet string `json:"subnet" yaml:"subnet"`
+	Timer  int    `json:"timer" yaml:"timer"`
+}
+
+// StepDuration get the parsed Step of a stepping timer. An invalid step
+// is zero; Load rejects it.
+func (c TimerConfig) StepDuration() time.Duration {
+	step, _ := time.ParseDuration(c.Step)
+	return step
+}
+
+// Load read the configuration file path. A file with the extension .yaml
+// or .yml is YAML, any other file is JSON. The configuration is validated;
+// unknown fields are rejected.
+func Load(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var cfg Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = decodeYAML(file, &cfg)
+	default:
+		err = decodeJSON(file, &cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not parse config %s: %w", path, err)
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Decode the JSON configuration of r to cfg.
+func decodeJSON(r io.Reader, cfg *Config) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(cfg)
+}
+
+// Decode the YAML configuration of r to cfg. An empty document is an
+// empty configuration.
+func decodeYAML(r io.Reader, cfg *Config) error {
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Validate check the ports, the log level and the predefined timers and
+// routes of the configuration.
+func (c *Config) Validate() error {
+	for name, port := range map[string]int{
+		"ntp": c.Ntp.Port, "web": c.Web.Port,
+	} {
+		if port != 0 && (port < 1 || port > 65535) {
+			return fmt.Errorf("%s port %d not in 1-65535", name, port)
+		}
+	}
+	if c.LogLevel != "" && !slices.Contains(LogLevels, c.LogLevel) {
+		return fmt.Errorf("unknown log level %q", c.LogLevel)
+	}
+	if c.LogFormat != "" && !slices.Contains(LogFormats, c.LogFormat) {
+		return fmt.Errorf("unknown log format %q", c.LogFormat)
+	}
+	for name, value := range map[string]string{
+		"ntp rate limit":        c.Ntp.RateLimit,
+		"ntp response ttl":      c.Ntp.ResponseTTL,
+		"ntp upstream interval": c.Ntp.UpstreamInterval,
+		"web timeout":           c.Web.Timeout,
+	} {
+		if _, err := time.ParseDuration(value); value != "" && err != nil {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+	}
+	for idx, timer := range c.Timers {
+		if err := timer.validate(); err != nil {
+			return fmt.Errorf("timer %d: %w", idx, err)
+		}
+	}
+	for idx, route := range c.Routes {
+		if _, _, err := net.ParseCIDR(route.Subnet); err != nil {
+			return fmt.Errorf("route %d: invalid subnet %q",
+				idx, route.Subnet)
+		}
+		if route.Timer < 0 || route.Timer >= len(c.Timers) {
+			return fmt.Errorf("route %d: unknown timer %d",
+				idx, route.Timer)
+		}
+	}
+	return nil
+}
+
+// Check the type and the type specific values of a timer.
+func (c TimerConfig) validate() error {
+	switch c.Type {
+	case "system", "modify":
+	case "rate", "drift":
+		if c.Rate <= 0 {
+			return errors.New("rate must be positive")
+		}
+	case "stepping":
+		if _, err := time.ParseDuration(c.Step); err != nil {
+			return fmt.Errorf("invalid step %q", c.Step)
+		}
+	default:
+		return fmt.Errorf("unknown timer type %q", c.Type)
+	}
+	return nil
+}
+
+// Apply set the flags of fs to the values of the configuration. The
+// precedence is flags over environment over file over defaults, so a
+// flag is only set, when it is not set on the command line, its key in
+// envKeys is not set in the environment and the file value is not zero.
+// The flags are named like the flags of the server command; flags not
+// defined in fs are skipped.
+func (c *Config) Apply(fs *flag.FlagSet, envKeys map[string]string) error {
+	values := c.flagValues()
+
+	// Skip the flags set on the command line or in the environment.
+	fs.Visit(func(f *flag.Flag) {
+		delete(values, f.Name)
+	})
+	for name, value := range values {
+		if _, ok := os.LookupEnv(envKeys[name]); ok || value == "" {
+			continue
+		}
+		if fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("can not apply %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Get the configuration values by flag name. A zero value is empty.
+func (c *Config) flagValues() map[string]string {
+	values := map[string]string{
+		"host":              c.Ntp.Host,
+		"port":              formatInt(c.Ntp.Port),
+		"network":           c.Ntp.Network,
+		"inline":            formatBool(c.Ntp.Inline),
+		"ntp-optional":      formatBool(c.Ntp.Optional),
+		"local-clock":       formatBool(c.Ntp.LocalClock),
+		"local-stratum":     formatInt(int(c.Ntp.LocalStratum)),
+		"workers":           formatInt(c.Ntp.Workers),
+		"queue":             formatInt(c.Ntp.Queue),
+		"queue-kod":         formatBool(c.Ntp.QueueKoD),
+		"rate-limit":        c.Ntp.RateLimit,
+		"rate-burst":        formatInt(c.Ntp.RateBurst),
+		"keys":              c.Ntp.Keys,
+		"validation":        c.Ntp.Validation,
+		"addresses":         strings.Join(c.Ntp.Addresses, ","),
+		"listeners":         strings.Join(c.Ntp.Listeners, ","),
+		"loopback-refid":    c.Ntp.LoopbackRefId,
+		"response-ttl":      c.Ntp.ResponseTTL,
+		"upstream":          c.Ntp.Upstream,
+		"upstream-interval": c.Ntp.UpstreamInterval,
+		"capture":           c.Ntp.Capture.File,
+		"capture-size":      formatInt(int(c.Ntp.Capture.Size)),
+		"capture-files":     formatInt(c.Ntp.Capture.Files),
+		"web-host":          c.Web.Host,
+		"web-port":          formatInt(c.Web.Port),
+		"web-timeout":       c.Web.Timeout,
+		"timer-types":       strings.Join(c.Web.TimerTypes, ","),
+		"identity":          c.Web.Identity,
+		"routing":           c.Routing,
+		"max-routes":        formatInt(c.MaxRoutes),
+		"loglevel":          c.LogLevel,
+		"log-format":        c.LogFormat,
+	}
+	return values
+}
+
+// Format an integer flag value; zero is empty.
+func formatInt(value int) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.Itoa(value)
+}
+
+// Format a boolean flag value; false is empty.
+func formatBool(value bool) string {
+	if !value {
+		return ""
+	}
+	return "true"
+}
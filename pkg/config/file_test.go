@@ -0,0 +1,195 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	filename := writeEnvFile(t, dir, "config.json", `{
+		"ntp": {"host": "0.0.0.0", "port": 1123},
+		"logLevel": "info",
+		"timers": [
+			{"type": "modify", "time": "2000-01-01T00:00:00Z"},
+			{"type": "stepping", "step": "-2s"}
+		],
+		"routes": [{"subnet": "10.0.0.0/8", "timer": 1}]
+	}`)
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Fatalf("can not load config: %s", err)
+	}
+	if cfg.Ntp.Host != "0.0.0.0" || cfg.Ntp.Port != 1123 ||
+		cfg.LogLevel != "info" {
+		t.Errorf("invalid settings: %+v", cfg)
+	}
+	// The web server is not configured and keeps the defaults.
+	if cfg.Web.Host != "" || cfg.Web.Port != 0 {
+		t.Errorf("invalid web settings: %+v", cfg.Web)
+	}
+	start := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if len(cfg.Timers) != 2 || !cfg.Timers[0].Time.Equal(start) ||
+		cfg.Timers[1].StepDuration() != -2*time.Second {
+		t.Errorf("invalid timers: %+v", cfg.Timers)
+	}
+	if len(cfg.Routes) != 1 || cfg.Routes[0].Subnet != "10.0.0.0/8" {
+		t.Errorf("invalid routes: %+v", cfg.Routes)
+	}
+
+	// A missing file is an error.
+	if _, err = Load(dir + "/missing.json"); err == nil {
+		t.Errorf("missing file loaded")
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	dir := t.TempDir()
+	filename := writeEnvFile(t, dir, "config.yaml", `
+ntp:
+  host: 0.0.0.0
+  port: 1123
+  addresses: [127.0.0.1:2123, 127.0.0.1:3123]
+  capture:
+    file: capture.bin
+web:
+  timeout: 5s
+logLevel: info
+timers:
+  - type: modify
+    time: 2000-01-01T00:00:00Z
+routes:
+  - subnet: 10.0.0.0/8
+    timer: 0
+`)
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Fatalf("can not load config: %s", err)
+	}
+	if cfg.Ntp.Host != "0.0.0.0" || cfg.Ntp.Port != 1123 ||
+		len(cfg.Ntp.Addresses) != 2 || cfg.Ntp.Capture.File != "capture.bin" ||
+		cfg.Web.Timeout != "5s" || cfg.LogLevel != "info" {
+		t.Errorf("invalid settings: %+v", cfg)
+	}
+	start := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if len(cfg.Timers) != 1 || !cfg.Timers[0].Time.Equal(start) {
+		t.Errorf("invalid timers: %+v", cfg.Timers)
+	}
+	if len(cfg.Routes) != 1 || cfg.Routes[0].Subnet != "10.0.0.0/8" {
+		t.Errorf("invalid routes: %+v", cfg.Routes)
+	}
+
+	// An empty file keeps the defaults.
+	filename = writeEnvFile(t, dir, "empty.yml", "")
+	if _, err = Load(filename); err != nil {
+		t.Errorf("can not load empty config: %s", err)
+	}
+}
+
+func TestLoadInvalidYAML(t *testing.T) {
+	// Create test table; each config must be rejected.
+	table := []string{
+		"ntp:\n  port: 65536\n",
+		"logFormat: xml\n",
+		"web:\n  timeout: 1x\n",
+		"unknown: true\n",
+		"ntp: [",
+	}
+	dir := t.TempDir()
+	for _, content := range table {
+		filename := writeEnvFile(t, dir, "config.yaml", content)
+		if _, err := Load(filename); err == nil {
+			t.Errorf("invalid config loaded: %q", content)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	// Create test table; each config must be rejected.
+	table := []string{
+		`{"ntp": {"port": 65536}}`,
+		`{"web": {"port": -1}}`,
+		`{"logLevel": "verbose"}`,
+		`{"logFormat": "xml"}`,
+		`{"ntp": {"rateLimit": "1x"}}`,
+		`{"web": {"timeout": "1x"}}`,
+		`{"timers": [{"type": "atomic"}]}`,
+		`{"timers": [{"type": "rate", "rate": 0}]}`,
+		`{"timers": [{"type": "stepping", "step": "1x"}]}`,
+		`{"timers": [{"type": "system"}], "routes": [{"subnet": "10.0.0.0"}]}`,
+		`{"timers": [{"type": "system"}], "routes": [{"subnet": "10.0.0.0/8", "timer": 1}]}`,
+		`{"unknown": true}`,
+		`{"ntp": `,
+	}
+	dir := t.TempDir()
+	for _, content := range table {
+		filename := writeEnvFile(t, dir, "config.json", content)
+		if _, err := Load(filename); err == nil {
+			t.Errorf("invalid config loaded: %s", content)
+		}
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	// Create a flag set with defaults; the port is set on the command
+	// line and the web host in the environment.
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	host := fs.String("host", "localhost", "")
+	port := fs.Int("port", 123, "")
+	webHost := fs.String("web-host", "localhost", "")
+	webPort := fs.Int("web-port", 80, "")
+	logLevel := fs.String("loglevel", "debug", "")
+	inline := fs.Bool("inline", false, "")
+	rateLimit := fs.Duration("rate-limit", 0, "")
+	addresses := fs.String("addresses", "", "")
+	maxRoutes := fs.Int("max-routes", 0, "")
+	if err := fs.Parse([]string{"-port", "1123"}); err != nil {
+		t.Fatalf("can not parse flags: %s", err)
+	}
+	t.Setenv("ZG_TEST_WEB_HOST", "127.0.0.1")
+
+	cfg := Config{
+		Ntp: NtpConfig{
+			Host: "0.0.0.0", Port: 2123, Inline: true, RateLimit: "2s",
+			Addresses: []string{"127.0.0.1:2123", "127.0.0.1:3123"},
+		},
+		Web:       WebConfig{Host: "example.com"},
+		MaxRoutes: 100,
+		LogLevel:  "warn",
+		LogFormat: "json",
+	}
+	err := cfg.Apply(fs, map[string]string{
+		"host":     "ZG_TEST_HOST",
+		"port":     "ZG_TEST_PORT",
+		"web-host": "ZG_TEST_WEB_HOST",
+		"web-port": "ZG_TEST_WEB_PORT",
+		"loglevel": "ZG_TEST_LOGLEVEL",
+	})
+	if err != nil {
+		t.Fatalf("can not apply config: %s", err)
+	}
+
+	// Flags over environment over file over defaults.
+	if *port != 1123 {
+		t.Errorf("flag overridden by file: %d", *port)
+	}
+	if *webHost != "localhost" {
+		t.Errorf("environment overridden by file: %s", *webHost)
+	}
+	if *host != "0.0.0.0" || *logLevel != "warn" {
+		t.Errorf("file not applied: %s %s", *host, *logLevel)
+	}
+	if !*inline || *rateLimit != 2*time.Second ||
+		*addresses != "127.0.0.1:2123,127.0.0.1:3123" || *maxRoutes != 100 {
+		t.Errorf("file not applied: %t %s %s %d",
+			*inline, *rateLimit, *addresses, *maxRoutes)
+	}
+	if *webPort != 80 {
+		t.Errorf("default overridden by unset file value: %d", *webPort)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// Summary is the effective configuration of an application. The summary
+// is logged on startup for audit and troubleshooting.
+type Summary struct {
+	Version      string         // The application version.
+	NtpAddress   string         // The ntp server bind address.
+	WebAddress   string         // The web server bind address.
+	DefaultTimer string         // The default timer type name.
+	LogLevel     string         // The application logger level.
+	Options      map[string]any // Additional feature flags and limits.
+}
+
+// LogStartupSummary write the Summary cfg as a single structured log entry
+// to logger. Each setting is a separate log field.
+func LogStartupSummary(logger log.FieldLogger, cfg Summary) {
+	fields := log.Fields{
+		"version":       cfg.Version,
+		"ntp_address":   cfg.NtpAddress,
+		"web_address":   cfg.WebAddress,
+		"default_timer": cfg.DefaultTimer,
+		"log_level":     cfg.LogLevel,
+	}
+	for key, value := range cfg.Options {
+		fields[key] = value
+	}
+	logger.WithFields(fields).Info("startup configuration")
+}
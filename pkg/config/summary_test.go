@@ -0,0 +1,47 @@
+// Copyright 2024 The Zeitgeist Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestLogStartupSummary(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+
+	LogStartupSummary(logger, Summary{
+		Version:      "1.2.3",
+		NtpAddress:   "localhost:123",
+		WebAddress:   "localhost:80",
+		DefaultTimer: "SystemTimer",
+		LogLevel:     "info",
+		Options:      map[string]any{"max_routes": 10},
+	})
+
+	// The summary must be a single log entry.
+	if len(hook.AllEntries()) != 1 {
+		t.Fatalf("invalid number of log entries: %d",
+			len(hook.AllEntries()))
+	}
+
+	// Test that all key fields are present.
+	fields := hook.LastEntry().Data
+	want := map[string]any{
+		"version":       "1.2.3",
+		"ntp_address":   "localhost:123",
+		"web_address":   "localhost:80",
+		"default_timer": "SystemTimer",
+		"log_level":     "info",
+		"max_routes":    10,
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("invalid log field %s: want %v get %v",
+				key, value, fields[key])
+		}
+	}
+}